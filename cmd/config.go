@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sort"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -40,15 +42,129 @@ var configShowCmd = &cobra.Command{
 	},
 }
 
+var configLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the configuration for mistakes",
+	Long: `Check the config file for unknown fields, readable_paths and
+writable_paths that do not exist, runtimes enabled without their tool
+installed, AWS misconfiguration, and extra_commands that are also in
+denied_commands.
+
+Exits non-zero if any errors are found. Warnings are printed but do not
+change the exit status.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := config.Path()
+		if err != nil {
+			return err
+		}
+		issues, err := config.LintFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s does not exist; using defaults\n", p)
+				return nil
+			}
+			return fmt.Errorf("reading config: %w", err)
+		}
+		return printLintIssues(cmd.OutOrStdout(), p, issues)
+	},
+}
+
+// printLintIssues writes issues to w and returns an error if any of them
+// is a LintError.
+func printLintIssues(w io.Writer, path string, issues []config.LintIssue) error {
+	if len(issues) == 0 {
+		fmt.Fprintf(w, "%s: no issues found\n", path)
+		return nil
+	}
+	errCount := 0
+	for _, i := range issues {
+		if i.Severity == config.LintError {
+			errCount++
+		}
+		fmt.Fprintln(w, i)
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%s: %d error(s) found", path, errCount)
+	}
+	return nil
+}
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <old.yaml> <new.yaml>",
+	Short: "Show how the policy of two config files differs",
+	Long: `Compare the policy of two config files: extra_commands and
+denied_commands added or removed, path entries added or removed, and
+settings such as git permissions, runtime toggles and limits whose
+effective value changed. Unset settings compare as their defaults.
+
+Lines start with + for added entries, - for removed entries and ~ for
+changed settings. Environment overrides are not applied.`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldCfg, err := config.ParseFile(args[0])
+		if err != nil {
+			return err
+		}
+		newCfg, err := config.ParseFile(args[1])
+		if err != nil {
+			return err
+		}
+		printPolicyDiff(cmd.OutOrStdout(), config.DiffPolicy(oldCfg, newCfg))
+		return nil
+	},
+}
+
+// printPolicyDiff writes d to w, one added, removed or changed entry per
+// line.
+func printPolicyDiff(w io.Writer, d config.PolicyDiff) {
+	if d.Empty() {
+		fmt.Fprintln(w, "no policy changes")
+		return
+	}
+	printList := func(sign, setting string, entries []string) {
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s %s: %s\n", sign, setting, e)
+		}
+	}
+	printList("+", "extra_commands", d.AddedCommands)
+	printList("-", "extra_commands", d.RemovedCommands)
+	printList("+", "denied_commands", d.AddedDeniedCommands)
+	printList("-", "denied_commands", d.RemovedDeniedCommands)
+	settings := make(map[string]bool)
+	for setting := range d.AddedPaths {
+		settings[setting] = true
+	}
+	for setting := range d.RemovedPaths {
+		settings[setting] = true
+	}
+	sorted := make([]string, 0, len(settings))
+	for setting := range settings {
+		sorted = append(sorted, setting)
+	}
+	sort.Strings(sorted)
+	for _, setting := range sorted {
+		printList("+", setting, d.AddedPaths[setting])
+		printList("-", setting, d.RemovedPaths[setting])
+	}
+	for _, c := range d.Changes {
+		fmt.Fprintf(w, "~ %s: %s -> %s\n", c.Setting, c.Old, c.New)
+	}
+}
+
 func init() {
 	configCmd.AddCommand(configPathCmd)
 	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configLintCmd)
+	configCmd.AddCommand(configDiffCmd)
 	rootCmd.AddCommand(configCmd)
 }
 
-// loadConfig is a helper used by config subcommands.
+// loadConfig is a helper used by config subcommands. It reads the file
+// without environment overrides so that saving does not persist them.
 func loadConfig() (*config.Config, error) {
-	return config.Load()
+	return config.LoadFile()
 }
 
 // saveConfig is a helper used by config subcommands.
@@ -39,6 +39,18 @@ var awsShowCmd = &cobra.Command{
 			fmt.Println("  Security: More secure (1-hour STS tokens)")
 			fmt.Println("  ~/.aws: Blocked")
 			fmt.Println("  ~/.ssh: Private keys blocked")
+			if cfg.AWS.RoleARN != "" {
+				fmt.Printf("  Assumed role: %s\n", cfg.AWS.RoleARN)
+			}
+			if cfg.AWS.SessionPolicy != "" {
+				fmt.Println("  Session policy: inline")
+			}
+			for _, arn := range cfg.AWS.SessionPolicyARNs {
+				fmt.Printf("  Session policy ARN: %s\n", arn)
+			}
+			if cfg.AWS.MaxCredentialTTL > 0 {
+				fmt.Printf("  Max credential TTL: %s\n", cfg.AWS.MaxCredentialTTL)
+			}
 		} else {
 			fmt.Println("  Mode: disabled")
 			fmt.Println("  AWS CLI commands are not allowed")
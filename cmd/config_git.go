@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/gartnera/lite-sandbox/config"
@@ -22,17 +23,45 @@ var gitShowCmd = &cobra.Command{
 			return err
 		}
 		g := cfg.Git
-		fmt.Printf("local_read:   %v\n", g.GitLocalRead())
-		fmt.Printf("local_write:  %v\n", g.GitLocalWrite())
-		fmt.Printf("remote_read:  %v\n", g.GitRemoteRead())
-		fmt.Printf("remote_write: %v\n", g.GitRemoteWrite())
+		fmt.Printf("local_read:        %v\n", g.GitLocalRead())
+		fmt.Printf("local_write:       %v\n", g.GitLocalWrite())
+		fmt.Printf("remote_read:       %v\n", g.GitRemoteRead())
+		fmt.Printf("remote_write:      %v\n", g.GitRemoteWrite())
+		fmt.Printf("allow_destructive: %v\n", g.GitAllowDestructive())
+		if g == nil || len(g.PerPath) == 0 {
+			return nil
+		}
+		patterns := make([]string, 0, len(g.PerPath))
+		for pattern := range g.PerPath {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		fmt.Println("per_path:")
+		for _, pattern := range patterns {
+			o := g.PerPath[pattern]
+			if o == nil {
+				o = &config.GitConfig{}
+			}
+			fmt.Printf("  %s: local_read=%v local_write=%v remote_read=%v remote_write=%v allow_destructive=%v\n", pattern,
+				boolOr(o.LocalRead, g.GitLocalRead()), boolOr(o.LocalWrite, g.GitLocalWrite()),
+				boolOr(o.RemoteRead, g.GitRemoteRead()), boolOr(o.RemoteWrite, g.GitRemoteWrite()),
+				boolOr(o.AllowDestructive, g.GitAllowDestructive()))
+		}
 		return nil
 	},
 }
 
+// boolOr returns *b, or def if b is nil.
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
 var gitSetCmd = &cobra.Command{
 	Use:   "set <key> <true|false>",
-	Short: "Set a git permission (local_read, local_write, remote_read, remote_write)",
+	Short: "Set a git permission (local_read, local_write, remote_read, remote_write, allow_destructive)",
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
@@ -64,8 +93,10 @@ var gitSetCmd = &cobra.Command{
 			cfg.Git.RemoteRead = &val
 		case "remote_write":
 			cfg.Git.RemoteWrite = &val
+		case "allow_destructive":
+			cfg.Git.AllowDestructive = &val
 		default:
-			return fmt.Errorf("unknown git permission key %q; valid keys: local_read, local_write, remote_read, remote_write", key)
+			return fmt.Errorf("unknown git permission key %q; valid keys: local_read, local_write, remote_read, remote_write, allow_destructive", key)
 		}
 
 		if err := saveConfig(cfg); err != nil {
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/gartnera/lite-sandbox/config"
 	"github.com/spf13/cobra"
@@ -21,6 +22,12 @@ var configLocalBinaryExecutionShowCmd = &cobra.Command{
 			return err
 		}
 		fmt.Printf("Local Binary Execution: %v\n", cfg.LocalBinaryExecution.IsEnabled())
+		if cfg.LocalBinaryExecution != nil && len(cfg.LocalBinaryExecution.AllowedPaths) > 0 {
+			fmt.Printf("Allowed Paths: %s\n", strings.Join(cfg.LocalBinaryExecution.AllowedPaths, ", "))
+		}
+		if cfg.LocalBinaryExecution != nil && len(cfg.LocalBinaryExecution.AllowedScripts) > 0 {
+			fmt.Printf("Allowed Scripts: %s\n", strings.Join(cfg.LocalBinaryExecution.AllowedScripts, ", "))
+		}
 		return nil
 	},
 }
@@ -34,7 +41,10 @@ var configLocalBinaryExecutionEnableCmd = &cobra.Command{
 			return err
 		}
 		t := true
-		cfg.LocalBinaryExecution = &config.LocalBinaryExecutionConfig{Enabled: &t}
+		if cfg.LocalBinaryExecution == nil {
+			cfg.LocalBinaryExecution = &config.LocalBinaryExecutionConfig{}
+		}
+		cfg.LocalBinaryExecution.Enabled = &t
 		if err := saveConfig(cfg); err != nil {
 			return err
 		}
@@ -52,7 +62,10 @@ var configLocalBinaryExecutionDisableCmd = &cobra.Command{
 			return err
 		}
 		f := false
-		cfg.LocalBinaryExecution = &config.LocalBinaryExecutionConfig{Enabled: &f}
+		if cfg.LocalBinaryExecution == nil {
+			cfg.LocalBinaryExecution = &config.LocalBinaryExecutionConfig{}
+		}
+		cfg.LocalBinaryExecution.Enabled = &f
 		if err := saveConfig(cfg); err != nil {
 			return err
 		}
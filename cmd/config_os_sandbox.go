@@ -16,11 +16,18 @@ var configOSSandboxShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show current OS sandbox configuration",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := config.LoadFile()
 		if err != nil {
 			return err
 		}
 		fmt.Printf("OS Sandbox: %v\n", cfg.OSSandboxEnabled())
+		fmt.Printf("OS Sandbox Required: %v\n", cfg.RequiresOSSandbox())
+		for _, path := range cfg.OSSandboxExtraWritableBinds {
+			fmt.Printf("Extra Writable Bind: %s\n", path)
+		}
+		for _, path := range cfg.OSSandboxExtraReadableBinds {
+			fmt.Printf("Extra Readable Bind: %s\n", path)
+		}
 		return nil
 	},
 }
@@ -29,16 +36,23 @@ var configOSSandboxEnableCmd = &cobra.Command{
 	Use:   "enable",
 	Short: "Enable OS-level sandboxing with bubblewrap",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := config.LoadFile()
 		if err != nil {
 			return err
 		}
+		required, _ := cmd.Flags().GetBool("required")
 		t := true
 		cfg.OSSandbox = &t
+		if required {
+			cfg.OSSandboxRequired = &t
+		}
 		if err := config.Save(cfg); err != nil {
 			return err
 		}
 		fmt.Println("OS sandbox enabled")
+		if required {
+			fmt.Println("Commands will fail if the OS sandbox is unavailable")
+		}
 		return nil
 	},
 }
@@ -47,12 +61,14 @@ var configOSSandboxDisableCmd = &cobra.Command{
 	Use:   "disable",
 	Short: "Disable OS-level sandboxing",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := config.LoadFile()
 		if err != nil {
 			return err
 		}
 		f := false
 		cfg.OSSandbox = &f
+		// Leaving os_sandbox_required set would make every command fail.
+		cfg.OSSandboxRequired = nil
 		if err := config.Save(cfg); err != nil {
 			return err
 		}
@@ -66,4 +82,6 @@ func init() {
 	configOSSandboxCmd.AddCommand(configOSSandboxShowCmd)
 	configOSSandboxCmd.AddCommand(configOSSandboxEnableCmd)
 	configOSSandboxCmd.AddCommand(configOSSandboxDisableCmd)
+
+	configOSSandboxEnableCmd.Flags().Bool("required", false, "Fail commands instead of running them when the OS sandbox is unavailable")
 }
@@ -27,12 +27,16 @@ var runtimesShowCmd = &cobra.Command{
 		fmt.Println("Runtimes:")
 		if cfg.Runtimes.Go != nil {
 			fmt.Println("  go:")
-			fmt.Printf("    enabled:  %v\n", cfg.Runtimes.Go.GoEnabled())
-			fmt.Printf("    generate: %v\n", cfg.Runtimes.Go.GoGenerate())
+			fmt.Printf("    enabled:     %v\n", cfg.Runtimes.Go.GoEnabled())
+			fmt.Printf("    generate:    %v\n", cfg.Runtimes.Go.GoGenerate())
+			fmt.Printf("    allow_run:   %v\n", cfg.Runtimes.Go.GoRun())
+			fmt.Printf("    allow_fetch: %v\n", cfg.Runtimes.Go.GoFetch())
 		} else {
 			fmt.Println("  go: (defaults)")
-			fmt.Printf("    enabled:  %v\n", false)
-			fmt.Printf("    generate: %v\n", false)
+			fmt.Printf("    enabled:     %v\n", false)
+			fmt.Printf("    generate:    %v\n", false)
+			fmt.Printf("    allow_run:   %v\n", false)
+			fmt.Printf("    allow_fetch: %v\n", false)
 		}
 		if cfg.Runtimes.Pnpm != nil {
 			fmt.Println("  pnpm:")
@@ -46,6 +50,7 @@ var runtimesShowCmd = &cobra.Command{
 		if cfg.Runtimes.Rust != nil {
 			fmt.Println("  rust:")
 			fmt.Printf("    enabled: %v\n", cfg.Runtimes.Rust.RustEnabled())
+			fmt.Printf("    install: %v\n", cfg.Runtimes.Rust.RustInstall())
 			fmt.Printf("    publish: %v\n", cfg.Runtimes.Rust.RustPublish())
 		} else {
 			fmt.Println("  rust: (defaults)")
@@ -74,8 +79,10 @@ var goRuntimeShowCmd = &cobra.Command{
 		if cfg.Runtimes != nil && cfg.Runtimes.Go != nil {
 			g = cfg.Runtimes.Go
 		}
-		fmt.Printf("enabled:  %v\n", g.GoEnabled())
-		fmt.Printf("generate: %v\n", g.GoGenerate())
+		fmt.Printf("enabled:     %v\n", g.GoEnabled())
+		fmt.Printf("generate:    %v\n", g.GoGenerate())
+		fmt.Printf("allow_run:   %v\n", g.GoRun())
+		fmt.Printf("allow_fetch: %v\n", g.GoFetch())
 		return nil
 	},
 }
@@ -85,6 +92,8 @@ var goRuntimeEnableCmd = &cobra.Command{
 	Short: "Enable Go runtime commands",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		withGenerate, _ := cmd.Flags().GetBool("with-generate")
+		withRun, _ := cmd.Flags().GetBool("with-run")
+		withFetch, _ := cmd.Flags().GetBool("with-fetch")
 
 		cfg, err := loadConfig()
 		if err != nil {
@@ -103,6 +112,12 @@ var goRuntimeEnableCmd = &cobra.Command{
 		if withGenerate {
 			cfg.Runtimes.Go.Generate = &trueVal
 		}
+		if withRun {
+			cfg.Runtimes.Go.AllowRun = &trueVal
+		}
+		if withFetch {
+			cfg.Runtimes.Go.AllowFetch = &trueVal
+		}
 
 		if err := saveConfig(cfg); err != nil {
 			return err
@@ -112,6 +127,12 @@ var goRuntimeEnableCmd = &cobra.Command{
 		if withGenerate {
 			fmt.Println("runtimes.go.generate set to true")
 		}
+		if withRun {
+			fmt.Println("runtimes.go.allow_run set to true")
+		}
+		if withFetch {
+			fmt.Println("runtimes.go.allow_fetch set to true")
+		}
 		return nil
 	},
 }
@@ -121,6 +142,8 @@ var goRuntimeDisableCmd = &cobra.Command{
 	Short: "Disable Go runtime commands",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		withGenerate, _ := cmd.Flags().GetBool("with-generate")
+		withRun, _ := cmd.Flags().GetBool("with-run")
+		withFetch, _ := cmd.Flags().GetBool("with-fetch")
 
 		cfg, err := loadConfig()
 		if err != nil {
@@ -139,6 +162,12 @@ var goRuntimeDisableCmd = &cobra.Command{
 		if withGenerate {
 			cfg.Runtimes.Go.Generate = &falseVal
 		}
+		if withRun {
+			cfg.Runtimes.Go.AllowRun = &falseVal
+		}
+		if withFetch {
+			cfg.Runtimes.Go.AllowFetch = &falseVal
+		}
 
 		if err := saveConfig(cfg); err != nil {
 			return err
@@ -148,6 +177,12 @@ var goRuntimeDisableCmd = &cobra.Command{
 		if withGenerate {
 			fmt.Println("runtimes.go.generate set to false")
 		}
+		if withRun {
+			fmt.Println("runtimes.go.allow_run set to false")
+		}
+		if withFetch {
+			fmt.Println("runtimes.go.allow_fetch set to false")
+		}
 		return nil
 	},
 }
@@ -267,6 +302,7 @@ var rustRuntimeShowCmd = &cobra.Command{
 			r = cfg.Runtimes.Rust
 		}
 		fmt.Printf("enabled: %v\n", r.RustEnabled())
+		fmt.Printf("install: %v\n", r.RustInstall())
 		fmt.Printf("publish: %v\n", r.RustPublish())
 		return nil
 	},
@@ -276,6 +312,7 @@ var rustRuntimeEnableCmd = &cobra.Command{
 	Use:   "enable",
 	Short: "Enable Rust runtime commands (cargo, rustc)",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		withInstall, _ := cmd.Flags().GetBool("with-install")
 		withPublish, _ := cmd.Flags().GetBool("with-publish")
 
 		cfg, err := loadConfig()
@@ -292,6 +329,9 @@ var rustRuntimeEnableCmd = &cobra.Command{
 		trueVal := true
 		cfg.Runtimes.Rust.Enabled = &trueVal
 
+		if withInstall {
+			cfg.Runtimes.Rust.Install = &trueVal
+		}
 		if withPublish {
 			cfg.Runtimes.Rust.Publish = &trueVal
 		}
@@ -301,6 +341,9 @@ var rustRuntimeEnableCmd = &cobra.Command{
 		}
 
 		fmt.Println("runtimes.rust.enabled set to true")
+		if withInstall {
+			fmt.Println("runtimes.rust.install set to true")
+		}
 		if withPublish {
 			fmt.Println("runtimes.rust.publish set to true")
 		}
@@ -312,6 +355,7 @@ var rustRuntimeDisableCmd = &cobra.Command{
 	Use:   "disable",
 	Short: "Disable Rust runtime commands",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		withInstall, _ := cmd.Flags().GetBool("with-install")
 		withPublish, _ := cmd.Flags().GetBool("with-publish")
 
 		cfg, err := loadConfig()
@@ -328,6 +372,9 @@ var rustRuntimeDisableCmd = &cobra.Command{
 		falseVal := false
 		cfg.Runtimes.Rust.Enabled = &falseVal
 
+		if withInstall {
+			cfg.Runtimes.Rust.Install = &falseVal
+		}
 		if withPublish {
 			cfg.Runtimes.Rust.Publish = &falseVal
 		}
@@ -337,6 +384,9 @@ var rustRuntimeDisableCmd = &cobra.Command{
 		}
 
 		fmt.Println("runtimes.rust.enabled set to false")
+		if withInstall {
+			fmt.Println("runtimes.rust.install set to false")
+		}
 		if withPublish {
 			fmt.Println("runtimes.rust.publish set to false")
 		}
@@ -348,6 +398,10 @@ func init() {
 	// Add --with-generate flag to enable/disable commands
 	goRuntimeEnableCmd.Flags().Bool("with-generate", false, "Also enable go generate")
 	goRuntimeDisableCmd.Flags().Bool("with-generate", false, "Also disable go generate")
+	goRuntimeEnableCmd.Flags().Bool("with-run", false, "Also enable go run")
+	goRuntimeDisableCmd.Flags().Bool("with-run", false, "Also disable go run")
+	goRuntimeEnableCmd.Flags().Bool("with-fetch", false, "Also enable go get and remote go install")
+	goRuntimeDisableCmd.Flags().Bool("with-fetch", false, "Also disable go get and remote go install")
 
 	// Add go subcommands
 	goRuntimeCmd.AddCommand(goRuntimeShowCmd)
@@ -363,7 +417,9 @@ func init() {
 	pnpmRuntimeCmd.AddCommand(pnpmRuntimeEnableCmd)
 	pnpmRuntimeCmd.AddCommand(pnpmRuntimeDisableCmd)
 
-	// Add --with-publish flag to rust enable/disable commands
+	// Add --with-install and --with-publish flags to rust enable/disable commands
+	rustRuntimeEnableCmd.Flags().Bool("with-install", false, "Also enable cargo install")
+	rustRuntimeDisableCmd.Flags().Bool("with-install", false, "Also disable cargo install")
 	rustRuntimeEnableCmd.Flags().Bool("with-publish", false, "Also enable cargo publish")
 	rustRuntimeDisableCmd.Flags().Bool("with-publish", false, "Also disable cargo publish")
 
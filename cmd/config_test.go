@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigLint(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	t.Setenv("LITE_SANDBOX_CONFIG", configPath)
+
+	tests := []struct {
+		name     string
+		yaml     string
+		wantErr  bool
+		contains string
+	}{
+		{"clean", "extra_commands: [make]\n", false, "no issues found"},
+		{"warning only", "writable_paths: [" + filepath.Join(dir, "missing") + "]\n", false, "warning: writable_paths"},
+		{"error", "extra_commands: [curl]\ndenied_commands: [curl]\n", true, "error: extra_commands"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			configLintCmd.SetOut(&out)
+			defer configLintCmd.SetOut(nil)
+			err := configLintCmd.RunE(configLintCmd, nil)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("wantErr %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(out.String(), tt.contains) {
+				t.Fatalf("expected output containing %q, got %q", tt.contains, out.String())
+			}
+		})
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.yaml")
+	newPath := filepath.Join(dir, "new.yaml")
+	if err := os.WriteFile(oldPath, []byte("writable_paths: [/tmp/a]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newYAML := "extra_commands: [curl]\nwritable_paths: [/tmp/b]\ngit:\n  remote_write: true\n"
+	if err := os.WriteFile(newPath, []byte(newYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"changes", []string{oldPath, newPath}, "+ extra_commands: curl\n+ writable_paths: /tmp/b\n- writable_paths: /tmp/a\n~ git.remote_write: false -> true\n", ""},
+		{"same file", []string{newPath, newPath}, "no policy changes\n", ""},
+		{"missing file", []string{oldPath, filepath.Join(dir, "missing.yaml")}, "", "reading config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			configDiffCmd.SetOut(&out)
+			defer configDiffCmd.SetOut(nil)
+			err := configDiffCmd.RunE(configDiffCmd, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("expected output %q, got %q", tt.want, out.String())
+			}
+		})
+	}
+}
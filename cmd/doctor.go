@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/spf13/cobra"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose problems with the sandbox setup",
+	Long: `Check that the config loads and lints cleanly, that the OS sandbox can run
+(bwrap and user namespaces on Linux, sandbox-exec on macOS), that the tool of
+each enabled runtime is on PATH, and that the AWS settings are usable.
+
+Each check is reported as PASS, WARN or FAIL with a hint for fixing it.
+Exits non-zero if any check fails.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printDoctorReport(cmd.OutOrStdout(), runDoctorChecks())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// Dependencies of the doctor checks, replaceable in tests.
+var (
+	doctorLookPath = exec.LookPath
+	doctorReadFile = os.ReadFile
+	doctorGOOS     = runtime.GOOS
+	// doctorLoadAWSProfile returns an error if the named AWS profile cannot
+	// be loaded from the shared config and credentials files.
+	doctorLoadAWSProfile = func(ctx context.Context, profile string) error {
+		_, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithSharedConfigProfile(profile))
+		return err
+	}
+)
+
+// doctorStatus is the outcome of a doctorCheck.
+type doctorStatus string
+
+const (
+	doctorPass doctorStatus = "PASS"
+	doctorWarn doctorStatus = "WARN"
+	doctorFail doctorStatus = "FAIL"
+)
+
+// doctorCheck is the result of a single doctor check.
+type doctorCheck struct {
+	Name    string
+	Status  doctorStatus
+	Message string
+	// Details are extra lines, such as lint issues, printed under Message.
+	Details []string
+	// Hint says how to fix a WARN or FAIL.
+	Hint string
+}
+
+// runDoctorChecks runs every doctor check against the loaded config. If the
+// config cannot be loaded, the remaining checks use the defaults.
+func runDoctorChecks() []doctorCheck {
+	cfg, check := checkDoctorConfig()
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	checks := []doctorCheck{check, checkDoctorOSSandbox(cfg)}
+	checks = append(checks, checkDoctorRuntimes(cfg)...)
+	return append(checks, checkDoctorAWS(cfg))
+}
+
+// printDoctorReport writes checks to w and returns an error if any failed.
+func printDoctorReport(w io.Writer, checks []doctorCheck) error {
+	failed := 0
+	for _, c := range checks {
+		if c.Status == doctorFail {
+			failed++
+		}
+		fmt.Fprintf(w, "%s  %s: %s\n", c.Status, c.Name, c.Message)
+		for _, d := range c.Details {
+			fmt.Fprintf(w, "      %s\n", d)
+		}
+		if c.Hint != "" && c.Status != doctorPass {
+			fmt.Fprintf(w, "      hint: %s\n", c.Hint)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkDoctorConfig lints the config file and loads it with environment
+// overrides. It returns a nil config if loading fails.
+func checkDoctorConfig() (*config.Config, doctorCheck) {
+	check := doctorCheck{Name: "config"}
+	p, err := config.Path()
+	if err != nil {
+		check.Status = doctorFail
+		check.Message = err.Error()
+		check.Hint = "set LITE_SANDBOX_CONFIG to the config file path"
+		return nil, check
+	}
+
+	issues, lintErr := config.LintFile(p)
+	if lintErr != nil && !os.IsNotExist(lintErr) {
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("reading %s: %v", p, lintErr)
+		check.Hint = "check that the file is readable"
+		return nil, check
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("loading %s: %v", p, err)
+		check.Hint = "fix the config file or the LITE_SANDBOX_* environment variables"
+		return nil, check
+	}
+	if lintErr != nil {
+		check.Status = doctorPass
+		check.Message = fmt.Sprintf("%s does not exist; using defaults", p)
+		return cfg, check
+	}
+
+	errCount := 0
+	for _, i := range issues {
+		if i.Severity == config.LintError {
+			errCount++
+		}
+		check.Details = append(check.Details, i.String())
+	}
+	switch {
+	case errCount > 0:
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("%s has %d error(s) and %d warning(s)", p, errCount, len(issues)-errCount)
+		check.Hint = "fix the errors above; lite-sandbox config lint rechecks the file"
+	case len(issues) > 0:
+		check.Status = doctorWarn
+		check.Message = fmt.Sprintf("%s has %d warning(s)", p, len(issues))
+		check.Hint = "review the warnings above"
+	default:
+		check.Status = doctorPass
+		check.Message = fmt.Sprintf("%s has no issues", p)
+	}
+	return cfg, check
+}
+
+// osSandboxProblem returns why the OS sandbox cannot run on this system and
+// how to fix it, or empty strings if it can.
+func osSandboxProblem() (problem, hint string) {
+	switch doctorGOOS {
+	case "linux":
+		if _, err := doctorLookPath("bwrap"); err != nil {
+			return "bwrap was not found on PATH", "install bubblewrap (e.g. apt install bubblewrap or dnf install bubblewrap)"
+		}
+		// bwrap needs unprivileged user namespaces unless it is setuid.
+		// Either file may be absent, depending on the kernel.
+		if data, err := doctorReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && strings.TrimSpace(string(data)) == "0" {
+			return "unprivileged user namespaces are disabled (kernel.unprivileged_userns_clone=0)", "run sysctl -w kernel.unprivileged_userns_clone=1"
+		}
+		if data, err := doctorReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(data)) == "0" {
+			return "user namespaces are disabled (user.max_user_namespaces=0)", "run sysctl -w user.max_user_namespaces=15000"
+		}
+		return "", ""
+	case "darwin":
+		if _, err := doctorLookPath("sandbox-exec"); err != nil {
+			return "sandbox-exec was not found on PATH", "sandbox-exec ships with macOS in /usr/bin; add /usr/bin to PATH"
+		}
+		return "", ""
+	default:
+		return fmt.Sprintf("the OS sandbox is not supported on %s", doctorGOOS), "run lite-sandbox on Linux or macOS for OS-level isolation"
+	}
+}
+
+// checkDoctorOSSandbox checks whether the OS sandbox can run. A missing
+// dependency fails the check only if os_sandbox is enabled or required.
+func checkDoctorOSSandbox(cfg *config.Config) doctorCheck {
+	check := doctorCheck{Name: "os sandbox"}
+	if cfg.RequiresOSSandbox() && !cfg.OSSandboxEnabled() {
+		check.Status = doctorFail
+		check.Message = "os_sandbox_required is set but os_sandbox is disabled, so every command will fail"
+		check.Hint = "set os_sandbox: true"
+		return check
+	}
+	problem, hint := osSandboxProblem()
+	switch {
+	case problem != "" && cfg.OSSandboxEnabled():
+		check.Status = doctorFail
+		check.Message = "os_sandbox is enabled but " + problem
+		check.Hint = hint
+	case problem != "":
+		check.Status = doctorWarn
+		check.Message = "os_sandbox is disabled and could not be enabled: " + problem
+		check.Hint = hint
+	case cfg.OSSandboxEnabled():
+		check.Status = doctorPass
+		check.Message = "enabled"
+	default:
+		check.Status = doctorPass
+		check.Message = "available but disabled; set os_sandbox: true to enable it"
+	}
+	return check
+}
+
+// checkDoctorRuntimes checks that the tool of each enabled runtime is on
+// PATH, returning one check per enabled runtime.
+func checkDoctorRuntimes(cfg *config.Config) []doctorCheck {
+	r := cfg.Runtimes
+	if r == nil {
+		r = &config.RuntimesConfig{}
+	}
+	enabled := map[string]bool{
+		"go":   r.Go.GoEnabled(),
+		"pnpm": r.Pnpm.PnpmEnabled(),
+		"rust": r.Rust.RustEnabled(),
+	}
+	var checks []doctorCheck
+	for _, name := range []string{"go", "pnpm", "rust"} {
+		if !enabled[name] {
+			continue
+		}
+		bin := config.RuntimeTools[name]
+		check := doctorCheck{Name: "runtimes." + name}
+		if path, err := doctorLookPath(bin); err != nil {
+			check.Status = doctorFail
+			check.Message = fmt.Sprintf("enabled but %q was not found on PATH, so %s commands will fail", bin, bin)
+			check.Hint = fmt.Sprintf("install %s or disable runtimes.%s", bin, name)
+		} else {
+			check.Status = doctorPass
+			check.Message = fmt.Sprintf("%s found at %s", bin, path)
+		}
+		checks = append(checks, check)
+	}
+	if len(checks) == 0 {
+		checks = append(checks, doctorCheck{Name: "runtimes", Status: doctorPass, Message: "none enabled"})
+	}
+	return checks
+}
+
+// checkDoctorAWS checks that the aws CLI is on PATH and, with force_profile,
+// that the profile loads and the IMDS server can be created.
+func checkDoctorAWS(cfg *config.Config) doctorCheck {
+	check := doctorCheck{Name: "aws"}
+	a := cfg.AWS
+	if !a.AWSEnabled() {
+		check.Status = doctorPass
+		check.Message = "disabled"
+		return check
+	}
+	if _, err := doctorLookPath("aws"); err != nil {
+		check.Status = doctorFail
+		check.Message = `enabled but "aws" was not found on PATH`
+		check.Hint = "install the AWS CLI or remove the aws section"
+		return check
+	}
+	if !a.UsesIMDS() {
+		check.Status = doctorPass
+		check.Message = "enabled with raw credentials"
+		return check
+	}
+
+	if err := doctorLoadAWSProfile(context.Background(), a.IMDSProfile()); err != nil {
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("force_profile %q could not be loaded: %v", a.IMDSProfile(), err)
+		check.Hint = fmt.Sprintf("configure it with aws configure --profile %s", a.IMDSProfile())
+		return check
+	}
+	srv, err := newIMDSServer(a)
+	if err != nil {
+		check.Status = doctorFail
+		check.Message = err.Error()
+		check.Hint = "check aws.imds_address, aws.session_policy and aws.max_credential_ttl"
+		// A fixed imds_address may be in use by a running lite-sandbox.
+		if errors.Is(err, syscall.EADDRINUSE) {
+			check.Status = doctorWarn
+			check.Hint = "stop any other process listening on aws.imds_address, such as a running lite-sandbox serve"
+		}
+		return check
+	}
+	srv.Shutdown(context.Background())
+	check.Status = doctorPass
+	check.Message = fmt.Sprintf("force_profile %q is served over IMDS", a.IMDSProfile())
+	return check
+}
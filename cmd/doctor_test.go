@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+// stubDoctorDeps makes the doctor checks see a system running goos, with
+// only the binaries in found on PATH and files as the contents of /proc.
+func stubDoctorDeps(t *testing.T, goos string, found []string, files map[string]string) {
+	t.Helper()
+	origLookPath, origReadFile, origGOOS := doctorLookPath, doctorReadFile, doctorGOOS
+	t.Cleanup(func() {
+		doctorLookPath, doctorReadFile, doctorGOOS = origLookPath, origReadFile, origGOOS
+	})
+	doctorGOOS = goos
+	doctorLookPath = func(file string) (string, error) {
+		if slices.Contains(found, file) {
+			return "/usr/bin/" + file, nil
+		}
+		return "", errors.New("executable file not found in $PATH")
+	}
+	doctorReadFile = func(name string) ([]byte, error) {
+		if data, ok := files[name]; ok {
+			return []byte(data), nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+func TestCheckDoctorOSSandbox(t *testing.T) {
+	on := true
+	tests := []struct {
+		name     string
+		goos     string
+		found    []string
+		files    map[string]string
+		cfg      *config.Config
+		want     doctorStatus
+		contains string
+	}{
+		{"linux enabled", "linux", []string{"bwrap"}, nil, &config.Config{OSSandbox: &on}, doctorPass, "enabled"},
+		{"linux available but disabled", "linux", []string{"bwrap"}, nil, &config.Config{}, doctorPass, "available but disabled"},
+		{"bwrap missing and enabled", "linux", nil, nil, &config.Config{OSSandbox: &on}, doctorFail, "bwrap was not found"},
+		{"bwrap missing and disabled", "linux", nil, nil, &config.Config{}, doctorWarn, "bwrap was not found"},
+		{"userns clone disabled", "linux", []string{"bwrap"}, map[string]string{
+			"/proc/sys/kernel/unprivileged_userns_clone": "0\n",
+		}, &config.Config{OSSandbox: &on}, doctorFail, "unprivileged user namespaces are disabled"},
+		{"userns clone enabled", "linux", []string{"bwrap"}, map[string]string{
+			"/proc/sys/kernel/unprivileged_userns_clone": "1\n",
+			"/proc/sys/user/max_user_namespaces":         "63360\n",
+		}, &config.Config{OSSandbox: &on}, doctorPass, "enabled"},
+		{"no user namespaces", "linux", []string{"bwrap"}, map[string]string{
+			"/proc/sys/user/max_user_namespaces": "0\n",
+		}, &config.Config{OSSandbox: &on}, doctorFail, "max_user_namespaces=0"},
+		{"darwin enabled", "darwin", []string{"sandbox-exec"}, nil, &config.Config{OSSandbox: &on}, doctorPass, "enabled"},
+		{"sandbox-exec missing", "darwin", nil, nil, &config.Config{OSSandbox: &on}, doctorFail, "sandbox-exec was not found"},
+		{"unsupported platform", "windows", nil, nil, &config.Config{OSSandbox: &on}, doctorFail, "not supported on windows"},
+		{"required but disabled", "linux", []string{"bwrap"}, nil, &config.Config{OSSandboxRequired: &on}, doctorFail, "os_sandbox_required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubDoctorDeps(t, tt.goos, tt.found, tt.files)
+			check := checkDoctorOSSandbox(tt.cfg)
+			if check.Status != tt.want {
+				t.Errorf("expected %s, got %s: %s", tt.want, check.Status, check.Message)
+			}
+			if !strings.Contains(check.Message, tt.contains) {
+				t.Errorf("expected message containing %q, got %q", tt.contains, check.Message)
+			}
+			if check.Status != doctorPass && check.Hint == "" {
+				t.Error("expected a hint")
+			}
+		})
+	}
+}
+
+func TestCheckDoctorRuntimes(t *testing.T) {
+	on := true
+	stubDoctorDeps(t, "linux", []string{"cargo"}, nil)
+
+	checks := checkDoctorRuntimes(&config.Config{})
+	if len(checks) != 1 || checks[0].Status != doctorPass || checks[0].Message != "none enabled" {
+		t.Errorf("expected a single passing check with no runtimes enabled, got %+v", checks)
+	}
+
+	checks = checkDoctorRuntimes(&config.Config{Runtimes: &config.RuntimesConfig{
+		Go:   &config.GoConfig{Enabled: &on},
+		Rust: &config.RustConfig{Enabled: &on},
+	}})
+	if len(checks) != 2 {
+		t.Fatalf("expected a check per enabled runtime, got %+v", checks)
+	}
+	if checks[0].Name != "runtimes.go" || checks[0].Status != doctorFail || !strings.Contains(checks[0].Hint, "install go") {
+		t.Errorf("expected runtimes.go to fail with go missing, got %+v", checks[0])
+	}
+	if checks[1].Name != "runtimes.rust" || checks[1].Status != doctorPass || !strings.Contains(checks[1].Message, "/usr/bin/cargo") {
+		t.Errorf("expected runtimes.rust to pass with cargo found, got %+v", checks[1])
+	}
+}
+
+func TestCheckDoctorAWS(t *testing.T) {
+	on := true
+	origLoad := doctorLoadAWSProfile
+	t.Cleanup(func() { doctorLoadAWSProfile = origLoad })
+	doctorLoadAWSProfile = func(ctx context.Context, profile string) error {
+		if profile != "dev" {
+			return errors.New("failed to get shared config profile, " + profile)
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		found    []string
+		aws      *config.AWSConfig
+		want     doctorStatus
+		contains string
+	}{
+		{"disabled", nil, nil, doctorPass, "disabled"},
+		{"cli missing", nil, &config.AWSConfig{AllowRawCredentials: &on}, doctorFail, `"aws" was not found`},
+		{"raw credentials", []string{"aws"}, &config.AWSConfig{AllowRawCredentials: &on}, doctorPass, "raw credentials"},
+		{"force profile", []string{"aws"}, &config.AWSConfig{ForceProfile: "dev"}, doctorPass, `"dev" is served over IMDS`},
+		{"unknown profile", []string{"aws"}, &config.AWSConfig{ForceProfile: "prod"}, doctorFail, `"prod" could not be loaded`},
+		{"non-loopback imds address", []string{"aws"}, &config.AWSConfig{ForceProfile: "dev", IMDSAddr: "0.0.0.0:0"}, doctorFail, "not a loopback address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubDoctorDeps(t, "linux", tt.found, nil)
+			check := checkDoctorAWS(&config.Config{AWS: tt.aws})
+			if check.Status != tt.want {
+				t.Errorf("expected %s, got %s: %s", tt.want, check.Status, check.Message)
+			}
+			if !strings.Contains(check.Message, tt.contains) {
+				t.Errorf("expected message containing %q, got %q", tt.contains, check.Message)
+			}
+		})
+	}
+}
+
+func TestCheckDoctorConfig(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	t.Setenv("LITE_SANDBOX_CONFIG", configPath)
+
+	cfg, check := checkDoctorConfig()
+	if cfg == nil || check.Status != doctorPass || !strings.Contains(check.Message, "using defaults") {
+		t.Errorf("expected a missing config file to pass with defaults, got %+v", check)
+	}
+
+	tests := []struct {
+		name     string
+		yaml     string
+		want     doctorStatus
+		contains string
+	}{
+		{"clean", "extra_commands: [make]\n", doctorPass, "no issues"},
+		{"warning", "writable_paths: [" + filepath.Join(dir, "missing") + "]\n", doctorWarn, "1 warning(s)"},
+		{"lint error", "extra_commands: [curl]\ndenied_commands: [curl]\n", doctorFail, "1 error(s)"},
+		{"invalid yaml", "extra_commands: [\n", doctorFail, "loading"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, check := checkDoctorConfig()
+			if check.Status != tt.want {
+				t.Errorf("expected %s, got %s: %s", tt.want, check.Status, check.Message)
+			}
+			if !strings.Contains(check.Message, tt.contains) {
+				t.Errorf("expected message containing %q, got %q", tt.contains, check.Message)
+			}
+		})
+	}
+}
+
+func TestPrintDoctorReport(t *testing.T) {
+	var out bytes.Buffer
+	err := printDoctorReport(&out, []doctorCheck{
+		{Name: "config", Status: doctorPass, Message: "ok", Hint: "not shown"},
+		{Name: "os sandbox", Status: doctorWarn, Message: "disabled", Hint: "install bubblewrap"},
+	})
+	if err != nil {
+		t.Errorf("expected no error without failures, got %v", err)
+	}
+	want := "PASS  config: ok\nWARN  os sandbox: disabled\n      hint: install bubblewrap\n"
+	if out.String() != want {
+		t.Errorf("unexpected report:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	err = printDoctorReport(&out, []doctorCheck{
+		{Name: "runtimes.go", Status: doctorFail, Message: "go missing", Details: []string{"detail"}, Hint: "install go"},
+	})
+	if err == nil {
+		t.Error("expected an error when a check fails")
+	}
+	if !strings.Contains(out.String(), "FAIL  runtimes.go: go missing\n      detail\n      hint: install go\n") {
+		t.Errorf("unexpected report: %s", out.String())
+	}
+}
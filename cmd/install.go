@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/gartnera/lite-sandbox/config"
 )
 
 var installCmd = &cobra.Command{
@@ -49,6 +51,11 @@ func runInstall(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to access ~/.claude directory: %w", err)
 	}
 
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
 	// 1. Configure MCP server in ~/.claude.json (user-scoped)
 	claudeJsonPath := filepath.Join(homeDir, ".claude.json")
 	if err := configureMCPServer(claudeJsonPath, binPath); err != nil {
@@ -70,7 +77,7 @@ func runInstall(cmd *cobra.Command, args []string) error {
 
 	// 4. Configure preflight hook
 	settingsPath := filepath.Join(claudeDir, "settings.json")
-	if err := configurePreflightHook(settingsPath, binPath); err != nil {
+	if err := configurePreflightHook(settingsPath, binPath, cfg.PreflightToolNames()...); err != nil {
 		return fmt.Errorf("failed to configure preflight hook: %w", err)
 	}
 	fmt.Println("✓ Installed preflight hook to ~/.claude/settings.json")
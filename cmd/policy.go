@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
+	"github.com/spf13/cobra"
+)
+
+var policyFormat string
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Print the built-in command policy",
+	Long: `Print the commands the sandbox allows, grouped by category, the
+restrictions placed on their arguments, the commands gated by config, the
+environment variables that cannot be set and the redirection rules.
+
+The policy is generated from the tables used for validation. It does not
+include extra_commands or denied_commands from the config.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if policyFormat != "markdown" {
+			return fmt.Errorf("unsupported format %q (supported: markdown)", policyFormat)
+		}
+		return bash_sandboxed.WritePolicyMarkdown(cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	policyCmd.Flags().StringVar(&policyFormat, "format", "markdown", "output format (markdown)")
+	rootCmd.AddCommand(policyCmd)
+}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -14,7 +17,10 @@ import (
 	bash_sandboxed "github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
 )
 
-var preflightInstallFlag bool
+var (
+	preflightInstallFlag bool
+	preflightExplainFlag bool
+)
 
 var preflightCmd = &cobra.Command{
 	Use:   "preflight",
@@ -24,12 +30,19 @@ from stdin and denies Bash tool calls whose commands would pass sandbox validati
 redirecting Claude to use mcp__lite-sandbox__bash instead.
 
 When invoked from a terminal (or with --install), installs the hook into
-~/.claude/settings.json.`,
+~/.claude/settings.json.
+
+The hook is installed for, and handles, the tool names in preflight_tools
+(default: Bash).
+
+With --explain or LITE_SANDBOX_PREFLIGHT_DEBUG=1, the hook writes the reasoning
+behind its decision to stderr. The JSON on stdout is unchanged.`,
 	RunE: runPreflight,
 }
 
 func init() {
 	preflightCmd.Flags().BoolVar(&preflightInstallFlag, "install", false, "Install the preflight hook into ~/.claude/settings.json")
+	preflightCmd.Flags().BoolVar(&preflightExplainFlag, "explain", false, "Write the hook's reasoning to stderr")
 	rootCmd.AddCommand(preflightCmd)
 }
 
@@ -61,26 +74,53 @@ func runPreflight(cmd *cobra.Command, args []string) error {
 	return runPreflightHook()
 }
 
+// preflightDebugEnabled reports whether the hook should explain its decision
+// on stderr, via --explain or LITE_SANDBOX_PREFLIGHT_DEBUG.
+func preflightDebugEnabled() bool {
+	if preflightExplainFlag {
+		return true
+	}
+	debug, _ := strconv.ParseBool(os.Getenv("LITE_SANDBOX_PREFLIGHT_DEBUG"))
+	return debug
+}
+
+// preflightDebugf writes a diagnostic line to stderr if debugging is enabled.
+// Stdout is reserved for the hook's JSON response.
+func preflightDebugf(format string, args ...any) {
+	if preflightDebugEnabled() {
+		fmt.Fprintf(os.Stderr, "preflight: "+format+"\n", args...)
+	}
+}
+
 // runPreflightHook reads PreToolUse JSON from stdin and validates.
 // Fail-open: any error results in silent exit 0 (allow Bash).
 func runPreflightHook() error {
 	data, err := io.ReadAll(os.Stdin)
 	if err != nil {
+		preflightDebugf("failed to read input, allowing Bash: %v", err)
 		return nil // fail open
 	}
 
 	var input preflightHookInput
 	if err := json.Unmarshal(data, &input); err != nil {
+		preflightDebugf("failed to parse input, allowing Bash: %v", err)
 		return nil // fail open
 	}
 
-	// Only intercept Bash tool calls
-	if input.ToolName != "Bash" {
+	cfg, err := config.Load()
+	if err != nil {
+		preflightDebugf("using the default config: %v", err)
+	}
+
+	// Only intercept the configured Bash-like tools
+	if !slices.Contains(cfg.PreflightToolNames(), input.ToolName) {
+		preflightDebugf("tool %q is not in preflight_tools, allowing", input.ToolName)
 		return nil
 	}
 
 	// Escalate to user approval if the LLM is explicitly bypassing the sandbox
 	if input.ToolInput.DangerouslyDisableSandbox {
+		preflightDebugf("dangerouslyDisableSandbox is set, asking the user")
 		output := preflightHookOutput{}
 		output.HookSpecificOutput.HookEventName = "PreToolUse"
 		output.HookSpecificOutput.PermissionDecision = "ask"
@@ -92,35 +132,51 @@ func runPreflightHook() error {
 
 	command := input.ToolInput.Command
 	if command == "" {
+		preflightDebugf("empty command, allowing Bash")
 		return nil // fail open
 	}
 
 	cwd := input.CWD
 	if cwd == "" {
+		preflightDebugf("empty cwd, allowing Bash")
+		return nil // fail open
+	}
+	// Relative paths in the command resolve against cwd, so a relative cwd
+	// would make them resolve against the hook's own working directory.
+	if !filepath.IsAbs(cwd) {
+		preflightDebugf("cwd %q is not absolute, allowing Bash", cwd)
 		return nil // fail open
 	}
+	cwd = filepath.Clean(cwd)
+	preflightDebugf("validating command %q in %s", command, cwd)
 
-	// Create sandbox and load config
+	// Create sandbox and apply config
 	sandbox := bash_sandboxed.NewSandbox()
-	cfg, err := config.Load()
-	if err == nil && cfg != nil {
+	if cfg != nil {
 		sandbox.UpdateConfig(cfg, cwd)
 	}
 
 	// Construct paths the same way serve.go does (minus runtime paths)
 	readPaths := []string{cwd}
 	writePaths := []string{cwd}
+	if preflightDebugEnabled() {
+		effectiveRead, effectiveWrite := sandbox.EffectivePaths(readPaths, writePaths)
+		preflightDebugf("readable paths: %s", strings.Join(effectiveRead, ", "))
+		preflightDebugf("writable paths: %s", strings.Join(effectiveWrite, ", "))
+	}
 
 	// Validate against sandbox
 	if err := sandbox.ValidateCommand(command, cwd, readPaths, writePaths); err != nil {
+		preflightDebugf("validation failed, allowing Bash: %v", err)
 		return nil // command would fail in sandbox, allow Bash
 	}
+	preflightDebugf("validation passed, denying %s in favor of mcp__lite-sandbox__bash", input.ToolName)
 
-	// Command would pass sandbox validation — deny Bash and redirect
+	// Command would pass sandbox validation — deny the tool and redirect
 	output := preflightHookOutput{}
 	output.HookSpecificOutput.HookEventName = "PreToolUse"
 	output.HookSpecificOutput.PermissionDecision = "deny"
-	output.HookSpecificOutput.PermissionDecisionReason = "This command can run in the lite-sandbox. Use the mcp__lite-sandbox__bash tool instead of the built-in Bash tool."
+	output.HookSpecificOutput.PermissionDecisionReason = fmt.Sprintf("This command can run in the lite-sandbox. Use the mcp__lite-sandbox__bash tool instead of the built-in %s tool.", input.ToolName)
 
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
@@ -143,8 +199,13 @@ func runPreflightInstall() error {
 		return fmt.Errorf("failed to get home directory: %w", err)
 	}
 
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
 	settingsPath := filepath.Join(homeDir, ".claude", "settings.json")
-	if err := configurePreflightHook(settingsPath, binPath); err != nil {
+	if err := configurePreflightHook(settingsPath, binPath, cfg.PreflightToolNames()...); err != nil {
 		return fmt.Errorf("failed to configure preflight hook: %w", err)
 	}
 
@@ -165,8 +226,13 @@ type hookMatcher struct {
 }
 
 // configurePreflightHook merges the preflight hook into settings.json,
-// preserving all existing keys.
-func configurePreflightHook(settingsPath string, binPath string) error {
+// preserving all existing keys. The hook is installed with a matcher for
+// each of toolNames, or for config.DefaultPreflightTools if none are given.
+func configurePreflightHook(settingsPath string, binPath string, toolNames ...string) error {
+	if len(toolNames) == 0 {
+		toolNames = config.DefaultPreflightTools
+	}
+
 	cfg, err := readSettingsFile(settingsPath)
 	if err != nil {
 		return err
@@ -185,40 +251,10 @@ func configurePreflightHook(settingsPath string, binPath string) error {
 
 	hookCommand := binPath + " preflight"
 
-	// Check if our hook already exists in PreToolUse
 	preToolUseHooks := hooks["PreToolUse"]
-	found := false
-	for i, m := range preToolUseHooks {
-		if m.Matcher == "Bash" {
-			// Check if our command is already in this matcher's hooks
-			for _, h := range m.Hooks {
-				if h.Command == hookCommand {
-					found = true
-					break
-				}
-			}
-			if !found {
-				// Matcher exists but our command isn't there — add it
-				preToolUseHooks[i].Hooks = append(preToolUseHooks[i].Hooks, hookEntry{
-					Type:    "command",
-					Command: hookCommand,
-				})
-				found = true
-			}
-			break
-		}
-	}
-
-	if !found {
-		// Add new matcher entry
-		preToolUseHooks = append(preToolUseHooks, hookMatcher{
-			Matcher: "Bash",
-			Hooks: []hookEntry{
-				{Type: "command", Command: hookCommand},
-			},
-		})
+	for _, toolName := range toolNames {
+		preToolUseHooks = addPreflightHookMatcher(preToolUseHooks, toolName, hookCommand)
 	}
-
 	hooks["PreToolUse"] = preToolUseHooks
 
 	// Marshal hooks back into the config
@@ -230,3 +266,34 @@ func configurePreflightHook(settingsPath string, binPath string) error {
 
 	return writeSettingsFile(settingsPath, cfg)
 }
+
+// addPreflightHookMatcher adds hookCommand to the matcher for toolName,
+// adding the matcher if it does not exist. It does nothing if the matcher
+// already runs hookCommand.
+func addPreflightHookMatcher(matchers []hookMatcher, toolName, hookCommand string) []hookMatcher {
+	for i, m := range matchers {
+		if m.Matcher != toolName {
+			continue
+		}
+		// Check if our command is already in this matcher's hooks
+		for _, h := range m.Hooks {
+			if h.Command == hookCommand {
+				return matchers
+			}
+		}
+		// Matcher exists but our command isn't there — add it
+		matchers[i].Hooks = append(matchers[i].Hooks, hookEntry{
+			Type:    "command",
+			Command: hookCommand,
+		})
+		return matchers
+	}
+
+	// Add new matcher entry
+	return append(matchers, hookMatcher{
+		Matcher: toolName,
+		Hooks: []hookEntry{
+			{Type: "command", Command: hookCommand},
+		},
+	})
+}
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -102,10 +104,116 @@ func TestPreflightHookEmptyCommand(t *testing.T) {
 	}
 }
 
+func TestPreflightHookConfiguredTools(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("LITE_SANDBOX_CONFIG", configPath)
+	input := preflightHookInput{
+		ToolName: "Shell",
+		CWD:      t.TempDir(),
+	}
+	input.ToolInput.Command = "echo hello"
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shell is not handled by default
+	if output := capturePreflightHook(t, inputJSON); output != "" {
+		t.Errorf("expected empty output for Shell without preflight_tools, got: %s", output)
+	}
+
+	if err := os.WriteFile(configPath, []byte("preflight_tools: [Bash, Shell]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := capturePreflightHook(t, inputJSON)
+	var resp preflightHookOutput
+	if err := json.Unmarshal([]byte(output), &resp); err != nil {
+		t.Fatalf("failed to parse response JSON: %v", err)
+	}
+	if resp.HookSpecificOutput.PermissionDecision != "deny" {
+		t.Errorf("expected deny, got %s", resp.HookSpecificOutput.PermissionDecision)
+	}
+	if !strings.Contains(resp.HookSpecificOutput.PermissionDecisionReason, "built-in Shell tool") {
+		t.Errorf("expected the reason to name the Shell tool, got %q", resp.HookSpecificOutput.PermissionDecisionReason)
+	}
+
+	// Tools not in preflight_tools are still ignored
+	input.ToolName = "Read"
+	inputJSON, err = json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output := capturePreflightHook(t, inputJSON); output != "" {
+		t.Errorf("expected empty output for Read, got: %s", output)
+	}
+}
+
+func TestPreflightHookCWD(t *testing.T) {
+	t.Setenv("LITE_SANDBOX_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	cwd := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cwd, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cwd      string
+		command  string
+		wantDeny bool
+	}{
+		{"empty cwd", "", "echo hello", false},
+		{"relative cwd", "relative/dir", "echo hello", false},
+		{"dot cwd", ".", "cat file.txt", false},
+		{"absolute cwd", cwd, "cat file.txt", true},
+		{"unclean absolute cwd", cwd + "/./", "cat file.txt", true},
+		{"paths default to cwd", cwd, "cat " + filepath.Join(outside, "file.txt"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := preflightHookInput{
+				ToolName: "Bash",
+				CWD:      tt.cwd,
+			}
+			input.ToolInput.Command = tt.command
+			inputJSON, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := capturePreflightHook(t, inputJSON)
+			if !tt.wantDeny {
+				if output != "" {
+					t.Errorf("expected empty output, got: %s", output)
+				}
+				return
+			}
+			var resp preflightHookOutput
+			if err := json.Unmarshal([]byte(output), &resp); err != nil {
+				t.Fatalf("failed to parse response JSON: %v", err)
+			}
+			if resp.HookSpecificOutput.PermissionDecision != "deny" {
+				t.Errorf("expected deny, got %s", resp.HookSpecificOutput.PermissionDecision)
+			}
+		})
+	}
+}
+
 // capturePreflightHook simulates the hook by redirecting stdin/stdout
 // and calling runPreflightHook, then returning captured stdout.
 func capturePreflightHook(t *testing.T, inputData []byte) string {
 	t.Helper()
+	stdout, _ := capturePreflightHookOutput(t, inputData)
+	return stdout
+}
+
+// capturePreflightHookOutput is like capturePreflightHook but also returns
+// captured stderr.
+func capturePreflightHookOutput(t *testing.T, inputData []byte) (string, string) {
+	t.Helper()
 
 	// Create a pipe to simulate stdin
 	stdinR, stdinW, err := os.Pipe()
@@ -119,17 +227,23 @@ func capturePreflightHook(t *testing.T, inputData []byte) string {
 	}
 	stdinW.Close()
 
-	// Create a pipe to capture stdout
+	// Create pipes to capture stdout and stderr
 	stdoutR, stdoutW, err := os.Pipe()
 	if err != nil {
 		t.Fatal(err)
 	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Swap stdin/stdout
+	// Swap stdin/stdout/stderr
 	oldStdin := os.Stdin
 	oldStdout := os.Stdout
+	oldStderr := os.Stderr
 	os.Stdin = stdinR
 	os.Stdout = stdoutW
+	os.Stderr = stderrW
 
 	// Run the hook
 	_ = runPreflightHook()
@@ -137,12 +251,86 @@ func capturePreflightHook(t *testing.T, inputData []byte) string {
 	// Restore and close
 	os.Stdin = oldStdin
 	os.Stdout = oldStdout
+	os.Stderr = oldStderr
 	stdoutW.Close()
+	stderrW.Close()
 
-	buf := make([]byte, 4096)
-	n, _ := stdoutR.Read(buf)
+	stdout, _ := io.ReadAll(stdoutR)
 	stdoutR.Close()
-	return string(buf[:n])
+	stderr, _ := io.ReadAll(stderrR)
+	stderrR.Close()
+	return string(stdout), string(stderr)
+}
+
+func TestPreflightHookDebug(t *testing.T) {
+	t.Setenv("LITE_SANDBOX_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	cwd := t.TempDir()
+	hookInput := func(command string) []byte {
+		input := preflightHookInput{
+			ToolName: "Bash",
+			CWD:      cwd,
+		}
+		input.ToolInput.Command = command
+		inputJSON, err := json.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inputJSON
+	}
+
+	t.Run("quiet by default", func(t *testing.T) {
+		t.Setenv("LITE_SANDBOX_PREFLIGHT_DEBUG", "")
+		stdout, stderr := capturePreflightHookOutput(t, hookInput("echo hello"))
+		if stdout == "" {
+			t.Error("expected a deny response on stdout")
+		}
+		if stderr != "" {
+			t.Errorf("expected empty stderr, got: %s", stderr)
+		}
+	})
+
+	t.Run("valid command", func(t *testing.T) {
+		t.Setenv("LITE_SANDBOX_PREFLIGHT_DEBUG", "1")
+		stdout, stderr := capturePreflightHookOutput(t, hookInput("echo hello"))
+		var resp preflightHookOutput
+		if err := json.Unmarshal([]byte(stdout), &resp); err != nil {
+			t.Fatalf("expected stdout to be only the JSON response: %v\n%s", err, stdout)
+		}
+		if resp.HookSpecificOutput.PermissionDecision != "deny" {
+			t.Errorf("expected deny, got %s", resp.HookSpecificOutput.PermissionDecision)
+		}
+		for _, want := range []string{
+			`validating command "echo hello" in ` + cwd,
+			"readable paths: " + cwd,
+			"writable paths: " + cwd,
+			"validation passed",
+		} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("expected stderr to contain %q, got: %s", want, stderr)
+			}
+		}
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		t.Setenv("LITE_SANDBOX_PREFLIGHT_DEBUG", "1")
+		stdout, stderr := capturePreflightHookOutput(t, hookInput("python script.py"))
+		if stdout != "" {
+			t.Errorf("expected empty stdout for invalid command, got: %s", stdout)
+		}
+		if !strings.Contains(stderr, "validation failed, allowing Bash") || !strings.Contains(stderr, "python") {
+			t.Errorf("expected stderr to explain the validation failure, got: %s", stderr)
+		}
+	})
+
+	t.Run("explain flag", func(t *testing.T) {
+		t.Setenv("LITE_SANDBOX_PREFLIGHT_DEBUG", "")
+		preflightExplainFlag = true
+		defer func() { preflightExplainFlag = false }()
+		_, stderr := capturePreflightHookOutput(t, []byte("{invalid json"))
+		if !strings.Contains(stderr, "failed to parse input") {
+			t.Errorf("expected stderr to explain the parse failure, got: %s", stderr)
+		}
+	})
 }
 
 func TestPreflightHookScriptWithBlockedCommand(t *testing.T) {
@@ -386,3 +574,43 @@ func TestConfigurePreflightHookUpdatesExistingPath(t *testing.T) {
 		t.Fatalf("expected 2 hooks (old + new path), got %d", len(preToolUse[0].Hooks))
 	}
 }
+
+func TestConfigurePreflightHookMultipleTools(t *testing.T) {
+	tmpDir := t.TempDir()
+	settingsPath := filepath.Join(tmpDir, "settings.json")
+
+	// Run twice to check that each matcher is added once
+	for range 2 {
+		if err := configurePreflightHook(settingsPath, "/usr/local/bin/lite-sandbox", "Bash", "Shell"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(settingsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var hooks map[string][]hookMatcher
+	if err := json.Unmarshal(raw["hooks"], &hooks); err != nil {
+		t.Fatal(err)
+	}
+
+	preToolUse := hooks["PreToolUse"]
+	if len(preToolUse) != 2 {
+		t.Fatalf("expected 2 PreToolUse matchers, got %d", len(preToolUse))
+	}
+	for i, want := range []string{"Bash", "Shell"} {
+		if preToolUse[i].Matcher != want {
+			t.Errorf("expected matcher %s, got %s", want, preToolUse[i].Matcher)
+		}
+		if len(preToolUse[i].Hooks) != 1 || preToolUse[i].Hooks[0].Command != "/usr/local/bin/lite-sandbox preflight" {
+			t.Errorf("expected one preflight hook for %s, got %+v", want, preToolUse[i].Hooks)
+		}
+	}
+}
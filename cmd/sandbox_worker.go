@@ -5,16 +5,19 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var sandboxWorkerAllowedDirs []string
+
 var sandboxWorkerCmd = &cobra.Command{
 	Use:           "sandbox-worker",
 	Short:         "Run as a sandbox worker process (internal, runs inside bwrap)",
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return os_sandbox.RunWorker()
+		return os_sandbox.RunWorker(sandboxWorkerAllowedDirs)
 	},
 }
 
 func init() {
+	sandboxWorkerCmd.Flags().StringArrayVar(&sandboxWorkerAllowedDirs, "allowed-dir", nil, "Directory commands may run in (repeatable)")
 	rootCmd.AddCommand(sandboxWorkerCmd)
 }
@@ -16,12 +16,17 @@ import (
 
 	"github.com/gartnera/lite-sandbox/config"
 	"github.com/gartnera/lite-sandbox/internal/imds"
+	"github.com/gartnera/lite-sandbox/internal/status"
 	bash_sandboxed "github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
 )
 
 const runtimeErrorFallbackHint = "\n\nNote: This command passed sandbox validation but failed during execution. " +
 	"You may retry this command using the Bash tool with dangerouslyDisableSandbox set to true."
 
+// base64OutputNote precedes command output that was not valid UTF-8 and is
+// returned base64-encoded.
+const base64OutputNote = "output is not valid UTF-8 and is base64-encoded:\n"
+
 var serveCmd = &cobra.Command{
 	Use:   "serve-mcp",
 	Short: "Start the MCP server over stdio",
@@ -92,18 +97,27 @@ func newMCPServer(sandbox *bash_sandboxed.Sandbox) *server.MCPServer {
 		readPaths := append([]string{cwd}, sandbox.RuntimeReadPaths()...)
 		readPaths = append(readPaths, sandbox.ConfigReadPaths()...)
 		writePaths := append([]string{cwd}, sandbox.ConfigWritePaths()...)
-		output, err := sandbox.Execute(timeoutCtx, command, cwd, readPaths, writePaths)
-		if err != nil {
+		result := sandbox.ExecuteDetailed(timeoutCtx, command, cwd, readPaths, writePaths, bash_sandboxed.ExecuteOptions{})
+		if err := result.Err; err != nil {
 			errMsg := err.Error()
 			var cmdErr *bash_sandboxed.CommandFailedError
 			var exitStatus interp.ExitStatus
-			if errors.As(err, &cmdErr) && !errors.As(err, &exitStatus) {
-				errMsg += runtimeErrorFallbackHint
+			if errors.As(err, &cmdErr) {
+				if result.Encoding == bash_sandboxed.EncodingBase64 {
+					// Do not put the raw, invalid UTF-8 output in the message.
+					errMsg = fmt.Sprintf("command failed: %v\n%s%s", cmdErr.Err, base64OutputNote, result.Output)
+				}
+				if !errors.As(err, &exitStatus) {
+					errMsg += runtimeErrorFallbackHint
+				}
 			}
 			return mcp.NewToolResultError(errMsg), nil
 		}
 
-		return mcp.NewToolResultText(output), nil
+		if result.Encoding == bash_sandboxed.EncodingBase64 {
+			return mcp.NewToolResultText(base64OutputNote + result.Output), nil
+		}
+		return mcp.NewToolResultText(result.Output), nil
 	})
 	return s
 }
@@ -134,10 +148,10 @@ func runServe() error {
 	// Start IMDS server if AWS uses IMDS (force_profile is set)
 	var imdsServer *imds.Server
 	if cfg != nil && cfg.AWS != nil && cfg.AWS.UsesIMDS() {
-		// Use port 0 to get a random available port
-		imdsServer, err = imds.NewServer("127.0.0.1:0", cfg.AWS.IMDSProfile())
+		// Listens on a random loopback port unless aws.imds_address is set
+		imdsServer, err = newIMDSServer(cfg.AWS)
 		if err != nil {
-			return fmt.Errorf("failed to create IMDS server: %w", err)
+			return err
 		}
 
 		// Start IMDS server in background
@@ -159,6 +173,35 @@ func runServe() error {
 		sandbox.SetIMDSEndpoint(imdsServer.Endpoint())
 	}
 
+	// Start the status server if status_addr is set. Like the IMDS server,
+	// it is not restarted when the config changes.
+	if cfg != nil && cfg.StatusAddr != "" {
+		statusServer, err := status.NewServer(cfg.StatusAddr, sandbox)
+		if err != nil {
+			return fmt.Errorf("failed to create status server: %w", err)
+		}
+		go func() {
+			if err := statusServer.Start(); err != nil && err != http.ErrServerClosed {
+				slog.Error("status server failed", "error", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			if err := statusServer.Shutdown(shutdownCtx); err != nil {
+				slog.Error("failed to shutdown status server", "error", err)
+			}
+		}()
+	}
+
+	// Start the OS sandbox worker now so the first tool call doesn't pay the
+	// startup latency. Failures are logged here; Execute retries on demand.
+	go func() {
+		if err := sandbox.Warmup(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to warm up OS sandbox worker", "error", err)
+		}
+	}()
+
 	go func() {
 		err := config.Watch(ctx, func(newCfg *config.Config) {
 			sandbox.UpdateConfig(newCfg, cwd)
@@ -188,3 +231,39 @@ func runServe() error {
 	s := newMCPServer(sandbox)
 	return server.ServeStdio(s)
 }
+
+// newIMDSServer creates an IMDS server for the AWS config's force_profile,
+// listen address, credential scoping and TTL settings. The caller starts it.
+func newIMDSServer(a *config.AWSConfig) (*imds.Server, error) {
+	var opts []imds.ServerOption
+	if a.AllowsNonLoopbackIMDS() {
+		opts = append(opts, imds.AllowNonLoopback())
+	}
+	srv, err := imds.NewServer(a.IMDSAddress(), a.IMDSProfile(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMDS server: %w", err)
+	}
+	if err := srv.SetSessionPolicy(imdsSessionPolicy(a)); err != nil {
+		srv.Shutdown(context.Background())
+		return nil, fmt.Errorf("invalid aws session policy: %w", err)
+	}
+	if err := srv.SetMaxCredentialTTL(a.MaxCredentialTTL); err != nil {
+		srv.Shutdown(context.Background())
+		return nil, fmt.Errorf("invalid aws.max_credential_ttl: %w", err)
+	}
+	return srv, nil
+}
+
+// imdsSessionPolicy converts the AWS config's role_arn and session policy
+// settings for the IMDS server. It returns nil (pass-through) when none are
+// set.
+func imdsSessionPolicy(a *config.AWSConfig) *imds.SessionPolicy {
+	if a == nil || (a.RoleARN == "" && !a.HasSessionPolicy()) {
+		return nil
+	}
+	return &imds.SessionPolicy{
+		RoleARN:    a.RoleARN,
+		Policy:     a.SessionPolicy,
+		PolicyARNs: a.SessionPolicyARNs,
+	}
+}
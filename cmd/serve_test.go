@@ -316,3 +316,48 @@ func TestBashSandboxedTool_NegativeTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestBashSandboxedTool_BinaryOutputBase64(t *testing.T) {
+	c := setupClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		isError bool
+	}{
+		{"text", "echo hello", "hello\n", false},
+		{"invalid utf-8", `printf '\xff\xfe'`, base64OutputNote + "//4=", false},
+		{"invalid utf-8 on failure", `printf '\xff'; false`, base64OutputNote + "/w==", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := c.CallTool(ctx, mcp.CallToolRequest{
+				Params: mcp.CallToolParams{
+					Name:      "bash",
+					Arguments: map[string]any{"command": tt.command},
+				},
+			})
+			if err != nil {
+				t.Fatalf("CallTool failed: %v", err)
+			}
+			if result.IsError != tt.isError {
+				t.Fatalf("IsError = %v, want %v", result.IsError, tt.isError)
+			}
+			text, ok := result.Content[0].(mcp.TextContent)
+			if !ok {
+				t.Fatalf("expected text content, got %T", result.Content[0])
+			}
+			if tt.isError {
+				if !strings.Contains(text.Text, tt.want) || strings.Contains(text.Text, "\xff") {
+					t.Fatalf("expected error containing %q without raw bytes, got %q", tt.want, text.Text)
+				}
+				return
+			}
+			if text.Text != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, text.Text)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -19,15 +20,30 @@ import (
 	bash_sandboxed "github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
 )
 
+var (
+	shellCommandsFile    string
+	shellContinueOnError bool
+)
+
 var shellCmd = &cobra.Command{
 	Use:   "shell",
 	Short: "Start an interactive sandbox shell",
+	Long: `Start an interactive sandbox shell.
+
+With --commands-file, the commands in the file are run as discrete steps
+instead: each command is validated and executed in a fresh interpreter, so
+variables and cd do not carry over. Execution stops at the first failure
+unless --continue-on-error is set.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Failures are reported per command; usage is not helpful here.
+		cmd.SilenceUsage = true
 		return runShell()
 	},
 }
 
 func init() {
+	shellCmd.Flags().StringVar(&shellCommandsFile, "commands-file", "", "run commands from a file (one per line) in batch mode")
+	shellCmd.Flags().BoolVar(&shellContinueOnError, "continue-on-error", false, "with --commands-file, keep running after a command fails")
 	rootCmd.AddCommand(shellCmd)
 }
 
@@ -50,9 +66,9 @@ func runShell() error {
 	// Start IMDS server if AWS uses IMDS (force_profile is set)
 	var imdsServer *imds.Server
 	if cfg != nil && cfg.AWS != nil && cfg.AWS.UsesIMDS() {
-		imdsServer, err = imds.NewServer("127.0.0.1:0", cfg.AWS.IMDSProfile())
+		imdsServer, err = newIMDSServer(cfg.AWS)
 		if err != nil {
-			return fmt.Errorf("failed to create IMDS server: %w", err)
+			return err
 		}
 
 		// Start IMDS server in background
@@ -86,6 +102,10 @@ func runShell() error {
 	readPaths := append([]string{startDir}, sandbox.RuntimeReadPaths()...)
 	writePaths := []string{startDir}
 
+	if shellCommandsFile != "" {
+		return runCommandsFile(ctx, sandbox, shellCommandsFile, workDir, readPaths, writePaths)
+	}
+
 	parser := syntax.NewParser(syntax.Variant(syntax.LangBash))
 
 	for {
@@ -189,3 +209,74 @@ func changeDir(workDir, prevDir, target string, allowedPaths []string) (string,
 
 	return resolved, workDir
 }
+
+// runCommandsFile executes the commands in path as a batch, printing each
+// command's output as it is collected.
+func runCommandsFile(ctx context.Context, sandbox *bash_sandboxed.Sandbox, path, workDir string, readPaths, writePaths []string) error {
+	commands, err := readCommandsFile(path)
+	if err != nil {
+		return err
+	}
+	results, err := sandbox.ExecuteBatch(ctx, commands, workDir, readPaths, writePaths, !shellContinueOnError)
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(os.Stderr, "sandbox:%s$ %s\n", workDir, r.Command)
+		if r.Output != "" {
+			fmt.Print(r.Output)
+		}
+		if r.Err != nil {
+			failed++
+			// The output was already printed above; report only the cause.
+			var cfe *bash_sandboxed.CommandFailedError
+			if errors.As(r.Err, &cfe) {
+				fmt.Fprintln(os.Stderr, cfe.Err)
+			} else {
+				fmt.Fprintln(os.Stderr, r.Err)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d commands failed", failed, len(commands))
+	}
+	return err
+}
+
+// readCommandsFile reads one command per line from path. Blank lines and
+// lines starting with # are skipped. A command whose parse is incomplete
+// (e.g. an open if or quote) continues onto the following lines.
+func readCommandsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open commands file: %w", err)
+	}
+	defer f.Close()
+
+	parser := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	scanner := bufio.NewScanner(f)
+	var commands []string
+	var accumulated string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if accumulated == "" {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			accumulated = line
+		} else {
+			accumulated += "\n" + line
+		}
+		if _, err := parser.Parse(strings.NewReader(accumulated), ""); err != nil && syntax.IsIncomplete(err) {
+			continue
+		}
+		commands = append(commands, strings.TrimSpace(accumulated))
+		accumulated = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read commands file: %w", err)
+	}
+	if accumulated != "" {
+		commands = append(commands, strings.TrimSpace(accumulated))
+	}
+	return commands, nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCommandsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.sh")
+	content := "# setup\necho a\n\n  echo b  \nif true; then\n  echo c\nfi\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCommandsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"echo a", "echo b", "if true; then\n  echo c\nfi"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d commands, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadCommandsFile_Missing(t *testing.T) {
+	if _, err := readCommandsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v3"
@@ -19,6 +21,77 @@ type GitConfig struct {
 	LocalWrite  *bool `yaml:"local_write,omitempty"`
 	RemoteRead  *bool `yaml:"remote_read,omitempty"`
 	RemoteWrite *bool `yaml:"remote_write,omitempty"`
+	// AllowDestructive allows local write operations that discard work:
+	// git clean, git reset --hard, git stash drop/clear and git checkout -f.
+	AllowDestructive *bool `yaml:"allow_destructive,omitempty"`
+	// PerPath overrides these permissions for repositories whose directory
+	// matches a glob such as "~/work/trusted/*". Fields left unset in an
+	// override inherit from the enclosing git section.
+	PerPath map[string]*GitConfig `yaml:"per_path,omitempty"`
+}
+
+// ForDir returns the git permissions for a command run in dir: the PerPath
+// override whose glob matches dir or one of its parents, merged over g. When
+// several globs match, the longest wins. It returns g if none match or dir
+// is empty (unknown).
+func (g *GitConfig) ForDir(dir string) *GitConfig {
+	if g == nil || len(g.PerPath) == 0 || dir == "" {
+		return g
+	}
+	dir = filepath.Clean(dir)
+	best, found := "", false
+	for pattern := range g.PerPath {
+		if found && (len(pattern) < len(best) || len(pattern) == len(best) && pattern > best) {
+			continue
+		}
+		expanded := expandPaths([]string{pattern})
+		if len(expanded) == 0 || !matchDirGlob(expanded[0], dir) {
+			continue
+		}
+		best, found = pattern, true
+	}
+	if !found {
+		return g
+	}
+	merged := &GitConfig{
+		LocalRead:        g.LocalRead,
+		LocalWrite:       g.LocalWrite,
+		RemoteRead:       g.RemoteRead,
+		RemoteWrite:      g.RemoteWrite,
+		AllowDestructive: g.AllowDestructive,
+	}
+	if o := g.PerPath[best]; o != nil {
+		if o.LocalRead != nil {
+			merged.LocalRead = o.LocalRead
+		}
+		if o.LocalWrite != nil {
+			merged.LocalWrite = o.LocalWrite
+		}
+		if o.RemoteRead != nil {
+			merged.RemoteRead = o.RemoteRead
+		}
+		if o.RemoteWrite != nil {
+			merged.RemoteWrite = o.RemoteWrite
+		}
+		if o.AllowDestructive != nil {
+			merged.AllowDestructive = o.AllowDestructive
+		}
+	}
+	return merged
+}
+
+// matchDirGlob reports whether dir or one of its parents matches pattern.
+func matchDirGlob(pattern, dir string) bool {
+	for {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
 }
 
 // GitLocalRead returns whether local read git operations are allowed (default: true).
@@ -53,10 +126,22 @@ func (g *GitConfig) GitRemoteWrite() bool {
 	return *g.RemoteWrite
 }
 
+// GitAllowDestructive returns whether local write git operations that discard
+// work, such as git clean and git reset --hard, are allowed (default: true).
+// They also need local write.
+func (g *GitConfig) GitAllowDestructive() bool {
+	if g == nil || g.AllowDestructive == nil {
+		return true
+	}
+	return *g.AllowDestructive
+}
+
 // GoConfig controls granular Go runtime permission levels.
 type GoConfig struct {
-	Enabled  *bool `yaml:"enabled,omitempty"`
-	Generate *bool `yaml:"generate,omitempty"`
+	Enabled    *bool `yaml:"enabled,omitempty"`
+	Generate   *bool `yaml:"generate,omitempty"`
+	AllowRun   *bool `yaml:"allow_run,omitempty"`
+	AllowFetch *bool `yaml:"allow_fetch,omitempty"`
 }
 
 // GoEnabled returns whether go commands are allowed (default: false).
@@ -75,6 +160,23 @@ func (g *GoConfig) GoGenerate() bool {
 	return *g.Generate
 }
 
+// GoRun returns whether go run is allowed (default: false).
+func (g *GoConfig) GoRun() bool {
+	if g == nil || g.AllowRun == nil {
+		return false
+	}
+	return *g.AllowRun
+}
+
+// GoFetch returns whether go get and go install of remote packages
+// (pkg@version) are allowed (default: false).
+func (g *GoConfig) GoFetch() bool {
+	if g == nil || g.AllowFetch == nil {
+		return false
+	}
+	return *g.AllowFetch
+}
+
 // PnpmConfig controls granular pnpm runtime permission levels.
 type PnpmConfig struct {
 	Enabled *bool `yaml:"enabled,omitempty"`
@@ -101,9 +203,23 @@ func (p *PnpmConfig) PnpmPublish() bool {
 // Two modes:
 //  1. allow_raw_credentials: true - AWS CLI reads from ~/.aws/credentials directly (no blocking)
 //  2. force_profile: "name" - AWS CLI uses IMDS server with specified profile (blocks ~/.aws/)
+//
+// In force_profile mode, role_arn makes the IMDS server assume that role with
+// the profile's credentials, passing session_policy (an inline IAM policy
+// document) and session_policy_arns as session policies so the credentials
+// handed to sandboxed commands are scoped down. max_credential_ttl (e.g.
+// "15m") caps how long those credentials are reported valid before the IMDS
+// server rotates them. imds_address sets where the IMDS server listens; it must
+// be loopback unless imds_allow_non_loopback is true.
 type AWSConfig struct {
-	AllowRawCredentials *bool  `yaml:"allow_raw_credentials,omitempty"`
-	ForceProfile        string `yaml:"force_profile,omitempty"`
+	AllowRawCredentials  *bool         `yaml:"allow_raw_credentials,omitempty"`
+	ForceProfile         string        `yaml:"force_profile,omitempty"`
+	RoleARN              string        `yaml:"role_arn,omitempty"`
+	SessionPolicy        string        `yaml:"session_policy,omitempty"`
+	SessionPolicyARNs    []string      `yaml:"session_policy_arns,omitempty"`
+	MaxCredentialTTL     time.Duration `yaml:"max_credential_ttl,omitempty"`
+	IMDSAddr             string        `yaml:"imds_address,omitempty"`
+	IMDSAllowNonLoopback *bool         `yaml:"imds_allow_non_loopback,omitempty"`
 }
 
 // AWSEnabled returns whether aws commands are allowed at all (default: false).
@@ -139,10 +255,48 @@ func (a *AWSConfig) IMDSProfile() string {
 	return a.ForceProfile
 }
 
+// DefaultIMDSAddress is the IMDS listen address when imds_address is unset.
+const DefaultIMDSAddress = "127.0.0.1:0"
+
+// IMDSAddress returns the address the IMDS server listens on
+// (default: 127.0.0.1 on a random port).
+func (a *AWSConfig) IMDSAddress() string {
+	if a == nil || a.IMDSAddr == "" {
+		return DefaultIMDSAddress
+	}
+	return a.IMDSAddr
+}
+
+// AllowsNonLoopbackIMDS returns whether the IMDS server may listen on a
+// non-loopback address (default: false).
+func (a *AWSConfig) AllowsNonLoopbackIMDS() bool {
+	if a == nil || a.IMDSAllowNonLoopback == nil {
+		return false
+	}
+	return *a.IMDSAllowNonLoopback
+}
+
+// HasSessionPolicy returns whether an inline or managed session policy is
+// configured.
+func (a *AWSConfig) HasSessionPolicy() bool {
+	if a == nil {
+		return false
+	}
+	return a.SessionPolicy != "" || len(a.SessionPolicyARNs) > 0
+}
+
 // LocalBinaryExecutionConfig controls whether direct path execution
 // (./binary, ../binary, /path/to/binary) is allowed.
 type LocalBinaryExecutionConfig struct {
 	Enabled *bool `yaml:"enabled,omitempty"`
+	// AllowedPaths are the directories compiled binaries may be executed
+	// from. Relative entries are resolved against the working directory.
+	// Defaults to the working directory.
+	AllowedPaths []string `yaml:"allowed_paths,omitempty"`
+	// AllowedScripts, if set, limits direct execution to the paths matching
+	// one of these glob patterns, whether or not Enabled is set. Relative
+	// patterns are resolved against the working directory.
+	AllowedScripts []string `yaml:"allowed_scripts,omitempty"`
 }
 
 // IsEnabled returns whether local binary execution is allowed (default: false).
@@ -153,10 +307,61 @@ func (l *LocalBinaryExecutionConfig) IsEnabled() bool {
 	return *l.Enabled
 }
 
+// AllowsDirectExecution returns whether any path may be executed directly:
+// either local binary execution is enabled or AllowedScripts is set.
+func (l *LocalBinaryExecutionConfig) AllowsDirectExecution() bool {
+	return l.IsEnabled() || (l != nil && len(l.AllowedScripts) > 0)
+}
+
+// ScriptPatterns returns AllowedScripts with ~ expanded and relative entries
+// resolved against workDir. Relative entries are kept as they are if workDir
+// is empty. It returns nil if any path may be executed.
+func (l *LocalBinaryExecutionConfig) ScriptPatterns(workDir string) []string {
+	if l == nil || len(l.AllowedScripts) == 0 {
+		return nil
+	}
+	home, _ := os.UserHomeDir()
+	result := make([]string, 0, len(l.AllowedScripts))
+	for _, p := range l.AllowedScripts {
+		if home != "" && (p == "~" || strings.HasPrefix(p, "~/")) {
+			p = filepath.Join(home, p[1:])
+		} else if !filepath.IsAbs(p) && workDir != "" {
+			p = filepath.Join(workDir, p)
+		}
+		result = append(result, filepath.Clean(p))
+	}
+	return result
+}
+
+// BinaryPaths returns AllowedPaths, or just the working directory if it is
+// empty, with ~ expanded and relative entries resolved against workDir.
+func (l *LocalBinaryExecutionConfig) BinaryPaths(workDir string) []string {
+	paths := []string{"."}
+	if l != nil && len(l.AllowedPaths) > 0 {
+		paths = l.AllowedPaths
+	}
+	home, _ := os.UserHomeDir()
+	result := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if home != "" && (p == "~" || strings.HasPrefix(p, "~/")) {
+			p = filepath.Join(home, p[1:])
+		} else if !filepath.IsAbs(p) {
+			p = filepath.Join(workDir, p)
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		result = append(result, abs)
+	}
+	return result
+}
+
 // RustConfig controls granular Rust runtime permission levels.
 type RustConfig struct {
 	Enabled *bool `yaml:"enabled,omitempty"`
 	Publish *bool `yaml:"publish,omitempty"`
+	Install *bool `yaml:"install,omitempty"`
 }
 
 // RustEnabled returns whether cargo/rustc commands are allowed (default: false).
@@ -175,6 +380,15 @@ func (r *RustConfig) RustPublish() bool {
 	return *r.Publish
 }
 
+// RustInstall returns whether cargo install of local crates is allowed
+// (default: false).
+func (r *RustConfig) RustInstall() bool {
+	if r == nil || r.Install == nil {
+		return false
+	}
+	return *r.Install
+}
+
 // RuntimesConfig controls code execution runtime permissions.
 type RuntimesConfig struct {
 	Go   *GoConfig   `yaml:"go,omitempty"`
@@ -182,19 +396,147 @@ type RuntimesConfig struct {
 	Rust *RustConfig `yaml:"rust,omitempty"`
 }
 
+// RateLimitConfig limits how many commands can be run, as a token bucket
+// that holds up to Burst commands and refills at CommandsPerMinute. It
+// protects the host from a client stuck in a loop.
+type RateLimitConfig struct {
+	CommandsPerMinute int `yaml:"commands_per_minute,omitempty"`
+	// Burst is how many commands can run back to back after an idle
+	// period. Defaults to CommandsPerMinute.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// Limits returns the refill rate in commands per minute and the bucket size,
+// or 0, 0 if commands are not rate limited (default).
+func (r *RateLimitConfig) Limits() (perMinute, burst int) {
+	if r == nil || r.CommandsPerMinute <= 0 {
+		return 0, 0
+	}
+	burst = r.Burst
+	if burst <= 0 {
+		burst = r.CommandsPerMinute
+	}
+	return r.CommandsPerMinute, burst
+}
+
 // Config holds all user configuration. New fields can be added over time;
 // unknown YAML fields are silently ignored for forward compatibility.
 type Config struct {
-	ExtraCommands []string        `yaml:"extra_commands,omitempty"`
-	ReadablePaths []string        `yaml:"readable_paths,omitempty"`
-	WritablePaths []string        `yaml:"writable_paths,omitempty"`
-	Git           *GitConfig      `yaml:"git,omitempty"`
-	Runtimes      *RuntimesConfig `yaml:"runtimes,omitempty"`
+	ExtraCommands []string `yaml:"extra_commands,omitempty"`
+	ReadablePaths []string `yaml:"readable_paths,omitempty"`
+	WritablePaths []string `yaml:"writable_paths,omitempty"`
+	// DefaultReadablePaths and DefaultWritablePaths are added by the sandbox
+	// to the allowed paths of every command, in addition to those its caller
+	// passes. ~ is expanded like ReadablePaths.
+	DefaultReadablePaths []string                    `yaml:"default_readable_paths,omitempty"`
+	DefaultWritablePaths []string                    `yaml:"default_writable_paths,omitempty"`
+	Git                  *GitConfig                  `yaml:"git,omitempty"`
+	Runtimes             *RuntimesConfig             `yaml:"runtimes,omitempty"`
 	AWS                  *AWSConfig                  `yaml:"aws,omitempty"`
 	LocalBinaryExecution *LocalBinaryExecutionConfig `yaml:"local_binary_execution,omitempty"`
 	OSSandbox            *bool                       `yaml:"os_sandbox,omitempty"`
+	// DeniedCommands are always blocked, even if they appear in the built-in
+	// allowlist or extra_commands, and regardless of UnknownCommandPolicy.
+	DeniedCommands []string `yaml:"denied_commands,omitempty"`
+	// UnknownCommandPolicy controls commands that are not in the allowlist:
+	// "block" (default) rejects them, "warn" logs a warning and allows them.
+	UnknownCommandPolicy string `yaml:"unknown_command_policy,omitempty"`
+	// AllowFollow permits tail -f/-F/--follow/--retry, which keep running
+	// until the command times out.
+	AllowFollow *bool `yaml:"allow_follow,omitempty"`
+	// ReadonlySubpaths are globs, relative to each writable directory, of
+	// paths that can be read but not written (e.g., "vendor/**"). "**"
+	// matches any number of directories.
+	ReadonlySubpaths []string `yaml:"readonly_subpaths,omitempty"`
+	// OSSandboxRequired makes commands fail instead of running when the OS
+	// sandbox is disabled or its worker cannot start.
+	OSSandboxRequired *bool `yaml:"os_sandbox_required,omitempty"`
+	// OSSandboxExtraWritableBinds and OSSandboxExtraReadableBinds are
+	// directories the OS sandbox makes writable or readable in addition to
+	// the working directory and detected runtime paths, such as a shared
+	// build cache. ~ is expanded like ReadablePaths. They only affect the OS
+	// sandbox; commands must still be allowed to access the paths.
+	OSSandboxExtraWritableBinds []string `yaml:"os_sandbox_extra_writable_binds,omitempty"`
+	OSSandboxExtraReadableBinds []string `yaml:"os_sandbox_extra_readable_binds,omitempty"`
+	// RateLimit limits how fast commands can be run. Unset means no limit.
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty"`
+	// StripANSI removes ANSI escape sequences, such as colors, from command
+	// output before it is returned. Unset means true.
+	StripANSI *bool `yaml:"strip_ansi,omitempty"`
+	// AnnotateEmptyOutput replaces the empty output of a command that
+	// succeeds without writing anything with a note saying so, so that it
+	// is not mistaken for a failure. Unset means false.
+	AnnotateEmptyOutput *bool `yaml:"annotate_empty_output,omitempty"`
+	// StatusAddr is the host:port of the HTTP status server, which serves
+	// /healthz, /readyz and /stats. Unset means no status server; an
+	// address without a host listens on 127.0.0.1.
+	StatusAddr string `yaml:"status_addr,omitempty"`
+	// RejectBroadPaths makes commands fail, instead of logging a warning,
+	// when an allowed path is so broad that it makes path restrictions
+	// ineffective, such as / or the home directory. Unset means false.
+	RejectBroadPaths *bool `yaml:"reject_broad_paths,omitempty"`
+	// BlockSystemEnumeration blocks locate, whose database lists files
+	// across the whole host, and limits which, whereis, type and command -v
+	// to the commands the sandbox allows. Unset means false.
+	BlockSystemEnumeration *bool `yaml:"block_system_enumeration,omitempty"`
+	// MaxOutputBytes caps the combined stdout and stderr of a command. A
+	// command that writes more is stopped and its output truncated. Unset
+	// or 0 means DefaultMaxOutputBytes; a negative value means no limit.
+	MaxOutputBytes int `yaml:"max_output_bytes,omitempty"`
+	// MaxValidatedScripts caps how many script files, run or sourced by a
+	// command and the scripts it runs, are read and validated before the
+	// command is rejected. Unset or 0 means DefaultMaxValidatedScripts; a
+	// negative value means no limit.
+	MaxValidatedScripts int `yaml:"max_validated_scripts,omitempty"`
+	// MaxHeadTailBytes caps the byte count of head -c and tail -c, which
+	// read (and, for input that cannot seek, buffer) up to that many bytes
+	// even when their output is redirected to a file. Unset or 0 means
+	// DefaultMaxHeadTailBytes; a negative value means no limit.
+	MaxHeadTailBytes int `yaml:"max_head_tail_bytes,omitempty"`
+	// MaxSubstitutionDepth caps how deeply command substitutions, process
+	// substitutions and subshells may be nested in a command. Unset or 0
+	// means DefaultMaxSubstitutionDepth; a negative value means no limit.
+	MaxSubstitutionDepth int `yaml:"max_substitution_depth,omitempty"`
+	// MaxHeredocBytes caps the combined size of the heredoc bodies in a
+	// command. Unset or 0 means DefaultMaxHeredocBytes; a negative value
+	// means no limit.
+	MaxHeredocBytes int `yaml:"max_heredoc_bytes,omitempty"`
+	// TrustedSourceFiles are shell files, such as a vetted bootstrap
+	// library, whose commands are not checked against the allowlist when
+	// they are sourced. Their paths are still validated. ~ is expanded like
+	// ReadablePaths.
+	TrustedSourceFiles []string `yaml:"trusted_source_files,omitempty"`
+	// PreflightTools are the Claude Code tool names the preflight hook is
+	// installed for and handles. Unset means DefaultPreflightTools.
+	PreflightTools []string `yaml:"preflight_tools,omitempty"`
+	// ExecutionPath, if set, replaces PATH for every sandboxed command, so
+	// allowed command names only resolve to binaries in these directories.
+	// ~ is expanded like ReadablePaths.
+	ExecutionPath []string `yaml:"execution_path,omitempty"`
 }
 
+// DefaultMaxOutputBytes is the output limit used when MaxOutputBytes is unset.
+const DefaultMaxOutputBytes = 10 << 20
+
+// DefaultMaxValidatedScripts is the script file limit used when
+// MaxValidatedScripts is unset.
+const DefaultMaxValidatedScripts = 100
+
+// DefaultMaxHeadTailBytes is the head -c and tail -c limit used when
+// MaxHeadTailBytes is unset.
+const DefaultMaxHeadTailBytes = 1 << 30
+
+// DefaultMaxSubstitutionDepth is the nesting limit used when
+// MaxSubstitutionDepth is unset.
+const DefaultMaxSubstitutionDepth = 32
+
+// DefaultMaxHeredocBytes is the heredoc size limit used when MaxHeredocBytes
+// is unset: 10 MiB.
+const DefaultMaxHeredocBytes = 10 << 20
+
+// DefaultPreflightTools are the tool names used when PreflightTools is unset.
+var DefaultPreflightTools = []string{"Bash"}
+
 // ExpandedReadablePaths returns ReadablePaths with ~ expanded to the user's
 // home directory and all paths resolved to absolute paths.
 func (c *Config) ExpandedReadablePaths() []string {
@@ -207,23 +549,55 @@ func (c *Config) ExpandedWritablePaths() []string {
 	return expandPaths(c.WritablePaths)
 }
 
-// expandPaths expands ~ to the user's home directory and resolves absolute paths.
+// ExpandedDefaultReadablePaths returns DefaultReadablePaths with ~ expanded
+// to the user's home directory and all paths resolved to absolute paths.
+func (c *Config) ExpandedDefaultReadablePaths() []string {
+	return expandPaths(c.DefaultReadablePaths)
+}
+
+// ExpandedDefaultWritablePaths returns DefaultWritablePaths with ~ expanded
+// to the user's home directory and all paths resolved to absolute paths.
+func (c *Config) ExpandedDefaultWritablePaths() []string {
+	return expandPaths(c.DefaultWritablePaths)
+}
+
+// ExpandedOSSandboxWritableBinds returns OSSandboxExtraWritableBinds with ~
+// expanded to the user's home directory and all paths resolved to absolute
+// paths.
+func (c *Config) ExpandedOSSandboxWritableBinds() []string {
+	return expandPaths(c.OSSandboxExtraWritableBinds)
+}
+
+// ExpandedOSSandboxReadableBinds returns OSSandboxExtraReadableBinds with ~
+// expanded to the user's home directory and all paths resolved to absolute
+// paths.
+func (c *Config) ExpandedOSSandboxReadableBinds() []string {
+	return expandPaths(c.OSSandboxExtraReadableBinds)
+}
+
+// ExpandedTrustedSourceFiles returns TrustedSourceFiles with ~ expanded to
+// the user's home directory and all paths resolved to absolute paths.
+func (c *Config) ExpandedTrustedSourceFiles() []string {
+	return expandPaths(c.TrustedSourceFiles)
+}
+
+// ExpandedExecutionPath returns ExecutionPath with ~ expanded to the user's
+// home directory and all paths resolved to absolute paths.
+func (c *Config) ExpandedExecutionPath() []string {
+	return expandPaths(c.ExecutionPath)
+}
+
+// expandPaths expands each path with expandPath. Paths that reference an
+// unset variable are skipped with a warning.
 func expandPaths(paths []string) []string {
 	if len(paths) == 0 {
 		return nil
 	}
-	home, _ := os.UserHomeDir()
 	result := make([]string, 0, len(paths))
 	for _, p := range paths {
-		if home != "" && len(p) > 0 && p[0] == '~' {
-			if len(p) == 1 {
-				p = home
-			} else if p[1] == '/' {
-				p = filepath.Join(home, p[2:])
-			}
-		}
-		abs, err := filepath.Abs(p)
+		abs, err := expandPath(p)
 		if err != nil {
+			slog.Warn("skipping config path", "error", err)
 			continue
 		}
 		result = append(result, abs)
@@ -231,6 +605,175 @@ func expandPaths(paths []string) []string {
 	return result
 }
 
+// expandPath expands environment variables ($VAR or ${VAR}) in p, then a
+// leading ~ to the user's home directory, and resolves it to an absolute path.
+func expandPath(p string) (string, error) {
+	p, err := expandEnv(p)
+	if err != nil {
+		return "", err
+	}
+	if home, _ := os.UserHomeDir(); home != "" && len(p) > 0 && p[0] == '~' {
+		if len(p) == 1 {
+			p = home
+		} else if p[1] == '/' {
+			p = filepath.Join(home, p[2:])
+		}
+	}
+	return filepath.Abs(p)
+}
+
+// expandEnv expands $VAR and ${VAR} references in p. It fails if a variable
+// is unset or empty, since dropping it could turn p into a broader path
+// (${PROJECT_ROOT}/build into /build).
+func expandEnv(p string) (string, error) {
+	missing := ""
+	expanded := os.Expand(p, func(name string) string {
+		v := os.Getenv(name)
+		if v == "" && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("%q references unset variable $%s", p, missing)
+	}
+	return expanded, nil
+}
+
+// Unknown command policies for UnknownCommandPolicy.
+const (
+	UnknownCommandPolicyBlock = "block"
+	UnknownCommandPolicyWarn  = "warn"
+)
+
+// WarnOnUnknownCommands returns whether commands outside the allowlist are
+// allowed with a warning instead of blocked. Any value other than "warn",
+// including an unrecognized one, blocks (default: false).
+//
+// Warn mode treats the allowlist as advisory: any binary on PATH can run,
+// including interpreters and network tools that bypass argument and path
+// validation. Only use it in trusted environments.
+func (c *Config) WarnOnUnknownCommands() bool {
+	if c == nil {
+		return false
+	}
+	return c.UnknownCommandPolicy == UnknownCommandPolicyWarn
+}
+
+// FollowAllowed returns whether tail may follow files (default: false).
+func (c *Config) FollowAllowed() bool {
+	if c == nil || c.AllowFollow == nil {
+		return false
+	}
+	return *c.AllowFollow
+}
+
+// StripsANSI returns whether ANSI escape sequences are removed from command
+// output (default: true).
+func (c *Config) StripsANSI() bool {
+	if c == nil || c.StripANSI == nil {
+		return true
+	}
+	return *c.StripANSI
+}
+
+// AnnotatesEmptyOutput returns whether the empty output of a successful
+// command is replaced with a note (default: false).
+func (c *Config) AnnotatesEmptyOutput() bool {
+	if c == nil || c.AnnotateEmptyOutput == nil {
+		return false
+	}
+	return *c.AnnotateEmptyOutput
+}
+
+// RejectsBroadPaths returns whether overly broad allowed paths are rejected
+// rather than only logged (default: false).
+func (c *Config) RejectsBroadPaths() bool {
+	if c == nil || c.RejectBroadPaths == nil {
+		return false
+	}
+	return *c.RejectBroadPaths
+}
+
+// BlocksSystemEnumeration returns whether commands that reveal the host's
+// files and installed programs are restricted (default: false).
+func (c *Config) BlocksSystemEnumeration() bool {
+	if c == nil || c.BlockSystemEnumeration == nil {
+		return false
+	}
+	return *c.BlockSystemEnumeration
+}
+
+// OutputLimit returns the maximum number of output bytes a command may
+// write, or 0 if output is not limited (default: DefaultMaxOutputBytes).
+func (c *Config) OutputLimit() int {
+	if c == nil || c.MaxOutputBytes == 0 {
+		return DefaultMaxOutputBytes
+	}
+	if c.MaxOutputBytes < 0 {
+		return 0
+	}
+	return c.MaxOutputBytes
+}
+
+// ValidatedScriptLimit returns the maximum number of script files validated
+// for a command, or 0 if there is no limit (default:
+// DefaultMaxValidatedScripts).
+func (c *Config) ValidatedScriptLimit() int {
+	if c == nil || c.MaxValidatedScripts == 0 {
+		return DefaultMaxValidatedScripts
+	}
+	if c.MaxValidatedScripts < 0 {
+		return 0
+	}
+	return c.MaxValidatedScripts
+}
+
+// HeadTailByteLimit returns the largest byte count head -c and tail -c may
+// be given, or 0 if there is no limit (default: DefaultMaxHeadTailBytes).
+func (c *Config) HeadTailByteLimit() int {
+	if c == nil || c.MaxHeadTailBytes == 0 {
+		return DefaultMaxHeadTailBytes
+	}
+	if c.MaxHeadTailBytes < 0 {
+		return 0
+	}
+	return c.MaxHeadTailBytes
+}
+
+// SubstitutionDepthLimit returns how deeply substitutions and subshells may
+// be nested, or 0 if there is no limit (default: DefaultMaxSubstitutionDepth).
+func (c *Config) SubstitutionDepthLimit() int {
+	if c == nil || c.MaxSubstitutionDepth == 0 {
+		return DefaultMaxSubstitutionDepth
+	}
+	if c.MaxSubstitutionDepth < 0 {
+		return 0
+	}
+	return c.MaxSubstitutionDepth
+}
+
+// HeredocByteLimit returns the maximum combined size of the heredoc bodies
+// in a command, or 0 if there is no limit (default: DefaultMaxHeredocBytes).
+func (c *Config) HeredocByteLimit() int {
+	if c == nil || c.MaxHeredocBytes == 0 {
+		return DefaultMaxHeredocBytes
+	}
+	if c.MaxHeredocBytes < 0 {
+		return 0
+	}
+	return c.MaxHeredocBytes
+}
+
+// PreflightToolNames returns the tool names the preflight hook handles
+// (default: DefaultPreflightTools).
+func (c *Config) PreflightToolNames() []string {
+	if c == nil || len(c.PreflightTools) == 0 {
+		return DefaultPreflightTools
+	}
+	return c.PreflightTools
+}
+
 // OSSandboxEnabled returns whether OS-level sandboxing with bwrap is enabled (default: false).
 func (c *Config) OSSandboxEnabled() bool {
 	if c == nil || c.OSSandbox == nil {
@@ -239,6 +782,15 @@ func (c *Config) OSSandboxEnabled() bool {
 	return *c.OSSandbox
 }
 
+// RequiresOSSandbox returns whether commands must never run without the OS
+// sandbox (default: false).
+func (c *Config) RequiresOSSandbox() bool {
+	if c == nil || c.OSSandboxRequired == nil {
+		return false
+	}
+	return *c.OSSandboxRequired
+}
+
 // Path returns the platform-appropriate config file path.
 // If LITE_SANDBOX_CONFIG env var is set, that path is used directly.
 func Path() (string, error) {
@@ -252,9 +804,24 @@ func Path() (string, error) {
 	return filepath.Join(dir, appName, "config.yaml"), nil
 }
 
-// Load reads and parses the config file. If the file does not exist,
-// a zero-value Config is returned with no error.
+// Load reads and parses the config file, then applies LITE_SANDBOX_*
+// environment variable overrides (see ApplyEnvOverrides). If the file does
+// not exist, the config comes from the environment alone.
 func Load() (*Config, error) {
+	cfg, err := LoadFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplyEnvOverrides(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile reads and parses the config file without environment overrides,
+// for callers that modify and Save it. If the file does not exist, a
+// zero-value Config is returned with no error.
+func LoadFile() (*Config, error) {
 	p, err := Path()
 	if err != nil {
 		return nil, err
@@ -273,6 +840,20 @@ func Load() (*Config, error) {
 	return &cfg, nil
 }
 
+// ParseFile reads and parses the config file at path, without environment
+// overrides. Unlike LoadFile, a missing file is an error.
+func ParseFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
 // Save writes the config to the YAML file, creating the directory if needed.
 func Save(cfg *Config) error {
 	p, err := Path()
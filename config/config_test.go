@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -112,6 +113,63 @@ func TestExpandedWritablePaths(t *testing.T) {
 	}
 }
 
+func TestExpandedDefaultPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("failed to get home dir: %v", err)
+	}
+
+	cfg := &Config{
+		DefaultReadablePaths: []string{"~/.cache", "/usr/share"},
+		DefaultWritablePaths: []string{"~/.cache/build"},
+	}
+	if got, want := cfg.ExpandedDefaultReadablePaths(), []string{filepath.Join(home, ".cache"), "/usr/share"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := cfg.ExpandedDefaultWritablePaths(), []string{filepath.Join(home, ".cache/build")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestExpandedOSSandboxBinds(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("failed to get home dir: %v", err)
+	}
+
+	cfg := &Config{
+		OSSandboxExtraWritableBinds: []string{"~/.m2", "/var/cache/ccache"},
+		OSSandboxExtraReadableBinds: []string{"~/sdk"},
+	}
+	if got, want := cfg.ExpandedOSSandboxWritableBinds(), []string{filepath.Join(home, ".m2"), "/var/cache/ccache"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := cfg.ExpandedOSSandboxReadableBinds(), []string{filepath.Join(home, "sdk")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestExpandedPaths_EnvVars(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("failed to get home dir: %v", err)
+	}
+	t.Setenv("CUSTOM", "/opt/custom")
+
+	cfg := &Config{
+		WritablePaths: []string{"$HOME/x", "${CUSTOM}/y", "${LITE_SANDBOX_TEST_UNSET}/z", "~/w"},
+	}
+	want := []string{filepath.Join(home, "x"), "/opt/custom/y", filepath.Join(home, "w")}
+	if got := cfg.ExpandedWritablePaths(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	t.Setenv("CUSTOM", "")
+	if got := cfg.ExpandedWritablePaths(); len(got) != 2 {
+		t.Fatalf("expected a path with an empty variable to be skipped, got %v", got)
+	}
+}
+
 func TestExpandedPaths_Empty(t *testing.T) {
 	cfg := &Config{}
 	if got := cfg.ExpandedReadablePaths(); got != nil {
@@ -120,6 +178,12 @@ func TestExpandedPaths_Empty(t *testing.T) {
 	if got := cfg.ExpandedWritablePaths(); got != nil {
 		t.Fatalf("expected nil, got %v", got)
 	}
+	if got := cfg.ExpandedDefaultReadablePaths(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+	if got := cfg.ExpandedDefaultWritablePaths(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
 }
 
 func TestWatch(t *testing.T) {
@@ -177,3 +241,271 @@ func TestLocalBinaryExecutionConfig_IsEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalBinaryExecutionConfig_BinaryPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	tests := []struct {
+		name string
+		cfg  *LocalBinaryExecutionConfig
+		want []string
+	}{
+		{"nil config", nil, []string{"/work"}},
+		{"no allowed paths", &LocalBinaryExecutionConfig{}, []string{"/work"}},
+		{"relative", &LocalBinaryExecutionConfig{AllowedPaths: []string{"./bin", "tools/../out"}}, []string{"/work/bin", "/work/out"}},
+		{"absolute", &LocalBinaryExecutionConfig{AllowedPaths: []string{"/opt/bin/"}}, []string{"/opt/bin"}},
+		{"home", &LocalBinaryExecutionConfig{AllowedPaths: []string{"~/bin"}}, []string{filepath.Join(home, "bin")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.BinaryPaths("/work")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BinaryPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalBinaryExecutionConfig_ScriptPatterns(t *testing.T) {
+	boolPtr := func(b bool) *bool { return &b }
+	tests := []struct {
+		name    string
+		cfg     *LocalBinaryExecutionConfig
+		workDir string
+		want    []string
+		direct  bool
+	}{
+		{"nil config", nil, "/work", nil, false},
+		{"enabled", &LocalBinaryExecutionConfig{Enabled: boolPtr(true)}, "/work", nil, true},
+		{"relative", &LocalBinaryExecutionConfig{AllowedScripts: []string{"build.sh", "./scripts/*.sh"}}, "/work", []string{"/work/build.sh", "/work/scripts/*.sh"}, true},
+		{"absolute", &LocalBinaryExecutionConfig{AllowedScripts: []string{"/opt/run.sh"}}, "/work", []string{"/opt/run.sh"}, true},
+		{"unknown workDir", &LocalBinaryExecutionConfig{AllowedScripts: []string{"./build.sh"}}, "", []string{"build.sh"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ScriptPatterns(tt.workDir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScriptPatterns() = %v, want %v", got, tt.want)
+			}
+			if got := tt.cfg.AllowsDirectExecution(); got != tt.direct {
+				t.Errorf("AllowsDirectExecution() = %v, want %v", got, tt.direct)
+			}
+		})
+	}
+}
+
+func TestConfig_WarnOnUnknownCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want bool
+	}{
+		{"nil config", nil, false},
+		{"unset policy", &Config{}, false},
+		{"block policy", &Config{UnknownCommandPolicy: UnknownCommandPolicyBlock}, false},
+		{"warn policy", &Config{UnknownCommandPolicy: UnknownCommandPolicyWarn}, true},
+		{"unrecognized policy", &Config{UnknownCommandPolicy: "allow"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.WarnOnUnknownCommands(); got != tt.want {
+				t.Errorf("WarnOnUnknownCommands() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_OutputLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want int
+	}{
+		{"nil config", nil, DefaultMaxOutputBytes},
+		{"unset", &Config{}, DefaultMaxOutputBytes},
+		{"explicit limit", &Config{MaxOutputBytes: 1024}, 1024},
+		{"negative means unlimited", &Config{MaxOutputBytes: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.OutputLimit(); got != tt.want {
+				t.Errorf("OutputLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidatedScriptLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want int
+	}{
+		{"nil config", nil, DefaultMaxValidatedScripts},
+		{"unset", &Config{}, DefaultMaxValidatedScripts},
+		{"explicit limit", &Config{MaxValidatedScripts: 20}, 20},
+		{"negative means unlimited", &Config{MaxValidatedScripts: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ValidatedScriptLimit(); got != tt.want {
+				t.Errorf("ValidatedScriptLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_HeadTailByteLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want int
+	}{
+		{"nil config", nil, DefaultMaxHeadTailBytes},
+		{"unset", &Config{}, DefaultMaxHeadTailBytes},
+		{"explicit limit", &Config{MaxHeadTailBytes: 4096}, 4096},
+		{"negative means unlimited", &Config{MaxHeadTailBytes: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.HeadTailByteLimit(); got != tt.want {
+				t.Errorf("HeadTailByteLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_SubstitutionDepthLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want int
+	}{
+		{"nil config", nil, DefaultMaxSubstitutionDepth},
+		{"unset", &Config{}, DefaultMaxSubstitutionDepth},
+		{"explicit limit", &Config{MaxSubstitutionDepth: 8}, 8},
+		{"negative means unlimited", &Config{MaxSubstitutionDepth: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.SubstitutionDepthLimit(); got != tt.want {
+				t.Errorf("SubstitutionDepthLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_HeredocByteLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want int
+	}{
+		{"nil config", nil, DefaultMaxHeredocBytes},
+		{"unset", &Config{}, DefaultMaxHeredocBytes},
+		{"explicit limit", &Config{MaxHeredocBytes: 1024}, 1024},
+		{"negative means unlimited", &Config{MaxHeredocBytes: -1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.HeredocByteLimit(); got != tt.want {
+				t.Errorf("HeredocByteLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitConfig_Limits(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           *RateLimitConfig
+		wantPerMinute int
+		wantBurst     int
+	}{
+		{"nil config", nil, 0, 0},
+		{"unset", &RateLimitConfig{}, 0, 0},
+		{"burst without rate", &RateLimitConfig{Burst: 5}, 0, 0},
+		{"negative rate", &RateLimitConfig{CommandsPerMinute: -1, Burst: 5}, 0, 0},
+		{"default burst", &RateLimitConfig{CommandsPerMinute: 60}, 60, 60},
+		{"explicit burst", &RateLimitConfig{CommandsPerMinute: 60, Burst: 10}, 60, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perMinute, burst := tt.cfg.Limits()
+			if perMinute != tt.wantPerMinute || burst != tt.wantBurst {
+				t.Errorf("Limits() = %d, %d, want %d, %d", perMinute, burst, tt.wantPerMinute, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestAWSConfig_IMDSAddress(t *testing.T) {
+	var nilCfg *AWSConfig
+	if got := nilCfg.IMDSAddress(); got != DefaultIMDSAddress {
+		t.Errorf("nil config: got %q, want %q", got, DefaultIMDSAddress)
+	}
+	if nilCfg.AllowsNonLoopbackIMDS() {
+		t.Error("nil config should not allow non-loopback IMDS")
+	}
+	allow := true
+	cfg := &AWSConfig{IMDSAddr: "[::1]:9000", IMDSAllowNonLoopback: &allow}
+	if got := cfg.IMDSAddress(); got != "[::1]:9000" {
+		t.Errorf("got %q, want [::1]:9000", got)
+	}
+	if !cfg.AllowsNonLoopbackIMDS() {
+		t.Error("expected non-loopback IMDS to be allowed")
+	}
+}
+
+func TestGitConfig_ForDir(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	yes, no := true, false
+	g := &GitConfig{
+		LocalWrite: &no,
+		PerPath: map[string]*GitConfig{
+			"~/work/trusted/*":      {RemoteWrite: &yes},
+			"~/work/trusted/deploy": {LocalWrite: &yes, RemoteWrite: &no},
+			"/srv/*/scratch":        {LocalWrite: &yes},
+		},
+	}
+	tests := []struct {
+		name                    string
+		dir                     string
+		localWrite, remoteWrite bool
+	}{
+		{"unknown directory", "", false, false},
+		{"no match", "/tmp/repo", false, false},
+		{"glob match", filepath.Join(home, "work/trusted/app"), false, true},
+		{"subdirectory of match", filepath.Join(home, "work/trusted/app/src/pkg"), false, true},
+		{"longest glob wins", filepath.Join(home, "work/trusted/deploy"), true, false},
+		{"glob parent only", filepath.Join(home, "work/trusted"), false, false},
+		{"absolute glob", "/srv/a/scratch/x", true, false},
+		{"unclean path", "/srv/a/../b/scratch/", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.ForDir(tt.dir)
+			if got.GitLocalWrite() != tt.localWrite || got.GitRemoteWrite() != tt.remoteWrite {
+				t.Errorf("ForDir(%q): local_write=%v remote_write=%v, want %v %v",
+					tt.dir, got.GitLocalWrite(), got.GitRemoteWrite(), tt.localWrite, tt.remoteWrite)
+			}
+			if !got.GitLocalRead() || !got.GitRemoteRead() {
+				t.Errorf("ForDir(%q): read permissions should keep their defaults", tt.dir)
+			}
+		})
+	}
+	g.AllowDestructive = &no
+	g.PerPath["/srv/*/scratch"].AllowDestructive = &yes
+	if g.ForDir("/tmp/repo").GitAllowDestructive() || !g.ForDir("/srv/a/scratch").GitAllowDestructive() {
+		t.Error("expected allow_destructive to be overridden per path")
+	}
+
+	var nilCfg *GitConfig
+	if nilCfg.ForDir("/tmp") != nil {
+		t.Error("nil config should stay nil")
+	}
+}
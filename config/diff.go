@@ -0,0 +1,259 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PolicyDiff is how the policy of one config differs from another's, as
+// returned by DiffPolicy. Lists are sorted.
+type PolicyDiff struct {
+	// AddedCommands and RemovedCommands are extra_commands entries.
+	AddedCommands   []string
+	RemovedCommands []string
+	// AddedDeniedCommands and RemovedDeniedCommands are denied_commands
+	// entries.
+	AddedDeniedCommands   []string
+	RemovedDeniedCommands []string
+	// AddedPaths and RemovedPaths map a path list setting, such as
+	// "writable_paths", to the entries added to or removed from it. Paths
+	// are compared as written, before ~ and environment variables are
+	// expanded. They are nil if no path changed.
+	AddedPaths   map[string][]string
+	RemovedPaths map[string][]string
+	// Changes are the other settings whose effective value changed, such
+	// as git permissions and runtime toggles, sorted by setting.
+	Changes []SettingChange
+}
+
+// SettingChange is a setting whose effective value differs between two
+// configs. Unset settings have their default value.
+type SettingChange struct {
+	Setting string
+	Old     string
+	New     string
+}
+
+// Empty reports whether the two configs have the same policy.
+func (d PolicyDiff) Empty() bool {
+	return len(d.AddedCommands) == 0 && len(d.RemovedCommands) == 0 &&
+		len(d.AddedDeniedCommands) == 0 && len(d.RemovedDeniedCommands) == 0 &&
+		len(d.AddedPaths) == 0 && len(d.RemovedPaths) == 0 && len(d.Changes) == 0
+}
+
+// DiffPolicy returns how the policy of new differs from that of old: the
+// commands and paths added or removed, and the settings, such as git
+// permissions, runtime toggles and limits, whose effective value changed.
+// A nil config is the default config.
+func DiffPolicy(old, new *Config) PolicyDiff {
+	if old == nil {
+		old = &Config{}
+	}
+	if new == nil {
+		new = &Config{}
+	}
+	var d PolicyDiff
+	d.AddedCommands, d.RemovedCommands = diffLists(old.ExtraCommands, new.ExtraCommands)
+	d.AddedDeniedCommands, d.RemovedDeniedCommands = diffLists(old.DeniedCommands, new.DeniedCommands)
+
+	oldPaths, newPaths := policyPaths(old), policyPaths(new)
+	for setting := range oldPaths {
+		added, removed := diffLists(oldPaths[setting], newPaths[setting])
+		if len(added) > 0 {
+			if d.AddedPaths == nil {
+				d.AddedPaths = make(map[string][]string)
+			}
+			d.AddedPaths[setting] = added
+		}
+		if len(removed) > 0 {
+			if d.RemovedPaths == nil {
+				d.RemovedPaths = make(map[string][]string)
+			}
+			d.RemovedPaths[setting] = removed
+		}
+	}
+
+	oldSettings, newSettings := policySettings(old), policySettings(new)
+	for setting, o := range oldSettings {
+		n, ok := newSettings[setting]
+		if !ok {
+			n = "unset"
+		}
+		if n != o {
+			d.Changes = append(d.Changes, SettingChange{Setting: setting, Old: o, New: n})
+		}
+	}
+	for setting, n := range newSettings {
+		if _, ok := oldSettings[setting]; !ok && n != "unset" {
+			d.Changes = append(d.Changes, SettingChange{Setting: setting, Old: "unset", New: n})
+		}
+	}
+	sort.Slice(d.Changes, func(i, j int) bool { return d.Changes[i].Setting < d.Changes[j].Setting })
+	return d
+}
+
+// diffLists returns the entries of new that are not in old, and those of old
+// that are not in new, each sorted and without duplicates.
+func diffLists(old, new []string) (added, removed []string) {
+	for _, s := range new {
+		if !slices.Contains(old, s) && !slices.Contains(added, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if !slices.Contains(new, s) && !slices.Contains(removed, s) {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// policyPaths returns the path list settings of c by their YAML key.
+func policyPaths(c *Config) map[string][]string {
+	var allowedPaths, allowedScripts []string
+	if c.LocalBinaryExecution != nil {
+		allowedPaths = c.LocalBinaryExecution.AllowedPaths
+		allowedScripts = c.LocalBinaryExecution.AllowedScripts
+	}
+	return map[string][]string{
+		"readable_paths":                         c.ReadablePaths,
+		"writable_paths":                         c.WritablePaths,
+		"default_readable_paths":                 c.DefaultReadablePaths,
+		"default_writable_paths":                 c.DefaultWritablePaths,
+		"readonly_subpaths":                      c.ReadonlySubpaths,
+		"os_sandbox_extra_writable_binds":        c.OSSandboxExtraWritableBinds,
+		"os_sandbox_extra_readable_binds":        c.OSSandboxExtraReadableBinds,
+		"trusted_source_files":                   c.TrustedSourceFiles,
+		"execution_path":                         c.ExecutionPath,
+		"local_binary_execution.allowed_paths":   allowedPaths,
+		"local_binary_execution.allowed_scripts": allowedScripts,
+	}
+}
+
+// policySettings returns the effective value of each scalar setting of c
+// that affects what commands may do, by its YAML key. git.per_path
+// overrides are reported as written, since they inherit unset permissions.
+func policySettings(c *Config) map[string]string {
+	r := c.Runtimes
+	if r == nil {
+		r = &RuntimesConfig{}
+	}
+	aws := c.AWS
+	if aws == nil {
+		aws = &AWSConfig{}
+	}
+	perMinute, burst := c.RateLimit.Limits()
+	settings := map[string]string{
+		"git.local_read":                 fmt.Sprint(c.Git.GitLocalRead()),
+		"git.local_write":                fmt.Sprint(c.Git.GitLocalWrite()),
+		"git.remote_read":                fmt.Sprint(c.Git.GitRemoteRead()),
+		"git.remote_write":               fmt.Sprint(c.Git.GitRemoteWrite()),
+		"git.allow_destructive":          fmt.Sprint(c.Git.GitAllowDestructive()),
+		"runtimes.go.enabled":            fmt.Sprint(r.Go.GoEnabled()),
+		"runtimes.go.generate":           fmt.Sprint(r.Go.GoGenerate()),
+		"runtimes.go.allow_run":          fmt.Sprint(r.Go.GoRun()),
+		"runtimes.go.allow_fetch":        fmt.Sprint(r.Go.GoFetch()),
+		"runtimes.pnpm.enabled":          fmt.Sprint(r.Pnpm.PnpmEnabled()),
+		"runtimes.pnpm.publish":          fmt.Sprint(r.Pnpm.PnpmPublish()),
+		"runtimes.rust.enabled":          fmt.Sprint(r.Rust.RustEnabled()),
+		"runtimes.rust.publish":          fmt.Sprint(r.Rust.RustPublish()),
+		"runtimes.rust.install":          fmt.Sprint(r.Rust.RustInstall()),
+		"aws.enabled":                    fmt.Sprint(c.AWS.AWSEnabled()),
+		"aws.allow_raw_credentials":      fmt.Sprint(c.AWS.AllowsRawCredentials()),
+		"aws.force_profile":              formatString(aws.ForceProfile),
+		"aws.role_arn":                   formatString(aws.RoleARN),
+		"aws.session_policy":             formatString(aws.SessionPolicy),
+		"aws.session_policy_arns":        formatList(aws.SessionPolicyARNs),
+		"aws.max_credential_ttl":         formatTTL(aws.MaxCredentialTTL),
+		"aws.imds_address":               aws.IMDSAddress(),
+		"aws.imds_allow_non_loopback":    fmt.Sprint(aws.AllowsNonLoopbackIMDS()),
+		"local_binary_execution.enabled": fmt.Sprint(c.LocalBinaryExecution.IsEnabled()),
+		"os_sandbox":                     fmt.Sprint(c.OSSandboxEnabled()),
+		"os_sandbox_required":            fmt.Sprint(c.RequiresOSSandbox()),
+		"unknown_command_policy":         unknownCommandPolicy(c),
+		"allow_follow":                   fmt.Sprint(c.FollowAllowed()),
+		"block_system_enumeration":       fmt.Sprint(c.BlocksSystemEnumeration()),
+		"reject_broad_paths":             fmt.Sprint(c.RejectsBroadPaths()),
+		"rate_limit.commands_per_minute": formatLimit(perMinute),
+		"rate_limit.burst":               formatLimit(burst),
+		"max_output_bytes":               formatLimit(c.OutputLimit()),
+		"max_validated_scripts":          formatLimit(c.ValidatedScriptLimit()),
+		"max_head_tail_bytes":            formatLimit(c.HeadTailByteLimit()),
+		"max_substitution_depth":         formatLimit(c.SubstitutionDepthLimit()),
+		"max_heredoc_bytes":              formatLimit(c.HeredocByteLimit()),
+		"preflight_tools":                formatList(c.PreflightToolNames()),
+	}
+	if c.Git != nil {
+		for glob, g := range c.Git.PerPath {
+			if g == nil {
+				g = &GitConfig{}
+			}
+			prefix := "git.per_path." + glob + "."
+			settings[prefix+"local_read"] = formatBoolPtr(g.LocalRead)
+			settings[prefix+"local_write"] = formatBoolPtr(g.LocalWrite)
+			settings[prefix+"remote_read"] = formatBoolPtr(g.RemoteRead)
+			settings[prefix+"remote_write"] = formatBoolPtr(g.RemoteWrite)
+			settings[prefix+"allow_destructive"] = formatBoolPtr(g.AllowDestructive)
+		}
+	}
+	return settings
+}
+
+// unknownCommandPolicy returns the effective unknown_command_policy of c.
+func unknownCommandPolicy(c *Config) string {
+	if c.WarnOnUnknownCommands() {
+		return "warn"
+	}
+	return "block"
+}
+
+// formatLimit formats a limit returned by one of the limit accessors, where
+// 0 means no limit.
+func formatLimit(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
+// formatTTL formats max_credential_ttl, where 0 means no cap.
+func formatTTL(d time.Duration) string {
+	if d == 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
+
+// formatString formats an optional string setting, which is "unset" when
+// empty.
+func formatString(s string) string {
+	if s == "" {
+		return "unset"
+	}
+	return s
+}
+
+// formatList formats a list setting as its sorted, comma-separated entries,
+// or "unset" if it is empty, so that reordering the entries is not a change.
+func formatList(list []string) string {
+	if len(list) == 0 {
+		return "unset"
+	}
+	sorted := slices.Clone(list)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// formatBoolPtr formats an optional bool setting as "true", "false" or
+// "unset".
+func formatBoolPtr(b *bool) string {
+	if b == nil {
+		return "unset"
+	}
+	return fmt.Sprint(*b)
+}
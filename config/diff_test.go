@@ -0,0 +1,129 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffPolicy(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name string
+		old  *Config
+		new  *Config
+		want PolicyDiff
+	}{
+		{
+			name: "identical",
+			old:  &Config{ExtraCommands: []string{"make"}, WritablePaths: []string{"/tmp/out"}},
+			new:  &Config{ExtraCommands: []string{"make"}, WritablePaths: []string{"/tmp/out"}},
+		},
+		{
+			name: "explicit defaults",
+			old:  nil,
+			new:  &Config{Git: &GitConfig{LocalRead: &on, RemoteWrite: &off}},
+		},
+		{
+			name: "extra command and git remote write",
+			old:  &Config{},
+			new:  &Config{ExtraCommands: []string{"curl"}, Git: &GitConfig{RemoteWrite: &on}},
+			want: PolicyDiff{
+				AddedCommands: []string{"curl"},
+				Changes:       []SettingChange{{Setting: "git.remote_write", Old: "false", New: "true"}},
+			},
+		},
+		{
+			name: "commands and paths",
+			old: &Config{
+				ExtraCommands:  []string{"make", "curl"},
+				DeniedCommands: []string{"rm"},
+				ReadablePaths:  []string{"/a", "/b"},
+			},
+			new: &Config{
+				ExtraCommands: []string{"make", "wget"},
+				ReadablePaths: []string{"/b"},
+				WritablePaths: []string{"/c"},
+			},
+			want: PolicyDiff{
+				AddedCommands:         []string{"wget"},
+				RemovedCommands:       []string{"curl"},
+				RemovedDeniedCommands: []string{"rm"},
+				AddedPaths:            map[string][]string{"writable_paths": {"/c"}},
+				RemovedPaths:          map[string][]string{"readable_paths": {"/a"}},
+			},
+		},
+		{
+			name: "runtimes and limits",
+			old:  &Config{MaxOutputBytes: -1},
+			new:  &Config{Runtimes: &RuntimesConfig{Go: &GoConfig{Enabled: &on}}},
+			want: PolicyDiff{
+				Changes: []SettingChange{
+					{Setting: "max_output_bytes", Old: "unlimited", New: "10485760"},
+					{Setting: "runtimes.go.enabled", Old: "false", New: "true"},
+				},
+			},
+		},
+		{
+			name: "aws credential scope",
+			old: &Config{AWS: &AWSConfig{
+				ForceProfile:      "dev",
+				RoleARN:           "arn:aws:iam::123456789012:role/readonly",
+				SessionPolicyARNs: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				MaxCredentialTTL:  15 * time.Minute,
+			}},
+			new: &Config{AWS: &AWSConfig{
+				ForceProfile:         "dev",
+				IMDSAddr:             "0.0.0.0:8080",
+				IMDSAllowNonLoopback: &on,
+			}},
+			want: PolicyDiff{
+				Changes: []SettingChange{
+					{Setting: "aws.imds_address", Old: "127.0.0.1:0", New: "0.0.0.0:8080"},
+					{Setting: "aws.imds_allow_non_loopback", Old: "false", New: "true"},
+					{Setting: "aws.max_credential_ttl", Old: "15m0s", New: "unlimited"},
+					{Setting: "aws.role_arn", Old: "arn:aws:iam::123456789012:role/readonly", New: "unset"},
+					{Setting: "aws.session_policy_arns", Old: "arn:aws:iam::aws:policy/ReadOnlyAccess", New: "unset"},
+				},
+			},
+		},
+		{
+			name: "aws session policy and preflight tools",
+			old:  &Config{AWS: &AWSConfig{SessionPolicy: `{"Version":"2012-10-17"}`}},
+			new:  &Config{PreflightTools: []string{"Read", "Bash"}},
+			want: PolicyDiff{
+				Changes: []SettingChange{
+					{Setting: "aws.session_policy", Old: `{"Version":"2012-10-17"}`, New: "unset"},
+					{Setting: "preflight_tools", Old: "Bash", New: "Bash,Read"},
+				},
+			},
+		},
+		{
+			name: "reordered lists",
+			old:  &Config{PreflightTools: []string{"Bash", "Read"}, AWS: &AWSConfig{SessionPolicyARNs: []string{"a", "b"}}},
+			new:  &Config{PreflightTools: []string{"Read", "Bash"}, AWS: &AWSConfig{SessionPolicyARNs: []string{"b", "a"}}},
+		},
+		{
+			name: "git per path",
+			old:  &Config{Git: &GitConfig{PerPath: map[string]*GitConfig{"~/work/*": {RemoteWrite: &on}}}},
+			new:  &Config{Git: &GitConfig{PerPath: map[string]*GitConfig{"~/oss/*": {LocalWrite: &off}}}},
+			want: PolicyDiff{
+				Changes: []SettingChange{
+					{Setting: "git.per_path.~/oss/*.local_write", Old: "unset", New: "false"},
+					{Setting: "git.per_path.~/work/*.remote_write", Old: "true", New: "unset"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffPolicy(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffPolicy() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != reflect.DeepEqual(tt.want, PolicyDiff{}) {
+				t.Errorf("Empty() = %v for %+v", got.Empty(), got)
+			}
+		})
+	}
+}
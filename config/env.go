@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix prefixes every environment variable that overrides a config field.
+const envPrefix = "LITE_SANDBOX_"
+
+// envBoolFields maps environment variables (without envPrefix) to the boolean
+// config field they override. The field's parent section is created if the
+// file did not have one.
+var envBoolFields = map[string]func(c *Config) **bool{
+	"OS_SANDBOX":               func(c *Config) **bool { return &c.OSSandbox },
+	"OS_SANDBOX_REQUIRED":      func(c *Config) **bool { return &c.OSSandboxRequired },
+	"ALLOW_FOLLOW":             func(c *Config) **bool { return &c.AllowFollow },
+	"STRIP_ANSI":               func(c *Config) **bool { return &c.StripANSI },
+	"ANNOTATE_EMPTY_OUTPUT":    func(c *Config) **bool { return &c.AnnotateEmptyOutput },
+	"REJECT_BROAD_PATHS":       func(c *Config) **bool { return &c.RejectBroadPaths },
+	"BLOCK_SYSTEM_ENUMERATION": func(c *Config) **bool { return &c.BlockSystemEnumeration },
+	"LOCAL_BINARY_EXECUTION":   func(c *Config) **bool { return &c.localBinaryExecution().Enabled },
+	"GIT_LOCAL_READ":           func(c *Config) **bool { return &c.git().LocalRead },
+	"GIT_LOCAL_WRITE":          func(c *Config) **bool { return &c.git().LocalWrite },
+	"GIT_REMOTE_READ":          func(c *Config) **bool { return &c.git().RemoteRead },
+	"GIT_REMOTE_WRITE":         func(c *Config) **bool { return &c.git().RemoteWrite },
+	"GIT_ALLOW_DESTRUCTIVE":    func(c *Config) **bool { return &c.git().AllowDestructive },
+	"GO_ENABLED":               func(c *Config) **bool { return &c.goRuntime().Enabled },
+	"GO_GENERATE":              func(c *Config) **bool { return &c.goRuntime().Generate },
+	"GO_ALLOW_RUN":             func(c *Config) **bool { return &c.goRuntime().AllowRun },
+	"GO_ALLOW_FETCH":           func(c *Config) **bool { return &c.goRuntime().AllowFetch },
+	"PNPM_ENABLED":             func(c *Config) **bool { return &c.pnpmRuntime().Enabled },
+	"PNPM_PUBLISH":             func(c *Config) **bool { return &c.pnpmRuntime().Publish },
+	"RUST_ENABLED":             func(c *Config) **bool { return &c.rustRuntime().Enabled },
+	"RUST_INSTALL":             func(c *Config) **bool { return &c.rustRuntime().Install },
+	"RUST_PUBLISH":             func(c *Config) **bool { return &c.rustRuntime().Publish },
+	"AWS_ALLOW_RAW_CREDENTIALS": func(c *Config) **bool {
+		return &c.aws().AllowRawCredentials
+	},
+}
+
+// envListFields maps environment variables (without envPrefix) to the list
+// config field they replace. Values are comma-separated.
+var envListFields = map[string]func(c *Config) *[]string{
+	"EXTRA_COMMANDS":                  func(c *Config) *[]string { return &c.ExtraCommands },
+	"DENIED_COMMANDS":                 func(c *Config) *[]string { return &c.DeniedCommands },
+	"READABLE_PATHS":                  func(c *Config) *[]string { return &c.ReadablePaths },
+	"WRITABLE_PATHS":                  func(c *Config) *[]string { return &c.WritablePaths },
+	"DEFAULT_READABLE_PATHS":          func(c *Config) *[]string { return &c.DefaultReadablePaths },
+	"DEFAULT_WRITABLE_PATHS":          func(c *Config) *[]string { return &c.DefaultWritablePaths },
+	"OS_SANDBOX_EXTRA_WRITABLE_BINDS": func(c *Config) *[]string { return &c.OSSandboxExtraWritableBinds },
+	"OS_SANDBOX_EXTRA_READABLE_BINDS": func(c *Config) *[]string { return &c.OSSandboxExtraReadableBinds },
+	"READONLY_SUBPATHS":               func(c *Config) *[]string { return &c.ReadonlySubpaths },
+	"TRUSTED_SOURCE_FILES":            func(c *Config) *[]string { return &c.TrustedSourceFiles },
+	"PREFLIGHT_TOOLS":                 func(c *Config) *[]string { return &c.PreflightTools },
+	"EXECUTION_PATH":                  func(c *Config) *[]string { return &c.ExecutionPath },
+	"LOCAL_BINARY_EXECUTION_ALLOWED_PATHS": func(c *Config) *[]string {
+		return &c.localBinaryExecution().AllowedPaths
+	},
+	"LOCAL_BINARY_EXECUTION_ALLOWED_SCRIPTS": func(c *Config) *[]string {
+		return &c.localBinaryExecution().AllowedScripts
+	},
+}
+
+// envStringFields maps environment variables (without envPrefix) to the
+// string config field they override.
+var envStringFields = map[string]func(c *Config) *string{
+	"UNKNOWN_COMMAND_POLICY": func(c *Config) *string { return &c.UnknownCommandPolicy },
+	"STATUS_ADDR":            func(c *Config) *string { return &c.StatusAddr },
+	"AWS_FORCE_PROFILE":      func(c *Config) *string { return &c.aws().ForceProfile },
+}
+
+// envIntFields maps environment variables (without envPrefix) to the integer
+// config field they override.
+var envIntFields = map[string]func(c *Config) *int{
+	"RATE_LIMIT_COMMANDS_PER_MINUTE": func(c *Config) *int { return &c.rateLimit().CommandsPerMinute },
+	"RATE_LIMIT_BURST":               func(c *Config) *int { return &c.rateLimit().Burst },
+	"MAX_OUTPUT_BYTES":               func(c *Config) *int { return &c.MaxOutputBytes },
+	"MAX_VALIDATED_SCRIPTS":          func(c *Config) *int { return &c.MaxValidatedScripts },
+	"MAX_HEAD_TAIL_BYTES":            func(c *Config) *int { return &c.MaxHeadTailBytes },
+	"MAX_SUBSTITUTION_DEPTH":         func(c *Config) *int { return &c.MaxSubstitutionDepth },
+	"MAX_HEREDOC_BYTES":              func(c *Config) *int { return &c.MaxHeredocBytes },
+}
+
+// ApplyEnvOverrides overrides fields of cfg from LITE_SANDBOX_* environment
+// variables, so containerized deployments can configure the sandbox without
+// mounting a config file. For example, LITE_SANDBOX_OS_SANDBOX=true sets
+// os_sandbox, LITE_SANDBOX_EXTRA_COMMANDS=curl,wget replaces extra_commands
+// and LITE_SANDBOX_GIT_REMOTE_WRITE=false sets git.remote_write. Environment
+// variables take precedence over the file; unset or empty variables leave the
+// file's value unchanged.
+func ApplyEnvOverrides(cfg *Config) error {
+	for _, name := range sortedKeys(envBoolFields) {
+		v := os.Getenv(envPrefix + name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s%s: invalid boolean %q", envPrefix, name, v)
+		}
+		*envBoolFields[name](cfg) = &b
+	}
+	for _, name := range sortedKeys(envListFields) {
+		v := os.Getenv(envPrefix + name)
+		if v == "" {
+			continue
+		}
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*envListFields[name](cfg) = list
+	}
+	for _, name := range sortedKeys(envStringFields) {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			*envStringFields[name](cfg) = v
+		}
+	}
+	for _, name := range sortedKeys(envIntFields) {
+		v := os.Getenv(envPrefix + name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s%s: invalid integer %q", envPrefix, name, v)
+		}
+		*envIntFields[name](cfg) = n
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Config) git() *GitConfig {
+	if c.Git == nil {
+		c.Git = &GitConfig{}
+	}
+	return c.Git
+}
+
+func (c *Config) runtimes() *RuntimesConfig {
+	if c.Runtimes == nil {
+		c.Runtimes = &RuntimesConfig{}
+	}
+	return c.Runtimes
+}
+
+func (c *Config) goRuntime() *GoConfig {
+	r := c.runtimes()
+	if r.Go == nil {
+		r.Go = &GoConfig{}
+	}
+	return r.Go
+}
+
+func (c *Config) pnpmRuntime() *PnpmConfig {
+	r := c.runtimes()
+	if r.Pnpm == nil {
+		r.Pnpm = &PnpmConfig{}
+	}
+	return r.Pnpm
+}
+
+func (c *Config) rustRuntime() *RustConfig {
+	r := c.runtimes()
+	if r.Rust == nil {
+		r.Rust = &RustConfig{}
+	}
+	return r.Rust
+}
+
+func (c *Config) aws() *AWSConfig {
+	if c.AWS == nil {
+		c.AWS = &AWSConfig{}
+	}
+	return c.AWS
+}
+
+func (c *Config) localBinaryExecution() *LocalBinaryExecutionConfig {
+	if c.LocalBinaryExecution == nil {
+		c.LocalBinaryExecution = &LocalBinaryExecutionConfig{}
+	}
+	return c.LocalBinaryExecution
+}
+
+func (c *Config) rateLimit() *RateLimitConfig {
+	if c.RateLimit == nil {
+		c.RateLimit = &RateLimitConfig{}
+	}
+	return c.RateLimit
+}
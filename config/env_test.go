@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig points LITE_SANDBOX_CONFIG at a temp file containing yaml.
+func writeConfig(t *testing.T, yaml string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LITE_SANDBOX_CONFIG", p)
+}
+
+func TestLoad_EnvOverrides(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+		check func(c *Config) bool
+	}{
+		{"LITE_SANDBOX_OS_SANDBOX", "true", func(c *Config) bool { return c.OSSandboxEnabled() }},
+		{"LITE_SANDBOX_OS_SANDBOX_REQUIRED", "true", func(c *Config) bool { return c.RequiresOSSandbox() }},
+		{"LITE_SANDBOX_ALLOW_FOLLOW", "1", func(c *Config) bool { return c.FollowAllowed() }},
+		{"LITE_SANDBOX_STRIP_ANSI", "false", func(c *Config) bool { return !c.StripsANSI() }},
+		{"LITE_SANDBOX_ANNOTATE_EMPTY_OUTPUT", "true", func(c *Config) bool { return c.AnnotatesEmptyOutput() }},
+		{"LITE_SANDBOX_REJECT_BROAD_PATHS", "true", func(c *Config) bool { return c.RejectsBroadPaths() }},
+		{"LITE_SANDBOX_BLOCK_SYSTEM_ENUMERATION", "true", func(c *Config) bool { return c.BlocksSystemEnumeration() }},
+		{"LITE_SANDBOX_LOCAL_BINARY_EXECUTION", "true", func(c *Config) bool { return c.LocalBinaryExecution.IsEnabled() }},
+		{"LITE_SANDBOX_GIT_LOCAL_READ", "false", func(c *Config) bool { return !c.Git.GitLocalRead() }},
+		{"LITE_SANDBOX_GIT_LOCAL_WRITE", "false", func(c *Config) bool { return !c.Git.GitLocalWrite() }},
+		{"LITE_SANDBOX_GIT_REMOTE_READ", "false", func(c *Config) bool { return !c.Git.GitRemoteRead() }},
+		{"LITE_SANDBOX_GIT_REMOTE_WRITE", "false", func(c *Config) bool { return !c.Git.GitRemoteWrite() }},
+		{"LITE_SANDBOX_GIT_ALLOW_DESTRUCTIVE", "false", func(c *Config) bool { return !c.Git.GitAllowDestructive() }},
+		{"LITE_SANDBOX_GO_ENABLED", "true", func(c *Config) bool { return c.Runtimes.Go.GoEnabled() }},
+		{"LITE_SANDBOX_GO_GENERATE", "true", func(c *Config) bool { return c.Runtimes.Go.GoGenerate() }},
+		{"LITE_SANDBOX_GO_ALLOW_RUN", "true", func(c *Config) bool { return c.Runtimes.Go.GoRun() }},
+		{"LITE_SANDBOX_GO_ALLOW_FETCH", "true", func(c *Config) bool { return c.Runtimes.Go.GoFetch() }},
+		{"LITE_SANDBOX_PNPM_ENABLED", "true", func(c *Config) bool { return c.Runtimes.Pnpm.PnpmEnabled() }},
+		{"LITE_SANDBOX_PNPM_PUBLISH", "true", func(c *Config) bool { return c.Runtimes.Pnpm.PnpmPublish() }},
+		{"LITE_SANDBOX_RUST_ENABLED", "true", func(c *Config) bool { return c.Runtimes.Rust.RustEnabled() }},
+		{"LITE_SANDBOX_RUST_INSTALL", "true", func(c *Config) bool { return c.Runtimes.Rust.RustInstall() }},
+		{"LITE_SANDBOX_RUST_PUBLISH", "true", func(c *Config) bool { return c.Runtimes.Rust.RustPublish() }},
+		{"LITE_SANDBOX_AWS_ALLOW_RAW_CREDENTIALS", "true", func(c *Config) bool { return c.AWS.AllowsRawCredentials() }},
+		{"LITE_SANDBOX_AWS_FORCE_PROFILE", "dev", func(c *Config) bool { return c.AWS.IMDSProfile() == "dev" }},
+		{"LITE_SANDBOX_UNKNOWN_COMMAND_POLICY", "warn", func(c *Config) bool { return c.WarnOnUnknownCommands() }},
+		{"LITE_SANDBOX_STATUS_ADDR", ":9090", func(c *Config) bool { return c.StatusAddr == ":9090" }},
+		{"LITE_SANDBOX_RATE_LIMIT_COMMANDS_PER_MINUTE", "30", func(c *Config) bool {
+			perMinute, burst := c.RateLimit.Limits()
+			return perMinute == 30 && burst == 30
+		}},
+		{"LITE_SANDBOX_RATE_LIMIT_BURST", "5", func(c *Config) bool { return c.RateLimit.Burst == 5 }},
+		{"LITE_SANDBOX_MAX_OUTPUT_BYTES", "-1", func(c *Config) bool { return c.OutputLimit() == 0 }},
+		{"LITE_SANDBOX_MAX_VALIDATED_SCRIPTS", "20", func(c *Config) bool { return c.ValidatedScriptLimit() == 20 }},
+		{"LITE_SANDBOX_MAX_HEAD_TAIL_BYTES", "-1", func(c *Config) bool { return c.HeadTailByteLimit() == 0 }},
+		{"LITE_SANDBOX_MAX_SUBSTITUTION_DEPTH", "8", func(c *Config) bool { return c.SubstitutionDepthLimit() == 8 }},
+		{"LITE_SANDBOX_MAX_HEREDOC_BYTES", "1024", func(c *Config) bool { return c.HeredocByteLimit() == 1024 }},
+		{"LITE_SANDBOX_EXTRA_COMMANDS", "curl, wget,", func(c *Config) bool {
+			return reflect.DeepEqual(c.ExtraCommands, []string{"curl", "wget"})
+		}},
+		{"LITE_SANDBOX_DENIED_COMMANDS", "rm", func(c *Config) bool {
+			return reflect.DeepEqual(c.DeniedCommands, []string{"rm"})
+		}},
+		{"LITE_SANDBOX_READABLE_PATHS", "/data,/opt", func(c *Config) bool {
+			return reflect.DeepEqual(c.ReadablePaths, []string{"/data", "/opt"})
+		}},
+		{"LITE_SANDBOX_WRITABLE_PATHS", "/out", func(c *Config) bool {
+			return reflect.DeepEqual(c.WritablePaths, []string{"/out"})
+		}},
+		{"LITE_SANDBOX_DEFAULT_READABLE_PATHS", "/usr/share,~/docs", func(c *Config) bool {
+			return reflect.DeepEqual(c.DefaultReadablePaths, []string{"/usr/share", "~/docs"})
+		}},
+		{"LITE_SANDBOX_DEFAULT_WRITABLE_PATHS", "/cache", func(c *Config) bool {
+			return reflect.DeepEqual(c.DefaultWritablePaths, []string{"/cache"})
+		}},
+		{"LITE_SANDBOX_OS_SANDBOX_EXTRA_WRITABLE_BINDS", "~/.m2,/cache", func(c *Config) bool {
+			return reflect.DeepEqual(c.OSSandboxExtraWritableBinds, []string{"~/.m2", "/cache"})
+		}},
+		{"LITE_SANDBOX_OS_SANDBOX_EXTRA_READABLE_BINDS", "/opt/sdk", func(c *Config) bool {
+			return reflect.DeepEqual(c.OSSandboxExtraReadableBinds, []string{"/opt/sdk"})
+		}},
+		{"LITE_SANDBOX_TRUSTED_SOURCE_FILES", "/opt/corp/bootstrap.sh", func(c *Config) bool {
+			return reflect.DeepEqual(c.TrustedSourceFiles, []string{"/opt/corp/bootstrap.sh"})
+		}},
+		{"LITE_SANDBOX_PREFLIGHT_TOOLS", "Bash,Shell", func(c *Config) bool {
+			return reflect.DeepEqual(c.PreflightTools, []string{"Bash", "Shell"})
+		}},
+		{"LITE_SANDBOX_EXECUTION_PATH", "/usr/bin,/bin", func(c *Config) bool {
+			return reflect.DeepEqual(c.ExecutionPath, []string{"/usr/bin", "/bin"})
+		}},
+		{"LITE_SANDBOX_LOCAL_BINARY_EXECUTION_ALLOWED_PATHS", "./bin,/opt/tools", func(c *Config) bool {
+			return reflect.DeepEqual(c.LocalBinaryExecution.AllowedPaths, []string{"./bin", "/opt/tools"})
+		}},
+		{"LITE_SANDBOX_LOCAL_BINARY_EXECUTION_ALLOWED_SCRIPTS", "build.sh,scripts/*.sh", func(c *Config) bool {
+			return reflect.DeepEqual(c.LocalBinaryExecution.AllowedScripts, []string{"build.sh", "scripts/*.sh"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			writeConfig(t, "extra_commands: [make]\nreadable_paths: [/src]\ngit:\n  remote_write: true\n")
+			t.Setenv(tt.env, tt.value)
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Fatalf("%s=%s not applied: %+v", tt.env, tt.value, cfg)
+			}
+		})
+	}
+}
+
+func TestLoad_EnvOverridesUnset(t *testing.T) {
+	writeConfig(t, "extra_commands: [make]\nos_sandbox: true\ngit:\n  remote_write: true\n")
+	t.Setenv("LITE_SANDBOX_EXTRA_COMMANDS", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.ExtraCommands, []string{"make"}) {
+		t.Errorf("expected file extra_commands, got %v", cfg.ExtraCommands)
+	}
+	if !cfg.OSSandboxEnabled() || !cfg.Git.GitRemoteWrite() {
+		t.Errorf("expected file values to be kept, got %+v", cfg)
+	}
+	if cfg.Runtimes != nil || cfg.AWS != nil {
+		t.Errorf("expected no sections to be created, got %+v", cfg)
+	}
+}
+
+func TestLoad_EnvOverridesWithoutFile(t *testing.T) {
+	t.Setenv("LITE_SANDBOX_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("LITE_SANDBOX_OS_SANDBOX", "true")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.OSSandboxEnabled() {
+		t.Fatal("expected os_sandbox from the environment")
+	}
+}
+
+func TestLoad_EnvOverridesInvalidBool(t *testing.T) {
+	writeConfig(t, "")
+	t.Setenv("LITE_SANDBOX_GIT_REMOTE_WRITE", "yes please")
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), "LITE_SANDBOX_GIT_REMOTE_WRITE") {
+		t.Fatalf("expected invalid boolean error, got %v", err)
+	}
+}
+
+func TestLoad_EnvOverridesInvalidInt(t *testing.T) {
+	writeConfig(t, "")
+	t.Setenv("LITE_SANDBOX_RATE_LIMIT_BURST", "ten")
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), "LITE_SANDBOX_RATE_LIMIT_BURST: invalid integer") {
+		t.Fatalf("expected invalid integer error, got %v", err)
+	}
+}
+
+func TestLoadFile_IgnoresEnv(t *testing.T) {
+	writeConfig(t, "extra_commands: [make]\n")
+	t.Setenv("LITE_SANDBOX_EXTRA_COMMANDS", "curl")
+	cfg, err := LoadFile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.ExtraCommands, []string{"make"}) {
+		t.Fatalf("expected file extra_commands, got %v", cfg.ExtraCommands)
+	}
+}
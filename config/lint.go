@@ -0,0 +1,559 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LintSeverity classifies a LintIssue.
+type LintSeverity string
+
+const (
+	// LintError marks a config that is wrong or contradictory.
+	LintError LintSeverity = "error"
+	// LintWarning marks a config that loads but probably does not do what the
+	// user intended.
+	LintWarning LintSeverity = "warning"
+)
+
+// LintIssue is a single problem found by Lint.
+type LintIssue struct {
+	Severity LintSeverity
+	Field    string
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	if i.Field == "" {
+		return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+}
+
+// lookPath is exec.LookPath, replaceable in tests.
+var lookPath = exec.LookPath
+
+// RuntimeTools maps each runtime config key to the binary it needs on PATH.
+var RuntimeTools = map[string]string{
+	"go":   "go",
+	"pnpm": "pnpm",
+	"rust": "cargo",
+}
+
+// LintFile reads the config file at path and lints it.
+func LintFile(path string) ([]LintIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(data), nil
+}
+
+// Lint checks raw config YAML for mistakes that Load silently accepts:
+// unknown fields, paths that do not exist, invalid readonly_subpaths and
+// local_binary_execution.allowed_scripts globs, runtimes whose tool is not
+// installed, conflicting AWS and git settings, AWS session policies that
+// cannot be applied, commands that are both extra and denied,
+// os_sandbox_required without os_sandbox, invalid rate limits, and invalid
+// or non-loopback status server addresses. Issues are returned in a stable
+// order.
+func Lint(data []byte) []LintIssue {
+	var issues []LintIssue
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return []LintIssue{{Severity: LintError, Message: fmt.Sprintf("parsing config: %v", err)}}
+	}
+
+	// Load ignores unknown fields for forward compatibility; a strict decode
+	// surfaces typos like "writeable_paths".
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var strict Config
+	if err := dec.Decode(&strict); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				issues = append(issues, LintIssue{Severity: LintError, Message: "unknown field: " + msg})
+			}
+		} else if !errors.Is(err, io.EOF) {
+			issues = append(issues, LintIssue{Severity: LintError, Message: err.Error()})
+		}
+	}
+
+	issues = append(issues, lintPaths("readable_paths", cfg.ReadablePaths)...)
+	issues = append(issues, lintPaths("writable_paths", cfg.WritablePaths)...)
+	issues = append(issues, lintPaths("default_readable_paths", cfg.DefaultReadablePaths)...)
+	issues = append(issues, lintPaths("default_writable_paths", cfg.DefaultWritablePaths)...)
+	issues = append(issues, lintPaths("os_sandbox_extra_writable_binds", cfg.OSSandboxExtraWritableBinds)...)
+	issues = append(issues, lintPaths("os_sandbox_extra_readable_binds", cfg.OSSandboxExtraReadableBinds)...)
+	issues = append(issues, lintReadonlySubpaths(cfg.ReadonlySubpaths)...)
+	issues = append(issues, lintTrustedSourceFiles(&cfg)...)
+	issues = append(issues, lintExecutionPath(&cfg)...)
+	issues = append(issues, lintAllowedScripts(cfg.LocalBinaryExecution)...)
+	issues = append(issues, lintCommands(&cfg)...)
+	issues = append(issues, lintOSSandbox(&cfg)...)
+	issues = append(issues, lintRateLimit(cfg.RateLimit)...)
+	issues = append(issues, lintStatusAddr(cfg.StatusAddr)...)
+	issues = append(issues, lintGit(cfg.Git)...)
+	issues = append(issues, lintRuntimes(cfg.Runtimes)...)
+	issues = append(issues, lintAWS(cfg.AWS)...)
+	return issues
+}
+
+func lintPaths(field string, paths []string) []LintIssue {
+	var issues []LintIssue
+	for _, p := range paths {
+		path, err := expandPath(p)
+		if err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  err.Error() + " and is skipped",
+			})
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  fmt.Sprintf("%q does not exist", p),
+			})
+			continue
+		}
+		if !info.IsDir() {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  fmt.Sprintf("%q is not a directory", p),
+			})
+		}
+	}
+	return issues
+}
+
+// isAbsConfigPath reports whether p is absolute once environment variables
+// and ~ are expanded. Paths that reference an unset variable count as
+// absolute; they are reported when they are expanded.
+func isAbsConfigPath(p string) bool {
+	expanded, err := expandEnv(p)
+	return err != nil || filepath.IsAbs(expanded) || strings.HasPrefix(expanded, "~")
+}
+
+func lintReadonlySubpaths(patterns []string) []LintIssue {
+	var issues []LintIssue
+	for _, pattern := range patterns {
+		field := fmt.Sprintf("readonly_subpaths[%q]", pattern)
+		if filepath.IsAbs(pattern) {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "must be relative to the writable directories",
+			})
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "is not a valid glob",
+			})
+		}
+	}
+	return issues
+}
+
+// lintExecutionPath checks that execution_path entries are absolute
+// directories that sandboxed commands cannot write to, since a binary
+// planted in one would shadow an allowed command.
+func lintExecutionPath(cfg *Config) []LintIssue {
+	var issues []LintIssue
+	writable := expandPaths(slices.Concat(cfg.WritablePaths, cfg.DefaultWritablePaths))
+	for _, p := range cfg.ExecutionPath {
+		field := fmt.Sprintf("execution_path[%q]", p)
+		if !isAbsConfigPath(p) {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "must be an absolute path",
+			})
+			continue
+		}
+		issues = append(issues, lintPaths("execution_path", []string{p})...)
+		path, err := expandPath(p)
+		if err != nil {
+			continue // reported by lintPaths
+		}
+		for _, dir := range writable {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				issues = append(issues, LintIssue{
+					Severity: LintWarning,
+					Field:    field,
+					Message:  fmt.Sprintf("is under writable path %q, so sandboxed commands can plant binaries in it", dir),
+				})
+				break
+			}
+		}
+	}
+	return issues
+}
+
+func lintTrustedSourceFiles(cfg *Config) []LintIssue {
+	var issues []LintIssue
+	writable := expandPaths(slices.Concat(cfg.WritablePaths, cfg.DefaultWritablePaths))
+	for _, p := range cfg.TrustedSourceFiles {
+		field := fmt.Sprintf("trusted_source_files[%q]", p)
+		if !isAbsConfigPath(p) {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "must be an absolute path",
+			})
+			continue
+		}
+		path, err := expandPath(p)
+		if err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  err.Error() + " and is skipped",
+			})
+			continue
+		}
+		if info, err := os.Stat(path); err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  "does not exist",
+			})
+		} else if info.IsDir() {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "is a directory, not a file",
+			})
+		}
+		for _, dir := range writable {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				issues = append(issues, LintIssue{
+					Severity: LintWarning,
+					Field:    field,
+					Message:  fmt.Sprintf("is under writable path %q, so sandboxed commands can change what it runs", dir),
+				})
+				break
+			}
+		}
+	}
+	return issues
+}
+
+func lintAllowedScripts(l *LocalBinaryExecutionConfig) []LintIssue {
+	if l == nil {
+		return nil
+	}
+	var issues []LintIssue
+	for _, pattern := range l.AllowedScripts {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    fmt.Sprintf("local_binary_execution.allowed_scripts[%q]", pattern),
+				Message:  "is not a valid glob",
+			})
+		}
+	}
+	return issues
+}
+
+func lintCommands(cfg *Config) []LintIssue {
+	var issues []LintIssue
+	denied := make(map[string]bool, len(cfg.DeniedCommands))
+	for _, c := range cfg.DeniedCommands {
+		denied[c] = true
+	}
+	for _, c := range cfg.ExtraCommands {
+		if denied[c] {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    "extra_commands",
+				Message:  fmt.Sprintf("%q is also in denied_commands and will always be blocked", c),
+			})
+		}
+	}
+	switch cfg.UnknownCommandPolicy {
+	case "", UnknownCommandPolicyBlock, UnknownCommandPolicyWarn:
+	default:
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "unknown_command_policy",
+			Message:  fmt.Sprintf("%q is not one of %q or %q", cfg.UnknownCommandPolicy, UnknownCommandPolicyBlock, UnknownCommandPolicyWarn),
+		})
+	}
+	return issues
+}
+
+func lintOSSandbox(cfg *Config) []LintIssue {
+	if cfg.RequiresOSSandbox() && !cfg.OSSandboxEnabled() {
+		return []LintIssue{{
+			Severity: LintError,
+			Field:    "os_sandbox_required",
+			Message:  "is set but os_sandbox is disabled, so every command will fail",
+		}}
+	}
+	if !cfg.OSSandboxEnabled() {
+		var issues []LintIssue
+		if len(cfg.OSSandboxExtraWritableBinds) > 0 {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    "os_sandbox_extra_writable_binds",
+				Message:  "has no effect because os_sandbox is disabled",
+			})
+		}
+		if len(cfg.OSSandboxExtraReadableBinds) > 0 {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    "os_sandbox_extra_readable_binds",
+				Message:  "has no effect because os_sandbox is disabled",
+			})
+		}
+		return issues
+	}
+	return nil
+}
+
+func lintRateLimit(r *RateLimitConfig) []LintIssue {
+	if r == nil {
+		return nil
+	}
+	var issues []LintIssue
+	if r.CommandsPerMinute < 0 {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "rate_limit.commands_per_minute",
+			Message:  fmt.Sprintf("must not be negative, got %d", r.CommandsPerMinute),
+		})
+	}
+	if r.Burst < 0 {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "rate_limit.burst",
+			Message:  fmt.Sprintf("must not be negative, got %d", r.Burst),
+		})
+	} else if r.Burst > 0 && r.CommandsPerMinute <= 0 {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "rate_limit.burst",
+			Message:  "is ignored without commands_per_minute",
+		})
+	}
+	return issues
+}
+
+func lintStatusAddr(addr string) []LintIssue {
+	if addr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	switch {
+	case err != nil:
+		return []LintIssue{{
+			Severity: LintError,
+			Field:    "status_addr",
+			Message:  fmt.Sprintf("%q is not a host:port address", addr),
+		}}
+	case host != "" && !IsLoopbackHost(host):
+		return []LintIssue{{
+			Severity: LintWarning,
+			Field:    "status_addr",
+			Message:  fmt.Sprintf("%q is not loopback; command statistics may be reachable from other hosts", addr),
+		}}
+	}
+	return nil
+}
+
+func lintGit(g *GitConfig) []LintIssue {
+	if g == nil {
+		return nil
+	}
+	var issues []LintIssue
+	if g.GitRemoteWrite() && !g.GitRemoteRead() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "git",
+			Message:  "remote_write is enabled but remote_read is disabled; push works but fetch and pull do not",
+		})
+	}
+	if g.GitRemoteWrite() && !g.GitLocalWrite() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "git",
+			Message:  "remote_write is enabled but local_write is disabled; there is no way to create commits to push",
+		})
+	}
+	patterns := make([]string, 0, len(g.PerPath))
+	for pattern := range g.PerPath {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		field := fmt.Sprintf("git.per_path[%q]", pattern)
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    field,
+				Message:  "is not a valid glob",
+			})
+		}
+		if o := g.PerPath[pattern]; o != nil && len(o.PerPath) > 0 {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    field,
+				Message:  "nested per_path is ignored",
+			})
+		}
+	}
+	return issues
+}
+
+func lintRuntimes(r *RuntimesConfig) []LintIssue {
+	if r == nil {
+		return nil
+	}
+	enabled := map[string]bool{
+		"go":   r.Go.GoEnabled(),
+		"pnpm": r.Pnpm.PnpmEnabled(),
+		"rust": r.Rust.RustEnabled(),
+	}
+	var issues []LintIssue
+	for _, name := range []string{"go", "pnpm", "rust"} {
+		if !enabled[name] {
+			continue
+		}
+		bin := RuntimeTools[name]
+		if _, err := lookPath(bin); err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Field:    "runtimes." + name,
+				Message:  fmt.Sprintf("enabled but %q was not found on PATH", bin),
+			})
+		}
+	}
+	return issues
+}
+
+func lintAWS(a *AWSConfig) []LintIssue {
+	if a == nil {
+		return nil
+	}
+	var issues []LintIssue
+	if a.AllowsRawCredentials() && a.ForceProfile != "" {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "aws",
+			Message:  "allow_raw_credentials and force_profile are mutually exclusive",
+		})
+	}
+	if a.ForceProfile != "" && strings.TrimSpace(a.ForceProfile) != a.ForceProfile {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "aws.force_profile",
+			Message:  fmt.Sprintf("%q has leading or trailing whitespace", a.ForceProfile),
+		})
+	}
+	if a.HasSessionPolicy() && a.RoleARN == "" {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "aws",
+			Message:  "session_policy and session_policy_arns require role_arn",
+		})
+	}
+	if a.SessionPolicy != "" && !json.Valid([]byte(a.SessionPolicy)) {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "aws.session_policy",
+			Message:  "is not valid JSON",
+		})
+	}
+	if (a.RoleARN != "" || a.HasSessionPolicy()) && !a.UsesIMDS() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "aws",
+			Message:  "role_arn and session policies only apply with force_profile and are ignored",
+		})
+	}
+	if a.MaxCredentialTTL < 0 {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Field:    "aws.max_credential_ttl",
+			Message:  fmt.Sprintf("must not be negative, got %s", a.MaxCredentialTTL),
+		})
+	} else if a.MaxCredentialTTL > 0 && !a.UsesIMDS() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "aws.max_credential_ttl",
+			Message:  "only applies with force_profile and is ignored",
+		})
+	}
+	if a.IMDSAddr != "" {
+		host, _, err := net.SplitHostPort(a.IMDSAddr)
+		switch {
+		case err != nil:
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    "aws.imds_address",
+				Message:  fmt.Sprintf("%q is not a host:port address", a.IMDSAddr),
+			})
+		case !IsLoopbackHost(host) && !a.AllowsNonLoopbackIMDS():
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Field:    "aws.imds_address",
+				Message:  fmt.Sprintf("%q is not loopback; set imds_allow_non_loopback to expose credentials on it", a.IMDSAddr),
+			})
+		}
+	}
+	if a.AllowsNonLoopbackIMDS() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "aws.imds_allow_non_loopback",
+			Message:  "credentials may be reachable from other hosts",
+		})
+	}
+	if !a.AWSEnabled() {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "aws",
+			Message:  "section is present but neither allow_raw_credentials nor force_profile is set, so aws is disabled",
+		})
+		return issues
+	}
+	if _, err := lookPath("aws"); err != nil {
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Field:    "aws",
+			Message:  "enabled but \"aws\" was not found on PATH",
+		})
+	}
+	return issues
+}
+
+// IsLoopbackHost reports whether host is "localhost" or a loopback IP such as
+// 127.0.0.1 or ::1. An empty host (all interfaces) is not loopback.
+func IsLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubLookPath makes only the named binaries appear installed.
+func stubLookPath(t *testing.T, installed ...string) {
+	t.Helper()
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+	lookPath = func(name string) (string, error) {
+		for _, bin := range installed {
+			if bin == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+func TestLint(t *testing.T) {
+	stubLookPath(t, "go", "aws")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	t.Setenv("LITE_SANDBOX_TEST_DIR", dir)
+
+	tests := []struct {
+		name     string
+		yaml     string
+		severity LintSeverity
+		contains string
+	}{
+		{"invalid yaml", "extra_commands: [", LintError, "parsing config"},
+		{"unknown top-level field", "writeable_paths: [/tmp]\n", LintError, "writeable_paths"},
+		{"unknown nested field", "git:\n  remote_wirte: true\n", LintError, "remote_wirte"},
+		{"missing writable path", "writable_paths: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"missing readable path", "readable_paths: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"writable path is a file", "writable_paths: [" + file + "]\n", LintWarning, "not a directory"},
+		{"missing default readable path", "default_readable_paths: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"default writable path is a file", "default_writable_paths: [" + file + "]\n", LintWarning, "not a directory"},
+		{"readonly subpath absolute", "readonly_subpaths: [/vendor]\n", LintError, "must be relative"},
+		{"readonly subpath bad glob", "readonly_subpaths: [\"vendor/[\"]\n", LintError, "not a valid glob"},
+		{"allowed script bad glob", "local_binary_execution:\n  allowed_scripts: [\"build[.sh\"]\n", LintError, "not a valid glob"},
+		{"extra overlaps denied", "extra_commands: [curl]\ndenied_commands: [curl]\n", LintError, "\"curl\" is also in denied_commands"},
+		{"invalid unknown_command_policy", "unknown_command_policy: allow\n", LintError, "unknown_command_policy"},
+		{"os sandbox required but disabled", "os_sandbox_required: true\n", LintError, "os_sandbox is disabled"},
+		{"missing extra writable bind", "os_sandbox: true\nos_sandbox_extra_writable_binds: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"trusted source file relative", "trusted_source_files: [lib.sh]\n", LintError, "must be an absolute path"},
+		{"missing trusted source file", "trusted_source_files: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"trusted source file is a directory", "trusted_source_files: [" + dir + "]\n", LintError, "is a directory"},
+		{"trusted source file writable", "writable_paths: [" + dir + "]\ntrusted_source_files: [" + file + "]\n", LintWarning, "is under writable path"},
+		{"execution path relative", "execution_path: [bin]\n", LintError, "must be an absolute path"},
+		{"missing execution path", "execution_path: [" + missing + "]\n", LintWarning, "does not exist"},
+		{"execution path writable", "writable_paths: [" + dir + "]\nexecution_path: [" + dir + "]\n", LintWarning, "can plant binaries"},
+		{"execution path from variable", "writable_paths: [" + dir + "]\nexecution_path: [$LITE_SANDBOX_TEST_DIR]\n", LintWarning, "can plant binaries"},
+		{"path with unset variable", "writable_paths: [$LITE_SANDBOX_TEST_UNSET/x]\n", LintWarning, "references unset variable $LITE_SANDBOX_TEST_UNSET"},
+		{"trusted source file with unset variable", "trusted_source_files: [$LITE_SANDBOX_TEST_UNSET/lib.sh]\n", LintWarning, "is skipped"},
+		{"extra binds without os sandbox", "os_sandbox_extra_readable_binds: [/usr/share]\n", LintWarning, "os_sandbox_extra_readable_binds: has no effect"},
+		{"negative rate limit", "rate_limit:\n  commands_per_minute: -1\n", LintError, "must not be negative"},
+		{"negative burst", "rate_limit:\n  commands_per_minute: 10\n  burst: -1\n", LintError, "rate_limit.burst: must not be negative"},
+		{"burst without rate", "rate_limit:\n  burst: 5\n", LintWarning, "ignored without commands_per_minute"},
+		{"status address not host:port", "status_addr: localhost\n", LintError, "status_addr: \"localhost\" is not a host:port"},
+		{"status address not loopback", "status_addr: 0.0.0.0:9090\n", LintWarning, "reachable from other hosts"},
+		{"git push without fetch", "git:\n  remote_read: false\n  remote_write: true\n", LintWarning, "remote_read is disabled"},
+		{"git push without commit", "git:\n  local_write: false\n  remote_write: true\n", LintWarning, "local_write is disabled"},
+		{"git per_path bad glob", "git:\n  per_path:\n    \"/work/[\":\n      remote_write: true\n", LintError, "not a valid glob"},
+		{"git nested per_path", "git:\n  per_path:\n    /work/*:\n      per_path:\n        /x: {}\n", LintWarning, "nested per_path is ignored"},
+		{"runtime tool missing", "runtimes:\n  pnpm:\n    enabled: true\n", LintWarning, "\"pnpm\" was not found"},
+		{"rust runtime checks cargo", "runtimes:\n  rust:\n    enabled: true\n", LintWarning, "\"cargo\" was not found"},
+		{"aws conflicting modes", "aws:\n  allow_raw_credentials: true\n  force_profile: dev\n", LintError, "mutually exclusive"},
+		{"aws profile whitespace", "aws:\n  force_profile: \"dev \"\n", LintError, "whitespace"},
+		{"aws session policy without role", "aws:\n  force_profile: dev\n  session_policy_arns: [arn:aws:iam::aws:policy/ReadOnlyAccess]\n", LintError, "require role_arn"},
+		{"aws invalid session policy", "aws:\n  force_profile: dev\n  role_arn: arn:aws:iam::123456789012:role/r\n  session_policy: \"{bad\"\n", LintError, "not valid JSON"},
+		{"aws role without force_profile", "aws:\n  allow_raw_credentials: true\n  role_arn: arn:aws:iam::123456789012:role/r\n", LintWarning, "ignored"},
+		{"aws negative max ttl", "aws:\n  force_profile: dev\n  max_credential_ttl: -5m\n", LintError, "must not be negative"},
+		{"aws max ttl without force_profile", "aws:\n  allow_raw_credentials: true\n  max_credential_ttl: 15m\n", LintWarning, "max_credential_ttl: only applies"},
+		{"aws max ttl not a duration", "aws:\n  force_profile: dev\n  max_credential_ttl: soon\n", LintError, "parsing config"},
+		{"aws imds address not host:port", "aws:\n  force_profile: dev\n  imds_address: 127.0.0.1\n", LintError, "not a host:port"},
+		{"aws imds address not loopback", "aws:\n  force_profile: dev\n  imds_address: 0.0.0.0:0\n", LintError, "not loopback"},
+		{"aws imds non-loopback allowed", "aws:\n  force_profile: dev\n  imds_address: 0.0.0.0:0\n  imds_allow_non_loopback: true\n", LintWarning, "reachable from other hosts"},
+		{"aws section without mode", "aws:\n  allow_raw_credentials: false\n", LintWarning, "aws is disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := Lint([]byte(tt.yaml))
+			for _, i := range issues {
+				if i.Severity == tt.severity && strings.Contains(i.String(), tt.contains) {
+					return
+				}
+			}
+			t.Fatalf("expected %s containing %q, got %v", tt.severity, tt.contains, issues)
+		})
+	}
+}
+
+func TestLint_AWSMissingCLI(t *testing.T) {
+	stubLookPath(t)
+	issues := Lint([]byte("aws:\n  force_profile: dev\n"))
+	if len(issues) != 1 || issues[0].Severity != LintWarning || !strings.Contains(issues[0].Message, "\"aws\" was not found") {
+		t.Fatalf("expected a single missing aws CLI warning, got %v", issues)
+	}
+}
+
+func TestLint_CleanConfig(t *testing.T) {
+	stubLookPath(t, "go", "aws")
+	dir := t.TempDir()
+	yaml := `extra_commands: [make]
+denied_commands: [curl]
+readable_paths: [` + dir + `]
+writable_paths: [` + dir + `]
+default_readable_paths: [` + dir + `]
+default_writable_paths: [` + dir + `]
+unknown_command_policy: block
+readonly_subpaths: [vendor/**, "**/node_modules"]
+git:
+  remote_write: true
+  per_path:
+    ~/work/trusted/*:
+      remote_write: true
+runtimes:
+  go:
+    enabled: true
+aws:
+  force_profile: dev
+  role_arn: arn:aws:iam::123456789012:role/sandbox
+  session_policy: '{"Version":"2012-10-17","Statement":[]}'
+  session_policy_arns: [arn:aws:iam::aws:policy/ReadOnlyAccess]
+  max_credential_ttl: 15m
+  imds_address: "[::1]:0"
+os_sandbox: true
+os_sandbox_required: true
+rate_limit:
+  commands_per_minute: 120
+  burst: 20
+status_addr: ":9090"
+`
+	if issues := Lint([]byte(yaml)); len(issues) != 0 {
+		t.Fatalf("expected no issues, got %v", issues)
+	}
+	if issues := Lint(nil); len(issues) != 0 {
+		t.Fatalf("expected no issues for an empty config, got %v", issues)
+	}
+}
+
+func TestLintFile(t *testing.T) {
+	stubLookPath(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := LintFile(path); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("bogus: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := LintFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(issues) != 1 || issues[0].Severity != LintError {
+		t.Fatalf("expected one error, got %v", issues)
+	}
+}
@@ -15,6 +15,9 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	sandboxconfig "github.com/gartnera/lite-sandbox/config"
 )
 
 // Server implements an IMDSv2-compatible HTTP server that provides AWS credentials
@@ -28,8 +31,45 @@ type Server struct {
 	sessionStore *sessionStore
 	server       *http.Server
 	listener     net.Listener
+
+	sessionPolicy *SessionPolicy
+	maxTTL        time.Duration
+	// loadConfig loads the AWS config for the profile; replaced in tests.
+	loadConfig func(ctx context.Context, profile string) (aws.Config, error)
+	// newSTSClient builds the STS client used for AssumeRole; replaced in tests.
+	newSTSClient func(cfg aws.Config) assumeRoleAPI
+}
+
+const (
+	// credentialRefreshMargin is how long before expiry cached credentials
+	// are refreshed.
+	credentialRefreshMargin = 5 * time.Minute
+	// minAssumeRoleDuration is the shortest session STS AssumeRole accepts.
+	minAssumeRoleDuration = 15 * time.Minute
+	// defaultAssumeRoleDuration is the session length STS grants when no
+	// duration is requested.
+	defaultAssumeRoleDuration = time.Hour
+)
+
+// SessionPolicy scopes down the credentials the server hands out. The
+// profile's credentials are used to assume RoleARN, and Policy (an inline
+// IAM policy document) and PolicyARNs are passed to AssumeRole as session
+// policies. The resulting permissions are the intersection of the role's
+// policies and the session policies.
+type SessionPolicy struct {
+	RoleARN    string
+	Policy     string
+	PolicyARNs []string
+}
+
+// assumeRoleAPI is the subset of the STS client used by the server.
+type assumeRoleAPI interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
 }
 
+// roleSessionName identifies sessions created by the server in CloudTrail.
+const roleSessionName = "lite-sandbox-imds"
+
 // credentialCache stores AWS credentials and their expiry time.
 type credentialCache struct {
 	mu        sync.RWMutex
@@ -43,11 +83,41 @@ type sessionStore struct {
 	sessions map[string]time.Time // token -> expiry
 }
 
+// ServerOption configures optional NewServer behavior.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	allowNonLoopback bool
+}
+
+// AllowNonLoopback lets NewServer bind to an address that is not loopback.
+// The server hands out AWS credentials to anyone who can reach it, so this
+// should only be used when the network is otherwise isolated.
+func AllowNonLoopback() ServerOption {
+	return func(o *serverOptions) {
+		o.allowNonLoopback = true
+	}
+}
+
 // NewServer creates a new IMDS server that will listen on the given address
 // and use the specified AWS profile for credential lookups.
 // The server starts listening immediately but does not serve until Start() is called.
 // If addr uses port 0, a random available port is assigned.
-func NewServer(addr string, profile string) (*Server, error) {
+// addr must be a loopback address (e.g., 127.0.0.1:0 or [::1]:0) unless
+// AllowNonLoopback is passed.
+func NewServer(addr string, profile string, opts ...ServerOption) (*Server, error) {
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMDS address %q: %w", addr, err)
+	}
+	if !o.allowNonLoopback && !sandboxconfig.IsLoopbackHost(host) {
+		return nil, fmt.Errorf("IMDS address %q is not a loopback address; refusing to expose credentials on a routable interface", addr)
+	}
+
 	// Generate cryptographically secure random token for URL path
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
@@ -60,6 +130,11 @@ func NewServer(addr string, profile string) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
+	// "localhost" is resolved by the system; check what was actually bound.
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !o.allowNonLoopback && !tcpAddr.IP.IsLoopback() {
+		listener.Close()
+		return nil, fmt.Errorf("IMDS address %q bound to non-loopback %s", addr, tcpAddr)
+	}
 
 	return &Server{
 		addr:        listener.Addr().String(), // Use actual bound address
@@ -70,9 +145,54 @@ func NewServer(addr string, profile string) (*Server, error) {
 			sessions: make(map[string]time.Time),
 		},
 		listener: listener,
+		loadConfig: func(ctx context.Context, profile string) (aws.Config, error) {
+			return config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(profile))
+		},
+		newSTSClient: func(cfg aws.Config) assumeRoleAPI {
+			return sts.NewFromConfig(cfg)
+		},
 	}, nil
 }
 
+// SetSessionPolicy makes the server assume p.RoleARN with p's session
+// policies instead of passing the profile's credentials through. It must be
+// called before Start. A nil p restores pass-through.
+func (s *Server) SetSessionPolicy(p *SessionPolicy) error {
+	if p != nil {
+		if p.RoleARN == "" {
+			return fmt.Errorf("session policy requires a role ARN to assume")
+		}
+		if p.Policy != "" && !json.Valid([]byte(p.Policy)) {
+			return fmt.Errorf("session policy is not valid JSON")
+		}
+	}
+	s.sessionPolicy = p
+	return nil
+}
+
+// SetMaxCredentialTTL caps how long credentials handed to clients are
+// reported as valid. Credentials are refreshed when the cap is reached even
+// if the upstream credentials live longer, and AssumeRole sessions (see
+// SetSessionPolicy) are requested with the shortest duration STS allows that
+// covers d. It must be called before Start. Zero disables the cap.
+func (s *Server) SetMaxCredentialTTL(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("max credential TTL must not be negative, got %s", d)
+	}
+	s.maxTTL = d
+	return nil
+}
+
+// refreshMargin returns how long before expiry cached credentials are
+// refreshed. Short TTLs shrink the margin so credentials are not refetched
+// on every request.
+func (s *Server) refreshMargin() time.Duration {
+	if s.maxTTL > 0 && s.maxTTL/4 < credentialRefreshMargin {
+		return s.maxTTL / 4
+	}
+	return credentialRefreshMargin
+}
+
 // Endpoint returns the full IMDS endpoint URL to pass to AWS CLI via
 // AWS_EC2_METADATA_SERVICE_ENDPOINT environment variable.
 // Returns base URL with trailing slash (AWS SDK appends paths like /latest/api/token).
@@ -230,32 +350,52 @@ func (s *Server) validateSession(r *http.Request) bool {
 // getCredentials fetches or returns cached AWS credentials.
 // For SSO/temporary credentials, returns them directly.
 // For IAM user credentials, could use STS GetSessionToken but we just pass through for simplicity.
+// If a session policy is set, the profile's credentials are instead used to
+// assume the configured role with that policy.
 func (s *Server) getCredentials(ctx context.Context) (*aws.Credentials, error) {
 	s.credCache.mu.Lock()
 	defer s.credCache.mu.Unlock()
 
-	// Check if cached credentials are still valid (refresh 5 min before expiry)
+	// Check if cached credentials are still valid (refresh 5 min before
+	// expiry, or sooner for a short max TTL)
 	if s.credCache.awsCreds != nil &&
-		time.Now().Before(s.credCache.expiresAt.Add(-5*time.Minute)) {
+		time.Now().Before(s.credCache.expiresAt.Add(-s.refreshMargin())) {
 		slog.Debug("using cached credentials")
 		return s.credCache.awsCreds, nil
 	}
 
 	slog.Info("fetching credentials from profile", "profile", s.profile)
+	fetchedAt := time.Now()
 
 	// Load AWS config with specified profile
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithSharedConfigProfile(s.profile),
-	)
+	cfg, err := s.loadConfig(ctx, s.profile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// Retrieve credentials from the profile
-	// This handles SSO, assume-role, and IAM user credentials automatically
-	creds, err := cfg.Credentials.Retrieve(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
+	var creds aws.Credentials
+	if s.sessionPolicy != nil {
+		creds, err = s.assumeScopedRole(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Retrieve credentials from the profile
+		// This handles SSO, assume-role, and IAM user credentials automatically
+		creds, err = cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
+		}
+	}
+
+	// Never report credentials as valid for longer than the max TTL, even
+	// if the upstream credentials live longer or do not expire at all.
+	if s.maxTTL > 0 {
+		limit := fetchedAt.Add(s.maxTTL)
+		if !creds.CanExpire || creds.Expires.After(limit) {
+			creds.CanExpire = true
+			creds.Expires = limit
+		}
 	}
 
 	// Cache credentials
@@ -268,3 +408,43 @@ func (s *Server) getCredentials(ctx context.Context) (*aws.Credentials, error) {
 
 	return &creds, nil
 }
+
+// assumeScopedRole assumes the session policy's role using the profile's
+// credentials and returns the scoped-down session credentials.
+func (s *Server) assumeScopedRole(ctx context.Context, cfg aws.Config) (aws.Credentials, error) {
+	p := s.sessionPolicy
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(p.RoleARN),
+		RoleSessionName: aws.String(roleSessionName),
+	}
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+	if s.maxTTL > 0 && s.maxTTL < defaultAssumeRoleDuration {
+		// Request the shortest session that covers the max TTL so the
+		// credentials stop working upstream soon after they are rotated.
+		duration := max(s.maxTTL, minAssumeRoleDuration)
+		input.DurationSeconds = aws.Int32(int32((duration + time.Second - 1) / time.Second))
+	}
+	for _, arn := range p.PolicyARNs {
+		input.PolicyArns = append(input.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	slog.Info("assuming role with session policy", "role", p.RoleARN, "policy_arns", len(p.PolicyARNs), "inline_policy", p.Policy != "")
+	out, err := s.newSTSClient(cfg).AssumeRole(ctx, input)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to assume role %s: %w", p.RoleARN, err)
+	}
+	if out.Credentials == nil {
+		return aws.Credentials{}, fmt.Errorf("assume role %s returned no credentials", p.RoleARN)
+	}
+	c := out.Credentials
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(c.AccessKeyId),
+		SecretAccessKey: aws.ToString(c.SecretAccessKey),
+		SessionToken:    aws.ToString(c.SessionToken),
+		Source:          "AssumeRoleWithSessionPolicy",
+		CanExpire:       c.Expiration != nil,
+		Expires:         aws.ToTime(c.Expiration),
+	}, nil
+}
@@ -2,9 +2,21 @@ package imds
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 )
 
 func TestNewServer_RandomPort(t *testing.T) {
@@ -114,3 +126,406 @@ func TestServer_GracefulShutdown(t *testing.T) {
 		t.Error("server did not stop")
 	}
 }
+
+// fakeSTS records AssumeRole calls and returns fixed credentials.
+type fakeSTS struct {
+	input *sts.AssumeRoleInput
+	cfg   aws.Config
+	err   error
+}
+
+func (f *fakeSTS) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.input = params
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sts.AssumeRoleOutput{
+		Credentials: &types.Credentials{
+			AccessKeyId:     aws.String("ASIASCOPED"),
+			SecretAccessKey: aws.String("scoped-secret"),
+			SessionToken:    aws.String("scoped-token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+// newTestProfileServer creates a server whose "test" profile resolves to
+// static credentials from a temporary shared credentials file, and whose STS
+// client is fake.
+func newTestProfileServer(t *testing.T, fake *fakeSTS) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	credsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	if err := os.WriteFile(credsFile, []byte("[test]\naws_access_key_id = AKIABASE\naws_secret_access_key = base-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte("[profile test]\nregion = us-east-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credsFile)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	server, err := NewServer("127.0.0.1:0", "test")
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	server.newSTSClient = func(cfg aws.Config) assumeRoleAPI {
+		fake.cfg = cfg
+		return fake
+	}
+	return server
+}
+
+func TestServer_SessionPolicy(t *testing.T) {
+	fake := &fakeSTS{}
+	server := newTestProfileServer(t, fake)
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+	err := server.SetSessionPolicy(&SessionPolicy{
+		RoleARN:    "arn:aws:iam::123456789012:role/sandbox",
+		Policy:     policy,
+		PolicyARNs: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	})
+	if err != nil {
+		t.Fatalf("SetSessionPolicy: %v", err)
+	}
+
+	creds, err := server.getCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("getCredentials: %v", err)
+	}
+	if creds.AccessKeyID != "ASIASCOPED" || creds.SessionToken != "scoped-token" {
+		t.Errorf("expected scoped credentials, got %+v", creds)
+	}
+
+	if fake.input == nil {
+		t.Fatal("AssumeRole was not called")
+	}
+	if got := aws.ToString(fake.input.RoleArn); got != "arn:aws:iam::123456789012:role/sandbox" {
+		t.Errorf("RoleArn = %q", got)
+	}
+	if got := aws.ToString(fake.input.Policy); got != policy {
+		t.Errorf("Policy = %q, want %q", got, policy)
+	}
+	if len(fake.input.PolicyArns) != 1 || aws.ToString(fake.input.PolicyArns[0].Arn) != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyArns = %+v", fake.input.PolicyArns)
+	}
+	if aws.ToString(fake.input.RoleSessionName) == "" {
+		t.Error("RoleSessionName must be set")
+	}
+
+	// The STS client must be built from the profile's credentials.
+	base, err := fake.cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("retrieving base credentials: %v", err)
+	}
+	if base.AccessKeyID != "AKIABASE" {
+		t.Errorf("STS client uses %q, want profile credentials", base.AccessKeyID)
+	}
+
+	// Cached credentials are reused without another AssumeRole call.
+	fake.input = nil
+	if _, err := server.getCredentials(context.Background()); err != nil {
+		t.Fatalf("getCredentials (cached): %v", err)
+	}
+	if fake.input != nil {
+		t.Error("expected cached credentials, but AssumeRole was called again")
+	}
+}
+
+func TestServer_SessionPolicyAssumeRoleError(t *testing.T) {
+	fake := &fakeSTS{err: errors.New("access denied")}
+	server := newTestProfileServer(t, fake)
+	if err := server.SetSessionPolicy(&SessionPolicy{RoleARN: "arn:aws:iam::123456789012:role/sandbox"}); err != nil {
+		t.Fatalf("SetSessionPolicy: %v", err)
+	}
+	if _, err := server.getCredentials(context.Background()); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("expected AssumeRole error, got %v", err)
+	}
+}
+
+func TestServer_NoSessionPolicyPassesThrough(t *testing.T) {
+	fake := &fakeSTS{}
+	server := newTestProfileServer(t, fake)
+
+	creds, err := server.getCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("getCredentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKIABASE" {
+		t.Errorf("expected profile credentials, got %q", creds.AccessKeyID)
+	}
+	if fake.input != nil {
+		t.Error("AssumeRole should not be called without a session policy")
+	}
+}
+
+func TestServer_SetSessionPolicyValidation(t *testing.T) {
+	server, err := NewServer("127.0.0.1:0", "default")
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	if err := server.SetSessionPolicy(&SessionPolicy{Policy: "{}"}); err == nil {
+		t.Error("expected error for policy without role ARN")
+	}
+	if err := server.SetSessionPolicy(&SessionPolicy{RoleARN: "arn:aws:iam::123456789012:role/sandbox", Policy: "{not json"}); err == nil {
+		t.Error("expected error for invalid policy JSON")
+	}
+	if err := server.SetSessionPolicy(nil); err != nil {
+		t.Errorf("nil policy should be accepted: %v", err)
+	}
+}
+
+// newFakeProviderServer creates a server whose profile resolves to
+// credentials that expire after upstreamTTL (never, if zero). calls counts
+// credential retrievals.
+func newFakeProviderServer(t *testing.T, upstreamTTL time.Duration, calls *int) *Server {
+	t.Helper()
+	server, err := NewServer("127.0.0.1:0", "test")
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		*calls++
+		creds := aws.Credentials{
+			AccessKeyID:     fmt.Sprintf("AKIA%d", *calls),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+		}
+		if upstreamTTL > 0 {
+			creds.CanExpire = true
+			creds.Expires = time.Now().Add(upstreamTTL)
+		}
+		return creds, nil
+	})
+	server.loadConfig = func(ctx context.Context, profile string) (aws.Config, error) {
+		return aws.Config{Region: "us-east-1", Credentials: provider}, nil
+	}
+	return server
+}
+
+func TestServer_MaxCredentialTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstreamTTL time.Duration
+		maxTTL      time.Duration
+		wantTTL     time.Duration
+	}{
+		{"long-lived upstream is capped", 12 * time.Hour, 15 * time.Minute, 15 * time.Minute},
+		{"non-expiring upstream is capped", 0, 15 * time.Minute, 15 * time.Minute},
+		{"short-lived upstream is kept", 5 * time.Minute, 15 * time.Minute, 5 * time.Minute},
+		{"no cap", 12 * time.Hour, 0, 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := newFakeProviderServer(t, tt.upstreamTTL, &calls)
+			if err := server.SetMaxCredentialTTL(tt.maxTTL); err != nil {
+				t.Fatalf("SetMaxCredentialTTL: %v", err)
+			}
+			start := time.Now()
+			creds, err := server.getCredentials(context.Background())
+			if err != nil {
+				t.Fatalf("getCredentials: %v", err)
+			}
+			if !creds.CanExpire {
+				t.Fatal("expected credentials to expire")
+			}
+			if limit := time.Now().Add(tt.wantTTL); creds.Expires.After(limit) {
+				t.Errorf("expiration %s exceeds %s", creds.Expires, limit)
+			}
+			if floor := start.Add(tt.wantTTL - time.Minute); creds.Expires.Before(floor) {
+				t.Errorf("expiration %s is earlier than expected (%s)", creds.Expires, floor)
+			}
+		})
+	}
+}
+
+func TestServer_MaxCredentialTTLReportedToClients(t *testing.T) {
+	calls := 0
+	server := newFakeProviderServer(t, 12*time.Hour, &calls)
+	if err := server.SetMaxCredentialTTL(10 * time.Minute); err != nil {
+		t.Fatalf("SetMaxCredentialTTL: %v", err)
+	}
+
+	tokenReq := httptest.NewRequest(http.MethodPut, "/latest/api/token", nil)
+	tokenRec := httptest.NewRecorder()
+	server.handleGetToken(tokenRec, tokenReq)
+	token := tokenRec.Body.String()
+
+	req := httptest.NewRequest(http.MethodGet, "/latest/meta-data/iam/security-credentials/sandboxed-role", nil)
+	req.SetPathValue("role", "sandboxed-role")
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	rec := httptest.NewRecorder()
+	server.handleGetCredentials(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Expiration string
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	expiration, err := time.Parse(time.RFC3339, resp.Expiration)
+	if err != nil {
+		t.Fatalf("parsing expiration %q: %v", resp.Expiration, err)
+	}
+	if limit := time.Now().Add(10 * time.Minute); expiration.After(limit) {
+		t.Errorf("reported expiration %s exceeds max TTL (%s)", expiration, limit)
+	}
+}
+
+func TestServer_MaxCredentialTTLRefreshes(t *testing.T) {
+	calls := 0
+	server := newFakeProviderServer(t, 12*time.Hour, &calls)
+	if err := server.SetMaxCredentialTTL(40 * time.Millisecond); err != nil {
+		t.Fatalf("SetMaxCredentialTTL: %v", err)
+	}
+
+	first, err := server.getCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("getCredentials: %v", err)
+	}
+	if _, err := server.getCredentials(context.Background()); err != nil {
+		t.Fatalf("getCredentials: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached credentials within the TTL, got %d retrievals", calls)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	second, err := server.getCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("getCredentials: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected a refresh after the TTL, got %d retrievals", calls)
+	}
+	if second.AccessKeyID == first.AccessKeyID {
+		t.Error("expected rotated credentials after the TTL")
+	}
+}
+
+func TestServer_MaxCredentialTTLAssumeRoleDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxTTL time.Duration
+		want   int32 // 0 means DurationSeconds is not set
+	}{
+		{"below STS minimum", 5 * time.Minute, 900},
+		{"between minimum and default", 20 * time.Minute, 1200},
+		{"partial second rounds up", 20*time.Minute + 500*time.Millisecond, 1201},
+		{"above default", 2 * time.Hour, 0},
+		{"no cap", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSTS{}
+			server := newTestProfileServer(t, fake)
+			if err := server.SetSessionPolicy(&SessionPolicy{RoleARN: "arn:aws:iam::123456789012:role/sandbox"}); err != nil {
+				t.Fatalf("SetSessionPolicy: %v", err)
+			}
+			if err := server.SetMaxCredentialTTL(tt.maxTTL); err != nil {
+				t.Fatalf("SetMaxCredentialTTL: %v", err)
+			}
+			if _, err := server.getCredentials(context.Background()); err != nil {
+				t.Fatalf("getCredentials: %v", err)
+			}
+			got := aws.ToInt32(fake.input.DurationSeconds)
+			if got != tt.want {
+				t.Errorf("DurationSeconds = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_SetMaxCredentialTTLNegative(t *testing.T) {
+	server, err := NewServer("127.0.0.1:0", "default")
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+	if err := server.SetMaxCredentialTTL(-time.Minute); err == nil {
+		t.Error("expected error for negative TTL")
+	}
+}
+
+func TestNewServer_LoopbackOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		opts    []ServerOption
+		wantErr bool
+	}{
+		{"ipv4 loopback", "127.0.0.1:0", nil, false},
+		{"localhost", "localhost:0", nil, false},
+		{"all interfaces", "0.0.0.0:0", nil, true},
+		{"empty host", ":0", nil, true},
+		{"ipv6 all interfaces", "[::]:0", nil, true},
+		{"missing port", "127.0.0.1", nil, true},
+		{"all interfaces when allowed", "0.0.0.0:0", []ServerOption{AllowNonLoopback()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewServer(tt.addr, "default", tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					server.Shutdown(context.Background())
+					t.Fatalf("expected %q to be rejected", tt.addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			server.Shutdown(context.Background())
+		})
+	}
+}
+
+func TestNewServer_IPv6Loopback(t *testing.T) {
+	server, err := NewServer("[::1]:0", "default")
+	if err != nil {
+		if strings.Contains(err.Error(), "failed to listen") {
+			t.Skipf("IPv6 loopback unavailable: %v", err)
+		}
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	endpoint := server.Endpoint()
+	if !strings.HasPrefix(endpoint, "http://[::1]:") || !strings.HasSuffix(endpoint, "/") {
+		t.Fatalf("unexpected IPv6 endpoint format: %s", endpoint)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatalf("endpoint %q is not a valid URL: %v", endpoint, err)
+	}
+	if u.Hostname() != "::1" || u.Port() == "" || u.Port() == "0" {
+		t.Fatalf("unexpected host/port in %s", endpoint)
+	}
+
+	go server.Start()
+	req, err := http.NewRequest(http.MethodPut, endpoint+"latest/api/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("requesting token over IPv6: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
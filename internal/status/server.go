@@ -0,0 +1,105 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	bash_sandboxed "github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
+)
+
+// Sandbox is the part of *bash_sandboxed.Sandbox the server reports on.
+type Sandbox interface {
+	Ready() error
+	Stats() bash_sandboxed.Stats
+}
+
+// Server is an optional HTTP server that reports the health and activity of
+// the MCP server's sandbox for liveness and readiness checks: /healthz,
+// /readyz and /stats. It is separate from the IMDS server.
+type Server struct {
+	addr     string
+	sandbox  Sandbox
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer creates a status server for sandbox listening on addr. An
+// address without a host (e.g. ":9090") listens on 127.0.0.1 only. The
+// server starts listening immediately but does not serve until Start is
+// called.
+func NewServer(addr string, sandbox Sandbox) (*Server, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status address %q: %w", addr, err)
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Server{
+		addr:     listener.Addr().String(),
+		sandbox:  sandbox,
+		listener: listener,
+	}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Handler returns the server's HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /stats", s.handleStats)
+	return mux
+}
+
+// Start starts the HTTP server. This blocks until the server is shut down.
+func (s *Server) Start() error {
+	s.server = &http.Server{Handler: s.Handler()}
+	slog.Info("starting status server", "addr", s.addr)
+	return s.server.Serve(s.listener)
+}
+
+// Shutdown gracefully shuts down the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.server != nil {
+		err := s.server.Shutdown(ctx)
+		s.listener.Close()
+		return err
+	}
+	return s.listener.Close()
+}
+
+// handleHealthz reports that the process is alive.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether commands can run, which requires the OS
+// sandbox worker to have started when the OS sandbox is enabled.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.sandbox.Ready(); err != nil {
+		http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleStats returns the sandbox's Stats as JSON.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.sandbox.Stats()); err != nil {
+		slog.Error("failed to write stats", "error", err)
+	}
+}
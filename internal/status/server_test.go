@@ -0,0 +1,157 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gartnera/lite-sandbox/config"
+	bash_sandboxed "github.com/gartnera/lite-sandbox/tool/bash_sandboxed"
+)
+
+// fakeSandbox reports a fixed readiness error and stats.
+type fakeSandbox struct {
+	readyErr error
+	stats    bash_sandboxed.Stats
+}
+
+func (f *fakeSandbox) Ready() error                { return f.readyErr }
+func (f *fakeSandbox) Stats() bash_sandboxed.Stats { return f.stats }
+
+// startServer starts a status server for sb on a random loopback port and
+// returns its base URL.
+func startServer(t *testing.T, sb Sandbox) string {
+	t.Helper()
+	srv, err := NewServer("127.0.0.1:0", sb)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	go srv.Start()
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return "http://" + srv.Addr()
+}
+
+// get fetches url and returns the status code and body.
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", url, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestNewServer_DefaultsToLoopback(t *testing.T) {
+	srv, err := NewServer(":0", &fakeSandbox{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+	if !strings.HasPrefix(srv.Addr(), "127.0.0.1:") {
+		t.Errorf("expected a loopback address, got %s", srv.Addr())
+	}
+
+	if _, err := NewServer("no-port", &fakeSandbox{}); err == nil {
+		t.Error("expected an error for an address without a port")
+	}
+}
+
+func TestServer_Healthz(t *testing.T) {
+	base := startServer(t, &fakeSandbox{readyErr: errors.New("worker down")})
+	code, body := get(t, base+"/healthz")
+	if code != http.StatusOK || body != "ok\n" {
+		t.Errorf("expected 200 ok even when not ready, got %d %q", code, body)
+	}
+}
+
+func TestServer_Readyz(t *testing.T) {
+	sb := &fakeSandbox{}
+	base := startServer(t, sb)
+
+	code, body := get(t, base+"/readyz")
+	if code != http.StatusOK || body != "ok\n" {
+		t.Errorf("expected 200 ok, got %d %q", code, body)
+	}
+
+	sb.readyErr = errors.New("failed to start worker")
+	code, body = get(t, base+"/readyz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "failed to start worker") {
+		t.Errorf("expected 503 with the readiness error, got %d %q", code, body)
+	}
+}
+
+func TestServer_ReadyzRequiredOSSandbox(t *testing.T) {
+	sb := bash_sandboxed.NewSandbox()
+	defer sb.Close()
+	base := startServer(t, sb)
+
+	if code, body := get(t, base+"/readyz"); code != http.StatusOK {
+		t.Errorf("expected ready without the OS sandbox, got %d %q", code, body)
+	}
+	required := true
+	sb.UpdateConfig(&config.Config{OSSandboxRequired: &required}, t.TempDir())
+	code, body := get(t, base+"/readyz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "os_sandbox is disabled") {
+		t.Errorf("expected 503 when the required OS sandbox is disabled, got %d %q", code, body)
+	}
+}
+
+func TestServer_Stats(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	sb := bash_sandboxed.NewSandbox()
+	defer sb.Close()
+	sb.UpdateConfig(&config.Config{RateLimit: &config.RateLimitConfig{CommandsPerMinute: 1, Burst: 3}}, dir)
+	ctx := context.Background()
+	sb.Execute(ctx, "echo hi", dir, paths, paths)
+	sb.Execute(ctx, "python3 -c 1", dir, paths, paths)
+	sb.Execute(ctx, "false", dir, paths, paths)
+	sb.Execute(ctx, "echo limited", dir, paths, paths)
+	base := startServer(t, sb)
+
+	resp, err := http.Get(base + "/stats")
+	if err != nil {
+		t.Fatalf("GET /stats: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %q", ct)
+	}
+	var got map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+
+	want := map[string]any{
+		"commands":            4.0,
+		"rate_limited":        1.0,
+		"validation_failures": 1.0,
+		"command_failures":    1.0,
+		"os_sandbox":          false,
+		"worker":              nil,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	rl, ok := got["rate_limit"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected a rate_limit object, got %v", got["rate_limit"])
+	}
+	if rl["commands_per_minute"] != 1.0 || rl["burst"] != 3.0 {
+		t.Errorf("unexpected rate limits: %v", rl)
+	}
+	if available, ok := rl["available"].(float64); !ok || available >= 1 {
+		t.Errorf("expected less than one command available, got %v", rl["available"])
+	}
+}
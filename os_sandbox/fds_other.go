@@ -0,0 +1,6 @@
+//go:build !unix
+
+package os_sandbox
+
+// closeInheritedFDs is a no-op on platforms without an OS sandbox.
+func closeInheritedFDs() {}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package os_sandbox
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// closeInheritedFDs marks every open descriptor above stderr as close-on-exec
+// so that commands started by the worker only inherit stdin, stdout and stderr.
+// Descriptors opened by the Go runtime are already close-on-exec, so this only
+// affects descriptors inherited from the parent process.
+func closeInheritedFDs() {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		slog.Warn("failed to list open file descriptors", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil || fd <= 2 {
+			continue
+		}
+		syscall.CloseOnExec(fd)
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
 // HostMsgType identifies messages sent from host to worker.
@@ -22,6 +24,7 @@ const (
 	HostMsgExec     HostMsgType = iota // Start a command (Args, Dir, Env)
 	HostMsgStdin                       // Stdin data chunk (Data)
 	HostMsgStdinEOF                    // No more stdin
+	HostMsgCancel                      // Stop the command
 )
 
 // HostMsg is a message sent from the MCP server to a worker process.
@@ -87,10 +90,72 @@ type Worker struct {
 	dead bool
 
 	nextID    uint64
-	pending   map[uint64]chan WorkerMsg
+	pending   map[uint64]*msgQueue
 	pendingMu sync.Mutex
 }
 
+// msgQueue is an unbounded queue of the messages for one execution. The
+// dispatcher must never block on an execution that is slow to read its
+// messages: in a pipeline, that execution may be waiting to write its output
+// into the stdin of another execution, whose own messages would then never
+// be delivered.
+type msgQueue struct {
+	mu     sync.Mutex
+	msgs   []WorkerMsg
+	closed bool
+	// ready has room for one signal, sent when msgs or closed change.
+	ready chan struct{}
+}
+
+func newMsgQueue() *msgQueue {
+	return &msgQueue{ready: make(chan struct{}, 1)}
+}
+
+// push appends msg to the queue without blocking.
+func (q *msgQueue) push(msg WorkerMsg) {
+	q.mu.Lock()
+	q.msgs = append(q.msgs, msg)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close marks the end of the messages; pop returns false once the queued
+// messages are consumed.
+func (q *msgQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *msgQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next message, waiting for one if the queue is empty. It
+// returns false if the queue is empty and closed.
+func (q *msgQueue) pop() (WorkerMsg, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.msgs) > 0 {
+			msg := q.msgs[0]
+			q.msgs[0] = WorkerMsg{}
+			q.msgs = q.msgs[1:]
+			q.mu.Unlock()
+			return msg, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return WorkerMsg{}, false
+		}
+		<-q.ready
+	}
+}
+
 // sshAllowedFiles are the non-key files in ~/.ssh that remain accessible in the sandbox.
 var sshAllowedFiles = map[string]bool{
 	"known_hosts":      true,
@@ -125,9 +190,11 @@ func getSSHPrivateKeyPaths(sshDir string) []string {
 // The worker runs the "lite-sandbox sandbox-worker" subcommand inside a platform-specific sandbox.
 // On Linux, this uses bwrap. On macOS, this uses sandbox-exec with SBPL profiles.
 // extraBinds specifies additional writable paths to bind mount (e.g., for runtimes).
+// readOnlyBinds specifies additional read-only paths to bind mount, for paths the
+// sandbox would otherwise hide, such as those under /tmp.
 // blockAWSCredentials specifies whether to block ~/.aws directory.
 // Note: ~/.ssh private keys are ALWAYS blocked regardless of this parameter.
-func StartWorker(ctx context.Context, workDir string, extraBinds []string, blockAWSCredentials bool) (*Worker, error) {
+func StartWorker(ctx context.Context, workDir string, extraBinds, readOnlyBinds []string, blockAWSCredentials bool) (*Worker, error) {
 	// Find our own binary path to pass to the sandbox
 	self, err := os.Executable()
 	if err != nil {
@@ -175,29 +242,62 @@ func StartWorker(ctx context.Context, workDir string, extraBinds []string, block
 
 	slog.InfoContext(ctx, "starting worker", "binary", self, "workDir", realWorkDir, "platform", runtime.GOOS)
 
+	// The worker rejects any exec whose Dir is not under workDir or a bind.
+	workerArgs := []string{self, "sandbox-worker", "--allowed-dir", realWorkDir}
+	for _, path := range slices.Concat(extraBinds, readOnlyBinds) {
+		workerArgs = append(workerArgs, "--allowed-dir", path)
+	}
+
 	// Platform-specific sandbox command setup
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "linux":
 		// Build bwrap command
-		// Strategy: bind root read-only, add writable tmpfs for /tmp, add runtime binds, then rebind workDir as writable
-		// The order matters: later mounts can override earlier ones, so workDir bind comes last
-		// and will override the tmpfs if workDir is under /tmp (e.g., in tests)
+		// Strategy: bind root read-only, add writable tmpfs for /tmp, add read-only and runtime binds,
+		// rebind workDir as writable, then block credentials
+		// The order matters: later mounts can override earlier ones, so workDir bind comes after the
+		// other binds and will override the tmpfs if workDir is under /tmp (e.g., in tests), and
+		// credentials are blocked last so that no bind (e.g., of the home directory) exposes them
 		// --ro-bind / / : read-only root filesystem
 		// --tmpfs /tmp : writable /tmp (needed by Go and other tools for build cache)
-		// --tmpfs <credential-dir> : empty overlay to block credential access
+		// --ro-bind <path> <path> : extra read-only directories
 		// --bind <runtime-path> <runtime-path> : writable runtime directories (GOPATH, etc.)
 		// --bind <cwd> <cwd> : writable current working directory (overrides tmpfs if under /tmp)
+		// --tmpfs <credential-dir> : empty overlay to block credential access
 		// --dev /dev : fresh devtmpfs
 		// --proc /proc : fresh procfs
-		// --unshare-all --share-net : unshare everything except network
+		// --unshare-all --share-net : unshare everything except network; this
+		//   includes the PID namespace, so ps only sees the sandbox's processes
 		// --die-with-parent : kill worker if parent dies
+		// --new-session : detach from the controlling terminal (blocks TIOCSTI injection)
 		// --chdir <cwd> : start in working directory
 		args := []string{
 			"--ro-bind", "/", "/",
 			"--tmpfs", "/tmp",
 		}
 
+		// Add read-only bind mounts before the writable ones, so that a writable
+		// directory nested in a read-only one stays writable
+		for _, path := range readOnlyBinds {
+			if _, err := os.Stat(path); err != nil {
+				slog.WarnContext(ctx, "skipping missing read-only bind path", "path", path, "error", err)
+				continue
+			}
+			args = append(args, "--ro-bind", path, path)
+		}
+
+		// Add runtime bind mounts (e.g., GOPATH for Go runtime)
+		for _, path := range extraBinds {
+			// Create the directory if it doesn't exist
+			if err := os.MkdirAll(path, 0755); err != nil {
+				slog.WarnContext(ctx, "failed to create runtime bind path", "path", path, "error", err)
+				continue
+			}
+			args = append(args, "--bind", path, path)
+		}
+
+		args = append(args, "--bind", realWorkDir, realWorkDir)
+
 		// Block credential files/directories with overlays
 		homeDir, err := os.UserHomeDir()
 		if err == nil {
@@ -216,45 +316,45 @@ func StartWorker(ctx context.Context, workDir string, extraBinds []string, block
 			}
 		}
 
-		// Add runtime bind mounts (e.g., GOPATH for Go runtime)
-		for _, path := range extraBinds {
-			// Create the directory if it doesn't exist
-			if err := os.MkdirAll(path, 0755); err != nil {
-				slog.WarnContext(ctx, "failed to create runtime bind path", "path", path, "error", err)
-				continue
-			}
-			args = append(args, "--bind", path, path)
-		}
-
-		// Add workDir bind and remaining args
+		// Add remaining args
 		args = append(args,
-			"--bind", realWorkDir, realWorkDir,
 			"--dev", "/dev",
 			"--proc", "/proc",
 			"--unshare-all",
 			"--share-net",
 			"--die-with-parent",
+			"--new-session",
 			"--chdir", realWorkDir,
 			"--",
-			self, "sandbox-worker",
 		)
+		args = append(args, workerArgs...)
 
 		cmd = exec.CommandContext(ctx, "bwrap", args...)
 
 	case "darwin":
 		// Build sandbox-exec command
 		// Generate SBPL profile that allows read-only root and writable workDir + extraBinds
-		profile := generateSBPLProfile(realWorkDir, extraBinds, blockAWSCredentials)
+		profile := generateSBPLProfile(realWorkDir, extraBinds, readOnlyBinds, blockAWSCredentials)
 
 		// sandbox-exec -p <profile> <binary> <args>
-		cmd = exec.CommandContext(ctx, "sandbox-exec", "-p", profile, self, "sandbox-worker")
+		cmd = exec.CommandContext(ctx, "sandbox-exec", append([]string{"-p", profile}, workerArgs...)...)
 		cmd.Dir = realWorkDir
 
 	default:
 		return nil, fmt.Errorf("os sandbox not supported on %s", runtime.GOOS)
 	}
 
+	return startWorkerProcess(ctx, cmd)
+}
+
+// startWorkerProcess starts cmd, which runs the sandbox-worker subcommand, and
+// waits for it to be ready.
+func startWorkerProcess(ctx context.Context, cmd *exec.Cmd) (*Worker, error) {
 	cmd.Stderr = os.Stderr // Pass through stderr for worker logs
+	// Only stdin/stdout/stderr are passed to the worker; never hand it any
+	// other host descriptors. The worker also marks any descriptors it did
+	// inherit as close-on-exec before running commands.
+	cmd.ExtraFiles = nil
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -283,7 +383,7 @@ func StartWorker(ctx context.Context, workDir string, extraBinds []string, block
 		stdout:  stdout,
 		enc:     newHostLockedEncoder(stdin),
 		dec:     gob.NewDecoder(bufStdout),
-		pending: make(map[uint64]chan WorkerMsg),
+		pending: make(map[uint64]*msgQueue),
 	}
 
 	// Wait for ready signal from worker
@@ -308,14 +408,21 @@ func StartWorker(ctx context.Context, workDir string, extraBinds []string, block
 // generateSBPLProfile generates a Scheme-based sandbox profile for macOS sandbox-exec.
 // The profile allows read-only access to the entire filesystem, but restricts writes
 // to specific directories (workDir, extraBinds, and system temp directories).
+// readOnlyBinds are explicitly allowed to be read, before credentials are denied.
 // blockAWSCredentials controls whether ~/.aws is blocked.
 // Note: ~/.ssh private keys are ALWAYS blocked regardless of blockAWSCredentials.
-func generateSBPLProfile(workDir string, extraBinds []string, blockAWSCredentials bool) string {
+func generateSBPLProfile(workDir string, extraBinds, readOnlyBinds []string, blockAWSCredentials bool) string {
 	var sb strings.Builder
 
 	sb.WriteString("(version 1)\n")
 	sb.WriteString("(allow default)\n")
 
+	// Allow read access to extra read-only paths. The last matching rule wins,
+	// so these come before the credential denies, which must not be overridden.
+	for _, path := range readOnlyBinds {
+		sb.WriteString(fmt.Sprintf("(allow file-read* (subpath \"%s\"))\n", path))
+	}
+
 	// Get home directory for credential blocking
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -385,6 +492,8 @@ func generateSBPLProfile(workDir string, extraBinds []string, blockAWSCredential
 
 // Exec runs a command in the worker, streaming stdin/stdout/stderr.
 // Multiple Exec calls may run concurrently; each gets a unique ID for multiplexing.
+// If ctx is done before the command finishes, the worker kills that command only;
+// other executions keep running.
 // stdin, stdout, stderr may be nil.
 // Returns the command exit code and any protocol error.
 func (w *Worker) Exec(ctx context.Context, args []string, dir string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
@@ -400,12 +509,12 @@ func (w *Worker) Exec(ctx context.Context, args []string, dir string, env map[st
 	}
 	w.mu.Unlock()
 
-	// Generate unique ID and register a response channel.
+	// Generate unique ID and register a response queue.
 	w.pendingMu.Lock()
 	id := w.nextID
 	w.nextID++
-	ch := make(chan WorkerMsg, 64)
-	w.pending[id] = ch
+	queue := newMsgQueue()
+	w.pending[id] = queue
 	w.pendingMu.Unlock()
 
 	slog.DebugContext(ctx, "sending exec to worker", "args", args, "id", id)
@@ -421,24 +530,44 @@ func (w *Worker) Exec(ctx context.Context, args []string, dir string, env map[st
 		return 1, fmt.Errorf("failed to send exec: %w", err)
 	}
 
+	// Stop the command in the worker if ctx is done before it finishes, or,
+	// like SIGPIPE would, once its output can no longer be written.
+	var cancelOnce sync.Once
+	cancel := func() {
+		cancelOnce.Do(func() {
+			w.enc.send(HostMsg{ID: id, Type: HostMsgCancel}) //nolint:errcheck
+		})
+	}
+	stopCancel := context.AfterFunc(ctx, cancel)
+	defer stopCancel()
+
 	// Pump stdin in a background goroutine.
 	stdinDone := make(chan error, 1)
+	stdinStop := make(chan struct{})
 	go func() {
-		stdinDone <- w.pumpStdinForID(id, stdin)
+		stdinDone <- w.pumpStdinForID(id, stdin, stdinStop)
 	}()
 
-	// Read responses from the per-execution channel until WorkerMsgDone (channel closed by dispatcher).
+	// Read responses from the per-execution queue until WorkerMsgDone (queue closed by dispatcher).
 	var exitCode int
 	var execErr error
-	for msg := range ch {
+	for {
+		msg, ok := queue.pop()
+		if !ok {
+			break
+		}
 		switch msg.Type {
 		case WorkerMsgStdout:
 			if stdout != nil && len(msg.Data) > 0 {
-				stdout.Write(msg.Data) //nolint:errcheck
+				if _, err := stdout.Write(msg.Data); err != nil {
+					go cancel()
+				}
 			}
 		case WorkerMsgStderr:
 			if stderr != nil && len(msg.Data) > 0 {
-				stderr.Write(msg.Data) //nolint:errcheck
+				if _, err := stderr.Write(msg.Data); err != nil {
+					go cancel()
+				}
 			}
 		case WorkerMsgDone:
 			exitCode = msg.ExitCode
@@ -448,21 +577,52 @@ func (w *Worker) Exec(ctx context.Context, args []string, dir string, env map[st
 		}
 	}
 
-	// Wait for stdin pump to finish.
-	if pumpErr := <-stdinDone; pumpErr != nil && execErr == nil {
-		execErr = pumpErr
+	// Stop the stdin pump: a command like head can exit before reading all
+	// of stdin, whose writer (e.g. yes) may never stop or close it. The pump
+	// discards anything it reads after this. A read blocked on a silent
+	// writer is interrupted through a read deadline, which os.Pipe ends
+	// support, and the deadline is cleared once the pump has returned so the
+	// next command can read the rest of stdin. Other readers cannot be
+	// interrupted, so the pump is left to return after its current read.
+	close(stdinStop)
+	if d, ok := stdin.(readDeadliner); ok && d.SetReadDeadline(time.Now()) == nil {
+		pumpErr := <-stdinDone
+		d.SetReadDeadline(time.Time{}) //nolint:errcheck
+		if pumpErr != nil && execErr == nil {
+			execErr = pumpErr
+		}
+	} else {
+		select {
+		case pumpErr := <-stdinDone:
+			if pumpErr != nil && execErr == nil {
+				execErr = pumpErr
+			}
+		default:
+		}
 	}
 
 	return exitCode, execErr
 }
 
+// readDeadliner is implemented by readers whose blocked reads can be
+// interrupted, such as *os.File pipes.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
 // pumpStdinForID reads from r in 4096-byte chunks and sends them to the worker with the given ID,
-// then sends HostMsgStdinEOF. If r is nil, only the EOF is sent.
-func (w *Worker) pumpStdinForID(id uint64, r io.Reader) error {
+// then sends HostMsgStdinEOF. If r is nil, only the EOF is sent. It returns without
+// sending anything more once stop is closed.
+func (w *Worker) pumpStdinForID(id uint64, r io.Reader, stop <-chan struct{}) error {
 	if r != nil {
 		buf := make([]byte, 4096)
 		for {
 			n, err := r.Read(buf)
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
 			if n > 0 {
 				chunk := make([]byte, n)
 				copy(chunk, buf[:n])
@@ -485,7 +645,7 @@ func (w *Worker) pumpStdinForID(id uint64, r io.Reader) error {
 }
 
 // runDispatcher continuously reads WorkerMsg from the decoder and routes each message
-// to the appropriate pending execution channel. On decode error, all pending channels
+// to the appropriate pending execution queue. On decode error, all pending queues
 // receive a synthetic done-with-error message and are closed.
 func (w *Worker) runDispatcher() {
 	for {
@@ -495,28 +655,28 @@ func (w *Worker) runDispatcher() {
 			w.dead = true
 			w.mu.Unlock()
 
-			// Drain all pending channels with a synthetic error.
+			// Drain all pending queues with a synthetic error.
 			w.pendingMu.Lock()
-			for _, ch := range w.pending {
-				ch <- WorkerMsg{Type: WorkerMsgDone, ExitCode: 1, Error: "worker connection lost: " + err.Error()}
-				close(ch)
+			for _, queue := range w.pending {
+				queue.push(WorkerMsg{Type: WorkerMsgDone, ExitCode: 1, Error: "worker connection lost: " + err.Error()})
+				queue.close()
 			}
-			w.pending = make(map[uint64]chan WorkerMsg)
+			w.pending = make(map[uint64]*msgQueue)
 			w.pendingMu.Unlock()
 			return
 		}
 
 		w.pendingMu.Lock()
-		ch, ok := w.pending[msg.ID]
+		queue, ok := w.pending[msg.ID]
 		if ok && msg.Type == WorkerMsgDone {
 			delete(w.pending, msg.ID)
 		}
 		w.pendingMu.Unlock()
 
 		if ok {
-			ch <- msg
+			queue.push(msg)
 			if msg.Type == WorkerMsgDone {
-				close(ch)
+				queue.close()
 			}
 		}
 	}
@@ -551,3 +711,25 @@ func (w *Worker) IsDead() bool {
 	defer w.mu.Unlock()
 	return w.dead
 }
+
+// WorkerStats is a snapshot of a worker's state, for monitoring.
+type WorkerStats struct {
+	PID int `json:"pid"`
+	// Executions is the number of commands sent to the worker, and InFlight
+	// the number that have not finished.
+	Executions uint64 `json:"executions"`
+	InFlight   int    `json:"in_flight"`
+}
+
+// Stats returns a snapshot of the worker's state.
+func (w *Worker) Stats() WorkerStats {
+	var stats WorkerStats
+	if w.cmd.Process != nil {
+		stats.PID = w.cmd.Process.Pid
+	}
+	w.pendingMu.Lock()
+	stats.Executions = w.nextID
+	stats.InFlight = len(w.pending)
+	w.pendingMu.Unlock()
+	return stats
+}
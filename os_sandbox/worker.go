@@ -2,15 +2,25 @@ package os_sandbox
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
+// outputDrainTimeout is how long a canceled command's output keeps being
+// streamed after it is killed. Processes it started, such as the sleep in
+// sh -c "echo start; sleep 10", survive the kill and hold its stdout and
+// stderr open; once this passes, the output read so far is all that is sent.
+const outputDrainTimeout = time.Second
+
 // lockedEncoder wraps a gob.Encoder with a mutex and buffered writer for concurrent use.
 type lockedEncoder struct {
 	mu  sync.Mutex
@@ -38,13 +48,20 @@ func (le *lockedEncoder) send(msg WorkerMsg) error {
 // RunWorker is the main loop for a sandbox worker process (runs inside bwrap/sandbox-exec).
 // It reads HostMsg messages from stdin and dispatches them to concurrent executions.
 // Multiple executions may be in flight simultaneously, identified by their ID.
+// allowedDirs lists the directories (workDir and runtime binds) that an exec's
+// Dir must be under; executions with any other Dir are rejected. An empty list
+// disables the check.
 // This is called by the "sandbox-worker" CLI command.
-func RunWorker() error {
+func RunWorker(allowedDirs []string) error {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 	slog.SetDefault(logger)
 
 	slog.Info("sandbox worker started")
 
+	// Prevent descriptors leaked from the host (e.g., a pre-opened handle to a
+	// sensitive file) from reaching sandboxed commands.
+	closeInheritedFDs()
+
 	enc := newLockedEncoder(os.Stdout)
 	dec := gob.NewDecoder(os.Stdin)
 
@@ -56,6 +73,8 @@ func RunWorker() error {
 
 	// stdinPipes maps execution ID to the pipe writer feeding that execution's stdin.
 	stdinPipes := make(map[uint64]*io.PipeWriter)
+	// cancels maps execution ID to the function that kills that execution's command.
+	cancels := make(map[uint64]context.CancelFunc)
 	var stdinMu sync.Mutex
 
 	for {
@@ -70,13 +89,23 @@ func RunWorker() error {
 
 		switch msg.Type {
 		case HostMsgExec:
+			if !IsDirAllowed(msg.Dir, allowedDirs) {
+				slog.Error("rejecting command outside allowed directories", "args", msg.Args, "dir", msg.Dir, "id", msg.ID)
+				if err := enc.send(WorkerMsg{ID: msg.ID, Type: WorkerMsgDone, ExitCode: 1, Error: fmt.Sprintf("working directory %q is outside allowed directories", msg.Dir)}); err != nil {
+					return fmt.Errorf("failed to send rejection: %w", err)
+				}
+				continue
+			}
 			slog.Info("executing command", "args", msg.Args, "dir", msg.Dir, "id", msg.ID)
 			pr, pw := io.Pipe()
+			ctx, cancel := context.WithCancel(context.Background())
 			stdinMu.Lock()
 			stdinPipes[msg.ID] = pw
+			cancels[msg.ID] = cancel
 			stdinMu.Unlock()
 			go func(m HostMsg, stdinReader io.Reader) {
-				if err := streamCommand(enc, m.ID, m, stdinReader); err != nil {
+				defer cancel()
+				if err := streamCommand(ctx, enc, m.ID, m, stdinReader); err != nil {
 					slog.Error("streamCommand error", "id", m.ID, "error", err)
 				}
 				// Clean up: close and remove the pipe writer if still present.
@@ -85,6 +114,7 @@ func RunWorker() error {
 					pw.Close()
 					delete(stdinPipes, m.ID)
 				}
+				delete(cancels, m.ID)
 				stdinMu.Unlock()
 			}(msg, pr)
 
@@ -107,6 +137,15 @@ func RunWorker() error {
 				pw.Close()
 			}
 
+		case HostMsgCancel:
+			stdinMu.Lock()
+			cancel, ok := cancels[msg.ID]
+			stdinMu.Unlock()
+			if ok {
+				slog.Info("canceling command", "id", msg.ID)
+				cancel()
+			}
+
 		default:
 			slog.Error("unexpected message type", "type", msg.Type)
 			return fmt.Errorf("unexpected message type %d", msg.Type)
@@ -114,15 +153,42 @@ func RunWorker() error {
 	}
 }
 
+// IsDirAllowed reports whether dir (after symlink resolution) is equal to or
+// nested under one of allowedDirs. An empty allowedDirs permits any dir.
+// A dir that cannot be resolved is rejected.
+func IsDirAllowed(dir string, allowedDirs []string) bool {
+	if len(allowedDirs) == 0 {
+		return true
+	}
+	if !filepath.IsAbs(dir) {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range allowedDirs {
+		resolvedAllowed, err := filepath.EvalSymlinks(allowed)
+		if err != nil {
+			resolvedAllowed = filepath.Clean(allowed)
+		}
+		if resolved == resolvedAllowed || strings.HasPrefix(resolved, resolvedAllowed+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // streamCommand starts the command described by req, uses stdinReader for its stdin,
 // and streams stdout/stderr back via the encoder. Sends WorkerMsgDone when finished.
+// The command is killed when ctx is done, which happens on HostMsgCancel.
 // The id parameter is included in all outgoing WorkerMsg messages for multiplexing.
-func streamCommand(enc *lockedEncoder, id uint64, req HostMsg, stdinReader io.Reader) error {
+func streamCommand(ctx context.Context, enc *lockedEncoder, id uint64, req HostMsg, stdinReader io.Reader) error {
 	if len(req.Args) == 0 {
 		return enc.send(WorkerMsg{ID: id, Type: WorkerMsgDone, ExitCode: 1, Error: "no command specified"})
 	}
 
-	cmd := exec.Command(req.Args[0], req.Args[1:]...)
+	cmd := exec.CommandContext(ctx, req.Args[0], req.Args[1:]...)
 	cmd.Dir = req.Dir
 
 	if len(req.Env) > 0 {
@@ -133,7 +199,14 @@ func streamCommand(enc *lockedEncoder, id uint64, req HostMsg, stdinReader io.Re
 		cmd.Env = env
 	}
 
-	cmd.Stdin = stdinReader
+	// Copy stdin into a pipe of the command's own rather than set cmd.Stdin,
+	// so that Wait does not wait for stdinReader to be drained: a command
+	// that exits without reading all of its input, such as head, finishes
+	// even if the host never sends the rest of it or its EOF.
+	stdinPipe, err := cmd.StdinPipe()
+	if err != nil {
+		return enc.send(WorkerMsg{ID: id, Type: WorkerMsgDone, ExitCode: 1, Error: "failed to create stdin pipe: " + err.Error()})
+	}
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -149,6 +222,18 @@ func streamCommand(enc *lockedEncoder, id uint64, req HostMsg, stdinReader io.Re
 		return enc.send(WorkerMsg{ID: id, Type: WorkerMsgDone, ExitCode: 1, Error: "failed to start command: " + err.Error()})
 	}
 
+	go func() {
+		io.Copy(stdinPipe, stdinReader) //nolint:errcheck
+		stdinPipe.Close()
+	}()
+
+	stopDrain := context.AfterFunc(ctx, func() {
+		time.Sleep(outputDrainTimeout)
+		stdoutPipe.Close()
+		stderrPipe.Close()
+	})
+	defer stopDrain()
+
 	var wg sync.WaitGroup
 
 	// Goroutine 1: stream stdout chunks to host.
@@ -197,6 +282,9 @@ func streamCommand(enc *lockedEncoder, id uint64, req HostMsg, stdinReader io.Re
 	if err := cmd.Wait(); err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
+		} else if ctx.Err() != nil && cmd.ProcessState != nil {
+			// Killed on HostMsgCancel; Wait reports the context error.
+			exitCode = cmd.ProcessState.ExitCode()
 		} else {
 			exitCode = 1
 			errStr = err.Error()
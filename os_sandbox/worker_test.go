@@ -2,10 +2,16 @@ package os_sandbox
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -427,3 +433,398 @@ func TestWorkerIPCConcurrent(t *testing.T) {
 
 	demuxWg.Wait()
 }
+
+// TestWorkerRejectsDirOutsideAllowed tests that the worker refuses to run a
+// command whose Dir is outside the directories passed via --allowed-dir.
+func TestWorkerRejectsDirOutsideAllowed(t *testing.T) {
+	binary := "../lite-sandbox"
+	if _, err := os.Stat(binary); os.IsNotExist(err) {
+		t.Skipf("lite-sandbox binary not found at %s, skipping test (run 'go build' first)", binary)
+	}
+
+	tmpDir := t.TempDir()
+	cmd := exec.Command(binary, "sandbox-worker", "--allowed-dir", tmpDir)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdin pipe: %v", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdout pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start worker: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	bufStdin := bufio.NewWriter(stdin)
+	bufStdout := bufio.NewReader(stdout)
+	enc := gob.NewEncoder(bufStdin)
+	dec := gob.NewDecoder(bufStdout)
+
+	// Wait for ready signal
+	var ready WorkerMsg
+	if err := dec.Decode(&ready); err != nil {
+		t.Fatalf("failed to receive ready signal: %v", err)
+	}
+	if ready.Type != WorkerMsgReady {
+		t.Fatalf("expected WorkerMsgReady, got type %d", ready.Type)
+	}
+
+	// Dir outside the allowed directories is rejected without running.
+	if err := sendExec(enc, bufStdin, 1, []string{"pwd"}, "/etc"); err != nil {
+		t.Fatalf("failed to send exec: %v", err)
+	}
+	res, err := readWorkerResult(dec, 1)
+	if err != nil {
+		t.Fatalf("failed to read result: %v", err)
+	}
+	if res.err == "" || !strings.Contains(res.err, "outside allowed directories") {
+		t.Errorf("expected rejection error, got %q", res.err)
+	}
+	if res.exitCode == 0 {
+		t.Errorf("expected non-zero exit code for rejected exec")
+	}
+	if len(res.stdout) != 0 {
+		t.Errorf("expected no output from rejected exec, got %q", res.stdout)
+	}
+
+	// Dir inside the allowed directory still runs.
+	if err := sendExec(enc, bufStdin, 2, []string{"echo", "ok"}, tmpDir); err != nil {
+		t.Fatalf("failed to send exec: %v", err)
+	}
+	res, err = readWorkerResult(dec, 2)
+	if err != nil {
+		t.Fatalf("failed to read result: %v", err)
+	}
+	if res.err != "" || res.exitCode != 0 {
+		t.Fatalf("expected success in allowed dir, got exit %d err %q", res.exitCode, res.err)
+	}
+	if string(res.stdout) != "ok\n" {
+		t.Errorf("unexpected output: got %q, want %q", res.stdout, "ok\n")
+	}
+}
+
+func TestIsDirAllowed(t *testing.T) {
+	tmpDir := t.TempDir()
+	sub := filepath.Join(tmpDir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		allowed []string
+		want    bool
+	}{
+		{"no restriction", "/etc", nil, true},
+		{"exact match", tmpDir, []string{tmpDir}, true},
+		{"nested", sub, []string{tmpDir}, true},
+		{"outside", "/etc", []string{tmpDir}, false},
+		{"relative", "sub", []string{tmpDir}, false},
+		{"nonexistent", filepath.Join(tmpDir, "missing"), []string{tmpDir}, false},
+		{"prefix sibling", tmpDir + "-other", []string{tmpDir}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDirAllowed(tt.dir, tt.allowed); got != tt.want {
+				t.Errorf("IsDirAllowed(%q, %v) = %v, want %v", tt.dir, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	w := &Worker{
+		cmd:     &exec.Cmd{},
+		nextID:  5,
+		pending: map[uint64]*msgQueue{3: newMsgQueue(), 4: newMsgQueue()},
+	}
+	want := WorkerStats{Executions: 5, InFlight: 2}
+	if got := w.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMsgQueue(t *testing.T) {
+	q := newMsgQueue()
+	// The dispatcher pushes without waiting for the execution to read.
+	for i := range 1000 {
+		q.push(WorkerMsg{ID: uint64(i)})
+	}
+	q.close()
+	for i := range 1000 {
+		msg, ok := q.pop()
+		if !ok || msg.ID != uint64(i) {
+			t.Fatalf("pop %d: got %+v, %v", i, msg, ok)
+		}
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("expected pop to report a closed, empty queue")
+	}
+
+	q = newMsgQueue()
+	got := make(chan WorkerMsg)
+	go func() {
+		msg, _ := q.pop()
+		got <- msg
+	}()
+	q.push(WorkerMsg{ID: 7})
+	select {
+	case msg := <-got:
+		if msg.ID != 7 {
+			t.Fatalf("expected message 7, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pop did not wake up for a pushed message")
+	}
+}
+
+// TestWorkerDoesNotLeakInheritedFDs tests that a descriptor leaked into the
+// worker by its parent is not inherited by the commands the worker runs.
+func TestWorkerDoesNotLeakInheritedFDs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc/self/fd")
+	}
+	binary := "../lite-sandbox"
+	if _, err := os.Stat(binary); os.IsNotExist(err) {
+		t.Skipf("lite-sandbox binary not found at %s, skipping test (run 'go build' first)", binary)
+	}
+
+	tmpDir := t.TempDir()
+	secretPath := filepath.Join(tmpDir, "secret")
+	if err := os.WriteFile(secretPath, []byte("top-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open without O_CLOEXEC so the descriptor is inherited by the worker.
+	fd, err := syscall.Open(secretPath, syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open secret: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	cmd := exec.Command(binary, "sandbox-worker")
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdin pipe: %v", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdout pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start worker: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	bufStdin := bufio.NewWriter(stdin)
+	bufStdout := bufio.NewReader(stdout)
+	enc := gob.NewEncoder(bufStdin)
+	dec := gob.NewDecoder(bufStdout)
+
+	var ready WorkerMsg
+	if err := dec.Decode(&ready); err != nil {
+		t.Fatalf("failed to receive ready signal: %v", err)
+	}
+	if ready.Type != WorkerMsgReady {
+		t.Fatalf("expected WorkerMsgReady, got type %d", ready.Type)
+	}
+
+	fdPath := "/proc/self/fd/" + strconv.Itoa(fd)
+	if err := sendExec(enc, bufStdin, 1, []string{"cat", fdPath}, tmpDir); err != nil {
+		t.Fatalf("failed to send exec: %v", err)
+	}
+	res, err := readWorkerResult(dec, 1)
+	if err != nil {
+		t.Fatalf("failed to read result: %v", err)
+	}
+	if strings.Contains(string(res.stdout), "top-secret") {
+		t.Fatalf("command read secret through inherited descriptor %s", fdPath)
+	}
+	if res.exitCode == 0 {
+		t.Errorf("expected cat %s to fail, got exit code 0", fdPath)
+	}
+}
+
+func TestGenerateSBPLProfile_Binds(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	profile := generateSBPLProfile("/work", []string{"/cache/ccache"}, []string{home}, true)
+
+	for _, rule := range []string{
+		`(allow file-write* (subpath "/work"))`,
+		`(allow file-write* (subpath "/cache/ccache"))`,
+		`(allow file-read* (subpath "` + home + `"))`,
+	} {
+		if !strings.Contains(profile, rule+"\n") {
+			t.Errorf("expected profile to contain %s, got:\n%s", rule, profile)
+		}
+	}
+	if strings.Contains(profile, `(allow file-write* (subpath "`+home+`"))`) {
+		t.Errorf("expected read-only bind not to be writable, got:\n%s", profile)
+	}
+	// The last matching rule wins, so a read-only bind of the home directory
+	// must not come after the credential denies.
+	awsDeny := `(deny file-read* (subpath "` + filepath.Join(home, ".aws") + `"))`
+	if strings.Index(profile, awsDeny) < strings.Index(profile, `(allow file-read* (subpath "`+home+`"))`) {
+		t.Errorf("expected credential denies after read-only binds, got:\n%s", profile)
+	}
+}
+
+// TestWorkerExecCancel tests that canceling the context of one execution
+// kills only that execution's command.
+func TestWorkerExecCancel(t *testing.T) {
+	binary := "../lite-sandbox"
+	if _, err := os.Stat(binary); os.IsNotExist(err) {
+		t.Skipf("lite-sandbox binary not found at %s, skipping test (run 'go build' first)", binary)
+	}
+	w, err := startWorkerProcess(context.Background(), exec.Command(binary, "sandbox-worker"))
+	if err != nil {
+		t.Fatalf("failed to start worker: %v", err)
+	}
+	defer w.Close()
+	tmpDir := t.TempDir()
+
+	type result struct {
+		exitCode int
+		stdout   string
+		err      error
+		elapsed  time.Duration
+	}
+	run := func(ctx context.Context, args ...string) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			var stdout strings.Builder
+			start := time.Now()
+			code, err := w.Exec(ctx, args, tmpDir, nil, nil, &stdout, nil)
+			ch <- result{code, stdout.String(), err, time.Since(start)}
+		}()
+		return ch
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := run(ctx, "sleep", "30")
+	other := run(context.Background(), "sh", "-c", "sleep 1; echo done")
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case r := <-canceled:
+		if r.err != nil {
+			t.Errorf("canceled exec: unexpected error: %v", r.err)
+		}
+		if r.exitCode == 0 {
+			t.Error("canceled exec: expected a nonzero exit code")
+		}
+		if r.elapsed > 10*time.Second {
+			t.Errorf("canceled exec: took %v, expected it to be killed", r.elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the canceled exec")
+	}
+
+	select {
+	case r := <-other:
+		if r.err != nil || r.exitCode != 0 || r.stdout != "done\n" {
+			t.Errorf("other exec: got exit code %d, output %q, error %v; want it to complete", r.exitCode, r.stdout, r.err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the other exec")
+	}
+	if w.IsDead() {
+		t.Error("expected the worker to survive the cancellation")
+	}
+}
+
+// TestWorkerExecTimeoutPartialOutput tests that output written before a
+// command times out is returned, and that a process the command started,
+// which outlives it holding its stdout, does not delay the result.
+func TestWorkerExecTimeoutPartialOutput(t *testing.T) {
+	binary := "../lite-sandbox"
+	if _, err := os.Stat(binary); os.IsNotExist(err) {
+		t.Skipf("lite-sandbox binary not found at %s, skipping test (run 'go build' first)", binary)
+	}
+	w, err := startWorkerProcess(context.Background(), exec.Command(binary, "sandbox-worker"))
+	if err != nil {
+		t.Fatalf("failed to start worker: %v", err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var stdout, stderr strings.Builder
+	start := time.Now()
+	code, err := w.Exec(ctx, []string{"sh", "-c", "echo start; echo err >&2; sleep 10; echo end"}, t.TempDir(), nil, nil, &stdout, &stderr)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code == 0 {
+		t.Error("expected a nonzero exit code")
+	}
+	if stdout.String() != "start\n" || stderr.String() != "err\n" {
+		t.Errorf("expected the output written before the timeout, got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("expected the result shortly after the timeout, took %v", elapsed)
+	}
+	if w.IsDead() {
+		t.Error("expected the worker to survive the timeout")
+	}
+}
+
+// TestWorkerExecStopsStdinPump tests that a command that exits without
+// reading stdin does not leave the stdin pump blocked on a writer that stays
+// open and silent: the next reader of the pipe gets what is written next.
+func TestWorkerExecStopsStdinPump(t *testing.T) {
+	binary := "../lite-sandbox"
+	if _, err := os.Stat(binary); os.IsNotExist(err) {
+		t.Skipf("lite-sandbox binary not found at %s, skipping test (run 'go build' first)", binary)
+	}
+	w, err := startWorkerProcess(context.Background(), exec.Command(binary, "sandbox-worker"))
+	if err != nil {
+		t.Fatalf("failed to start worker: %v", err)
+	}
+	defer w.Close()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	if code, err := w.Exec(context.Background(), []string{"true"}, t.TempDir(), nil, pr, nil, nil); err != nil || code != 0 {
+		t.Fatalf("exec: exit code %d, error %v", code, err)
+	}
+
+	if _, err := pw.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4)
+		n, _ := pr.Read(buf)
+		read <- string(buf[:n])
+	}()
+	select {
+	case got := <-read:
+		if got != "next" {
+			t.Errorf("read %q after the command exited, want %q", got, "next")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reading stdin after the command exited")
+	}
+}
@@ -0,0 +1,127 @@
+package bash_sandboxed
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxAuditFiles bounds the number of files a write audit snapshots. Larger
+// trees are not audited, since walking and hashing them would dominate the
+// command's run time.
+const maxAuditFiles = 10000
+
+// errTooManyFiles stops a snapshot walk that exceeds maxAuditFiles.
+var errTooManyFiles = errors.New("too many files to audit")
+
+// File change kinds reported in FileChange.Change.
+const (
+	FileCreated  = "created"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+)
+
+// FileChange is a file that a command created, modified or deleted under
+// the write-allowed paths.
+type FileChange struct {
+	Path   string
+	Change string
+	// Size is the file's size after the command, or 0 if it was deleted.
+	Size int64
+}
+
+// fileState is what a snapshot records about a file.
+type fileState struct {
+	size int64
+	sum  [sha256.Size]byte
+}
+
+// snapshotFiles records the regular files and symlinks under roots, keyed by
+// absolute path. .git directories are skipped. It returns errTooManyFiles
+// if there are more than maxAuditFiles; unreadable entries are skipped.
+func snapshotFiles(roots []string) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if _, seen := files[path]; seen {
+				return nil // roots may overlap
+			}
+			if len(files) >= maxAuditFiles {
+				return errTooManyFiles
+			}
+			if st, ok := statFile(path, d); ok {
+				files[path] = st
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// statFile returns the size and content hash of a regular file, or the
+// target of a symlink.
+func statFile(path string, d fs.DirEntry) (fileState, bool) {
+	if d.Type()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fileState{}, false
+		}
+		return fileState{size: int64(len(target)), sum: sha256.Sum256([]byte(target))}, true
+	}
+	if !d.Type().IsRegular() {
+		return fileState{}, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fileState{}, false
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fileState{}, false
+	}
+	st := fileState{size: n}
+	h.Sum(st.sum[:0])
+	return st, true
+}
+
+// diffSnapshots returns the changes from before to after, sorted by path.
+func diffSnapshots(before, after map[string]fileState) []FileChange {
+	var changes []FileChange
+	for path, a := range after {
+		b, existed := before[path]
+		switch {
+		case !existed:
+			changes = append(changes, FileChange{Path: path, Change: FileCreated, Size: a.size})
+		case a != b:
+			changes = append(changes, FileChange{Path: path, Change: FileModified, Size: a.size})
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Change: FileDeleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,116 @@
+package bash_sandboxed
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+func TestExecuteDetailed_AuditWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    func(dir string) []FileChange
+	}{
+		{"create", "echo x > out.txt", func(dir string) []FileChange {
+			return []FileChange{{Path: filepath.Join(dir, "out.txt"), Change: FileCreated, Size: 2}}
+		}},
+		{"no writes", "cat existing.txt", func(string) []FileChange { return nil }},
+		{"modify same size", "echo new > existing.txt", func(dir string) []FileChange {
+			return []FileChange{{Path: filepath.Join(dir, "existing.txt"), Change: FileModified, Size: 4}}
+		}},
+		{"delete", "rm existing.txt", func(dir string) []FileChange {
+			return []FileChange{{Path: filepath.Join(dir, "existing.txt"), Change: FileDeleted}}
+		}},
+		{"nested and sorted", "mkdir sub && echo a > sub/b.txt && echo bb > a.txt", func(dir string) []FileChange {
+			return []FileChange{
+				{Path: filepath.Join(dir, "a.txt"), Change: FileCreated, Size: 3},
+				{Path: filepath.Join(dir, "sub", "b.txt"), Change: FileCreated, Size: 2},
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("old\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{dir}
+			r := newTestSandbox().ExecuteDetailed(context.Background(), tt.command, dir, paths, paths, ExecuteOptions{AuditWrites: true})
+			if r.Err != nil {
+				t.Fatalf("unexpected error: %v", r.Err)
+			}
+			if r.AuditSkipped {
+				t.Fatal("audit was skipped")
+			}
+			if want := tt.want(dir); !reflect.DeepEqual(r.FileChanges, want) {
+				t.Fatalf("FileChanges = %+v, want %+v", r.FileChanges, want)
+			}
+		})
+	}
+}
+
+// TestExecuteDetailed_AuditDefaultWritablePaths tests that writes to
+// default_writable_paths, which the caller does not pass, are reported.
+func TestExecuteDetailed_AuditDefaultWritablePaths(t *testing.T) {
+	dir, shared := t.TempDir(), t.TempDir()
+	s := newTestSandbox()
+	s.UpdateConfig(&config.Config{DefaultWritablePaths: []string{shared}}, dir)
+	paths := []string{dir}
+	r := s.ExecuteDetailed(context.Background(), "echo x > "+filepath.Join(shared, "cache.txt"), dir, paths, paths, ExecuteOptions{AuditWrites: true})
+	if r.Err != nil {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	want := []FileChange{{Path: filepath.Join(shared, "cache.txt"), Change: FileCreated, Size: 2}}
+	if !reflect.DeepEqual(r.FileChanges, want) {
+		t.Fatalf("FileChanges = %+v, want %+v", r.FileChanges, want)
+	}
+}
+
+func TestExecuteDetailed_AuditWritesOptIn(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	r := newTestSandbox().ExecuteDetailed(context.Background(), "echo x > out.txt", dir, paths, paths, ExecuteOptions{})
+	if r.Err != nil {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	if r.FileChanges != nil || r.AuditSkipped {
+		t.Fatalf("expected no audit without AuditWrites, got %+v", r)
+	}
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0o755)
+	os.WriteFile(filepath.Join(dir, ".git", "objects", "x"), []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+	os.Symlink("a.txt", filepath.Join(dir, "link"))
+
+	// Overlapping roots are counted once and .git is skipped.
+	files, err := snapshotFiles([]string{dir, dir})
+	if err != nil {
+		t.Fatalf("snapshotFiles: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a.txt and link, got %v", files)
+	}
+
+	for i := 0; i < maxAuditFiles; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", i)), nil, 0o644)
+	}
+	if _, err := snapshotFiles([]string{dir}); err != errTooManyFiles {
+		t.Fatalf("expected errTooManyFiles, got %v", err)
+	}
+	r := newTestSandbox().ExecuteDetailed(context.Background(), "echo x > out.txt", dir, []string{dir}, []string{dir}, ExecuteOptions{AuditWrites: true})
+	if r.Err != nil {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	if !r.AuditSkipped || r.FileChanges != nil {
+		t.Fatalf("expected the audit to be skipped, got %+v", r)
+	}
+}
@@ -18,23 +18,44 @@ import (
 //   - system() calls and pipe-based getline/print are blocked (NoExec)
 //   - file writes via print > file are blocked (NoFileWrites)
 //
+// An inline program is also scanned for getline forms that cannot be
+// path-validated (see scanAwkGetline). The files it reads are checked
+// against the allowed paths at execution time by validateAwkGetline.
+//
 // Path validation for -f script files and input file arguments is handled
 // by the standard validatePaths and CallHandler mechanisms.
-func validateAwkArgs(s *Sandbox, args []*syntax.Word) error {
+func validateAwkArgs(st *sandboxState, args []*syntax.Word, _ string) error {
+	hasProgram := false
 	i := 1 // skip command name
 	for i < len(args) {
 		lit := args[i].Lit()
 		switch {
-		case lit == "-f", lit == "-v", lit == "-F":
+		case lit == "-f":
+			hasProgram = true
+			i += 2 // flag + value
+		case lit == "-v", lit == "-F":
 			i += 2 // flag + value
 		case lit == "--":
+			if !hasProgram && i+1 < len(args) {
+				_, err := scanAwkGetline(wordText(args[i+1]))
+				return err
+			}
 			return nil
-		case strings.HasPrefix(lit, "-f"), strings.HasPrefix(lit, "-v"), strings.HasPrefix(lit, "-F"):
+		case strings.HasPrefix(lit, "-f"):
+			hasProgram = true
+			i++
+		case strings.HasPrefix(lit, "-v"), strings.HasPrefix(lit, "-F"):
 			i++
 		case strings.HasPrefix(lit, "-"):
 			return fmt.Errorf("awk flag %q is not supported in the sandbox", lit)
 		default:
 			// Inline program or file argument — allowed.
+			if !hasProgram {
+				hasProgram = true
+				if _, err := scanAwkGetline(wordText(args[i])); err != nil {
+					return err
+				}
+			}
 			i++
 		}
 	}
@@ -50,8 +71,9 @@ func validateAwkArgs(s *Sandbox, args []*syntax.Word) error {
 //
 // Input file arguments are already path-validated by the CallHandler before
 // this function is called. The -f program file is read here after the path
-// has been validated by the static validatePaths pass.
-func executeAwk(ctx context.Context, args []string) error {
+// has been validated by the static validatePaths pass. Files read with
+// getline < "file" are checked against readAllowedPaths here.
+func executeAwk(ctx context.Context, args []string, readAllowedPaths []string) error {
 	hc := interp.HandlerCtx(ctx)
 
 	var progSrc []byte
@@ -125,6 +147,10 @@ func executeAwk(ctx context.Context, args []string) error {
 	if progSrc == nil {
 		return fmt.Errorf("awk: no program specified")
 	}
+	progSrc, err := validateAwkGetline(progSrc, hc.Dir, readAllowedPaths)
+	if err != nil {
+		return fmt.Errorf("awk: %w", err)
+	}
 
 	prog, err := parser.ParseProgram(progSrc, nil)
 	if err != nil {
@@ -153,6 +179,147 @@ func executeAwk(ctx context.Context, args []string) error {
 	return nil
 }
 
+// awkGetlineFile is a file read with getline < "file" in an awk program.
+type awkGetlineFile struct {
+	name string
+	// start and end are the offsets of the quoted name in the program.
+	start, end int
+}
+
+// scanAwkGetline returns the files an awk program reads with getline.
+// It is a lightweight lexical scan rather than a parse, so it errs on the
+// side of rejecting programs: every "getline" word is checked, even inside
+// strings and regexes, and it must be plain getline, or read from a file
+// named by a single string literal. Command getline (cmd | getline), file
+// names built from expressions and targets other than a variable or simple
+// field are rejected.
+func scanAwkGetline(prog string) ([]awkGetlineFile, error) {
+	const keyword = "getline"
+	var files []awkGetlineFile
+	for i := 0; ; {
+		j := strings.Index(prog[i:], keyword)
+		if j < 0 {
+			return files, nil
+		}
+		start, end := i+j, i+j+len(keyword)
+		i = end
+		if start > 0 && isAwkNameChar(prog[start-1]) || end < len(prog) && isAwkNameChar(prog[end]) {
+			continue
+		}
+		before := strings.TrimRight(prog[:start], " \t")
+		if strings.HasSuffix(before, "|") && !strings.HasSuffix(before, "||") {
+			return nil, fmt.Errorf("awk command getline (cmd | getline) is not allowed")
+		}
+
+		// Skip an optional target: a variable or a simple field like $1.
+		pos := skipAwkSpace(prog, end)
+		name := pos
+		if name < len(prog) && prog[name] == '$' {
+			name++
+		}
+		nameEnd := name
+		for nameEnd < len(prog) && isAwkNameChar(prog[nameEnd]) {
+			nameEnd++
+		}
+		if nameEnd == name && name != pos {
+			return nil, fmt.Errorf("awk getline target %q is not supported in the sandbox", awkContext(prog, start))
+		}
+		pos = skipAwkSpace(prog, nameEnd)
+
+		if pos >= len(prog) || prog[pos] != '<' || strings.HasPrefix(prog[pos:], "<=") {
+			if pos < len(prog) && !awkGetlineEnd(prog[pos]) && prog[pos] != '<' && prog[pos] != '"' {
+				return nil, fmt.Errorf("awk getline form %q is not supported in the sandbox", awkContext(prog, start))
+			}
+			continue
+		}
+
+		// getline < "file": the file must be a single string literal.
+		pos = skipAwkSpace(prog, pos+1)
+		if pos >= len(prog) || prog[pos] != '"' {
+			return nil, fmt.Errorf("awk getline from a file that is not a string literal is not allowed: %q", awkContext(prog, start))
+		}
+		closing := strings.IndexAny(prog[pos+1:], "\"\\\n")
+		if closing < 0 || prog[pos+1+closing] != '"' {
+			return nil, fmt.Errorf("awk getline file names with escape sequences are not allowed: %q", awkContext(prog, start))
+		}
+		litEnd := pos + 1 + closing + 1
+		if after := skipAwkSpace(prog, litEnd); after < len(prog) && !awkGetlineEnd(prog[after]) {
+			return nil, fmt.Errorf("awk getline from a file that is not a string literal is not allowed: %q", awkContext(prog, start))
+		}
+		files = append(files, awkGetlineFile{name: prog[pos+1 : litEnd-1], start: pos, end: litEnd})
+		i = litEnd
+	}
+}
+
+// isAwkNameChar reports whether c can be part of an awk name or number.
+func isAwkNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// awkGetlineEnd reports whether c can follow a complete getline expression,
+// and so cannot continue its target or file name.
+func awkGetlineEnd(c byte) bool {
+	return strings.IndexByte(";\r\n})>=!&|,?:#", c) >= 0
+}
+
+// skipAwkSpace returns the offset of the first character at or after i in
+// prog that is not a blank or a backslash-newline continuation.
+func skipAwkSpace(prog string, i int) int {
+	for i < len(prog) {
+		switch {
+		case prog[i] == ' ' || prog[i] == '\t':
+			i++
+		case strings.HasPrefix(prog[i:], "\\\n"):
+			i += 2
+		case strings.HasPrefix(prog[i:], "\\\r\n"):
+			i += 3
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// awkContext returns the part of prog starting at i, up to the end of its
+// line, for error messages.
+func awkContext(prog string, i int) string {
+	if end := strings.IndexByte(prog[i:], '\n'); end >= 0 {
+		return prog[i : i+end]
+	}
+	return prog[i:]
+}
+
+// validateAwkGetline checks that the files prog reads with getline are
+// under readAllowedPaths, and rewrites relative file names to absolute
+// paths: goawk opens them relative to the server's working directory, not
+// dir. A file name of "-" reads standard input and is left unchanged.
+func validateAwkGetline(prog []byte, dir string, readAllowedPaths []string) ([]byte, error) {
+	files, err := scanAwkGetline(string(prog))
+	if err != nil {
+		return nil, err
+	}
+	// Rewrite from the end so earlier offsets stay valid.
+	for k := len(files) - 1; k >= 0; k-- {
+		f := files[k]
+		if f.name == "-" {
+			continue
+		}
+		if err := validateOpenPath(f.name, os.O_RDONLY, dir, readAllowedPaths, nil, nil); err != nil {
+			return nil, fmt.Errorf("getline: %w", err)
+		}
+		if !filepath.IsAbs(f.name) {
+			quoted := awkQuote(absPath(f.name, dir))
+			prog = append(append(append([]byte{}, prog[:f.start]...), quoted...), prog[f.end:]...)
+		}
+	}
+	return prog, nil
+}
+
+// awkQuote returns s as an awk string literal.
+func awkQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
 // readAwkFile reads an awk program from a file, resolving relative paths
 // against dir.
 func readAwkFile(path, dir string) ([]byte, error) {
@@ -93,6 +93,42 @@ func TestAwk(t *testing.T) {
 			command: `echo x | awk '{print > "out.txt"}'`,
 			wantErr: true,
 		},
+		{
+			name:    "prints first field",
+			command: `echo "a b" | awk '{print $1}'`,
+			wantOut: "a\n",
+		},
+		{
+			name: "getline from allowed file",
+			setup: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "data.txt"), []byte("from file\n"), 0600)
+			},
+			command: `echo x | awk '{ while ((getline line < "data.txt") > 0) print line }'`,
+			wantOut: "from file",
+		},
+		{
+			name:    "blocks getline from file outside allowed paths",
+			command: `echo x | awk '{ while ((getline line < "/etc/passwd") > 0) print line }'`,
+			wantErr: true,
+		},
+		{
+			name: "blocks getline outside allowed paths in program file",
+			setup: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "prog.awk"), []byte(`{ getline line < "/etc/passwd"; print line }`), 0600)
+			},
+			command: `echo x | awk -f prog.awk`,
+			wantErr: true,
+		},
+		{
+			name:    "blocks getline from expression",
+			command: `echo x | awk '{ f = "/etc/passwd"; getline line < f; print line }'`,
+			wantErr: true,
+		},
+		{
+			name:    "blocks command getline",
+			command: `echo x | awk '{ "cat /etc/passwd" | getline line; print line }'`,
+			wantErr: true,
+		},
 		{
 			name:    "blocks unsupported flag",
 			command: `echo x | awk --sandbox-break '{print}'`,
@@ -125,3 +161,54 @@ func TestAwk(t *testing.T) {
 		})
 	}
 }
+
+func TestScanAwkGetline(t *testing.T) {
+	tests := []struct {
+		prog      string
+		wantFiles []string
+		wantErr   bool
+	}{
+		{prog: `{print $1}`},
+		{prog: `{ getline; print }`},
+		{prog: `{ while ((getline line) > 0) n++ }`},
+		{prog: `{ getline $2 }`},
+		{prog: `{ print "getline failed" }`},
+		{prog: `{ if (x || getline) print }`},
+		{prog: `{ getline line < "a.txt" }`, wantFiles: []string{"a.txt"}},
+		{prog: `{ while ((getline line < "/etc/passwd") > 0) print line }`, wantFiles: []string{"/etc/passwd"}},
+		{prog: "{ getline <\"a\"; getline x <\"b\"\n}", wantFiles: []string{"a", "b"}},
+		{prog: `{ getline line \` + "\n" + `< "c" }`, wantFiles: []string{"c"}},
+		{prog: `{ "date" | getline d }`, wantErr: true},
+		{prog: `{ getline line < f }`, wantErr: true},
+		{prog: `{ getline line < ("/etc/" "passwd") }`, wantErr: true},
+		{prog: `{ getline line < "/etc/" "passwd" }`, wantErr: true},
+		{prog: `{ getline line < "/etc/pass\x77d" }`, wantErr: true},
+		{prog: `{ getline a[")"] < "/etc/passwd" }`, wantErr: true},
+		{prog: `{ getline $(1) < "/etc/passwd" }`, wantErr: true},
+		{prog: `{ getline $1.5 < "/etc/passwd" }`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prog, func(t *testing.T) {
+			files, err := scanAwkGetline(tt.prog)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got files %v", files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, f := range files {
+				names = append(names, f.name)
+				if tt.prog[f.start:f.end] != `"`+f.name+`"` {
+					t.Errorf("offsets of %q cover %q", f.name, tt.prog[f.start:f.end])
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("got files %v, want %v", names, tt.wantFiles)
+			}
+		})
+	}
+}
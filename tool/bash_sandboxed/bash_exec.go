@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
@@ -18,17 +22,32 @@ type contextKey int
 const (
 	// bashDepthKey tracks nesting depth of bash -c / bash script.sh calls.
 	bashDepthKey contextKey = iota
-	// sandboxPathsKey carries read/write allowed paths into nested interpreters.
+	// sandboxPathsKey carries read/write allowed paths and the sandbox state
+	// into nested interpreters.
 	sandboxPathsKey
 )
 
 // maxBashDepth is the maximum nesting depth for bash/sh execution.
 const maxBashDepth = 10
 
-// sandboxPaths holds the path configuration for nested interpreters.
+// sandboxPaths holds the path configuration for nested interpreters, and the
+// state the top-level interpreter was started with.
 type sandboxPaths struct {
 	readAllowedPaths  []string
 	writeAllowedPaths []string
+	// binaryPaths are the directories compiled binaries may be executed
+	// from (local_binary_execution.allowed_paths).
+	binaryPaths []string
+	// scriptPatterns are the paths that may be executed directly
+	// (local_binary_execution.allowed_scripts), or nil if any may be.
+	scriptPatterns []string
+	state          *sandboxState
+	// trustedCommands holds the names of the commands used by the
+	// trusted_source_files sourced so far. They are allowed at run time for
+	// the rest of the execution, not just inside the sourced file, so that
+	// the functions it defines can be called later; entries are never
+	// removed.
+	trustedCommands sync.Map
 }
 
 // isScriptPath returns true if the command name looks like a direct script
@@ -38,6 +57,156 @@ func isScriptPath(name string) bool {
 	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/")
 }
 
+// scriptAllowed reports whether the script path name, run in dir, matches one
+// of patterns, which are absolute unless the working directory was unknown.
+// Any script is allowed if patterns is nil. A relative name in an unknown dir
+// is allowed here, and checked again by the ExecHandler once dir is known.
+func scriptAllowed(name, dir string, patterns []string) bool {
+	if patterns == nil {
+		return true
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		if dir == "" {
+			return true
+		}
+		path = filepath.Join(dir, path)
+	}
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readBuiltinArgs removes the -s and -t options of the read builtin from
+// args, which may run it through builtin or command. The interpreter reads
+// "read -s" from the terminal on the process's own standard input rather than
+// the command's, so it would wait for the user of lite-sandbox shell, and it
+// does not support -t. Neither is needed to keep read from blocking: the
+// command's standard input is never a terminal and always ends, and slow
+// producers in a pipeline are bounded by the command timeout.
+func readBuiltinArgs(args []string) []string {
+	i := 0
+	for i < len(args) && (args[i] == "builtin" || args[i] == "command") {
+		i++
+	}
+	if i == len(args) || args[i] != "read" {
+		return args
+	}
+	rewritten := append([]string{}, args[:i+1]...)
+	rest := args[i+1:]
+	for len(rest) > 0 && len(rest[0]) > 1 && rest[0][0] == '-' && rest[0] != "--" {
+		arg := rest[0]
+		rest = rest[1:]
+		// value returns the option value: the rest of the cluster after j,
+		// or the next argument.
+		value := func(j int) (string, bool) {
+			if j+1 < len(arg) {
+				return arg[j+1:], true
+			}
+			if len(rest) == 0 {
+				return "", false
+			}
+			v := rest[0]
+			rest = rest[1:]
+			return v, true
+		}
+		kept := "-"
+	cluster:
+		for j := 1; j < len(arg); j++ {
+			switch c := arg[j]; c {
+			case 's':
+			case 't':
+				value(j)
+				break cluster
+			case 'p':
+				kept += "p"
+				if prompt, ok := value(j); ok {
+					rewritten = append(rewritten, kept, prompt)
+					kept = "-"
+				}
+				break cluster
+			default:
+				kept += string(c)
+			}
+		}
+		if kept != "-" {
+			rewritten = append(rewritten, kept)
+		}
+	}
+	return append(rewritten, rest...)
+}
+
+// printfPercentB matches a %b conversion in a printf format. Its argument has
+// its escape sequences expanded like the format itself.
+var printfPercentB = regexp.MustCompile(`%[-+ 0-9]*b`)
+
+// validateFormatEscapes rejects the arguments of echo -e and printf, which
+// may run through builtin or command, that end in an escape sequence the
+// interpreter would read past the end of, such as a trailing \0 or \x. The
+// interpreter's escape expansion panics on them instead of failing.
+func validateFormatEscapes(args []string) error {
+	i := 0
+	for i < len(args) && (args[i] == "builtin" || args[i] == "command") {
+		i++
+	}
+	if i == len(args) {
+		return nil
+	}
+	name, rest := args[i], args[i+1:]
+	var expanded []string
+	switch name {
+	case "echo":
+		// Like the interpreter, only separate -n, -e and -E are options.
+		interpret := false
+		for len(rest) > 0 && (rest[0] == "-n" || rest[0] == "-e" || rest[0] == "-E") {
+			interpret = interpret || rest[0] == "-e"
+			rest = rest[1:]
+		}
+		if interpret {
+			expanded = rest
+		}
+	case "printf":
+		if len(rest) > 0 {
+			expanded = rest[:1]
+			if printfPercentB.MatchString(rest[0]) {
+				expanded = rest
+			}
+		}
+	}
+	for _, arg := range expanded {
+		if escapesOverrun(arg) {
+			return fmt.Errorf("%s: %q ends in an incomplete escape sequence", name, arg)
+		}
+	}
+	return nil
+}
+
+// escapesOverrun reports whether expanding the escape sequences in s, as
+// echo -e and printf do, makes the interpreter panic.
+func escapesOverrun(s string) (overrun bool) {
+	defer func() {
+		if recover() != nil {
+			overrun = true
+		}
+	}()
+	expand.Format(&expand.Config{}, s, nil)
+	return false
+}
+
+// isSetuidOrSetgid reports whether the file at path (following symlinks) has
+// the setuid or setgid bit set. Such a binary would run with its owner's
+// privileges, which the OS sandbox does not contain.
+func isSetuidOrSetgid(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0
+}
+
 // isBinaryExecutable checks if the file at path is a compiled binary
 // by reading its magic bytes. Detects ELF and Mach-O formats.
 func isBinaryExecutable(path string) bool {
@@ -88,7 +257,7 @@ func isBinaryExecutable(path string) bool {
 
 // validateSourceArgs validates source/. command arguments at the AST level.
 // Requires a file path argument (no bare "source" which would be a no-op).
-func validateSourceArgs(s *Sandbox, args []*syntax.Word) error {
+func validateSourceArgs(st *sandboxState, args []*syntax.Word) error {
 	cmdName := wordText(args[0])
 	if len(args) < 2 {
 		return fmt.Errorf("bare %q (no file argument) is not allowed", cmdName)
@@ -120,7 +289,7 @@ var allowedBashFlags = map[string]bool{
 }
 
 // validateBashArgs validates bash/sh command arguments at the AST level.
-func validateBashArgs(s *Sandbox, args []*syntax.Word) error {
+func validateBashArgs(st *sandboxState, args []*syntax.Word) error {
 	cmdName := wordText(args[0])
 	i := 1
 	foundC := false
@@ -342,18 +511,18 @@ func (s *Sandbox) executeBash(ctx context.Context, args []string) error {
 		script = "set " + strings.Join(shellFlags, " ") + "\n" + script
 	}
 
-	// Parse the script
-	f, err := ParseBash(script)
+	// Parse the script; sh gets POSIX semantics
+	f, err := ParseBashVariant(script, shellVariant(cmdName))
 	if err != nil {
 		return fmt.Errorf("%s: %w", cmdName, err)
 	}
 
 	// Validate through the sandbox
-	if err := s.validate(f); err != nil {
+	hc := interp.HandlerCtx(ctx)
+	if err := paths.state.validateWithFunctions(f, nil, hc.Dir); err != nil {
 		return fmt.Errorf("%s: validation failed: %w", cmdName, err)
 	}
 
-	hc := interp.HandlerCtx(ctx)
 	if err := validatePaths(f, hc.Dir, paths.readAllowedPaths, paths.writeAllowedPaths); err != nil {
 		return fmt.Errorf("%s: validation failed: %w", cmdName, err)
 	}
@@ -371,7 +540,8 @@ func (s *Sandbox) executeBash(ctx context.Context, args []string) error {
 
 // executeScript runs a script file (e.g., ./script.sh) by reading it,
 // stripping any shebang line, then parsing and executing it through the
-// sandbox interpreter with the same security restrictions.
+// sandbox interpreter with the same security restrictions. A #!/bin/sh script
+// is parsed as POSIX sh.
 func (s *Sandbox) executeScript(ctx context.Context, args []string) error {
 	// Check nesting depth
 	depth := 0
@@ -397,6 +567,7 @@ func (s *Sandbox) executeScript(ctx context.Context, args []string) error {
 		return fmt.Errorf("cannot read script %s: %w", scriptPath, err)
 	}
 	script := string(data)
+	variant := shebangVariant(script)
 
 	// Strip shebang line if present
 	if strings.HasPrefix(script, "#!") {
@@ -408,11 +579,11 @@ func (s *Sandbox) executeScript(ctx context.Context, args []string) error {
 	}
 
 	// Parse and validate
-	f, err := ParseBash(script)
+	f, err := ParseBashVariant(script, variant)
 	if err != nil {
 		return fmt.Errorf("script %s: %w", args[0], err)
 	}
-	if err := s.validate(f); err != nil {
+	if err := paths.state.validateWithFunctions(f, nil, hc.Dir); err != nil {
 		return fmt.Errorf("script %s: validation failed: %w", args[0], err)
 	}
 	if err := validatePaths(f, hc.Dir, paths.readAllowedPaths, paths.writeAllowedPaths); err != nil {
@@ -434,7 +605,7 @@ func (s *Sandbox) executeScript(ctx context.Context, args []string) error {
 		}
 		script = "set -- " + strings.Join(setArgs, " ") + "\n" + script
 		// Re-parse with the set command prepended
-		f, err = ParseBash(script)
+		f, err = ParseBashVariant(script, variant)
 		if err != nil {
 			return fmt.Errorf("script %s: %w", args[0], err)
 		}
@@ -446,10 +617,6 @@ func (s *Sandbox) executeScript(ctx context.Context, args []string) error {
 // runNestedInterp creates and runs a nested interpreter with the same
 // security handlers as the parent.
 func (s *Sandbox) runNestedInterp(ctx context.Context, f *syntax.File, hc interp.HandlerContext, paths *sandboxPaths) error {
-	s.mu.RLock()
-	useOSSandbox := s.osSandbox
-	s.mu.RUnlock()
-
 	// Build environment from parent context
 	var env []string
 	hc.Env.Each(func(name string, vr expand.Variable) bool {
@@ -466,7 +633,7 @@ func (s *Sandbox) runNestedInterp(ctx context.Context, f *syntax.File, hc interp
 		interp.Env(expand.ListEnviron(env...)),
 	}
 
-	opts = append(opts, s.buildSecurityHandlers(paths.readAllowedPaths, paths.writeAllowedPaths, useOSSandbox)...)
+	opts = append(opts, s.buildSecurityHandlers(paths)...)
 
 	runner, err := interp.New(opts...)
 	if err != nil {
@@ -478,57 +645,119 @@ func (s *Sandbox) runNestedInterp(ctx context.Context, f *syntax.File, hc interp
 
 // buildSecurityHandlers returns the common CallHandler, OpenHandler, and
 // ExecHandler options used by both the top-level and nested interpreters.
-func (s *Sandbox) buildSecurityHandlers(readAllowedPaths, writeAllowedPaths []string, useOSSandbox bool) []interp.RunnerOption {
+// Commands are checked against paths.state, not the sandbox's current state.
+func (s *Sandbox) buildSecurityHandlers(paths *sandboxPaths) []interp.RunnerOption {
+	st := paths.state
+	readAllowedPaths, writeAllowedPaths := paths.readAllowedPaths, paths.writeAllowedPaths
+	useOSSandbox := st.osSandbox
 	return []interp.RunnerOption{
 		interp.CallHandler(func(ctx context.Context, args []string) ([]string, error) {
 			hc := interp.HandlerCtx(ctx)
-			if err := validateExpandedPaths(args, hc.Dir, readAllowedPaths, writeAllowedPaths); err != nil {
+			if err := validateEnvUnchanged(hc.Env, st.pathEnv()); err != nil {
+				return nil, err
+			}
+			if err := validateExpandedPaths(args, hc.Dir, readAllowedPaths, writeAllowedPaths, st.cfg.ReadonlySubpaths); err != nil {
+				return nil, err
+			}
+			if err := validateChecksumManifests(args, hc.Dir, readAllowedPaths); err != nil {
+				return nil, err
+			}
+			if err := validateRegularFileOperands(args, hc.Dir); err != nil {
 				return nil, err
 			}
-			return args, nil
+			if err := validateFormatEscapes(args); err != nil {
+				return nil, err
+			}
+			if len(args) >= 2 && (args[0] == "source" || args[0] == ".") && st.isTrustedSourceFile(args[1], hc.Dir) {
+				for name := range trustedSourceCommands(absPath(args[1], hc.Dir)) {
+					paths.trustedCommands.Store(name, true)
+				}
+			}
+			return readBuiltinArgs(args), nil
 		}),
 		interp.OpenHandler(func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
 			hc := interp.HandlerCtx(ctx)
-			if err := validateOpenPath(path, flag, hc.Dir, readAllowedPaths, writeAllowedPaths); err != nil {
+			if err := validateOpenPath(path, flag, hc.Dir, readAllowedPaths, writeAllowedPaths, st.cfg.ReadonlySubpaths); err != nil {
 				return nil, err
 			}
 			return interp.DefaultOpenHandler()(ctx, path, flag, perm)
 		}),
+		interp.StatHandler(func(ctx context.Context, path string, followSymlinks bool) (fs.FileInfo, error) {
+			if err := validateStatPath(path, readAllowedPaths); err != nil {
+				return nil, err
+			}
+			return interp.DefaultStatHandler()(ctx, path, followSymlinks)
+		}),
 		interp.ExecHandler(func(ctx context.Context, args []string) error {
-			extra := s.getExtraCommands()
+			extra := st.extraCommands
 			if len(args) > 0 {
 				cmdName := args[0]
 				// Runtime command whitelist check — catches blocked commands
 				// introduced via source/. or other dynamic execution paths.
-				if !allowedCommands[cmdName] && !extra[cmdName] {
-					if !s.getConfig().LocalBinaryExecution.IsEnabled() || !isScriptPath(cmdName) {
-						return fmt.Errorf("command %q is not allowed", cmdName)
+				// It uses the name the command is invoked by, not the binary
+				// it resolves to, so ls is allowed even where it is a symlink
+				// to busybox, while busybox itself is not.
+				if st.deniedCommands[cmdName] {
+					return deniedCommandError(cmdName)
+				}
+				if !allowedCommands[cmdName] && !extra[cmdName] && !paths.isTrustedCommand(cmdName) {
+					if !st.cfg.LocalBinaryExecution.AllowsDirectExecution() || !isScriptPath(cmdName) {
+						if !st.cfg.WarnOnUnknownCommands() {
+							return commandNotAllowedError(cmdName)
+						}
+						warnUnknownCommand(cmdName)
 					}
 				}
+				if err := st.validateExecArgs(args, interp.HandlerCtx(ctx).Dir); err != nil {
+					return err
+				}
+				args = rewriteExecArgs(args, extra)
 				switch cmdName {
 				case "awk":
-					return executeAwk(ctx, args)
+					return executeAwk(ctx, args, readAllowedPaths)
 				case "bash", "sh":
 					return s.executeBash(ctx, args)
 				}
 				if isScriptPath(cmdName) {
-					if !s.getConfig().LocalBinaryExecution.IsEnabled() {
+					if !st.cfg.LocalBinaryExecution.AllowsDirectExecution() {
 						return fmt.Errorf("direct execution of %q is not allowed", cmdName)
 					}
-					// Check if file is a compiled binary (ELF/Mach-O)
 					hc := interp.HandlerCtx(ctx)
+					if !scriptAllowed(cmdName, hc.Dir, paths.scriptPatterns) {
+						return fmt.Errorf("direct execution of %q is not allowed: it does not match local_binary_execution.allowed_scripts", cmdName)
+					}
+					// Check if file is a compiled binary (ELF/Mach-O)
 					path := absPath(cmdName, hc.Dir)
 					if isBinaryExecutable(path) {
+						if !IsUnderAllowedPaths(ResolvePath(path, hc.Dir), paths.binaryPaths) {
+							return fmt.Errorf("direct execution of %q is not allowed: binary is outside local_binary_execution.allowed_paths", cmdName)
+						}
+						if isSetuidOrSetgid(path) {
+							return fmt.Errorf("refusing to execute setuid/setgid binary %q", cmdName)
+						}
 						if useOSSandbox {
-							return s.execInWorker(ctx, args)
+							return s.execInWorker(ctx, st, args)
 						}
 						return interp.DefaultExecHandler(-1)(ctx, args)
 					}
 					return s.executeScript(ctx, args)
 				}
 			}
+			if st.executionPath != "" && !strings.Contains(args[0], "/") {
+				// Resolve the command here: the worker would otherwise
+				// look it up in its own PATH. The path found is not a
+				// symlink target, so a multi-call binary such as busybox
+				// still sees the applet name in argv[0].
+				hc := interp.HandlerCtx(ctx)
+				path, err := interp.LookPathDir(hc.Dir, expand.ListEnviron("PATH="+st.executionPath), args[0])
+				if err != nil {
+					fmt.Fprintln(hc.Stderr, err)
+					return interp.ExitStatus(127)
+				}
+				args = append([]string{path}, args[1:]...)
+			}
 			if useOSSandbox {
-				return s.execInWorker(ctx, args)
+				return s.execInWorker(ctx, st, args)
 			}
 			return interp.DefaultExecHandler(-1)(ctx, args)
 		}),
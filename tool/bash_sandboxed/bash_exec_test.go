@@ -6,8 +6,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gartnera/lite-sandbox/config"
 )
@@ -164,6 +166,34 @@ func TestExecuteBash(t *testing.T) {
 			command: "bash -c 'echo line1\necho line2'",
 			wantOut: "line1\nline2\n",
 		},
+		{
+			name:    "stdin piped into bash -c",
+			command: `echo hello | bash -c 'cat'`,
+			wantOut: "hello\n",
+		},
+		{
+			name:    "multi-line stdin piped into bash -c",
+			command: `seq 5 | bash -c 'wc -l'`,
+			wantOut: "5\n",
+		},
+		{
+			name:    "stdin read line by line in bash -c",
+			command: `printf 'a\nb\n' | bash -c 'while read l; do echo "x$l"; done'`,
+			wantOut: "xa\nxb\n",
+		},
+		{
+			name:    "stdin through nested bash and inner pipe",
+			command: `echo hello | bash -c 'bash -c "cat | tr a-z A-Z"'`,
+			wantOut: "HELLO\n",
+		},
+		{
+			name: "stdin piped into bash script.sh",
+			setup: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "upper.sh"), []byte("tr a-z A-Z\n"), 0600)
+			},
+			command: `echo hello | bash upper.sh`,
+			wantOut: "HELLO\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -286,6 +316,43 @@ func TestExecuteBash_PathValidation(t *testing.T) {
 	}
 }
 
+func TestExecuteBash_ShellVariant(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "arrays.sh"), []byte("a=(x y)\necho ${a[1]}\n"), 0600)
+
+	tests := []struct {
+		name    string
+		command string
+		wantOut string
+		errMsg  string
+	}{
+		{"bash -c arrays", `bash -c 'a=(x y); echo ${a[1]}'`, "y\n", ""},
+		{"sh -c arrays", `sh -c 'a=(x y); echo ${a[1]}'`, "", "failed to parse posix"},
+		{"bash -c test clause", `bash -c '[[ -n x ]] && echo yes'`, "yes\n", ""},
+		{"sh -c test clause", `sh -c '[[ -n x ]] && echo yes'`, "", `"[["`},
+		{"sh -c posix test", `sh -c '[ -n x ] && echo yes'`, "yes\n", ""},
+		{"bash script file", `bash arrays.sh`, "y\n", ""},
+		{"sh script file", `sh arrays.sh`, "", "failed to parse posix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := executeInDir(t, dir, tt.command)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v (output %q)", tt.errMsg, err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out != tt.wantOut {
+				t.Errorf("expected %q, got %q", tt.wantOut, out)
+			}
+		})
+	}
+}
+
 func TestValidateSourceArgs_Allowed(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -467,6 +534,151 @@ func TestValidateCommand_ScriptWithSource(t *testing.T) {
 	}
 }
 
+func TestValidateCommand_SourceCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		errMsg string
+	}{
+		{
+			name:  "two-file cycle",
+			files: map[string]string{"a.sh": "source ./b.sh\n", "b.sh": "source ./a.sh\necho b\n"},
+		},
+		{
+			name:  "three-file cycle",
+			files: map[string]string{"a.sh": "source ./b.sh\n", "b.sh": "source ./c.sh\n", "c.sh": "source ./a.sh\n"},
+		},
+		{
+			name:  "self cycle",
+			files: map[string]string{"a.sh": "[ -n \"$A\" ] && return; A=1; source ./a.sh\n"},
+		},
+		{
+			name:   "cycle with a blocked command",
+			files:  map[string]string{"a.sh": "source ./b.sh\n", "b.sh": "source ./c.sh\n", "c.sh": "source ./a.sh\ncurl http://evil.com\n"},
+			errMsg: `command "curl" is not allowed`,
+		},
+		{
+			name:  "diamond",
+			files: map[string]string{"a.sh": "source ./b.sh\nsource ./c.sh\n", "b.sh": "source ./d.sh\n", "c.sh": "source ./d.sh\n", "d.sh": "echo d\n"},
+		},
+		{
+			name:   "diamond with a blocked command",
+			files:  map[string]string{"a.sh": "source ./b.sh\nsource ./c.sh\n", "b.sh": "source ./d.sh\n", "c.sh": "source ./d.sh\n", "d.sh": "curl http://evil.com\n"},
+			errMsg: `command "curl" is not allowed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			for name, content := range tt.files {
+				os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644)
+			}
+			err := NewSandbox().ValidateCommand("source ./a.sh", workDir, []string{workDir}, []string{workDir})
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// A chain without a cycle still hits the depth limit.
+	workDir := t.TempDir()
+	for i := 0; i <= maxBashDepth; i++ {
+		content := fmt.Sprintf("source ./s%d.sh\n", i+1)
+		os.WriteFile(filepath.Join(workDir, fmt.Sprintf("s%d.sh", i)), []byte(content), 0644)
+	}
+	err := NewSandbox().ValidateCommand("source ./s0.sh", workDir, []string{workDir}, []string{workDir})
+	if err == nil || !strings.Contains(err.Error(), "nesting depth exceeded") {
+		t.Fatalf("expected nesting depth error, got %v", err)
+	}
+}
+
+func TestTrustedSourceFiles(t *testing.T) {
+	workDir := t.TempDir()
+	libDir := t.TempDir()
+	lib := "fetch_version() { curl --version | head -1; }\n"
+	trusted := filepath.Join(libDir, "bootstrap.sh")
+	untrusted := filepath.Join(libDir, "other.sh")
+	badPaths := filepath.Join(libDir, "paths.sh")
+	os.WriteFile(trusted, []byte(lib), 0644)
+	os.WriteFile(untrusted, []byte(lib), 0644)
+	os.WriteFile(badPaths, []byte("cat /etc/passwd\n"), 0644)
+	paths := []string{workDir, libDir}
+
+	s := NewSandbox()
+	defer s.Close()
+	s.UpdateConfig(&config.Config{TrustedSourceFiles: []string{trusted, badPaths}}, workDir)
+
+	command := "source " + trusted + "; fetch_version"
+	if err := s.ValidateCommand(command, workDir, paths, paths); err != nil {
+		t.Fatalf("expected the trusted library to pass validation, got: %v", err)
+	}
+	out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("expected the trusted library to run, got: %v", err)
+	}
+	if !strings.HasPrefix(out, "curl ") {
+		t.Errorf("expected curl version output, got %q", out)
+	}
+
+	command = "source " + untrusted + "; fetch_version"
+	if err := s.ValidateCommand(command, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), `command "curl" is not allowed`) {
+		t.Errorf("expected the untrusted library to fail validation, got: %v", err)
+	}
+	if _, err := s.Execute(context.Background(), command, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), `command "curl" is not allowed`) {
+		t.Errorf("expected the untrusted library to fail at run time, got: %v", err)
+	}
+
+	if _, err := s.Execute(context.Background(), "source "+trusted+"; curl --version", workDir, paths, paths); err == nil {
+		t.Error("expected curl outside the trusted library to be blocked")
+	}
+	if err := s.ValidateCommand("source "+badPaths, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), "outside allowed directories") {
+		t.Errorf("expected paths in a trusted library to be validated, got: %v", err)
+	}
+}
+
+// TestTrustedSourceFiles_Scope checks that the commands a trusted file uses
+// stay allowed at run time for the rest of the execution, including in files
+// sourced afterwards, but not for other commands or later executions.
+func TestTrustedSourceFiles_Scope(t *testing.T) {
+	workDir := t.TempDir()
+	libDir := t.TempDir()
+	trusted := filepath.Join(libDir, "bootstrap.sh")
+	other := filepath.Join(libDir, "other.sh")
+	os.WriteFile(trusted, []byte("fetch_version() { curl --version | head -1; }\n"), 0644)
+	os.WriteFile(other, []byte("curl --version | head -1\n"), 0644)
+	paths := []string{workDir, libDir}
+
+	s := NewSandbox()
+	defer s.Close()
+	s.UpdateConfig(&config.Config{TrustedSourceFiles: []string{trusted}}, workDir)
+
+	command := "source " + trusted + "; f=" + other + "; source \"$f\""
+	out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("expected a file sourced after the trusted library to use its commands, got: %v", err)
+	}
+	if !strings.HasPrefix(out, "curl ") {
+		t.Errorf("expected curl version output, got %q", out)
+	}
+
+	os.WriteFile(other, []byte("wget --version\n"), 0644)
+	if _, err := s.Execute(context.Background(), command, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), `command "wget" is not allowed`) {
+		t.Errorf("expected commands the trusted library does not use to be blocked, got: %v", err)
+	}
+
+	os.WriteFile(other, []byte("curl --version | head -1\n"), 0644)
+	command = "f=" + other + "; source \"$f\""
+	if _, err := s.Execute(context.Background(), command, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), `command "curl" is not allowed`) {
+		t.Errorf("expected the allowance to end with the execution that sourced the trusted library, got: %v", err)
+	}
+}
+
 func TestValidateScriptPath_Allowed(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -588,6 +800,23 @@ func TestExecuteScript(t *testing.T) {
 			wantErr: true,
 			errMsg:  "python",
 		},
+		{
+			name: "sh shebang parsed as POSIX",
+			setup: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "script.sh"), []byte("#!/bin/sh\na=(x y)\necho ${a[1]}\n"), 0755)
+			},
+			command: `./script.sh`,
+			wantErr: true,
+			errMsg:  "failed to parse posix",
+		},
+		{
+			name: "bash shebang parsed as bash",
+			setup: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "script.sh"), []byte("#!/usr/bin/env bash\na=(x y)\necho ${a[1]}\n"), 0755)
+			},
+			command: `./script.sh`,
+			wantOut: "y\n",
+		},
 		{
 			name:    "script file does not exist",
 			command: `./nonexistent.sh`,
@@ -667,6 +896,54 @@ func TestExecuteScript_BlockedWhenDisabled(t *testing.T) {
 	}
 }
 
+func TestExecuteScript_AllowedScripts(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "scripts"), 0o755)
+	for _, name := range []string{"build.sh", "evil.sh", "scripts/test.sh"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("echo "+name+"\n"), 0o755)
+	}
+	allowlist := []string{"build.sh", "scripts/*.sh"}
+
+	tests := []struct {
+		name    string
+		cfg     *config.LocalBinaryExecutionConfig
+		command string
+		wantOut string
+		errMsg  string
+	}{
+		{"listed script", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, "./build.sh", "build.sh\n", ""},
+		{"glob match", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, "./scripts/test.sh", "scripts/test.sh\n", ""},
+		{"relative to workdir after cd", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, "cd scripts && ./test.sh && ../build.sh", "scripts/test.sh\nbuild.sh\n", ""},
+		{"unlisted script", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, "./evil.sh", "", `command "./evil.sh" is not allowed`},
+		{"unlisted via parent dir", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, "cd scripts && ../evil.sh", "", `command "../evil.sh" is not allowed`},
+		{"unlisted after dynamic cd", &config.LocalBinaryExecutionConfig{AllowedScripts: allowlist}, `d=scripts; cd "$d" && ../evil.sh`, "", "does not match local_binary_execution.allowed_scripts"},
+		{"allowlist applies when enabled", &config.LocalBinaryExecutionConfig{Enabled: boolPtr(true), AllowedScripts: allowlist}, "./evil.sh", "", `command "./evil.sh" is not allowed`},
+		{"enabled allows any script", &config.LocalBinaryExecutionConfig{Enabled: boolPtr(true)}, "./evil.sh", "evil.sh\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSandbox()
+			s.UpdateConfig(&config.Config{LocalBinaryExecution: tt.cfg}, dir)
+			out, err := executeInDirWithSandbox(t, s, dir, tt.command)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out != tt.wantOut {
+				t.Errorf("output %q, want %q", out, tt.wantOut)
+			}
+			if err := s.ValidateCommand(tt.command, dir, []string{dir}, []string{dir}); err != nil {
+				t.Errorf("ValidateCommand: unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 func TestExecuteScript_DepthLimit(t *testing.T) {
 	dir := t.TempDir()
 
@@ -765,6 +1042,292 @@ func main() {
 	}
 }
 
+func TestExecuteBinary_AllowedPaths(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go compiler not available")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hello.go")
+	os.WriteFile(src, []byte(`package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello-from-binary")
+}
+`), 0644)
+	for _, sub := range []string{"bin", "other"} {
+		binPath := filepath.Join(dir, sub, "tool")
+		cmd := exec.Command("go", "build", "-o", binPath, src)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile test binary: %v\n%s", err, out)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "other", "script.sh"), []byte("echo from-script\n"), 0755)
+
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{
+		LocalBinaryExecution: &config.LocalBinaryExecutionConfig{
+			Enabled:      boolPtr(true),
+			AllowedPaths: []string{"./bin"},
+		},
+	}, "")
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		errMsg  string
+	}{
+		{"binary in allowed path", "./bin/tool", "hello-from-binary", ""},
+		{"binary after cd", "cd bin && ./tool", "hello-from-binary", ""},
+		{"binary outside allowed paths", "./other/tool", "", "outside local_binary_execution.allowed_paths"},
+		{"absolute binary outside allowed paths", filepath.Join(dir, "other", "tool"), "", "outside local_binary_execution.allowed_paths"},
+		{"script outside allowed paths", "./other/script.sh", "from-script", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := executeInDirWithSandbox(t, s, dir, tt.command)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v (output %q)", tt.errMsg, err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.TrimSpace(out) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestExecuteBinary_SetuidRefused(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go compiler not available")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hello.go")
+	os.WriteFile(src, []byte(`package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello-from-binary")
+}
+`), 0644)
+	binPath := filepath.Join(dir, "hello")
+	cmd := exec.Command("go", "build", "-o", binPath, src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile test binary: %v\n%s", err, out)
+	}
+
+	s := newTestSandboxWithLocalBinaryExecution()
+	for _, mode := range []os.FileMode{0755 | os.ModeSetuid, 0755 | os.ModeSetgid} {
+		if err := os.Chmod(binPath, mode); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(binPath); err != nil || info.Mode()&(os.ModeSetuid|os.ModeSetgid) == 0 {
+			t.Skip("filesystem does not keep setuid/setgid bits")
+		}
+		out, err := executeInDirWithSandbox(t, s, dir, `./hello`)
+		if err == nil || !strings.Contains(err.Error(), "refusing to execute setuid/setgid binary") {
+			t.Fatalf("mode %v: expected setuid/setgid refusal, got %v (output %q)", mode, err, out)
+		}
+	}
+
+	if err := os.Chmod(binPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := executeInDirWithSandbox(t, s, dir, `./hello`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(out) != "hello-from-binary" {
+		t.Errorf("expected 'hello-from-binary', got %q", out)
+	}
+}
+
+func TestReadBuiltinArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"read", "x"}, []string{"read", "x"}},
+		{[]string{"read", "-r", "x"}, []string{"read", "-r", "x"}},
+		{[]string{"read", "-s", "x"}, []string{"read", "x"}},
+		{[]string{"read", "-rs", "x"}, []string{"read", "-r", "x"}},
+		{[]string{"read", "-t", "5", "x"}, []string{"read", "x"}},
+		{[]string{"read", "-t5", "-r", "x"}, []string{"read", "-r", "x"}},
+		{[]string{"read", "-rt", "0.5", "x"}, []string{"read", "-r", "x"}},
+		{[]string{"read", "-sp", "Password: ", "x"}, []string{"read", "-p", "Password: ", "x"}},
+		{[]string{"read", "-rpName:", "x"}, []string{"read", "-rp", "Name:", "x"}},
+		{[]string{"read", "-p", "-s", "x"}, []string{"read", "-p", "-s", "x"}},
+		{[]string{"read", "--", "-s"}, []string{"read", "--", "-s"}},
+		{[]string{"builtin", "read", "-s", "x"}, []string{"builtin", "read", "x"}},
+		{[]string{"command", "read", "-t", "1", "x"}, []string{"command", "read", "x"}},
+		{[]string{"echo", "-s"}, []string{"echo", "-s"}},
+	}
+	for _, tt := range tests {
+		if got := readBuiltinArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readBuiltinArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteRead_DoesNotBlock(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"read x; echo done $?", "done 1\n"},
+		{"read -t 1 x; echo rc=$?", "rc=1\n"},
+		{"echo hi | { read -t 1 x; echo got $x; }", "got hi\n"},
+		{"read -s x <<< secret; echo $x", "secret\n"},
+		{"command read -rs x <<< secret; echo $x", "secret\n"},
+		{"bash -c 'read -s -t 1 x; echo nested $?'", "nested 1\n"},
+		{"cat; echo done", "done\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			out, err := newTestSandbox().Execute(ctx, tt.command, dir, []string{dir}, []string{dir})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out != tt.want {
+				t.Errorf("output %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFormatEscapes(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{[]string{"printf", `a\n`}, false},
+		{[]string{"printf", `\x2fetc`}, false},
+		{[]string{"printf", `a\0`}, true},
+		{[]string{"printf", `a\01`}, true},
+		{[]string{"printf", `a\012`}, false},
+		{[]string{"printf", `a\x`}, true},
+		{[]string{"printf", `a\x4`}, true},
+		{[]string{"printf", `a\u12`}, true},
+		{[]string{"printf", `a\`}, true},
+		{[]string{"printf", `%s\n`, `C:\`}, false},
+		{[]string{"printf", `%b\n`, `C:\`}, true},
+		{[]string{"printf", `%-5b`, `\0`}, true},
+		{[]string{"echo", `a\0`}, false},
+		{[]string{"echo", "-E", `a\0`}, false},
+		{[]string{"echo", "-e", `a\0`}, true},
+		{[]string{"echo", "-n", "-e", "ok", `a\x`}, true},
+		{[]string{"echo", "-ne", `a\x`}, false},
+		{[]string{"builtin", "printf", `\0`}, true},
+		{[]string{"command", "echo", "-e", `\`}, true},
+		{[]string{"cat", `\0`}, false},
+	}
+	for _, tt := range tests {
+		err := validateFormatEscapes(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateFormatEscapes(%q) = %v, want error: %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExecute_ConstructedPaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command string
+		want    string
+		errMsg  string
+	}{
+		{`cat $(printf '\x2e/file')`, "hello\n", ""},
+		{`cat $(echo -e '\x2e/file')`, "hello\n", ""},
+		{`cat $(printf '\x2fetc\x2fpasswd')`, "", `path "/etc/passwd" resolves to "/etc/passwd" which is outside allowed directories`},
+		{`cat $(printf '\57etc\57passwd')`, "", "outside allowed directories"},
+		{`cat $(printf '%b' '\x2fetc\x2fpasswd')`, "", "outside allowed directories"},
+		{`cat "$(echo -e '\x2fetc\x2fpasswd')"`, "", "outside allowed directories"},
+		{`f=$(printf '\x2fetc\x2fpasswd'); head -n 1 "$f"`, "", "outside allowed directories"},
+		{`cat < "$(printf '\x2fetc\x2fpasswd')"`, "", "outside allowed directories"},
+		{`cat $(printf '\x2fetc')$(printf '\x2fpasswd')`, "", "outside allowed directories"},
+		{`echo hi > "$(printf '\x2ftmp\x2fout')"`, "", "outside allowed directories"},
+		{`bash -c "cat \$(printf '\x2fetc\x2fpasswd')"`, "", "outside allowed directories"},
+		{`printf 'x\0'`, "", `printf: "x\\0" ends in an incomplete escape sequence`},
+		{`f='\x'; echo -e "a$f"`, "", `echo: "a\\x" ends in an incomplete escape sequence`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			out, err := newTestSandbox().Execute(context.Background(), tt.command, dir, []string{dir}, []string{dir})
+			if tt.errMsg == "" {
+				if err != nil || out != tt.want {
+					t.Errorf("expected %q, got %q and %v", tt.want, out, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if strings.Contains(out, "root:") {
+				t.Errorf("expected /etc/passwd not to be read, got %q", out)
+			}
+		})
+	}
+}
+
+// TestExecute_ExpandedArgValidation tests that options which only appear
+// after expansion, including in the command a wrapper runs, are checked by
+// the command's argument validator before it runs.
+func TestExecute_ExpandedArgValidation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "log"), []byte("line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{`f=-z; rg $f a`, `rg --search-zip runs gzip`},
+		{`f=--search-zip; rg "$f" a`, `rg --search-zip runs gzip`},
+		{`f=-z; timeout 5 rg $f a`, `rg --search-zip runs gzip`},
+		{`f=-f; tail $f log`, "tail -f is not allowed"},
+		{`f=-f; nice tail $f log`, "tail -f is not allowed"},
+		{`f=-x; tar -t $f -f a.tar`, "extracts files"},
+		{`n=2G; head -c $n log`, "head -c 2G is more than the limit"},
+		{`n=2G; tail -c "$n" log`, "tail -c 2G is more than the limit"},
+		{`b=--bytes=5GB; head $b log`, "head -c 5GB is more than the limit"},
+		{`n=2G; timeout 5 head -c $n log`, "head -c 2G is more than the limit"},
+		{`n=2G; env tail -c $n log`, "tail -c 2G is more than the limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			_, err := newTestSandbox().Execute(context.Background(), tt.command, dir, []string{dir}, []string{dir})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	out, err := newTestSandbox().Execute(context.Background(), `n=1; tail -n $n log`, dir, []string{dir}, []string{dir})
+	if err != nil || out != "line\n" {
+		t.Errorf("expected an expanded option value to be allowed, got %q and %v", out, err)
+	}
+	out, err = newTestSandbox().Execute(context.Background(), `n=2; head -c $n log`, dir, []string{dir}, []string{dir})
+	if err != nil || out != "li" {
+		t.Errorf("expected an expanded byte count under the limit to be allowed, got %q and %v", out, err)
+	}
+}
+
 func TestIsBinaryExecutable(t *testing.T) {
 	dir := t.TempDir()
 
@@ -848,3 +1411,32 @@ func TestLocalBinaryExecutionConfig_YAML(t *testing.T) {
 		t.Fatal("expected local binary execution to be enabled")
 	}
 }
+
+func TestValidateCommand_ScriptLimit(t *testing.T) {
+	workDir := t.TempDir()
+	var main strings.Builder
+	for i := range 50 {
+		name := fmt.Sprintf("lib%d.sh", i)
+		fmt.Fprintf(&main, "source ./%s\n", name)
+		os.WriteFile(filepath.Join(workDir, name), []byte("echo lib\n"), 0644)
+	}
+	os.WriteFile(filepath.Join(workDir, "main.sh"), []byte(main.String()), 0644)
+	paths := []string{workDir}
+
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{MaxValidatedScripts: 20}, workDir)
+	err := s.ValidateCommand("source ./main.sh", workDir, paths, paths)
+	if err == nil || !strings.Contains(err.Error(), "too many script files to validate (max 20") {
+		t.Fatalf("expected the script limit error, got %v", err)
+	}
+
+	s.UpdateConfig(&config.Config{MaxValidatedScripts: 100}, workDir)
+	if err := s.ValidateCommand("source ./main.sh", workDir, paths, paths); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.UpdateConfig(&config.Config{MaxValidatedScripts: -1}, workDir)
+	if err := s.ValidateCommand("source ./main.sh", workDir, paths, paths); err != nil {
+		t.Fatalf("unexpected error without a limit: %v", err)
+	}
+}
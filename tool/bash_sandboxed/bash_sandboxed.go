@@ -1,14 +1,19 @@
 package bash_sandboxed
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/gartnera/lite-sandbox/config"
 	"github.com/gartnera/lite-sandbox/os_sandbox"
@@ -33,12 +38,56 @@ func (e *CommandFailedError) Unwrap() error {
 	return e.Err
 }
 
+// ValidationError is returned for some validation failures when a
+// configuration change would allow the command. Suggestion describes that
+// change and is appended to the error message.
+type ValidationError struct {
+	Err        error
+	Suggestion string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Suggestion == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (suggestion: %s)", e.Err, e.Suggestion)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
 // Sandbox executes bash commands after parsing and validating them against
 // the built-in allowlist plus any extra commands from config.
 type Sandbox struct {
-	mu               sync.RWMutex
-	cfg              *config.Config
-	extraCommands    map[string]bool
+	// state is replaced as a whole by UpdateConfig and SetIMDSEndpoint and
+	// read without locking.
+	state atomic.Pointer[sandboxState]
+	// mu serializes state updates and guards worker, workerState and
+	// workerErr.
+	mu     sync.Mutex
+	worker *os_sandbox.Worker
+	// workerState is the state worker was started with.
+	workerState *sandboxState
+	// workerErr is the error from the last failed worker start, cleared when
+	// a worker starts.
+	workerErr error
+	// recorder and replay are set by StartRecording and LoadReplay.
+	recorder atomic.Pointer[recorder]
+	replay   atomic.Pointer[replayLog]
+	counters counters
+	// warnedBroadPaths holds the overly broad allowed paths that have been
+	// logged, so that each is logged once.
+	warnedBroadPaths sync.Map
+}
+
+// sandboxState is the configuration-derived state of a Sandbox. It is never
+// modified once stored: updates build a new sandboxState and swap it in, so a
+// caller that loads it once sees the fields of a single config, never a mix
+// of an old and a new one.
+type sandboxState struct {
+	cfg           *config.Config
+	extraCommands map[string]bool
 	// extraSubCommands holds per-command first-arg restrictions parsed from
 	// extra_commands entries that contain a space (e.g. "pnpx prettier").
 	// A nil slice means no restriction (bare command entry); a non-nil slice
@@ -48,25 +97,59 @@ type Sandbox struct {
 	// (i.e., the entry has no subcommand restriction). These commands bypass
 	// bash AST parsing and are executed directly with the real bash.
 	bareExtraCommands map[string]bool
+	// deniedCommands holds commands from denied_commands and
+	// privilegeEscalationCommands, which are blocked even if they are
+	// otherwise allowed.
+	deniedCommands   map[string]bool
 	imdsEndpoint     string
 	runtimeReadPaths []string
-	osSandbox        bool
-	worker           *os_sandbox.Worker
-	workerWorkDir    string
-	workerRuntimeBinds []string
-	workerBlockAWS   bool
-	// argValidators holds a reference to commandArgValidators so that
-	// validateSubCommand can look up per-command validators at runtime
-	// without creating a package-level initialization cycle.
-	argValidators map[string]func(s *Sandbox, args []*syntax.Word) error
+	// defaultReadPaths and defaultWritePaths are the expanded
+	// default_readable_paths and default_writable_paths, which are added to
+	// the allowed paths of every command.
+	defaultReadPaths  []string
+	defaultWritePaths []string
+	// trustedSourceFiles are the expanded trusted_source_files.
+	trustedSourceFiles []string
+	// executionPath is the expanded execution_path joined into a PATH
+	// value, or empty if commands run with the sandbox process's PATH.
+	executionPath string
+	// runtimeHealth records, per enabled runtime, whether its binary was
+	// found on PATH when the config was applied.
+	runtimeHealth map[string]RuntimeStatus
+	osSandbox     bool
+	workerWorkDir string
+	// workerBinds are the runtime paths and os_sandbox_extra_writable_binds
+	// the worker can write, and workerReadBinds the
+	// os_sandbox_extra_readable_binds it can read.
+	workerBinds     []string
+	workerReadBinds []string
+	workerBlockAWS  bool
+	// rateLimiter is nil if commands are not rate limited. It has its own
+	// lock and is carried over to the next state while the limits are
+	// unchanged.
+	rateLimiter *rateLimiter
+}
+
+// pathEnv returns the PATH sandboxed commands run with: execution_path if it
+// is set, otherwise the sandbox process's own PATH.
+func (st *sandboxState) pathEnv() string {
+	if st.executionPath != "" {
+		return st.executionPath
+	}
+	return os.Getenv("PATH")
 }
 
 // NewSandbox creates a Sandbox with no extra commands.
 func NewSandbox() *Sandbox {
-	return &Sandbox{
-		cfg:           &config.Config{},
-		argValidators: commandArgValidators,
-	}
+	s := &Sandbox{}
+	s.state.Store(&sandboxState{cfg: &config.Config{}, deniedCommands: privilegeEscalationCommands})
+	return s
+}
+
+// loadState returns the current state. Callers that consult several fields
+// should load it once and use that snapshot throughout.
+func (s *Sandbox) loadState() *sandboxState {
+	return s.state.Load()
 }
 
 // UpdateConfig replaces the sandbox configuration with the provided config.
@@ -86,39 +169,67 @@ func (s *Sandbox) UpdateConfig(cfg *config.Config, workDir string) {
 			bare[c] = true
 		}
 	}
+	denied := make(map[string]bool, len(cfg.DeniedCommands)+len(privilegeEscalationCommands))
+	for c := range privilegeEscalationCommands {
+		denied[c] = true
+	}
+	for _, c := range cfg.DeniedCommands {
+		denied[c] = true
+	}
+	if cfg.UnknownCommandPolicy != "" && cfg.UnknownCommandPolicy != config.UnknownCommandPolicyBlock &&
+		cfg.UnknownCommandPolicy != config.UnknownCommandPolicyWarn {
+		slog.Warn("unrecognized unknown_command_policy; unknown commands will be blocked", "policy", cfg.UnknownCommandPolicy)
+	}
+	if cfg.WarnOnUnknownCommands() {
+		slog.Warn("unknown_command_policy is \"warn\": commands outside the allowlist will be allowed")
+	}
 	// Detect runtime paths for read-only access (e.g., GOPATH, GOCACHE, pnpm store)
 	runtimeReadPaths := detectRuntimeBinds(cfg.Runtimes)
+	runtimeHealth := checkRuntimeHealth(cfg.Runtimes)
 
 	// Determine if AWS credentials should be blocked
 	blockAWSCredentials := shouldBlockAWSCredentials(cfg.AWS)
 
 	s.mu.Lock()
-	s.cfg = cfg
-	s.extraCommands = m
-	s.extraSubCommands = sub
-	s.bareExtraCommands = bare
-	s.runtimeReadPaths = runtimeReadPaths
-
-	// Store worker config for lazy start / restart.
-	s.workerWorkDir = workDir
-	s.workerRuntimeBinds = runtimeReadPaths
-	s.workerBlockAWS = blockAWSCredentials
-
-	// Handle OS sandbox enable/disable
-	newOSSandbox := cfg.OSSandboxEnabled()
-	if newOSSandbox != s.osSandbox {
-		// OS sandbox setting changed
+	defer s.mu.Unlock()
+	old := s.loadState()
+	st := &sandboxState{
+		cfg:                cfg,
+		extraCommands:      m,
+		extraSubCommands:   sub,
+		bareExtraCommands:  bare,
+		deniedCommands:     denied,
+		imdsEndpoint:       old.imdsEndpoint,
+		runtimeReadPaths:   runtimeReadPaths,
+		defaultReadPaths:   cfg.ExpandedDefaultReadablePaths(),
+		defaultWritePaths:  cfg.ExpandedDefaultWritablePaths(),
+		trustedSourceFiles: cfg.ExpandedTrustedSourceFiles(),
+		executionPath:      strings.Join(cfg.ExpandedExecutionPath(), string(os.PathListSeparator)),
+		runtimeHealth:      runtimeHealth,
+		osSandbox:          cfg.OSSandboxEnabled(),
+		// Worker config for lazy start / restart.
+		workerWorkDir:   workDir,
+		workerBinds:     slices.Concat(runtimeReadPaths, cfg.ExpandedOSSandboxWritableBinds()),
+		workerReadBinds: cfg.ExpandedOSSandboxReadableBinds(),
+		workerBlockAWS:  blockAWSCredentials,
+	}
+	perMinute, burst := cfg.RateLimit.Limits()
+	st.rateLimiter = rateLimiterFor(old.rateLimiter, perMinute, burst)
+
+	// Handle OS sandbox enable/disable, and restart the worker on its next
+	// use when its binds change
+	bindsChanged := !slices.Equal(st.workerBinds, old.workerBinds) || !slices.Equal(st.workerReadBinds, old.workerReadBinds)
+	if st.osSandbox != old.osSandbox || bindsChanged {
 		if s.worker != nil {
 			slog.Info("closing existing worker")
 			s.worker.Close()
 			s.worker = nil
 		}
-		if newOSSandbox {
+		if st.osSandbox && !old.osSandbox {
 			slog.Info("enabling OS sandbox", "block_aws_credentials", blockAWSCredentials)
 		}
-		s.osSandbox = newOSSandbox
 	}
-	s.mu.Unlock()
+	s.state.Store(st)
 }
 
 // shouldBlockAWSCredentials determines if ~/.aws/ should be blocked.
@@ -133,57 +244,50 @@ func shouldBlockAWSCredentials(awsCfg *config.AWSConfig) bool {
 	return awsCfg.UsesIMDS()
 }
 
-// getConfig returns a snapshot of the current config.
-func (s *Sandbox) getConfig() *config.Config {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.cfg
-}
-
-// getExtraCommands returns a snapshot of the current extra commands.
-func (s *Sandbox) getExtraCommands() map[string]bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.extraCommands
-}
-
-// getExtraSubCommands returns the per-command first-arg restriction map.
-// A nil slice for a command means no restriction (any first arg allowed).
-// A non-nil slice means only those first args are permitted.
-func (s *Sandbox) getExtraSubCommands() map[string][]string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.extraSubCommands
-}
-
 // SetIMDSEndpoint sets the IMDS endpoint URL for AWS credential fetching.
 func (s *Sandbox) SetIMDSEndpoint(endpoint string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.imdsEndpoint = endpoint
+	st := *s.loadState()
+	st.imdsEndpoint = endpoint
+	s.state.Store(&st)
 }
 
 // RuntimeReadPaths returns the detected runtime paths that should be
 // readable (but not writable) by sandboxed commands. These include paths
 // like GOPATH, GOCACHE, and pnpm store directories.
 func (s *Sandbox) RuntimeReadPaths() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.runtimeReadPaths
+	return s.loadState().runtimeReadPaths
+}
+
+// RuntimeHealth returns the health status of each enabled runtime, keyed by
+// runtime name ("go", "pnpm", "rust"). Disabled runtimes are omitted. The
+// map is a copy that the caller may modify.
+func (s *Sandbox) RuntimeHealth() map[string]RuntimeStatus {
+	return maps.Clone(s.loadState().runtimeHealth)
 }
 
 // ConfigReadPaths returns the user-configured readable paths (with ~ expanded).
 func (s *Sandbox) ConfigReadPaths() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.cfg.ExpandedReadablePaths()
+	return s.loadState().cfg.ExpandedReadablePaths()
 }
 
 // ConfigWritePaths returns the user-configured writable paths (with ~ expanded).
 func (s *Sandbox) ConfigWritePaths() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.cfg.ExpandedWritablePaths()
+	return s.loadState().cfg.ExpandedWritablePaths()
+}
+
+// EffectivePaths returns the paths Execute and ValidateCommand allow for the
+// given caller paths, with default_readable_paths and default_writable_paths
+// appended.
+func (s *Sandbox) EffectivePaths(readAllowedPaths, writeAllowedPaths []string) ([]string, []string) {
+	return s.loadState().withDefaultPaths(readAllowedPaths, writeAllowedPaths)
+}
+
+// withDefaultPaths returns the caller's allowed paths with
+// default_readable_paths and default_writable_paths appended.
+func (st *sandboxState) withDefaultPaths(readAllowedPaths, writeAllowedPaths []string) ([]string, []string) {
+	return slices.Concat(readAllowedPaths, st.defaultReadPaths), slices.Concat(writeAllowedPaths, st.defaultWritePaths)
 }
 
 // Close shuts down the sandbox, closing the worker if running.
@@ -227,6 +331,48 @@ func detectRuntimeBinds(runtimes *config.RuntimesConfig) []string {
 	return binds
 }
 
+// RuntimeStatus describes whether an enabled runtime is usable.
+type RuntimeStatus struct {
+	// Binary is the executable the runtime depends on (e.g. "go", "cargo").
+	Binary string
+	// Healthy is true when Binary was found on PATH.
+	Healthy bool
+	// Error explains why the runtime is unhealthy. Empty when healthy.
+	Error string
+}
+
+// checkRuntimeHealth verifies that the binary for each enabled runtime is on
+// PATH. A missing binary does not prevent the config from being applied, but
+// commands for that runtime will fail, so a warning is logged up front.
+func checkRuntimeHealth(runtimes *config.RuntimesConfig) map[string]RuntimeStatus {
+	if runtimes == nil {
+		return nil
+	}
+
+	enabled := map[string]bool{
+		"go":   runtimes.Go != nil && runtimes.Go.GoEnabled(),
+		"pnpm": runtimes.Pnpm != nil && runtimes.Pnpm.PnpmEnabled(),
+		"rust": runtimes.Rust != nil && runtimes.Rust.RustEnabled(),
+	}
+
+	health := make(map[string]RuntimeStatus)
+	for name, on := range enabled {
+		if !on {
+			continue
+		}
+		binary := config.RuntimeTools[name]
+		status := RuntimeStatus{Binary: binary, Healthy: true}
+		if _, err := exec.LookPath(binary); err != nil {
+			status.Healthy = false
+			status.Error = fmt.Sprintf("%s runtime is enabled but %q was not found on PATH", name, binary)
+			slog.Warn("runtime enabled but binary not found; commands for this runtime will fail",
+				"runtime", name, "binary", binary, "error", err)
+		}
+		health[name] = status
+	}
+	return health
+}
+
 // detectGoBinds detects Go environment paths that need to be writable.
 // Returns GOPATH and GOCACHE (build cache) directories.
 func detectGoBinds() []string {
@@ -315,25 +461,71 @@ func detectRustBinds() []string {
 
 // ParseBash parses a command string as bash and returns the AST.
 func ParseBash(command string) (*syntax.File, error) {
-	parser := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	return ParseBashVariant(command, syntax.LangBash)
+}
+
+// ParseBashVariant parses a command string in the given shell language
+// variant and returns the AST. Under syntax.LangPOSIX, bash-only syntax such
+// as arrays is a parse error and [[ is an ordinary command name.
+func ParseBashVariant(command string, variant syntax.LangVariant) (*syntax.File, error) {
+	parser := syntax.NewParser(syntax.Variant(variant))
 	f, err := parser.Parse(strings.NewReader(command), "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse bash: %w", err)
+		return nil, fmt.Errorf("failed to parse %s: %w", variant, err)
 	}
 	return f, nil
 }
 
+// shellVariant returns the language variant a shell binary parses: POSIX for
+// sh, bash otherwise.
+func shellVariant(shell string) syntax.LangVariant {
+	switch filepath.Base(shell) {
+	case "sh", "dash":
+		return syntax.LangPOSIX
+	}
+	return syntax.LangBash
+}
+
+// shebangVariant returns the language variant for a script based on its
+// shebang line, looking through "#!/usr/bin/env sh". Scripts without a
+// shebang are run by bash.
+func shebangVariant(script string) syntax.LangVariant {
+	if !strings.HasPrefix(script, "#!") {
+		return syntax.LangBash
+	}
+	line, _, _ := strings.Cut(script[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return syntax.LangBash
+	}
+	shell := fields[0]
+	if filepath.Base(shell) == "env" {
+		shell = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				shell = f
+				break
+			}
+		}
+	}
+	return shellVariant(shell)
+}
+
 // blockedEnvVars lists environment variables that cannot be assigned in sandboxed commands.
 // PATH is inherited but cannot be mutated (prevents command whitelist bypass).
 // Others prevent shared library injection, auto-sourced scripts, and unexpected behavior.
 var blockedEnvVars = map[string]string{
-	"PATH":            "mutating PATH could bypass the command whitelist",
-	"LD_PRELOAD":      "shared library injection",
-	"LD_LIBRARY_PATH": "shared library injection",
-	"BASH_ENV":        "auto-sourced script injection",
-	"ENV":             "auto-sourced script injection",
-	"CDPATH":          "unexpected directory resolution",
-	"PROMPT_COMMAND":  "arbitrary command execution",
+	"PATH":                "mutating PATH could bypass the command whitelist",
+	"LD_PRELOAD":          "shared library injection",
+	"LD_LIBRARY_PATH":     "shared library injection",
+	"BASH_ENV":            "auto-sourced script injection",
+	"ENV":                 "auto-sourced script injection",
+	"CDPATH":              "unexpected directory resolution",
+	"PROMPT_COMMAND":      "arbitrary command execution",
+	"MANOPT":              "can set man's pager, browser and config file",
+	"MANPAGER":            "can set the pager man runs",
+	"PAGER":               "can set the pager man and other commands run",
+	"RIPGREP_CONFIG_PATH": "can set rg's --pre and --hostname-bin commands",
 }
 
 // validateAssigns checks that none of the assignments target a blocked environment variable.
@@ -349,6 +541,49 @@ func validateAssigns(assigns []*syntax.Assign) error {
 	return nil
 }
 
+// validateExtraEnv checks the variables passed to ExecuteWithEnv: names must
+// be valid shell variable names, and none may be in blockedEnvVars.
+func validateExtraEnv(env map[string]string) error {
+	for name := range env {
+		if !syntax.ValidName(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if reason, blocked := blockedEnvVars[name]; blocked {
+			return fmt.Errorf("setting %s is not allowed: %s", name, reason)
+		}
+	}
+	return nil
+}
+
+// withExtraEnv returns env with the variables in extra appended in
+// "name=value" form, so that they replace any inherited values.
+func withExtraEnv(env []string, extra map[string]string) []string {
+	for name, value := range extra {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// validateEnvUnchanged checks that none of the variables in blockedEnvVars
+// differ from the sandbox process's environment, except PATH, which must
+// equal path, the PATH the command was started with. validateAssigns rejects
+// literal assignments before execution; this catches the indirect ones that
+// only happen at runtime, such as through a nameref (declare -n r=PATH;
+// r=...), a dynamic declare or export ("$x=..."), or read.
+func validateEnvUnchanged(env expand.Environ, path string) error {
+	for name, reason := range blockedEnvVars {
+		want := os.Getenv(name)
+		if name == "PATH" {
+			want = path
+		}
+		_, vr := env.Get(name).Resolve(env)
+		if vr.String() != want {
+			return fmt.Errorf("setting %s is not allowed: %s", name, reason)
+		}
+	}
+	return nil
+}
+
 // collectDeclaredFunctions walks the AST and collects function names from:
 // 1. FuncDecl nodes (inline function declarations)
 // 2. source/. commands with literal file paths (read and extract FuncDecl names)
@@ -404,87 +639,282 @@ func extractFunctionsFromFile(filePath, workDir string, funcs map[string]bool) {
 // validate walks the parsed AST and enforces:
 // 1. All commands must be in the allowedCommands whitelist, extra commands, or declared functions
 // 2. Redirections must pass validateRedirect (safe subset only)
-// 3. No process substitutions are permitted
+// 3. Commands inside process substitutions are validated like any other command
 // 4. Per-command argument validators (e.g., blocking find -exec)
 // 5. Blocked environment variable assignments (PATH, LD_PRELOAD, etc.)
 func (s *Sandbox) validate(f *syntax.File) error {
-	return s.validateWithFunctions(f, nil)
+	return s.loadState().validateWithFunctions(f, nil, "")
 }
 
 // validateWithWorkDir validates the AST, also collecting function declarations
 // from inline FuncDecl nodes and sourced files to allow calls to user-defined functions.
 func (s *Sandbox) validateWithWorkDir(f *syntax.File, workDir string) error {
+	return s.loadState().validateWithWorkDir(f, workDir)
+}
+
+// validateWithWorkDir is Sandbox.validateWithWorkDir against st.
+func (st *sandboxState) validateWithWorkDir(f *syntax.File, workDir string) error {
 	funcs := collectDeclaredFunctions(f, workDir)
-	return s.validateWithFunctions(f, funcs)
+	return st.validateWithFunctions(f, funcs, workDir)
 }
 
 // validateWithFunctions is the core validation logic, optionally accepting
 // a set of declared function names to allow in addition to the command whitelist.
-func (s *Sandbox) validateWithFunctions(f *syntax.File, declaredFuncs map[string]bool) error {
-	extra := s.getExtraCommands()
-	extraSub := s.getExtraSubCommands()
-	bare := s.getBareExtraCommands()
+// workDir, if known, is where the script starts; validators that depend on the
+// directory (git per_path) see it adjusted for literal cd commands.
+func (st *sandboxState) validateWithFunctions(f *syntax.File, declaredFuncs map[string]bool, workDir string) error {
+	if err := st.validateNestingDepth(f); err != nil {
+		return err
+	}
+	if err := st.validateHeredocSize(f); err != nil {
+		return err
+	}
+	lists := st.commandLists(workDir)
+	isDeclared := func(name string) bool { return declaredFuncs[name] }
+	dirs := trackCdDirs(f, workDir)
 	var validationErr error
 	syntax.Walk(f, func(node syntax.Node) bool {
 		if validationErr != nil {
 			return false
 		}
-		switch n := node.(type) {
-		case *syntax.Stmt:
-			for _, r := range n.Redirs {
-				if err := validateRedirect(r); err != nil {
-					validationErr = err
-					return false
-				}
+		validationErr = st.validateNode(node, lists, isDeclared, dirs.dir(node, workDir))
+		return validationErr == nil
+	})
+	return validationErr
+}
+
+// validateNestingDepth rejects f if command substitutions, process
+// substitutions or subshells are nested more than max_substitution_depth
+// levels deep, which would otherwise make every later walk of the AST (and
+// the interpreter) recurse that deep.
+func (st *sandboxState) validateNestingDepth(f *syntax.File) error {
+	limit := st.cfg.SubstitutionDepthLimit()
+	if limit == 0 {
+		return nil
+	}
+	return checkNestingDepth(f, 0, limit)
+}
+
+// checkNestingDepth walks node, which is nested depth levels deep, and
+// returns an error at the first substitution or subshell beyond limit.
+func checkNestingDepth(node syntax.Node, depth, limit int) error {
+	var err error
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n.(type) {
+		case *syntax.CmdSubst, *syntax.ProcSubst, *syntax.Subshell:
+			if n == node {
+				return true
 			}
-		case *syntax.CallExpr:
-			if err := validateAssigns(n.Assigns); err != nil {
-				validationErr = err
-				return false
+			if depth >= limit {
+				err = fmt.Errorf("command substitution nesting too deep (max %d, see max_substitution_depth)", limit)
+			} else {
+				err = checkNestingDepth(n, depth+1, limit)
 			}
-			if len(n.Args) > 0 {
-				cmdName := extractCommandName(n.Args[0])
-				if cmdName == "" {
-					validationErr = fmt.Errorf("dynamic command names are not allowed")
-					return false
-				}
-				// Check whether this command is allowed via extra_commands.
-				// Bare entries (no subcommand restriction) always match.
-				// Restricted entries (e.g. "pnpx prettier") only match when the
-				// first non-flag argument matches the restriction.
-				inExtra := extra[cmdName] && (bare[cmdName] || extraSubCommandMatches(extraSub, cmdName, n.Args))
-				if !allowedCommands[cmdName] && !inExtra && !declaredFuncs[cmdName] {
-					if !s.getConfig().LocalBinaryExecution.IsEnabled() || !isScriptPath(cmdName) {
-						validationErr = fmt.Errorf("command %q is not allowed", cmdName)
-						return false
-					}
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// validateHeredocSize rejects f if the bodies of its heredocs (<< and <<-)
+// add up to more than max_heredoc_bytes, counted as they appear in the
+// source, including the line that closes each one.
+func (st *sandboxState) validateHeredocSize(f *syntax.File) error {
+	limit := st.cfg.HeredocByteLimit()
+	if limit == 0 {
+		return nil
+	}
+	total := 0
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if r, ok := node.(*syntax.Redirect); ok && r.Hdoc != nil && (r.Op == syntax.Hdoc || r.Op == syntax.DashHdoc) {
+			total += int(r.Hdoc.End().Offset() - r.Hdoc.Pos().Offset())
+		}
+		return total <= limit
+	})
+	if total > limit {
+		return fmt.Errorf("heredoc bodies are more than the limit of %d bytes (max_heredoc_bytes)", limit)
+	}
+	return nil
+}
+
+// commandLists is a snapshot of the config-derived command sets consulted
+// for every command during validation.
+type commandLists struct {
+	extra           map[string]bool
+	extraSub        map[string][]string
+	bare            map[string]bool
+	denied          map[string]bool
+	localBinaryExec bool
+	// scriptPatterns are the local_binary_execution.allowed_scripts patterns,
+	// or nil if any script path may be executed.
+	scriptPatterns []string
+	warnUnknown    bool
+}
+
+// commandLists takes a snapshot of the current command configuration.
+// workDir is where the script starts ("" if unknown), against which relative
+// allowed_scripts patterns are resolved.
+func (st *sandboxState) commandLists(workDir string) commandLists {
+	return commandLists{
+		extra:           st.extraCommands,
+		extraSub:        st.extraSubCommands,
+		bare:            st.bareExtraCommands,
+		denied:          st.deniedCommands,
+		localBinaryExec: st.cfg.LocalBinaryExecution.AllowsDirectExecution(),
+		scriptPatterns:  st.cfg.LocalBinaryExecution.ScriptPatterns(workDir),
+		warnUnknown:     st.cfg.WarnOnUnknownCommands(),
+	}
+}
+
+// validateNode applies the command-level checks to a single AST node.
+// isDeclared is consulted only for command names that are not otherwise
+// allowed, and reports whether the name is a user-defined function. workDir
+// is the directory the node runs in ("" if unknown), passed to per-command
+// validators.
+func (st *sandboxState) validateNode(node syntax.Node, lists commandLists, isDeclared func(string) bool, workDir string) error {
+	switch n := node.(type) {
+	case *syntax.Stmt:
+		for _, r := range n.Redirs {
+			if err := validateRedirect(r); err != nil {
+				return err
+			}
+		}
+	case *syntax.CallExpr:
+		if err := validateAssigns(n.Assigns); err != nil {
+			return err
+		}
+		if len(n.Args) > 0 {
+			cmdName := extractCommandName(n.Args[0])
+			if cmdName == "" {
+				return fmt.Errorf("dynamic command names are not allowed")
+			}
+			if lists.denied[cmdName] {
+				return deniedCommandError(cmdName)
+			}
+			if name := absoluteCommandName(cmdName, n.Args, lists, workDir); name != "" {
+				return absoluteCommandError(cmdName, name)
+			}
+			source := allowSource(cmdName, n.Args, lists, isDeclared, workDir)
+			if source == "" {
+				if !lists.warnUnknown {
+					return commandNotAllowedError(cmdName)
 				}
-				// Skip per-command validators for commands allowed via extra_commands —
-				// the user has explicitly opted in to those commands.
-				if !inExtra {
-					if validator, ok := commandArgValidators[cmdName]; ok {
-						if err := validator(s, n.Args); err != nil {
-							validationErr = err
-							return false
-						}
+				warnUnknownCommand(cmdName)
+			}
+			inExtra := source == AllowSourceExtraCommands
+			// Skip per-command validators for commands allowed via extra_commands —
+			// the user has explicitly opted in to those commands.
+			if !inExtra {
+				if validator, ok := commandArgValidators[cmdName]; ok {
+					if err := validator(st, n.Args, workDir); err != nil {
+						return err
 					}
 				}
 			}
-		case *syntax.DeclClause:
-			if err := validateAssigns(n.Args); err != nil {
-				validationErr = err
-				return false
-			}
-		case *syntax.ProcSubst:
-			// Allowed: the walker recurses into the substitution's statements,
-			// so all commands inside are validated against the whitelist.
-		case *syntax.CoprocClause:
-			validationErr = fmt.Errorf("coprocesses are not allowed")
-			return false
 		}
-		return true
-	})
-	return validationErr
+	case *syntax.DeclClause:
+		// export, declare, local, readonly, typeset and nameref. Their
+		// arguments are all Assigns, so a bare name such as "local PATH",
+		// which would shadow PATH with an unset local, is rejected as well.
+		if err := validateAssigns(n.Args); err != nil {
+			return err
+		}
+	case *syntax.ProcSubst:
+		// Allowed: the walker recurses into the substitution's statements,
+		// so all commands inside are validated against the whitelist.
+	case *syntax.CoprocClause:
+		return fmt.Errorf("coprocesses are not allowed")
+	}
+	return nil
+}
+
+// Sources of an allow decision, as returned by Sandbox.WhyAllowed: the config
+// key that allows the command, or "builtin" and "function" for the built-in
+// allowlist and functions the script declares. Commands gated by a config
+// section report its key (e.g. "runtimes.go" or "aws").
+const (
+	AllowSourceBuiltin          = "builtin"
+	AllowSourceExtraCommands    = "extra_commands"
+	AllowSourceDeclaredFunction = "function"
+	AllowSourceLocalBinary      = "local_binary_execution"
+)
+
+// allowSource returns why the command invocation args (args[0] is cmdName)
+// passes the allowlist, or "" if it does not. extra_commands takes precedence
+// so that the per-command validators are skipped for commands the user opted
+// in to. Bare extra_commands entries always match; restricted entries (e.g.
+// "pnpx prettier") only match when the first non-flag argument matches the
+// restriction. Runtime-gated commands report their runtime even when it is
+// disabled; their validators reject them in that case. dir is the directory
+// the command runs in ("" if unknown), against which script paths are matched.
+func allowSource(cmdName string, args []*syntax.Word, lists commandLists, isDeclared func(string) bool, dir string) string {
+	switch {
+	case lists.extra[cmdName] && (lists.bare[cmdName] || extraSubCommandMatches(lists.extraSub, cmdName, args)):
+		return AllowSourceExtraCommands
+	case allowedCommands[cmdName]:
+		if rc, ok := runtimeCommands[cmdName]; ok {
+			return rc.source
+		}
+		return AllowSourceBuiltin
+	case lists.localBinaryExec && isScriptPath(cmdName) && scriptAllowed(cmdName, dir, lists.scriptPatterns):
+		return AllowSourceLocalBinary
+	case isDeclared(cmdName):
+		return AllowSourceDeclaredFunction
+	}
+	return ""
+}
+
+// WhyAllowed returns the source of the decision to allow cmdName, invoked
+// without arguments, under the current config: one of the AllowSource
+// constants or the key of the config section that gates it. It returns "" if
+// cmdName is denied, not allowed, or gated by a section that is disabled.
+// Function declarations are only known while validating a script, so
+// WhyAllowed never reports AllowSourceDeclaredFunction.
+func (s *Sandbox) WhyAllowed(cmdName string) string {
+	return s.loadState().whyAllowed(cmdName)
+}
+
+// whyAllowed is WhyAllowed under the config of st.
+func (st *sandboxState) whyAllowed(cmdName string) string {
+	if st.deniedCommands[cmdName] {
+		return ""
+	}
+	args := []*syntax.Word{{Parts: []syntax.WordPart{&syntax.Lit{Value: cmdName}}}}
+	source := allowSource(cmdName, args, st.commandLists(""), func(string) bool { return false }, "")
+	if rc, ok := runtimeCommands[cmdName]; ok && source == rc.source && !rc.enabled(st.cfg) {
+		return ""
+	}
+	return source
+}
+
+// deniedCommandError returns the error for a denied command: one in
+// privilegeEscalationCommands or listed in denied_commands.
+func deniedCommandError(cmdName string) error {
+	if privilegeEscalationCommands[cmdName] {
+		return fmt.Errorf("command %q is not allowed: it runs commands with elevated privileges and cannot be enabled", cmdName)
+	}
+	return fmt.Errorf("command %q is denied by denied_commands", cmdName)
+}
+
+// commandNotAllowedError returns the error for a command that is not in the
+// allowlist, suggesting extra_commands. Networking commands get a warning
+// instead, because allowing them allows access to any host.
+func commandNotAllowedError(cmdName string) error {
+	suggestion := fmt.Sprintf("add %q to extra_commands", cmdName)
+	if networkCommands[cmdName] {
+		suggestion = fmt.Sprintf("%q accesses the network and the sandbox cannot restrict which hosts it reaches; add it to extra_commands only if unrestricted network access is acceptable", cmdName)
+	}
+	return &ValidationError{Err: fmt.Errorf("command %q is not allowed", cmdName), Suggestion: suggestion}
+}
+
+// warnUnknownCommand logs that a command outside the allowlist is being
+// allowed because unknown_command_policy is "warn".
+func warnUnknownCommand(cmdName string) {
+	slog.Warn("allowing unknown command (unknown_command_policy: warn)", "command", cmdName)
 }
 
 // extractCommandName returns the literal name of a command from a Word node.
@@ -526,82 +956,244 @@ func extraSubCommandMatches(extraSub map[string][]string, cmdName string, args [
 // workDir is the working directory for resolving relative paths.
 // readAllowedPaths are absolute directories that read-only commands may access.
 // writeAllowedPaths are absolute directories that write commands may access.
+// Relative workDir or allowed paths are rejected. default_readable_paths and
+// default_writable_paths are added to the allowed paths. Allowed paths so
+// broad that they defeat path restrictions, like / or the home directory,
+// are logged, or rejected if reject_broad_paths is set.
 func (s *Sandbox) ValidateCommand(command string, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
-	// Bare extra_commands entries bypass AST parsing; treat as valid.
-	if s.isExtraCommandInvocation(command) {
-		return nil
-	}
-	f, err := ParseBash(command)
-	if err != nil {
+	if err := validateDirs(workDir, readAllowedPaths, writeAllowedPaths); err != nil {
 		return err
 	}
-	if err := s.validateWithWorkDir(f, workDir); err != nil {
+	st := s.loadState()
+	readAllowedPaths, writeAllowedPaths = st.withDefaultPaths(readAllowedPaths, writeAllowedPaths)
+	if err := s.checkBroadPaths(st.cfg, readAllowedPaths, writeAllowedPaths); err != nil {
 		return err
 	}
-	if err := validatePaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
-		return err
+	// Bare extra_commands entries bypass AST parsing; treat as valid.
+	if st.isExtraCommandInvocation(command) {
+		return nil
 	}
-	if err := validateRedirectPaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+	f, err := ParseBash(command)
+	if err != nil {
 		return err
 	}
-	if err := s.validateScriptContents(f, workDir, readAllowedPaths, writeAllowedPaths, 0); err != nil {
+	st.resolveAbsoluteCommands(f, workDir, readAllowedPaths)
+	scripts, err := st.validateSinglePass(f, workDir, readAllowedPaths, writeAllowedPaths)
+	if err != nil {
 		return err
 	}
-	return nil
+	budget := &scriptBudget{limit: st.cfg.ValidatedScriptLimit()}
+	return st.validateScriptInvocations(scripts, readAllowedPaths, writeAllowedPaths, nil, budget)
 }
 
-// validateScriptContents walks the AST looking for script invocations
-// (direct script paths like ./script.sh or bash/sh with a script file),
-// reads the script contents, and validates them recursively. This catches
-// cases where a script file contains blocked commands that would fail at
-// runtime, allowing the preflight hook to let Bash handle the command directly.
-// Errors reading files are silently ignored (fail-open) since the file may
-// not exist yet at preflight time.
-func (s *Sandbox) validateScriptContents(f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string, depth int) error {
-	if depth >= maxBashDepth {
-		return fmt.Errorf("script nesting depth exceeded (max %d)", maxBashDepth)
+// validationResult accumulates the first error of each validation category
+// found while walking an AST once.
+type validationResult struct {
+	// commandEvents records command-level failures in walk order. An event
+	// with a non-empty undeclared name only fails if no function of that
+	// name is declared anywhere in the script, which is not known until the
+	// walk completes.
+	commandEvents []commandEvent
+	pathErr       error
+	redirectErr   error
+	scripts       []scriptInvocation
+}
+
+// scriptInvocation is a command that runs another script, with the directory
+// it runs in ("" if not known statically).
+type scriptInvocation struct {
+	call *syntax.CallExpr
+	dir  string
+}
+
+// commandEvent is a command-level failure recorded during a single pass.
+type commandEvent struct {
+	undeclared string
+	err        error
+}
+
+// validateSinglePass performs the same checks as validateWithWorkDir,
+// validatePaths and validateRedirectPaths, in that order of precedence, but
+// walks the AST only once. Function declarations are collected in the same
+// walk. It returns the script invocations found so the caller can validate
+// their contents with validateScriptInvocations.
+func (st *sandboxState) validateSinglePass(f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string) ([]scriptInvocation, error) {
+	if err := st.validateNestingDepth(f); err != nil {
+		return nil, err
 	}
+	if err := st.validateHeredocSize(f); err != nil {
+		return nil, err
+	}
+	lists := st.commandLists(workDir)
+	resolver := newPathResolver()
+	dirs := trackCdDirs(f, workDir)
+	funcs := make(map[string]bool)
+	var res validationResult
+	commandFailed := false
 
-	var validationErr error
 	syntax.Walk(f, func(node syntax.Node) bool {
-		if validationErr != nil {
-			return false
+		// Function declarations are collected for the whole script even after
+		// a failure, since earlier calls may depend on later declarations.
+		switch n := node.(type) {
+		case *syntax.FuncDecl:
+			funcs[n.Name.Value] = true
+		case *syntax.CallExpr:
+			if dir := dirs.dir(n, workDir); len(n.Args) >= 2 && dir != "" {
+				cmdName := extractCommandName(n.Args[0])
+				if cmdName == "source" || cmdName == "." {
+					if filePath := n.Args[1].Lit(); filePath != "" {
+						extractFunctionsFromFile(filePath, dir, funcs)
+					}
+				}
+			}
 		}
-		ce, ok := node.(*syntax.CallExpr)
-		if !ok || len(ce.Args) == 0 {
-			return true
+
+		if !commandFailed {
+			isDeclared := func(name string) bool {
+				res.commandEvents = append(res.commandEvents, commandEvent{undeclared: name, err: commandNotAllowedError(name)})
+				return true
+			}
+			if err := st.validateNode(node, lists, isDeclared, dirs.dir(node, workDir)); err != nil {
+				res.commandEvents = append(res.commandEvents, commandEvent{err: err})
+				commandFailed = true
+			}
 		}
 
-		cmdName := extractCommandName(ce.Args[0])
-		if cmdName == "" {
-			return true
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			if res.redirectErr == nil {
+				res.redirectErr = validateStmtRedirectPaths(resolver, n, dirs.dir(n, workDir), readAllowedPaths, writeAllowedPaths)
+			}
+		case *syntax.CallExpr:
+			dir := dirs.dir(n, workDir)
+			if res.pathErr == nil {
+				res.pathErr = validateCallPaths(resolver, n, dir, readAllowedPaths, writeAllowedPaths)
+			}
+			if len(n.Args) > 0 && isScriptInvocation(extractCommandName(n.Args[0])) {
+				res.scripts = append(res.scripts, scriptInvocation{call: n, dir: dir})
+			}
+		case *syntax.TestClause:
+			if res.pathErr == nil {
+				res.pathErr = validateTestClausePaths(resolver, n, dirs.dir(n, workDir), readAllowedPaths)
+			}
 		}
+		return true
+	})
 
-		switch {
-		case isScriptPath(cmdName):
-			validationErr = s.validateScriptFile(cmdName, workDir, readAllowedPaths, writeAllowedPaths, depth)
-		case cmdName == "bash" || cmdName == "sh":
-			validationErr = s.validateBashScriptArg(ce.Args, workDir, readAllowedPaths, writeAllowedPaths, depth)
-		case cmdName == "source" || cmdName == ".":
-			validationErr = s.validateSourceFileArg(ce.Args, workDir, readAllowedPaths, writeAllowedPaths, depth)
+	for _, ev := range res.commandEvents {
+		if ev.undeclared == "" {
+			return nil, ev.err
+		}
+		if funcs[ev.undeclared] {
+			continue
 		}
+		if !lists.warnUnknown {
+			return nil, ev.err
+		}
+		warnUnknownCommand(ev.undeclared)
+	}
+	if res.pathErr != nil {
+		return nil, res.pathErr
+	}
+	if res.redirectErr != nil {
+		return nil, res.redirectErr
+	}
+	return res.scripts, nil
+}
 
-		return validationErr == nil
-	})
-	return validationErr
+// validateScriptInvocations reads the contents of each script run by the
+// given commands (direct script paths like ./script.sh, bash/sh with a script
+// file, or source/.) and validates them recursively. This catches cases where
+// a script file contains blocked commands that would fail at runtime,
+// allowing the preflight hook to let Bash handle the command directly.
+// Errors reading files are silently ignored (fail-open) since the file may
+// not exist yet at preflight time. chain holds the resolved paths of the
+// script files being validated, outermost first; a script already in chain
+// is sourced in a cycle and is not validated again. budget limits the total
+// number of script files read for the top-level command.
+func (st *sandboxState) validateScriptInvocations(scripts []scriptInvocation, readAllowedPaths, writeAllowedPaths []string, chain []string, budget *scriptBudget) error {
+	if len(chain) >= maxBashDepth {
+		return fmt.Errorf("script nesting depth exceeded (max %d)", maxBashDepth)
+	}
+	for _, inv := range scripts {
+		if err := st.validateScriptInvocation(inv.call, inv.dir, readAllowedPaths, writeAllowedPaths, chain, budget); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// validateScriptFile reads a script file path, parses and validates its contents.
-func (s *Sandbox) validateScriptFile(scriptPath, workDir string, readAllowedPaths, writeAllowedPaths []string, depth int) error {
+// scriptBudget counts the script files validated for a top-level command,
+// so that a script sourcing many files cannot make validation read and
+// parse all of them.
+type scriptBudget struct {
+	limit int // 0 means no limit
+	used  int
+}
+
+// spend records that another script file is about to be validated and
+// returns an error if that exceeds the limit.
+func (b *scriptBudget) spend() error {
+	b.used++
+	if b.limit > 0 && b.used > b.limit {
+		return fmt.Errorf("too many script files to validate (max %d, see max_validated_scripts)", b.limit)
+	}
+	return nil
+}
+
+// isScriptInvocation reports whether cmdName runs another script whose
+// contents should be validated: a script path, bash/sh, or source/. .
+func isScriptInvocation(cmdName string) bool {
+	if cmdName == "" {
+		return false
+	}
+	return isScriptPath(cmdName) || cmdName == "bash" || cmdName == "sh" || cmdName == "source" || cmdName == "."
+}
+
+// validateScriptInvocation validates the contents of the script run by ce,
+// if any.
+func (st *sandboxState) validateScriptInvocation(ce *syntax.CallExpr, workDir string, readAllowedPaths, writeAllowedPaths []string, chain []string, budget *scriptBudget) error {
+	cmdName := extractCommandName(ce.Args[0])
+	switch {
+	case cmdName == "":
+		return nil
+	case isScriptPath(cmdName):
+		return st.validateScriptFile(cmdName, workDir, readAllowedPaths, writeAllowedPaths, chain, budget, syntax.LangAuto)
+	case cmdName == "bash" || cmdName == "sh":
+		return st.validateBashScriptArg(ce.Args, workDir, readAllowedPaths, writeAllowedPaths, chain, budget)
+	case cmdName == "source" || cmdName == ".":
+		return st.validateSourceFileArg(ce.Args, workDir, readAllowedPaths, writeAllowedPaths, chain, budget)
+	}
+	return nil
+}
+
+// validateScriptFile reads a script file path, parses and validates its
+// contents. Relative paths are skipped when workDir is not known statically.
+// The script is parsed as variant, or by its shebang if variant is
+// syntax.LangAuto.
+func (st *sandboxState) validateScriptFile(scriptPath, workDir string, readAllowedPaths, writeAllowedPaths []string, chain []string, budget *scriptBudget, variant syntax.LangVariant) error {
+	if workDir == "" && !filepath.IsAbs(scriptPath) {
+		return nil
+	}
 	path := absPath(scriptPath, workDir)
+	resolved := ResolvePath(path, workDir)
+	if slices.Contains(chain, resolved) {
+		return nil // already being validated further up the chain
+	}
 	if isBinaryExecutable(path) {
 		return nil
 	}
+	if err := budget.spend(); err != nil {
+		return err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil // fail-open: file may not exist at preflight time
 	}
 	script := string(data)
+	if variant == syntax.LangAuto {
+		variant = shebangVariant(script)
+	}
 	if strings.HasPrefix(script, "#!") {
 		if idx := strings.IndexByte(script, '\n'); idx >= 0 {
 			script = script[idx+1:]
@@ -609,25 +1201,20 @@ func (s *Sandbox) validateScriptFile(scriptPath, workDir string, readAllowedPath
 			script = ""
 		}
 	}
-	sf, err := ParseBash(script)
+	sf, err := ParseBashVariant(script, variant)
 	if err != nil {
 		return nil // fail-open: unparseable scripts handled at runtime
 	}
-	if err := s.validateWithWorkDir(sf, workDir); err != nil {
-		return fmt.Errorf("script %s: %w", scriptPath, err)
-	}
-	if err := validatePaths(sf, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
-		return fmt.Errorf("script %s: %w", scriptPath, err)
-	}
-	if err := validateRedirectPaths(sf, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+	scripts, err := st.validateSinglePass(sf, workDir, readAllowedPaths, writeAllowedPaths)
+	if err != nil {
 		return fmt.Errorf("script %s: %w", scriptPath, err)
 	}
-	return s.validateScriptContents(sf, workDir, readAllowedPaths, writeAllowedPaths, depth+1)
+	return st.validateScriptInvocations(scripts, readAllowedPaths, writeAllowedPaths, append(slices.Clip(chain), resolved), budget)
 }
 
 // validateBashScriptArg extracts the script file argument from bash/sh args
 // (when not using -c) and validates the script contents.
-func (s *Sandbox) validateBashScriptArg(args []*syntax.Word, workDir string, readAllowedPaths, writeAllowedPaths []string, depth int) error {
+func (st *sandboxState) validateBashScriptArg(args []*syntax.Word, workDir string, readAllowedPaths, writeAllowedPaths []string, chain []string, budget *scriptBudget) error {
 	i := 1
 	foundC := false
 	for i < len(args) {
@@ -664,7 +1251,7 @@ func (s *Sandbox) validateBashScriptArg(args []*syntax.Word, workDir string, rea
 		}
 		// First non-flag argument is the script file
 		if !foundC {
-			return s.validateScriptFile(text, workDir, readAllowedPaths, writeAllowedPaths, depth)
+			return st.validateScriptFile(text, workDir, readAllowedPaths, writeAllowedPaths, chain, budget, shellVariant(extractCommandName(args[0])))
 		}
 		i++
 	}
@@ -673,7 +1260,7 @@ func (s *Sandbox) validateBashScriptArg(args []*syntax.Word, workDir string, rea
 
 // validateSourceFileArg extracts the file argument from source/. args
 // and validates the file contents recursively.
-func (s *Sandbox) validateSourceFileArg(args []*syntax.Word, workDir string, readAllowedPaths, writeAllowedPaths []string, depth int) error {
+func (st *sandboxState) validateSourceFileArg(args []*syntax.Word, workDir string, readAllowedPaths, writeAllowedPaths []string, chain []string, budget *scriptBudget) error {
 	if len(args) < 2 {
 		return nil
 	}
@@ -681,7 +1268,10 @@ func (s *Sandbox) validateSourceFileArg(args []*syntax.Word, workDir string, rea
 	if filePath == "" {
 		return nil // dynamic path, can't validate statically
 	}
-	return s.validateScriptFile(filePath, workDir, readAllowedPaths, writeAllowedPaths, depth)
+	if st.isTrustedSourceFile(filePath, workDir) {
+		return validateTrustedSourceFile(filePath, workDir, readAllowedPaths, writeAllowedPaths)
+	}
+	return st.validateScriptFile(filePath, workDir, readAllowedPaths, writeAllowedPaths, chain, budget, syntax.LangBash)
 }
 
 // firstCommandWord extracts the first word from a command string, stopping at
@@ -698,62 +1288,131 @@ func firstCommandWord(s string) string {
 	return s
 }
 
-// getBareExtraCommands returns a snapshot of the bare (unrestricted) extra commands.
-func (s *Sandbox) getBareExtraCommands() map[string]bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.bareExtraCommands
-}
-
 // isExtraCommandInvocation reports whether the command string should bypass
 // bash AST parsing because its leading command is a bare extra_commands entry
 // (i.e., added without a subcommand restriction).
-func (s *Sandbox) isExtraCommandInvocation(command string) bool {
+func (st *sandboxState) isExtraCommandInvocation(command string) bool {
 	word := firstCommandWord(command)
 	if word == "" {
 		return false
 	}
-	return s.getBareExtraCommands()[word]
+	return st.bareExtraCommands[word] && !st.deniedCommands[word]
 }
 
 // executeRaw executes a command string directly using the system bash without
 // going through AST parsing or validation. Used for bare extra_commands entries.
-func (s *Sandbox) executeRaw(ctx context.Context, command string, workDir string) (string, error) {
-	s.mu.RLock()
-	imdsEndpoint := s.imdsEndpoint
-	s.mu.RUnlock()
+func (s *Sandbox) executeRaw(ctx context.Context, st *sandboxState, command string, workDir string, extraEnv map[string]string) (string, error) {
+	imdsEndpoint := st.imdsEndpoint
 
-	env := os.Environ()
+	env := withExtraEnv(os.Environ(), extraEnv)
 	if imdsEndpoint != "" {
 		env = append(env, fmt.Sprintf("AWS_EC2_METADATA_SERVICE_ENDPOINT=%s", imdsEndpoint))
 	}
+	if st.executionPath != "" {
+		env = append(env, "PATH="+st.executionPath)
+	}
 
-	var out bytes.Buffer
+	ctx, cancel, out := withOutputLimit(ctx, st.cfg.OutputLimit())
+	defer cancel()
 	cmd := exec.CommandContext(ctx, "bash", "-c", command)
 	cmd.Dir = workDir
-	cmd.Stdout = &out
-	cmd.Stderr = &out
+	cmd.Stdout = out
+	cmd.Stderr = out
 	cmd.Env = env
 
-	if err := cmd.Run(); err != nil {
-		output := out.String()
-		return output, &CommandFailedError{Err: err, Output: output}
-	}
-	return out.String(), nil
+	return out.result(cmd.Run())
 }
 
 // Execute parses, validates, and executes a bash command.
 // workDir is the working directory for the command and for resolving relative paths.
 // readAllowedPaths are absolute directories that read-only commands may access.
 // writeAllowedPaths are absolute directories that write commands may access.
-// It returns the combined stdout and stderr output.
+// Relative workDir or allowed paths are rejected. default_readable_paths and
+// default_writable_paths are added to the allowed paths. Allowed paths so
+// broad that they defeat path restrictions, like / or the home directory,
+// are logged, or rejected if reject_broad_paths is set.
+// It returns the combined stdout and stderr output, with ANSI escape
+// sequences removed unless strip_ansi is false. If annotate_empty_output is
+// set, a successful command without output returns a note saying so. A
+// command whose output exceeds max_output_bytes is stopped and fails with
+// the truncated output.
+// If rate_limit is set, commands beyond it fail with a "rate limit exceeded"
+// error without running.
 func (s *Sandbox) Execute(ctx context.Context, command string, workDir string, readAllowedPaths, writeAllowedPaths []string) (string, error) {
+	return s.ExecuteWithEnv(ctx, command, workDir, readAllowedPaths, writeAllowedPaths, nil)
+}
+
+// ExecuteWithEnv is like Execute, but adds extraEnv to the environment of
+// this command only, replacing inherited variables of the same name. The
+// variables that sandboxed commands cannot set, such as PATH and
+// LD_PRELOAD, are rejected, and the IMDS endpoint and execution_path still
+// take precedence. extraEnv is not part of the key for record and replay.
+func (s *Sandbox) ExecuteWithEnv(ctx context.Context, command string, workDir string, readAllowedPaths, writeAllowedPaths []string, extraEnv map[string]string) (string, error) {
+	s.counters.commands.Add(1)
+	st := s.loadState()
+	if rl := st.rateLimiter; rl != nil {
+		if err := rl.allow(); err != nil {
+			s.counters.rateLimited.Add(1)
+			return "", err
+		}
+	}
+	if l := s.replay.Load(); l != nil {
+		if rec, ok := l.lookup(command, workDir); ok {
+			slog.InfoContext(ctx, "replaying sandboxed bash", "command", command)
+			output, err := rec.result()
+			s.counters.record(err)
+			return output, err
+		}
+		if l.strict {
+			err := fmt.Errorf("replay: no recorded result for %q in %s", command, workDir)
+			s.counters.record(err)
+			return "", err
+		}
+	}
+
+	output, err := s.execute(ctx, st, command, workDir, readAllowedPaths, writeAllowedPaths, extraEnv)
+	s.counters.record(err)
+	cfg := st.cfg
+	output, err = transformOutput(cfg, output, err)
+	output = annotateEmptyOutput(cfg, output, err)
+	if r := s.recorder.Load(); r != nil {
+		if recErr := r.record(newReplayRecord(command, workDir, output, err)); recErr != nil {
+			slog.Warn("failed to record command", "command", command, "error", recErr)
+		}
+	}
+	return output, err
+}
+
+// execute is Execute without recording or replay. The command is validated
+// and run against st, so a concurrent UpdateConfig takes effect from the
+// next command.
+func (s *Sandbox) execute(ctx context.Context, st *sandboxState, command string, workDir string, readAllowedPaths, writeAllowedPaths []string, extraEnv map[string]string) (string, error) {
 	slog.InfoContext(ctx, "executing sandboxed bash", "command", command)
 
+	if err := validateDirs(workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+		return "", err
+	}
+	if err := validateExtraEnv(extraEnv); err != nil {
+		return "", fmt.Errorf("validation failed: %w", err)
+	}
+	readAllowedPaths, writeAllowedPaths = st.withDefaultPaths(readAllowedPaths, writeAllowedPaths)
+	if err := s.checkBroadPaths(st.cfg, readAllowedPaths, writeAllowedPaths); err != nil {
+		return "", err
+	}
+
+	required := st.cfg.RequiresOSSandbox()
+	if required {
+		if err := s.checkOSSandboxAvailable(st); err != nil {
+			return "", err
+		}
+	}
+
 	// Bare extra_commands entries bypass bash AST parsing entirely and are
-	// executed directly with the real bash for maximum compatibility.
-	if s.isExtraCommandInvocation(command) {
-		return s.executeRaw(ctx, command, workDir)
+	// executed directly with the real bash for maximum compatibility. The
+	// real bash runs outside the OS sandbox, so they go through the
+	// interpreter when the OS sandbox is required.
+	if !required && st.isExtraCommandInvocation(command) {
+		return s.executeRaw(ctx, st, command, workDir, extraEnv)
 	}
 
 	// Parse and validate
@@ -761,8 +1420,9 @@ func (s *Sandbox) Execute(ctx context.Context, command string, workDir string, r
 	if err != nil {
 		return "", err
 	}
+	st.resolveAbsoluteCommands(f, workDir, readAllowedPaths)
 
-	if err := s.validateWithWorkDir(f, workDir); err != nil {
+	if err := st.validateWithWorkDir(f, workDir); err != nil {
 		return "", fmt.Errorf("validation failed: %w", err)
 	}
 
@@ -776,67 +1436,175 @@ func (s *Sandbox) Execute(ctx context.Context, command string, workDir string, r
 
 	// Always execute using interp
 	// If OS sandbox is enabled, ExecHandler will send commands to worker
-	return s.executeWithInterp(ctx, f, workDir, readAllowedPaths, writeAllowedPaths)
+	return s.executeWithInterp(ctx, st, f, workDir, readAllowedPaths, writeAllowedPaths, extraEnv)
+}
+
+// Output encodings reported in ExecuteResult.Encoding.
+const (
+	// EncodingText means Output is the command's output unchanged.
+	EncodingText = "text"
+	// EncodingBase64 means the command's output was not valid UTF-8, so
+	// Output holds it in standard base64 encoding.
+	EncodingBase64 = "base64"
+)
+
+// ExecuteResult is the outcome of one command run by ExecuteDetailed or
+// ExecuteBatch.
+type ExecuteResult struct {
+	Command string
+	Output  string
+	// Encoding is EncodingText or EncodingBase64. Only ExecuteDetailed
+	// encodes output.
+	Encoding string
+	Err      error
+	// FileChanges lists the files the command created, modified or deleted
+	// under the write-allowed paths, including default_writable_paths, if
+	// ExecuteOptions.AuditWrites was set.
+	FileChanges []FileChange
+	// AuditSkipped is set if AuditWrites was requested but the write-allowed
+	// paths hold more than maxAuditFiles files, so FileChanges is empty.
+	AuditSkipped bool
+}
+
+// ExecuteOptions are optional behaviors of ExecuteDetailed.
+type ExecuteOptions struct {
+	// AuditWrites snapshots the files under the write-allowed paths and
+	// default_writable_paths before and after the command, hashing each one, and reports the difference in
+	// ExecuteResult.FileChanges. It is expensive for large trees.
+	AuditWrites bool
+}
+
+// ExecuteDetailed is like Execute, but base64-encodes the output if it is not
+// valid UTF-8, so that binary output (e.g. from xxd or head -c) survives
+// transports such as JSON that would replace invalid bytes. Valid UTF-8 output
+// is returned as is. If the command fails, Err is the error from Execute and
+// Output is still the (possibly encoded) output. With opts.AuditWrites it also
+// reports which files the command changed.
+func (s *Sandbox) ExecuteDetailed(ctx context.Context, command string, workDir string, readAllowedPaths, writeAllowedPaths []string, opts ExecuteOptions) ExecuteResult {
+	var before map[string]fileState
+	var auditPaths []string
+	auditSkipped := false
+	if opts.AuditWrites {
+		var err error
+		_, auditPaths = s.EffectivePaths(readAllowedPaths, writeAllowedPaths)
+		if before, err = snapshotFiles(auditPaths); err != nil {
+			auditSkipped = true
+		}
+	}
+
+	output, err := s.Execute(ctx, command, workDir, readAllowedPaths, writeAllowedPaths)
+	result := ExecuteResult{Command: command, Output: output, Encoding: EncodingText, Err: err, AuditSkipped: auditSkipped}
+	if !utf8.ValidString(output) {
+		result.Output = base64.StdEncoding.EncodeToString([]byte(output))
+		result.Encoding = EncodingBase64
+	}
+
+	if opts.AuditWrites && !auditSkipped {
+		after, err := snapshotFiles(auditPaths)
+		if err != nil {
+			result.AuditSkipped = true
+		} else {
+			result.FileChanges = diffSnapshots(before, after)
+		}
+	}
+	return result
+}
+
+// ExecuteBatch validates and executes commands sequentially, each in a fresh
+// interpreter so that environment and working directory changes do not carry
+// over between commands. If stopOnError is true, execution stops after the
+// first failing command; otherwise every command is run. One result is
+// returned per command that was run. The returned error is the first command
+// failure, if any, or the context error if ctx is cancelled between commands.
+func (s *Sandbox) ExecuteBatch(ctx context.Context, commands []string, workDir string, readAllowedPaths, writeAllowedPaths []string, stopOnError bool) ([]ExecuteResult, error) {
+	results := make([]ExecuteResult, 0, len(commands))
+	var firstErr error
+	for i, command := range commands {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		output, err := s.Execute(ctx, command, workDir, readAllowedPaths, writeAllowedPaths)
+		results = append(results, ExecuteResult{Command: command, Output: output, Encoding: EncodingText, Err: err})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("command %d (%q): %w", i+1, command, err)
+			}
+			if stopOnError {
+				break
+			}
+		}
+	}
+	return results, firstErr
 }
 
 // executeWithInterp executes the parsed command using interp.
-// If OS sandbox is enabled, ExecHandler delegates to the worker.
-func (s *Sandbox) executeWithInterp(ctx context.Context, f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string) (string, error) {
-	s.mu.RLock()
-	useOSSandbox := s.osSandbox
-	imdsEndpoint := s.imdsEndpoint
-	s.mu.RUnlock()
+// If OS sandbox is enabled, ExecHandler delegates to the worker. The whole
+// run, including nested bash and scripts, uses st, the state the command
+// was validated against. extraEnv is added to the inherited environment.
+func (s *Sandbox) executeWithInterp(ctx context.Context, st *sandboxState, f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string, extraEnv map[string]string) (string, error) {
+	imdsEndpoint := st.imdsEndpoint
 
-	var out bytes.Buffer
+	ctx, cancel, out := withOutputLimit(ctx, st.cfg.OutputLimit())
+	defer cancel()
 
 	// Build environment with IMDS endpoint if AWS is enabled
 	// IMPORTANT: Set as actual environment variable so subprocesses (like aws cli) can see it
-	env := os.Environ()
+	env := withExtraEnv(os.Environ(), extraEnv)
 	if imdsEndpoint != "" {
 		envVar := fmt.Sprintf("AWS_EC2_METADATA_SERVICE_ENDPOINT=%s", imdsEndpoint)
 		env = append(env, envVar)
 		// Also set in actual process environment so it's visible in shell sessions
 		os.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", imdsEndpoint)
 	}
+	// Later entries win, so this replaces the inherited PATH.
+	if st.executionPath != "" {
+		env = append(env, "PATH="+st.executionPath)
+	}
 
 	// Store sandbox paths in context so nested bash/sh can access them
-	ctx = context.WithValue(ctx, sandboxPathsKey, &sandboxPaths{
+	paths := &sandboxPaths{
 		readAllowedPaths:  readAllowedPaths,
 		writeAllowedPaths: writeAllowedPaths,
-	})
+		binaryPaths:       st.cfg.LocalBinaryExecution.BinaryPaths(workDir),
+		scriptPatterns:    st.cfg.LocalBinaryExecution.ScriptPatterns(workDir),
+		state:             st,
+	}
+	ctx = context.WithValue(ctx, sandboxPathsKey, paths)
 
 	// Build interpreter options
 	opts := []interp.RunnerOption{
 		interp.Dir(workDir),
-		interp.StdIO(nil, &out, &out),
+		interp.StdIO(nil, out, out),
 		interp.Env(expand.ListEnviron(env...)),
 	}
 
 	// Add security handlers (CallHandler, OpenHandler, ExecHandler)
-	opts = append(opts, s.buildSecurityHandlers(readAllowedPaths, writeAllowedPaths, useOSSandbox)...)
+	opts = append(opts, s.buildSecurityHandlers(paths)...)
 
 	runner, err := interp.New(opts...)
 	if err != nil {
 		return "", fmt.Errorf("failed to create interpreter: %w", err)
 	}
 
-	err = runner.Run(ctx, f)
-	output := out.String()
-	if err != nil {
-		return output, &CommandFailedError{Err: err, Output: output}
-	}
-	return output, nil
+	return out.result(runner.Run(ctx, f))
 }
 
 // execInWorker sends a command to the worker for execution in the OS sandbox.
-func (s *Sandbox) execInWorker(ctx context.Context, args []string) error {
-	w, err := s.getOrCreateWorker()
+func (s *Sandbox) execInWorker(ctx context.Context, st *sandboxState, args []string) error {
+	w, err := s.getOrCreateWorker(st)
 	if err != nil {
 		return fmt.Errorf("failed to get worker: %w", err)
 	}
 
 	hc := interp.HandlerCtx(ctx)
 
+	// Reject working directories outside the worker's binds before sending;
+	// the worker performs the same check on its side.
+	workerDirs := slices.Concat([]string{st.workerWorkDir}, st.workerBinds, st.workerReadBinds)
+	if !os_sandbox.IsDirAllowed(hc.Dir, workerDirs) {
+		return fmt.Errorf("working directory %q is outside the sandbox worker's allowed directories", hc.Dir)
+	}
+
 	// Convert environment
 	envMap := make(map[string]string)
 	hc.Env.Each(func(name string, vr expand.Variable) bool {
@@ -853,27 +1621,96 @@ func (s *Sandbox) execInWorker(ctx context.Context, args []string) error {
 	}
 
 	if exitCode != 0 {
+		// Like interp's default exec handler, report a command killed
+		// because ctx is done as the context error.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		return interp.ExitStatus(exitCode)
 	}
 
 	return nil
 }
 
-// getOrCreateWorker returns the current worker, starting a new one if the worker
-// is nil or dead. Must be called without holding s.mu.
-func (s *Sandbox) getOrCreateWorker() (*os_sandbox.Worker, error) {
+// startWorker starts an OS sandbox worker; replaced in tests.
+var startWorker = os_sandbox.StartWorker
+
+// Warmup starts the OS sandbox worker ahead of the first Execute, so the first
+// command does not pay the sandbox startup latency and startup failures are
+// reported before any command runs. It is a no-op when the OS sandbox is
+// disabled. The worker outlives ctx; if ctx is done before the worker is
+// ready, Warmup returns ctx.Err() and startup continues in the background.
+func (s *Sandbox) Warmup(ctx context.Context) error {
+	st := s.loadState()
+	if !st.osSandbox {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.getOrCreateWorker(st)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkOSSandboxAvailable returns an error unless the OS sandbox is enabled
+// and its worker is running or can be started. It is used when
+// os_sandbox_required is set, so that no command runs without the OS-level
+// isolation. st is the state the command runs with.
+func (s *Sandbox) checkOSSandboxAvailable(st *sandboxState) error {
+	if !st.osSandbox {
+		return fmt.Errorf("OS sandbox required but unavailable: os_sandbox is disabled")
+	}
+	if _, err := s.getOrCreateWorker(st); err != nil {
+		return fmt.Errorf("OS sandbox required but unavailable: %w", err)
+	}
+	return nil
+}
+
+// getOrCreateWorker returns the current worker, starting a new one from st if
+// the worker is nil or dead, or was started with different binds than st. A
+// command whose state predates a config change therefore never runs in a
+// worker set up for another config. Must be called without holding s.mu.
+func (s *Sandbox) getOrCreateWorker(st *sandboxState) (*os_sandbox.Worker, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.worker != nil && !s.worker.IsDead() {
-		return s.worker, nil
+		if s.workerState.sameWorker(st) {
+			return s.worker, nil
+		}
+		slog.Info("closing sandbox worker started with a different config")
+		s.worker.Close()
+		s.worker = nil
 	}
 
-	slog.Info("starting new sandbox worker", "workDir", s.workerWorkDir, "blockAWS", s.workerBlockAWS)
-	w, err := os_sandbox.StartWorker(context.Background(), s.workerWorkDir, s.workerRuntimeBinds, s.workerBlockAWS)
+	slog.Info("starting new sandbox worker", "workDir", st.workerWorkDir, "blockAWS", st.workerBlockAWS)
+	w, err := startWorker(context.Background(), st.workerWorkDir, st.workerBinds, st.workerReadBinds, st.workerBlockAWS)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start worker: %w", err)
+		s.workerErr = fmt.Errorf("failed to start worker: %w", err)
+		return nil, s.workerErr
 	}
 	s.worker = w
+	s.workerState = st
+	s.workerErr = nil
 	return w, nil
 }
+
+// sameWorker reports whether a worker started with st can run commands for
+// o: both set it up with the same working directory, binds and AWS
+// credential blocking.
+func (st *sandboxState) sameWorker(o *sandboxState) bool {
+	return st.workerWorkDir == o.workerWorkDir &&
+		slices.Equal(st.workerBinds, o.workerBinds) &&
+		slices.Equal(st.workerReadBinds, o.workerReadBinds) &&
+		st.workerBlockAWS == o.workerBlockAWS
+}
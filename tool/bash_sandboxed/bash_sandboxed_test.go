@@ -1,14 +1,24 @@
 package bash_sandboxed
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gartnera/lite-sandbox/config"
+	"github.com/gartnera/lite-sandbox/os_sandbox"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func TestParseBash_Valid(t *testing.T) {
@@ -53,6 +63,55 @@ func TestParseBash_Invalid(t *testing.T) {
 	}
 }
 
+func TestParseBashVariant(t *testing.T) {
+	// [[ is a test clause in bash but an ordinary command name in POSIX sh.
+	for _, tt := range []struct {
+		variant syntax.LangVariant
+		want    string
+	}{
+		{syntax.LangBash, "*syntax.TestClause"},
+		{syntax.LangPOSIX, "*syntax.CallExpr"},
+	} {
+		f, err := ParseBashVariant("[[ -n x ]]", tt.variant)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.variant, err)
+		}
+		if got := fmt.Sprintf("%T", f.Stmts[0].Cmd); got != tt.want {
+			t.Errorf("%s: parsed [[ as %s, want %s", tt.variant, got, tt.want)
+		}
+	}
+
+	if _, err := ParseBashVariant("a=(1 2)", syntax.LangBash); err != nil {
+		t.Fatalf("expected arrays to parse as bash, got: %v", err)
+	}
+	_, err := ParseBashVariant("a=(1 2)", syntax.LangPOSIX)
+	if err == nil || !strings.Contains(err.Error(), "failed to parse posix") {
+		t.Fatalf("expected a posix parse error for arrays, got: %v", err)
+	}
+}
+
+func TestShebangVariant(t *testing.T) {
+	tests := []struct {
+		script string
+		want   syntax.LangVariant
+	}{
+		{"echo hi\n", syntax.LangBash},
+		{"#!/bin/bash\necho hi\n", syntax.LangBash},
+		{"#!/usr/bin/env bash\n", syntax.LangBash},
+		{"#!/bin/sh\necho hi\n", syntax.LangPOSIX},
+		{"#!/bin/sh -e\n", syntax.LangPOSIX},
+		{"#! /bin/dash", syntax.LangPOSIX},
+		{"#!/usr/bin/env sh\n", syntax.LangPOSIX},
+		{"#!/usr/bin/env -S sh -e\n", syntax.LangPOSIX},
+		{"#!\n", syntax.LangBash},
+	}
+	for _, tt := range tests {
+		if got := shebangVariant(tt.script); got != tt.want {
+			t.Errorf("shebangVariant(%q) = %s, want %s", tt.script, got, tt.want)
+		}
+	}
+}
+
 func TestBashSandboxed_Executes(t *testing.T) {
 	workDir := t.TempDir()
 	out, err := NewSandbox().Execute(context.Background(), "echo hello", workDir, []string{workDir}, []string{workDir})
@@ -240,6 +299,7 @@ func TestValidate_BlockedCommands(t *testing.T) {
 		{"pip", "pip install requests", `command "pip" is not allowed`},
 		{"pip3", "pip3 install requests", `command "pip3" is not allowed`},
 		{"cargo", "cargo build", `command "cargo" is not allowed (runtimes.rust.enabled is disabled)`},
+		{"dc shell escape", "echo '!id' | dc", `command "dc" is not allowed`},
 
 		// Networking (data exfiltration / remote code fetch)
 		{"curl", "curl https://example.com", `command "curl" is not allowed`},
@@ -303,6 +363,8 @@ func TestValidate_AllowedProcSubst(t *testing.T) {
 		{"input substitution with allowed commands", "diff <(echo a) <(echo b)"},
 		{"output substitution with allowed command", "echo hello > >(cat)"},
 		{"sort with two process substitutions", "comm <(sort file1) <(sort file2)"},
+		{"input redirect from substitution", "cat < <(echo hi)"},
+		{"here-string word is a substitution", "cat <<< <(echo hi)"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -325,6 +387,12 @@ func TestValidate_BlockedProcSubst(t *testing.T) {
 	}{
 		{"blocked command inside input substitution", `diff <(python3 script.py) <(echo b)`, `command "python3" is not allowed`},
 		{"blocked command inside output substitution", `echo hello > >(python3 script.py)`, `command "python3" is not allowed`},
+		{"blocked command inside input redirect target", `cat < <(curl example.com)`, `command "curl" is not allowed`},
+		{"blocked command inside append redirect target", `echo hello >> >(python3 script.py)`, `command "python3" is not allowed`},
+		{"blocked command inside here-string substitution", `cat <<< <(python3 script.py)`, `command "python3" is not allowed`},
+		{"blocked command inside here-string output substitution", `cat <<< >(python3 script.py)`, `command "python3" is not allowed`},
+		{"blocked command inside heredoc command substitution", "cat <<EOF\n$(python3 script.py)\nEOF", `command "python3" is not allowed`},
+		{"exec with fd redirect from substitution", `exec 3< <(echo hi)`, `command "exec" is not allowed`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -343,6 +411,115 @@ func TestValidate_BlockedProcSubst(t *testing.T) {
 	}
 }
 
+func TestValidate_NestingDepth(t *testing.T) {
+	// nest wraps "echo x" in n levels of open/close.
+	nest := func(n int, open, close string) string {
+		return strings.Repeat(open, n) + "echo x" + strings.Repeat(close, n)
+	}
+	limit := config.DefaultMaxSubstitutionDepth
+	tests := []struct {
+		name    string
+		command string
+		cfg     *config.Config
+		wantErr bool
+	}{
+		{"command substitutions at the limit", nest(limit, "echo $(", ")"), nil, false},
+		{"command substitutions beyond the limit", nest(limit+1, "echo $(", ")"), nil, true},
+		{"backquotes beyond the limit", "echo `" + nest(limit, "echo $(", ")") + "`", nil, true},
+		{"subshells at the limit", nest(limit, "( ", " )"), nil, false},
+		{"subshells beyond the limit", nest(limit+1, "( ", " )"), nil, true},
+		{"process substitutions beyond the limit", nest(limit+1, "cat <(", ")"), nil, true},
+		{"mixed nesting beyond the limit", nest(limit/2+1, "echo $( (", ") )"), nil, true},
+		{"siblings do not add up", strings.Repeat(nest(limit, "echo $(", ")")+"; ", 3), nil, false},
+		{"configured limit", nest(5, "echo $(", ")"), &config.Config{MaxSubstitutionDepth: 4}, true},
+		{"unlimited", nest(limit+1, "echo $(", ")"), &config.Config{MaxSubstitutionDepth: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			s := newTestSandbox()
+			if tt.cfg != nil {
+				s.UpdateConfig(tt.cfg, "")
+			}
+			err = s.validate(f)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "command substitution nesting too deep") {
+				t.Fatalf("expected a nesting error, got %v", err)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	err := NewSandbox().ValidateCommand(nest(limit+1, "echo $(", ")"), dir, []string{dir}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "command substitution nesting too deep") {
+		t.Errorf("expected ValidateCommand to reject deep nesting, got %v", err)
+	}
+}
+
+func TestValidate_HeredocSize(t *testing.T) {
+	// heredoc returns a command whose heredoc body and closing EOF line
+	// are n bytes long.
+	heredoc := func(n int) string {
+		return "cat <<'EOF'\n" + strings.Repeat("x", n-4) + "\nEOF\n"
+	}
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"under the limit", heredoc(1000), false},
+		{"at the limit", heredoc(1024), false},
+		{"over the limit", heredoc(1025), true},
+		{"dash heredoc over the limit", "cat <<-EOF\n" + strings.Repeat("x", 1100) + "\nEOF\n", true},
+		{"expanding heredoc over the limit", "cat <<EOF\n$HOME " + strings.Repeat("x", 1100) + "\nEOF\n", true},
+		{"combined size over the limit", heredoc(600) + heredoc(600), true},
+		{"heredocs in one statement", "cat <<A - 3<<B\n" + strings.Repeat("x", 600) + "\nA\n" + strings.Repeat("y", 600) + "\nB\n", true},
+		{"here-strings are not counted", "cat <<< " + strings.Repeat("x", 2000), false},
+	}
+	s := newTestSandbox()
+	s.UpdateConfig(&config.Config{MaxHeredocBytes: 1024}, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = s.validate(f)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "more than the limit of 1024 bytes (max_heredoc_bytes)") {
+				t.Fatalf("expected a heredoc size error, got %v", err)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	paths := []string{dir}
+	if err := s.ValidateCommand(heredoc(2000), dir, paths, paths); err == nil {
+		t.Error("expected ValidateCommand to reject a heredoc over the limit")
+	}
+	out, err := s.Execute(context.Background(), heredoc(1024), dir, paths, paths)
+	if err != nil || len(out) != 1021 {
+		t.Errorf("expected the heredoc to be printed, got %d bytes and %v", len(out), err)
+	}
+	s.UpdateConfig(&config.Config{MaxHeredocBytes: -1}, "")
+	if err := s.ValidateCommand(heredoc(2000), dir, paths, paths); err != nil {
+		t.Errorf("expected no limit with max_heredoc_bytes: -1, got %v", err)
+	}
+}
+
 func TestValidate_BlockedInPipeline(t *testing.T) {
 	f, err := ParseBash("echo hello | python script.py")
 	if err != nil {
@@ -427,12 +604,21 @@ func TestValidate_BlockedEnvVars(t *testing.T) {
 		{"ENV assignment", "ENV=/tmp/evil.sh", "setting ENV is not allowed"},
 		{"CDPATH assignment", "CDPATH=/tmp", "setting CDPATH is not allowed"},
 		{"PROMPT_COMMAND assignment", "PROMPT_COMMAND=evil", "setting PROMPT_COMMAND is not allowed"},
+		{"MANOPT prefix", "MANOPT=-Hfirefox man ls", "setting MANOPT is not allowed"},
 		// Inline assignments with command
 		{"PATH inline", "PATH=/tmp/evil echo hello", "setting PATH is not allowed"},
 		// export/declare
 		{"export PATH", "export PATH=/tmp/evil", "setting PATH is not allowed"},
 		{"declare PATH", "declare PATH=/tmp/evil", "setting PATH is not allowed"},
 		{"export LD_PRELOAD", "export LD_PRELOAD=/tmp/evil.so", "setting LD_PRELOAD is not allowed"},
+		{"declare -x PATH", "declare -x PATH=/tmp/evil", "setting PATH is not allowed"},
+		{"readonly PATH", "readonly PATH=/x", "setting PATH is not allowed"},
+		{"typeset -x LD_PRELOAD", "typeset -x LD_PRELOAD=y", "setting LD_PRELOAD is not allowed"},
+		{"local PATH", "local PATH=z", "setting PATH is not allowed"},
+		{"local PATH in function", "f() { local PATH; ls; }; f", "setting PATH is not allowed"},
+		{"declare -n PATH", "declare -n PATH", "setting PATH is not allowed"},
+		{"PATH append", "PATH+=:/tmp/evil", "setting PATH is not allowed"},
+		{"PATH index", "declare PATH[0]=/tmp/evil", "setting PATH is not allowed"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -461,6 +647,10 @@ func TestValidate_AllowedEnvVars(t *testing.T) {
 		{"export FOO", "export FOO=bar"},
 		{"declare FOO", "declare -a arr"},
 		{"HOME assignment", "HOME=/tmp"},
+		{"export existing FOO", "export FOO"},
+		{"readonly FOO", "readonly FOO=1"},
+		{"typeset -x FOO", "typeset -x FOO=1"},
+		{"local FOO", "local FOO=1"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -475,6 +665,54 @@ func TestValidate_AllowedEnvVars(t *testing.T) {
 	}
 }
 
+// TestExecute_IndirectExpansion covers ${!var} and namerefs, whose values are
+// only known at runtime: as a command name they are rejected as dynamic, and
+// paths or blocked variables reached through them are caught after expansion.
+func TestExecute_IndirectExpansion(t *testing.T) {
+	workDir := t.TempDir()
+	os.WriteFile(filepath.Join(workDir, "file.txt"), []byte("hello\n"), 0o644)
+	paths := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		errMsg  string
+	}{
+		{"indirect command", "x=rm; ${!x} file.txt", "", "dynamic command names are not allowed"},
+		{"quoted indirect command", `x=rm; "${!x}" file.txt`, "", "dynamic command names are not allowed"},
+		{"indirect path", "p=/etc/passwd; v=p; cat ${!v}", "", "outside allowed directories"},
+		{"indirect redirect", "p=/etc/passwd; v=p; cat < ${!v}", "", "outside allowed directories"},
+		{"indirect traversal", "p=../..; v=p; ls ${!v}", "", "outside allowed directories"},
+		{"indirect cd", "p=/tmp; v=p; cd ${!v}", "", "outside allowed directories"},
+		{"nameref path", "p=/etc/passwd; declare -n r=p; cat $r", "", "outside allowed directories"},
+		{"nameref PATH", "declare -n r=PATH; r=.; ls", "", "setting PATH is not allowed"},
+		{"dynamic declare PATH", `x=PATH; declare "$x=."; ls`, "", "setting PATH is not allowed"},
+		{"dynamic export LD_PRELOAD", `x=LD_PRELOAD; export "$x=lib.so"; ls`, "", "setting LD_PRELOAD is not allowed"},
+		{"read PATH", "read PATH <<< .; ls", "", "setting PATH is not allowed"},
+		{"indirect path allowed", "p=file.txt; v=p; cat ${!v}", "hello\n", ""},
+		{"indirect value allowed", "FOO=bar; x=FOO; echo ${!x}", "bar\n", ""},
+		{"nameref allowed", "declare -n r=FOO; r=baz; echo $FOO", "baz\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := newTestSandbox().Execute(context.Background(), tt.command, workDir, paths, paths)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v (output %q)", tt.errMsg, err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, out)
+			}
+		})
+	}
+}
+
 func TestValidate_ExtraCommands(t *testing.T) {
 	s := NewSandbox()
 
@@ -500,6 +738,99 @@ func TestValidate_ExtraCommands(t *testing.T) {
 	}
 }
 
+func TestWhyAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		command string
+		want    string
+	}{
+		{"builtin", &config.Config{}, "echo", AllowSourceBuiltin},
+		{"not allowed", &config.Config{}, "curl", ""},
+		{"extra command", &config.Config{ExtraCommands: []string{"curl"}}, "curl", AllowSourceExtraCommands},
+		{"restricted extra command", &config.Config{ExtraCommands: []string{"pnpx prettier"}}, "pnpx", AllowSourceExtraCommands},
+		{"extra overrides runtime", &config.Config{ExtraCommands: []string{"go"}}, "go", AllowSourceExtraCommands},
+		{"denied", &config.Config{ExtraCommands: []string{"curl"}, DeniedCommands: []string{"curl"}}, "curl", ""},
+		{"denied builtin", &config.Config{DeniedCommands: []string{"echo"}}, "echo", ""},
+		{"go runtime", &config.Config{Runtimes: &config.RuntimesConfig{Go: &config.GoConfig{Enabled: boolPtr(true)}}}, "go", "runtimes.go"},
+		{"go runtime disabled", &config.Config{}, "go", ""},
+		{"rust runtime", &config.Config{Runtimes: &config.RuntimesConfig{Rust: &config.RustConfig{Enabled: boolPtr(true)}}}, "rustc", "runtimes.rust"},
+		{"aws", &config.Config{AWS: &config.AWSConfig{ForceProfile: "dev"}}, "aws", "aws"},
+		{"local binary", &config.Config{LocalBinaryExecution: &config.LocalBinaryExecutionConfig{Enabled: boolPtr(true)}}, "./build.sh", AllowSourceLocalBinary},
+		{"local binary disabled", &config.Config{}, "./build.sh", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSandbox()
+			s.UpdateConfig(tt.cfg, "")
+			if got := s.WhyAllowed(tt.command); got != tt.want {
+				t.Fatalf("WhyAllowed(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowSource_DeclaredFunction(t *testing.T) {
+	isDeclared := func(name string) bool { return name == "helper" }
+	args := []*syntax.Word{{Parts: []syntax.WordPart{&syntax.Lit{Value: "helper"}}}}
+	if got := allowSource("helper", args, NewSandbox().loadState().commandLists(""), isDeclared, ""); got != AllowSourceDeclaredFunction {
+		t.Fatalf("allowSource(helper) = %q, want %q", got, AllowSourceDeclaredFunction)
+	}
+}
+
+// TestUpdateConfig_ConcurrentExecute swaps configs while commands run. Every
+// Execute must see one config or the other, never a mix; run with -race.
+func TestUpdateConfig_ConcurrentExecute(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSandbox()
+	cfgs := []*config.Config{
+		{DeniedCommands: []string{"cat"}, ExtraCommands: []string{"curl"}},
+		{},
+	}
+
+	stop := make(chan struct{})
+	updaterDone := make(chan struct{})
+	go func() {
+		defer close(updaterDone)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.UpdateConfig(cfgs[i%2], dir)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				out, err := s.Execute(context.Background(), "cat f", dir, []string{dir}, nil)
+				if err != nil && !strings.Contains(err.Error(), "denied by denied_commands") {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if err == nil && out != "hi" {
+					t.Errorf("unexpected output %q", out)
+				}
+				st := s.loadState()
+				denied := len(st.cfg.DeniedCommands) > 0
+				if st.deniedCommands["cat"] != denied || st.extraCommands["curl"] != denied {
+					t.Errorf("torn state: cfg %+v, denied %v, extra %v", st.cfg, st.deniedCommands, st.extraCommands)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-updaterDone
+}
+
 func TestValidate_ExtraCommandsSubcommand(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -689,7 +1020,7 @@ func TestIsExtraCommandInvocation(t *testing.T) {
 		{"echo hello", false},
 	}
 	for _, tt := range tests {
-		got := s.isExtraCommandInvocation(tt.command)
+		got := s.loadState().isExtraCommandInvocation(tt.command)
 		if got != tt.want {
 			t.Errorf("isExtraCommandInvocation(%q) = %v, want %v", tt.command, got, tt.want)
 		}
@@ -793,6 +1124,113 @@ func TestConfigPaths(t *testing.T) {
 	}
 }
 
+func TestExecute_DefaultPaths(t *testing.T) {
+	workDir := t.TempDir()
+	shared := t.TempDir()
+	cache := t.TempDir()
+	other := t.TempDir()
+	for _, dir := range []string{shared, other} {
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{workDir}
+	ctx := context.Background()
+
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{
+		DefaultReadablePaths: []string{shared},
+		DefaultWritablePaths: []string{cache},
+	}, workDir)
+
+	out, err := s.Execute(ctx, "cat "+filepath.Join(shared, "file.txt"), workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("expected read in default_readable_paths to succeed, got %v", err)
+	}
+	if out != "content\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if err := s.ValidateCommand("cat "+filepath.Join(shared, "file.txt"), workDir, paths, paths); err != nil {
+		t.Errorf("expected ValidateCommand to allow default_readable_paths, got %v", err)
+	}
+	if _, err := s.Execute(ctx, "touch "+filepath.Join(cache, "out"), workDir, paths, paths); err != nil {
+		t.Fatalf("expected write in default_writable_paths to succeed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cache, "out")); err != nil {
+		t.Errorf("expected file to be created: %v", err)
+	}
+
+	// The defaults are added to, not replacing, the caller's paths.
+	if _, err := s.Execute(ctx, "echo hi > out.txt", workDir, paths, paths); err != nil {
+		t.Errorf("expected write in the caller's path to succeed, got %v", err)
+	}
+	// Paths that are neither configured nor passed stay blocked.
+	if _, err := s.Execute(ctx, "cat "+filepath.Join(other, "file.txt"), workDir, paths, paths); err == nil {
+		t.Error("expected read outside allowed paths to fail")
+	}
+	if _, err := s.Execute(ctx, "touch "+filepath.Join(shared, "out"), workDir, paths, paths); err == nil {
+		t.Error("expected write to a default readable path to fail")
+	}
+
+	s.UpdateConfig(&config.Config{}, workDir)
+	if _, err := s.Execute(ctx, "cat "+filepath.Join(shared, "file.txt"), workDir, paths, paths); err == nil {
+		t.Error("expected read to fail after default_readable_paths is removed")
+	}
+}
+
+func TestExecute_BroadPaths(t *testing.T) {
+	var logBuf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logBuf, nil)))
+	defer slog.SetDefault(prev)
+
+	workDir := t.TempDir()
+	ctx := context.Background()
+	broad := []string{workDir, "/"}
+
+	s := NewSandbox()
+	if _, err := s.Execute(ctx, "echo hi", workDir, broad, []string{workDir}); err != nil {
+		t.Fatalf("expected broad path to be allowed with a warning, got %v", err)
+	}
+	if !strings.Contains(logBuf.String(), "allowed path is too broad") || !strings.Contains(logBuf.String(), "path=/") {
+		t.Fatalf("expected warning for /, got log: %q", logBuf.String())
+	}
+
+	logBuf.Reset()
+	if _, err := s.Execute(ctx, "echo hi", workDir, broad, []string{workDir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(logBuf.String(), "too broad") {
+		t.Errorf("expected the warning to be logged once, got log: %q", logBuf.String())
+	}
+
+	logBuf.Reset()
+	if _, err := s.Execute(ctx, "echo hi", workDir, []string{workDir}, []string{workDir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(logBuf.String(), "too broad") {
+		t.Errorf("expected no warning for a narrow path, got log: %q", logBuf.String())
+	}
+
+	reject := true
+	s.UpdateConfig(&config.Config{RejectBroadPaths: &reject}, workDir)
+	_, err := s.Execute(ctx, "echo hi", workDir, []string{workDir}, broad)
+	if err == nil || !strings.Contains(err.Error(), "too broad") {
+		t.Fatalf("expected reject_broad_paths to reject /, got %v", err)
+	}
+	if err := s.ValidateCommand("echo hi", workDir, broad, []string{workDir}); err == nil {
+		t.Error("expected ValidateCommand to reject / with reject_broad_paths")
+	}
+	if _, err := s.Execute(ctx, "echo hi", workDir, []string{workDir}, []string{workDir}); err != nil {
+		t.Errorf("expected narrow paths to be allowed with reject_broad_paths, got %v", err)
+	}
+
+	s.UpdateConfig(&config.Config{RejectBroadPaths: &reject, DefaultReadablePaths: []string{"/etc"}}, workDir)
+	if _, err := s.Execute(ctx, "echo hi", workDir, []string{workDir}, []string{workDir}); err == nil {
+		t.Error("expected a broad default_readable_paths entry to be rejected")
+	}
+}
+
 func TestValidateCommand(t *testing.T) {
 	workDir := t.TempDir()
 	s := NewSandbox()
@@ -828,6 +1266,79 @@ func TestValidateCommand(t *testing.T) {
 	}
 }
 
+func TestValidateCommand_FollowsCd(t *testing.T) {
+	root := t.TempDir()
+	workDir := filepath.Join(root, "proj")
+	if err := os.MkdirAll(filepath.Join(workDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSandbox()
+	allowed := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"relative path after cd", "cd sub && cat f", false},
+		{"parent of cd target", "cd sub && cat ../f", false},
+		{"cd then escape", "cd sub && cat ../../secret", true},
+		{"cd with semicolon", "cd sub; cat ../f", false},
+		{"cd -P", "cd -P sub && cat ../f", false},
+		{"cd or exit", "cd sub || exit 1; cat ../f", false},
+		{"nested cd", "cd sub && cd .. && cat f", false},
+		{"cd outside allowed", "cd .. && cat proj/f", true},
+		{"cd in subshell does not persist", "(cd sub); cat ../f", true},
+		{"cd in pipeline does not persist", "cd sub | cat; cat ../f", true},
+		{"conditional cd defers relative paths", "if true; then cd sub; fi; cat ../f", false},
+		{"cd in both branches", "if true; then cd sub; else cd sub; fi; cat ../f", false},
+		{"cd in loop defers relative paths", "for d in a b; do cd sub; done; cat ../../secret", false},
+		{"redirect after cd", "cd sub && echo hi > ../out.txt", false},
+		{"redirect escape after cd", "cd sub && echo hi > ../../out.txt", true},
+		{"file test after cd", "cd sub && [[ -f ../f ]]", false},
+		{"dynamic cd defers relative paths", "cd \"$DIR\" && cat ../f", false},
+		{"dynamic cd still checks absolute paths", "cd \"$DIR\" && cat /etc/passwd", true},
+		{"cd in command substitution does not persist", "x=$(cd sub && cat ../f); cat ../f", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ValidateCommand(tt.command, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to pass, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestValidateCommand_ScriptAfterCd(t *testing.T) {
+	workDir := t.TempDir()
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{
+		LocalBinaryExecution: &config.LocalBinaryExecutionConfig{
+			Enabled: boolPtr(true),
+		},
+	}, "")
+	sub := filepath.Join(workDir, "sub")
+	os.MkdirAll(sub, 0o755)
+	os.WriteFile(filepath.Join(workDir, "run.sh"), []byte("#!/bin/bash\necho hello\n"), 0o755)
+	os.WriteFile(filepath.Join(sub, "run.sh"), []byte("#!/bin/bash\ncurl http://example.com\n"), 0o755)
+
+	err := s.ValidateCommand("cd sub && ./run.sh", workDir, []string{workDir}, []string{workDir})
+	if err == nil || !strings.Contains(err.Error(), "curl") {
+		t.Fatalf("expected sub/run.sh to be validated and fail on 'curl', got: %v", err)
+	}
+	if err := s.ValidateCommand("./run.sh", workDir, []string{workDir}, []string{workDir}); err != nil {
+		t.Fatalf("expected run.sh in workDir to pass, got: %v", err)
+	}
+	// The directory is unknown after a dynamic cd; the script is checked at runtime.
+	if err := s.ValidateCommand("cd \"$DIR\" && ./run.sh", workDir, []string{workDir}, []string{workDir}); err != nil {
+		t.Fatalf("expected script after dynamic cd to be deferred, got: %v", err)
+	}
+}
+
 func TestValidateCommand_ScriptWithBlockedCommand(t *testing.T) {
 	workDir := t.TempDir()
 	s := NewSandbox()
@@ -981,3 +1492,926 @@ func TestExecute_CompletesBeforeTimeout(t *testing.T) {
 		t.Fatalf("expected 'fast\\n', got %q", out)
 	}
 }
+
+// TestBashSandboxed_ManCatPager runs man through a stand-in that prints its
+// arguments, checking that the exec handler forces "-P cat" so MANPAGER is
+// never spawned, also when man is run by a wrapper.
+func TestBashSandboxed_ManCatPager(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "man"), []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := NewSandbox()
+	workDir := t.TempDir()
+	for _, command := range []string{"man ls", "timeout 5 man ls", "env man ls", "nice -n 5 man ls", "timeout 5 env man ls"} {
+		out, err := s.Execute(context.Background(), command, workDir, []string{workDir}, []string{workDir})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", command, err)
+		}
+		if out != "-P cat ls\n" {
+			t.Fatalf("%s: expected man to run with -P cat, got %q", command, out)
+		}
+	}
+	for _, command := range []string{"MANPAGER=less man ls", "env MANPAGER=evil man ls", "PAGER=evil man ls", "env PAGER=evil man ls"} {
+		_, err := s.Execute(context.Background(), command, workDir, []string{workDir}, []string{workDir})
+		if err == nil || !strings.Contains(err.Error(), "PAGER is not allowed") {
+			t.Fatalf("%s: expected setting the pager to be rejected, got %v", command, err)
+		}
+	}
+}
+
+func TestRuntimeHealth_MissingBinary(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	s := NewSandbox()
+	cfg := &config.Config{
+		Runtimes: &config.RuntimesConfig{
+			Go: &config.GoConfig{Enabled: boolPtr(true)},
+		},
+	}
+	s.UpdateConfig(cfg, t.TempDir())
+	defer s.Close()
+
+	health := s.RuntimeHealth()
+	status, ok := health["go"]
+	if !ok {
+		t.Fatalf("expected go runtime status, got %v", health)
+	}
+	if status.Healthy {
+		t.Fatal("expected go runtime to be unhealthy when go is not on PATH")
+	}
+	if !strings.Contains(status.Error, "not found on PATH") {
+		t.Fatalf("expected not found error, got %q", status.Error)
+	}
+	if _, ok := health["pnpm"]; ok {
+		t.Fatal("expected disabled pnpm runtime to be omitted")
+	}
+	if len(s.RuntimeReadPaths()) != 0 {
+		t.Fatalf("expected no runtime paths, got %v", s.RuntimeReadPaths())
+	}
+
+	delete(health, "go")
+	if _, ok := s.RuntimeHealth()["go"]; !ok {
+		t.Fatal("expected modifying the returned map not to change the sandbox's runtime health")
+	}
+}
+
+func TestRuntimeHealth_NoRuntimes(t *testing.T) {
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{}, t.TempDir())
+	defer s.Close()
+
+	if health := s.RuntimeHealth(); len(health) != 0 {
+		t.Fatalf("expected empty runtime health, got %v", health)
+	}
+}
+
+// validateCommandMultiPass is the reference implementation of
+// ValidateCommand that walks the AST once per check. It is used to verify
+// that the single-pass validator preserves the same error semantics.
+func (s *Sandbox) validateCommandMultiPass(command string, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	if s.loadState().isExtraCommandInvocation(command) {
+		return nil
+	}
+	f, err := ParseBash(command)
+	if err != nil {
+		return err
+	}
+	if err := s.validateWithWorkDir(f, workDir); err != nil {
+		return err
+	}
+	if err := validatePaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+		return err
+	}
+	if err := validateRedirectPaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+		return err
+	}
+	var validationErr error
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if validationErr != nil {
+			return false
+		}
+		ce, ok := node.(*syntax.CallExpr)
+		if !ok || len(ce.Args) == 0 {
+			return true
+		}
+		validationErr = s.loadState().validateScriptInvocation(ce, workDir, readAllowedPaths, writeAllowedPaths, nil, &scriptBudget{})
+		return validationErr == nil
+	})
+	return validationErr
+}
+
+func TestValidateCommand_SinglePassMatchesMultiPass(t *testing.T) {
+	workDir := t.TempDir()
+	readDir := t.TempDir()
+	readPaths := []string{workDir, readDir}
+	writePaths := []string{workDir}
+
+	if err := os.WriteFile(filepath.Join(workDir, "good.sh"), []byte("#!/bin/bash\necho ok\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "bad.sh"), []byte("#!/bin/bash\npython -c 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "lib.sh"), []byte("helper() { echo hi; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	corpus := []string{
+		"echo hello",
+		"ls -la | grep foo | wc -l",
+		"cat /etc/passwd",
+		"python script.py",
+		"cat /etc/passwd; python",
+		"python; cat /etc/passwd",
+		"echo hi > /etc/out",
+		"echo hi > /etc/out; cat /etc/passwd",
+		"cat /etc/passwd; echo hi > /etc/out",
+		"echo hi > out.txt",
+		"cat < " + readDir + "/in.txt",
+		"echo hi > " + readDir + "/out.txt",
+		"cp a.txt " + readDir + "/b.txt",
+		"myfunc() { echo hi; }; myfunc",
+		"myfunc; myfunc() { echo hi; }",
+		"undeclared; myfunc() { echo hi; }",
+		"myfunc; python; myfunc() { echo hi; }",
+		"source lib.sh; helper",
+		"helper; source lib.sh",
+		"find . -delete",
+		`find . -exec python {} \;`,
+		"PATH=/tmp ls",
+		"export LD_PRELOAD=x",
+		"$CMD arg",
+		"coproc cat",
+		"cat .git/config",
+		"./good.sh",
+		"./bad.sh",
+		"bash bad.sh",
+		"source bad.sh",
+		"./bad.sh; cat /etc/passwd",
+		"cat /etc/passwd; ./bad.sh",
+		"for f in *.go; do grep -n TODO \"$f\" > /etc/todo; done",
+		"if true; then python; else cat /etc/shadow; fi",
+		"sort -o /etc/x in.txt",
+		"echo $(python)",
+		"echo hi 2>&1 >&3",
+	}
+	s := newTestSandbox()
+	for _, command := range corpus {
+		t.Run(command, func(t *testing.T) {
+			want := s.validateCommandMultiPass(command, workDir, readPaths, writePaths)
+			got := s.ValidateCommand(command, workDir, readPaths, writePaths)
+			if (want == nil) != (got == nil) {
+				t.Fatalf("multi-pass returned %v, single-pass returned %v", want, got)
+			}
+			if want != nil && want.Error() != got.Error() {
+				t.Fatalf("error mismatch:\n multi-pass:  %q\n single-pass: %q", want.Error(), got.Error())
+			}
+		})
+	}
+}
+
+// largeScript builds a script of n blocks, each exercising commands,
+// redirects, paths, and function calls.
+func largeScript(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "fn%d() { grep -n pattern ./src/file%d.go | sort -u > out%d.txt; }\n", i, i, i)
+		fmt.Fprintf(&b, "if [ -f ./data/%d.txt ]; then cat ./data/%d.txt | wc -l; fi\n", i, i)
+		fmt.Fprintf(&b, "for f in ./dir%d/*.go; do head -n 5 \"$f\" 2>/dev/null; done\n", i)
+		fmt.Fprintf(&b, "fn%d\n", i)
+	}
+	return b.String()
+}
+
+func BenchmarkValidateLargeScript(b *testing.B) {
+	workDir := b.TempDir()
+	paths := []string{workDir}
+	script := largeScript(500)
+	s := newTestSandbox()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.ValidateCommand(script, workDir, paths, paths); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateLargeScriptMultiPass(b *testing.B) {
+	workDir := b.TempDir()
+	paths := []string{workDir}
+	script := largeScript(500)
+	s := newTestSandbox()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.validateCommandMultiPass(script, workDir, paths, paths); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnknownCommandPolicy(t *testing.T) {
+	var logBuf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logBuf, nil)))
+	defer slog.SetDefault(prev)
+
+	workDir := t.TempDir()
+	paths := []string{workDir}
+
+	newSandbox := func(cfg *config.Config) *Sandbox {
+		s := NewSandbox()
+		s.UpdateConfig(cfg, workDir)
+		return s
+	}
+
+	t.Run("warn allows unknown command with warning", func(t *testing.T) {
+		logBuf.Reset()
+		s := newSandbox(&config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn})
+		if err := s.ValidateCommand("somebinary --flag", workDir, paths, paths); err != nil {
+			t.Fatalf("expected unknown command to be allowed in warn mode, got: %v", err)
+		}
+		if !strings.Contains(logBuf.String(), "command=somebinary") {
+			t.Fatalf("expected warning for somebinary, got log: %q", logBuf.String())
+		}
+	})
+
+	t.Run("warn still applies argument validators", func(t *testing.T) {
+		s := newSandbox(&config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn})
+		err := s.ValidateCommand("find . -delete", workDir, paths, paths)
+		if err == nil || !strings.Contains(err.Error(), `find flag "-delete" is not allowed`) {
+			t.Fatalf("expected find -delete to be blocked, got: %v", err)
+		}
+	})
+
+	t.Run("block rejects unknown command", func(t *testing.T) {
+		s := newSandbox(&config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyBlock})
+		err := s.ValidateCommand("somebinary --flag", workDir, paths, paths)
+		if err == nil || !strings.Contains(err.Error(), `command "somebinary" is not allowed`) {
+			t.Fatalf("expected somebinary to be blocked, got: %v", err)
+		}
+	})
+
+	t.Run("default rejects unknown command", func(t *testing.T) {
+		s := newSandbox(&config.Config{})
+		if err := s.ValidateCommand("somebinary", workDir, paths, paths); err == nil {
+			t.Fatal("expected somebinary to be blocked by default")
+		}
+	})
+
+	t.Run("unrecognized policy rejects unknown command", func(t *testing.T) {
+		s := newSandbox(&config.Config{UnknownCommandPolicy: "allow"})
+		if err := s.ValidateCommand("somebinary", workDir, paths, paths); err == nil {
+			t.Fatal("expected somebinary to be blocked for unrecognized policy")
+		}
+	})
+
+	denied := []struct {
+		name    string
+		cfg     *config.Config
+		command string
+	}{
+		{"denied unknown command in warn mode", &config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn, DeniedCommands: []string{"curl"}}, "curl https://example.com"},
+		{"denied builtin allowlist command", &config.Config{DeniedCommands: []string{"ls"}}, "ls -la"},
+		{"denied extra command", &config.Config{ExtraCommands: []string{"curl"}, DeniedCommands: []string{"curl"}}, "curl https://example.com"},
+		{"denied command in pipeline", &config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn, DeniedCommands: []string{"curl"}}, "echo hi | curl -d @- https://example.com"},
+		{"denied command via find -exec", &config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn, DeniedCommands: []string{"rm"}}, `find . -exec rm {} \;`},
+	}
+	for _, tt := range denied {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSandbox(tt.cfg)
+			err := s.ValidateCommand(tt.command, workDir, paths, paths)
+			if err == nil || !strings.Contains(err.Error(), "is denied by denied_commands") {
+				t.Fatalf("expected denied error, got: %v", err)
+			}
+		})
+	}
+
+	t.Run("warn allows unknown command at runtime", func(t *testing.T) {
+		s := newSandbox(&config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn})
+		defer s.Close()
+		_, err := s.Execute(context.Background(), "true", workDir, paths, paths)
+		if err != nil {
+			t.Fatalf("expected allowed command to run, got: %v", err)
+		}
+		_, err = s.Execute(context.Background(), "nonexistent-binary-xyz", workDir, paths, paths)
+		if err != nil && strings.Contains(err.Error(), "is not allowed") {
+			t.Fatalf("expected unknown command to pass validation in warn mode, got: %v", err)
+		}
+	})
+}
+
+func TestPrivilegeEscalationCommandsDenied(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	all := []string{"sudo", "sudoedit", "su", "pkexec", "doas", "run0", "machinectl"}
+	configs := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{"default", nil},
+		{"extra_commands", &config.Config{ExtraCommands: all}},
+		{"restricted extra_commands", &config.Config{ExtraCommands: []string{"doas x", "machinectl shell"}}},
+		{"unknown_command_policy warn", &config.Config{UnknownCommandPolicy: config.UnknownCommandPolicyWarn}},
+	}
+	commands := []string{
+		"sudo x",
+		"sudoedit /etc/hosts",
+		"su -c x",
+		"pkexec x",
+		"doas x",
+		"run0 x",
+		"machinectl shell",
+		"echo hi | doas x",
+		"xargs pkexec x",
+		"timeout 5 run0 x",
+		"env FOO=1 doas x",
+		`find . -exec sudo x \;`,
+		`bash -c 'doas x'`,
+	}
+	for _, c := range configs {
+		for _, command := range commands {
+			t.Run(c.name+"/"+command, func(t *testing.T) {
+				s := NewSandbox()
+				if c.cfg != nil {
+					s.UpdateConfig(c.cfg, workDir)
+				}
+				defer s.Close()
+				if !strings.HasPrefix(command, "bash -c") {
+					err := s.ValidateCommand(command, workDir, paths, paths)
+					if err == nil || !strings.Contains(err.Error(), "is not allowed: it runs commands with elevated privileges") {
+						t.Fatalf("ValidateCommand: expected privilege escalation error, got %v", err)
+					}
+				}
+				_, err := s.Execute(context.Background(), command, workDir, paths, paths)
+				if err == nil || !strings.Contains(err.Error(), "elevated privileges") {
+					t.Fatalf("Execute: expected privilege escalation error, got %v", err)
+				}
+			})
+		}
+	}
+	for _, name := range all {
+		if allowedCommands[name] {
+			t.Errorf("%q is in allowedCommands", name)
+		}
+	}
+	if got := NewSandbox().WhyAllowed("doas"); got != "" {
+		t.Errorf("WhyAllowed(doas) = %q, want empty", got)
+	}
+}
+
+func TestValidationSuggestions(t *testing.T) {
+	workDir := t.TempDir()
+	readOnly := t.TempDir()
+	outside := t.TempDir()
+	readPaths := []string{workDir, readOnly}
+	writePaths := []string{workDir}
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"cp a.txt " + filepath.Join(outside, "x"), "add " + outside + " to readable_paths, or writable_paths if the command writes to it"},
+		{"cat < " + filepath.Join(outside, "missing", "f"), "add " + filepath.Join(outside, "missing") + " to readable_paths"},
+		{"cat " + outside, "add " + filepath.Dir(outside) + " to readable_paths"},
+		{"echo hi > " + filepath.Join(readOnly, "out.txt"), "add " + readOnly + " to writable_paths"},
+		{"gcc main.c", `add "gcc" to extra_commands`},
+		{"curl https://example.com", `"curl" accesses the network`},
+		{"echo hi | wget -qO- https://example.com", `"wget" accesses the network`},
+		{"echo https://example.com | xargs curl", `"curl" accesses the network`},
+	}
+	s := NewSandbox()
+	defer s.Close()
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			errs := map[string]error{"ValidateCommand": s.ValidateCommand(tt.command, workDir, readPaths, writePaths)}
+			_, errs["Execute"] = s.Execute(context.Background(), tt.command, workDir, readPaths, writePaths)
+			for name, err := range errs {
+				var vErr *ValidationError
+				if !errors.As(err, &vErr) {
+					t.Fatalf("%s: expected ValidationError, got %v", name, err)
+				}
+				if !strings.Contains(vErr.Suggestion, tt.want) {
+					t.Errorf("%s: expected suggestion containing %q, got %q", name, tt.want, vErr.Suggestion)
+				}
+				if !strings.Contains(err.Error(), "(suggestion: "+vErr.Suggestion+")") {
+					t.Errorf("%s: expected the suggestion in the error message, got %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationSuggestions_SystemDirs(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	s := NewSandbox()
+	defer s.Close()
+	for _, command := range []string{"cp a.txt /etc/x", "cat < /etc/passwd", "cat /usr/share/dict/words", "ls /"} {
+		err := s.ValidateCommand(command, workDir, paths, paths)
+		var vErr *ValidationError
+		if !errors.As(err, &vErr) {
+			t.Fatalf("%s: expected ValidationError, got %v", command, err)
+		}
+		if vErr.Suggestion != "" {
+			t.Errorf("%s: expected no suggestion for a system directory, got %q", command, vErr.Suggestion)
+		}
+	}
+}
+
+func TestExecute_SystemInfo(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("a\np\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSandbox()
+	ctx := context.Background()
+
+	for _, command := range []string{"nproc", "getconf ARG_MAX"} {
+		out, err := s.Execute(ctx, command, dir, paths, paths)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", command, err)
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(out)); err != nil || n <= 0 {
+			t.Errorf("%s: expected a positive number, got %q", command, out)
+		}
+	}
+
+	out, err := s.Execute(ctx, "nice -n 10 grep p f", dir, paths, paths)
+	if err != nil || out != "p\n" {
+		t.Errorf("expected nice to run grep, got %q and %v", out, err)
+	}
+	if _, err := s.Execute(ctx, "nice -n 10 cat /etc/passwd", dir, paths, paths); err == nil || !strings.Contains(err.Error(), "outside allowed directories") {
+		t.Errorf("expected the command run by nice to be path-validated, got %v", err)
+	}
+}
+
+func TestExecuteWithEnv(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+	ctx := context.Background()
+
+	out, err := s.ExecuteWithEnv(ctx, "echo $TASK_ID", dir, paths, paths, map[string]string{"TASK_ID": "task-1"})
+	if err != nil || out != "task-1\n" {
+		t.Errorf("expected TASK_ID to be visible, got %q and %v", out, err)
+	}
+	out, err = s.ExecuteWithEnv(ctx, "bash -c 'echo $TASK_ID'", dir, paths, paths, map[string]string{"TASK_ID": "task-2"})
+	if err != nil || out != "task-2\n" {
+		t.Errorf("expected TASK_ID to be visible in nested bash, got %q and %v", out, err)
+	}
+
+	for name, want := range map[string]string{
+		"PATH":       "setting PATH is not allowed",
+		"LD_PRELOAD": "setting LD_PRELOAD is not allowed",
+		"A=B":        `invalid environment variable name "A=B"`,
+		"":           `invalid environment variable name ""`,
+	} {
+		_, err := s.ExecuteWithEnv(ctx, "echo hi", dir, paths, paths, map[string]string{name: "/tmp"})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", name, want, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("task-%d", i)
+			out, err := s.ExecuteWithEnv(ctx, "echo $TASK_ID", dir, paths, paths, map[string]string{"TASK_ID": id})
+			if err != nil || out != id+"\n" {
+				errs <- fmt.Errorf("expected %q, got %q and %v", id, out, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			out, err := s.Execute(ctx, "echo \"[$TASK_ID]\"", dir, paths, paths)
+			if err != nil || out != "[]\n" {
+				errs <- fmt.Errorf("expected TASK_ID not to leak into Execute, got %q and %v", out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if _, ok := os.LookupEnv("TASK_ID"); ok {
+		t.Error("expected TASK_ID not to be set in the process environment")
+	}
+}
+
+func TestExecuteDetailed(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	tests := []struct {
+		name         string
+		command      string
+		wantOutput   string
+		wantEncoding string
+		wantErr      bool
+	}{
+		{"text", "echo hello", "hello\n", EncodingText, false},
+		{"utf-8", "printf 'h\xc3\xa9'", "hé", EncodingText, false},
+		{"invalid utf-8", `printf '\xff\xfe'`, base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe}), EncodingBase64, false},
+		{"invalid utf-8 on failure", `printf '\xff'; false`, base64.StdEncoding.EncodeToString([]byte{0xff}), EncodingBase64, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestSandbox().ExecuteDetailed(context.Background(), tt.command, workDir, paths, paths, ExecuteOptions{})
+			if (r.Err != nil) != tt.wantErr {
+				t.Fatalf("Err = %v, wantErr %v", r.Err, tt.wantErr)
+			}
+			if r.Output != tt.wantOutput || r.Encoding != tt.wantEncoding {
+				t.Fatalf("got (%q, %s), want (%q, %s)", r.Output, r.Encoding, tt.wantOutput, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestExecuteBatch(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	commands := []string{"echo a", "echo b", "rm x", "echo c"}
+
+	t.Run("stop on error", func(t *testing.T) {
+		s := newTestSandbox()
+		results, err := s.ExecuteBatch(context.Background(), commands, workDir, paths, paths, true)
+		if err == nil {
+			t.Fatal("expected batch error")
+		}
+		if len(results) != 3 {
+			t.Fatalf("expected 3 results, got %d: %+v", len(results), results)
+		}
+		if results[0].Output != "a\n" || results[1].Output != "b\n" {
+			t.Fatalf("unexpected outputs: %q, %q", results[0].Output, results[1].Output)
+		}
+		if results[2].Command != "rm x" || results[2].Err == nil {
+			t.Fatalf("expected rm x to fail, got %+v", results[2])
+		}
+		if !strings.Contains(err.Error(), `command 3 ("rm x")`) {
+			t.Fatalf("expected error to identify rm x, got %q", err.Error())
+		}
+	})
+
+	t.Run("continue on error", func(t *testing.T) {
+		s := newTestSandbox()
+		results, err := s.ExecuteBatch(context.Background(), commands, workDir, paths, paths, false)
+		if err == nil {
+			t.Fatal("expected batch error")
+		}
+		if len(results) != 4 {
+			t.Fatalf("expected 4 results, got %d: %+v", len(results), results)
+		}
+		if results[2].Err == nil {
+			t.Fatal("expected rm x to fail")
+		}
+		if results[3].Err != nil || results[3].Output != "c\n" {
+			t.Fatalf("expected echo c to succeed, got %+v", results[3])
+		}
+	})
+
+	t.Run("state does not persist", func(t *testing.T) {
+		if err := os.Mkdir(filepath.Join(workDir, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		s := newTestSandbox()
+		results, err := s.ExecuteBatch(context.Background(), []string{"FOO=bar; cd sub", "echo \"[$FOO]\"; pwd"}, workDir, paths, paths, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "[]\n" + workDir + "\n"
+		if results[1].Output != want {
+			t.Fatalf("expected %q, got %q", want, results[1].Output)
+		}
+	})
+
+	t.Run("validation failure", func(t *testing.T) {
+		s := newTestSandbox()
+		results, err := s.ExecuteBatch(context.Background(), []string{"python -c 1", "echo ok"}, workDir, paths, paths, true)
+		if err == nil || !strings.Contains(err.Error(), `command "python" is not allowed`) {
+			t.Fatalf("expected validation error, got %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		s := newTestSandbox()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results, err := s.ExecuteBatch(ctx, commands, workDir, paths, paths, false)
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no results, got %d", len(results))
+		}
+	})
+}
+
+// stubStartWorker replaces startWorker for the duration of the test. The
+// returned workers are never started, so tests must not Close them.
+func stubStartWorker(t *testing.T, fn func() (*os_sandbox.Worker, error)) *int {
+	t.Helper()
+	calls := 0
+	orig := startWorker
+	t.Cleanup(func() { startWorker = orig })
+	startWorker = func(ctx context.Context, workDir string, extraBinds, readOnlyBinds []string, blockAWSCredentials bool) (*os_sandbox.Worker, error) {
+		calls++
+		return fn()
+	}
+	return &calls
+}
+
+func newOSSandboxTestSandbox(t *testing.T) *Sandbox {
+	t.Helper()
+	s := NewSandbox()
+	enabled := true
+	s.UpdateConfig(&config.Config{OSSandbox: &enabled}, t.TempDir())
+	// Drop the stub worker so Close does not touch it.
+	t.Cleanup(func() { s.worker = nil })
+	return s
+}
+
+func TestWarmup_StartsWorkerOnce(t *testing.T) {
+	fake := &os_sandbox.Worker{}
+	calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return fake, nil })
+	s := newOSSandboxTestSandbox(t)
+
+	if err := s.Warmup(context.Background()); err != nil {
+		t.Fatalf("Warmup: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected Warmup to start one worker, got %d", *calls)
+	}
+
+	w, err := s.getOrCreateWorker(s.loadState())
+	if err != nil {
+		t.Fatalf("getOrCreateWorker: %v", err)
+	}
+	if w != fake {
+		t.Fatal("getOrCreateWorker did not return the pre-started worker")
+	}
+	if err := s.Warmup(context.Background()); err != nil {
+		t.Fatalf("second Warmup: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected no additional worker starts, got %d", *calls)
+	}
+}
+
+func TestWarmup_NoOpWithoutOSSandbox(t *testing.T) {
+	calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return &os_sandbox.Worker{}, nil })
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{}, t.TempDir())
+
+	if err := s.Warmup(context.Background()); err != nil {
+		t.Fatalf("Warmup: %v", err)
+	}
+	if *calls != 0 {
+		t.Fatalf("expected no worker start with OS sandbox disabled, got %d", *calls)
+	}
+}
+
+func TestWarmup_SurfacesStartupError(t *testing.T) {
+	calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return nil, fmt.Errorf("bwrap: not found") })
+	s := newOSSandboxTestSandbox(t)
+
+	err := s.Warmup(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "bwrap: not found") {
+		t.Fatalf("expected startup error, got %v", err)
+	}
+	if s.worker != nil {
+		t.Fatal("no worker should be stored after a failed start")
+	}
+	// A later Execute retries the start.
+	if _, err := s.getOrCreateWorker(s.loadState()); err == nil {
+		t.Fatal("expected retry to fail again")
+	}
+	if *calls != 2 {
+		t.Fatalf("expected a retry after a failed warmup, got %d starts", *calls)
+	}
+}
+
+func TestExecute_OSSandboxRequired(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name      string
+		osSandbox *bool
+		required  *bool
+		command   string
+		errMsg    string
+	}{
+		{"worker fails, not required", &enabled, nil, "echo hi", ""},
+		{"worker fails, required", &enabled, &enabled, "echo hi", "OS sandbox required but unavailable: failed to start worker: bwrap: not found"},
+		{"os sandbox disabled, required", nil, &enabled, "echo hi", "OS sandbox required but unavailable: os_sandbox is disabled"},
+		{"extra command, required", &enabled, &enabled, "true hi", "OS sandbox required but unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubStartWorker(t, func() (*os_sandbox.Worker, error) { return nil, fmt.Errorf("bwrap: not found") })
+			workDir := t.TempDir()
+			marker := filepath.Join(workDir, "ran")
+			s := NewSandbox()
+			s.UpdateConfig(&config.Config{
+				OSSandbox:         tt.osSandbox,
+				OSSandboxRequired: tt.required,
+				ExtraCommands:     []string{"true"},
+			}, workDir)
+
+			out, err := s.Execute(context.Background(), tt.command+" > ran", workDir, []string{workDir}, []string{workDir})
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected the degraded path to run, got %v", err)
+				}
+				if _, err := os.Stat(marker); err != nil {
+					t.Fatalf("expected the command to run: %v (output %q)", err, out)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if _, err := os.Stat(marker); !os.IsNotExist(err) {
+				t.Fatal("command ran even though the OS sandbox is required")
+			}
+		})
+	}
+}
+
+func TestWarmup_ContextDone(t *testing.T) {
+	release := make(chan struct{})
+	fake := &os_sandbox.Worker{}
+	stubStartWorker(t, func() (*os_sandbox.Worker, error) {
+		<-release
+		return fake, nil
+	})
+	s := newOSSandboxTestSandbox(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Warmup(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// Startup keeps going in the background and its worker is kept.
+	close(release)
+	w, err := s.getOrCreateWorker(s.loadState())
+	if err != nil {
+		t.Fatalf("getOrCreateWorker: %v", err)
+	}
+	if w != fake {
+		t.Fatal("expected the worker started in the background")
+	}
+}
+
+// TestValidate_UsesOneState checks that a command is validated against the
+// state it started with, including in the validators of commands run by
+// wrappers, after the config has changed.
+func TestValidate_UsesOneState(t *testing.T) {
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{}, t.TempDir())
+	old := s.loadState()
+	s.UpdateConfig(&config.Config{Runtimes: &config.RuntimesConfig{Go: &config.GoConfig{Enabled: boolPtr(true)}}}, t.TempDir())
+
+	for _, command := range []string{"go version", "echo | xargs go version", "timeout 5 go version"} {
+		f, err := ParseBash(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := old.validateWithWorkDir(f, ""); err == nil || !strings.Contains(err.Error(), "runtimes.go.enabled is disabled") {
+			t.Errorf("%s: expected the old config to reject go, got %v", command, err)
+		}
+	}
+}
+
+// TestGetOrCreateWorker_UsesCommandState checks that a worker is started
+// with the binds of the state a command was validated against, even if the
+// config changed since.
+func TestGetOrCreateWorker_UsesCommandState(t *testing.T) {
+	var gotBinds []string
+	orig := startWorker
+	t.Cleanup(func() { startWorker = orig })
+	startWorker = func(ctx context.Context, workDir string, extraBinds, readOnlyBinds []string, blockAWSCredentials bool) (*os_sandbox.Worker, error) {
+		gotBinds = extraBinds
+		return &os_sandbox.Worker{}, nil
+	}
+	s := newOSSandboxTestSandbox(t)
+	enabled := true
+	oldBind, newBind := t.TempDir(), t.TempDir()
+	s.UpdateConfig(&config.Config{OSSandbox: &enabled, OSSandboxExtraWritableBinds: []string{oldBind}}, t.TempDir())
+	old := s.loadState()
+	s.UpdateConfig(&config.Config{OSSandbox: &enabled, OSSandboxExtraWritableBinds: []string{newBind}}, t.TempDir())
+
+	if _, err := s.getOrCreateWorker(old); err != nil {
+		t.Fatalf("getOrCreateWorker: %v", err)
+	}
+	if !slices.Contains(gotBinds, oldBind) || slices.Contains(gotBinds, newBind) {
+		t.Fatalf("expected the worker to start with the command's binds %q, got %q", oldBind, gotBinds)
+	}
+}
+
+// TestBashSandboxed_ExecutionPath plants a grep in the working directory,
+// which is first on the sandbox process's PATH, and checks that with
+// execution_path set the real grep runs instead, including for nested bash
+// and for commands started by xargs.
+func TestBashSandboxed_ExecutionPath(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "grep"), []byte("#!/bin/sh\necho pwned\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "file.txt"), []byte("hello\nworld\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", workDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	paths := []string{workDir}
+
+	s := NewSandbox()
+	out, err := s.Execute(context.Background(), "grep hello file.txt", workDir, paths, paths)
+	if err != nil || out != "pwned\n" {
+		t.Fatalf("expected the planted grep to shadow the real one without execution_path, got %q and %v", out, err)
+	}
+
+	s.UpdateConfig(&config.Config{ExecutionPath: []string{"/usr/bin", "/bin"}}, workDir)
+	for _, command := range []string{
+		"grep hello file.txt",
+		"bash -c 'grep hello file.txt'",
+		"echo file.txt | xargs grep hello",
+	} {
+		out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", command, err)
+		}
+		if out != "hello\n" {
+			t.Errorf("%s: expected the real grep to run, got %q", command, out)
+		}
+	}
+
+	out, err = s.Execute(context.Background(), "printenv PATH", workDir, paths, paths)
+	if err != nil || out != "/usr/bin:/bin\n" {
+		t.Errorf("expected PATH to be the execution path, got %q and %v", out, err)
+	}
+	if _, err := s.Execute(context.Background(), "PATH=/tmp ls", workDir, paths, paths); err == nil || !strings.Contains(err.Error(), "setting PATH is not allowed") {
+		t.Errorf("expected PATH to stay blocked, got %v", err)
+	}
+}
+
+// TestBashSandboxed_MultiCallBinary tests that commands which are symlinks to
+// a busybox-style multi-call binary are allowed by the name they are invoked
+// by, and run with that name as argv[0], while invoking the multi-call
+// binary directly is checked as that binary.
+func TestBashSandboxed_MultiCallBinary(t *testing.T) {
+	workDir := t.TempDir()
+	binDir := t.TempDir()
+	busybox := filepath.Join(binDir, "busybox")
+	script := "#!/bin/sh\ncase \"${0##*/}\" in\nbusybox) echo \"busybox applet $1\" ;;\n*) echo \"applet ${0##*/}\" ;;\nesac\n"
+	if err := os.WriteFile(busybox, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, applet := range []string{"ls", "cat"} {
+		if err := os.Symlink("busybox", filepath.Join(binDir, applet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{workDir}
+	executionPath := []string{binDir, "/usr/bin", "/bin"}
+
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{ExecutionPath: executionPath}, workDir)
+	for command, want := range map[string]string{
+		"ls":              "applet ls\n",
+		"cat":             "applet cat\n",
+		"echo | xargs ls": "applet ls\n",
+	} {
+		out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", command, err)
+		}
+		if out != want {
+			t.Errorf("%s: expected %q, got %q", command, want, out)
+		}
+	}
+
+	for _, command := range []string{"busybox sh", "busybox ls", "echo | xargs busybox sh"} {
+		_, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		if err == nil || !strings.Contains(err.Error(), `command "busybox" is not allowed`) {
+			t.Errorf("%s: expected busybox to be blocked, got %v", command, err)
+		}
+	}
+
+	// Allowing one applet of the multi-call binary does not allow the others.
+	s.UpdateConfig(&config.Config{ExecutionPath: executionPath, ExtraCommands: []string{"busybox ls"}}, workDir)
+	out, err := s.Execute(context.Background(), "busybox ls", workDir, paths, paths)
+	if err != nil || out != "busybox applet ls\n" {
+		t.Errorf("expected busybox ls to be allowed, got %q and %v", out, err)
+	}
+	if _, err := s.Execute(context.Background(), "busybox sh", workDir, paths, paths); err == nil {
+		t.Error("expected busybox sh to be blocked with only busybox ls allowed")
+	}
+}
@@ -0,0 +1,79 @@
+package bash_sandboxed
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// resolveAbsoluteCommands rewrites commands invoked by absolute path, such
+// as /bin/cat, to their name when the name is allowed, the path is in the
+// read-allowed paths, and it is the same file the name resolves to on the
+// execution path. The rest of validation, and the interpreter, then treat
+// /bin/cat exactly like cat. Other absolute paths are left as they are and
+// are rejected by validateNode, unless local binary execution allows them.
+// It must run before the AST is validated.
+func (st *sandboxState) resolveAbsoluteCommands(f *syntax.File, workDir string, readAllowedPaths []string) {
+	lists := st.commandLists(workDir)
+	env := expand.ListEnviron("PATH=" + st.pathEnv())
+	r := newPathResolver()
+	syntax.Walk(f, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		path := call.Args[0].Lit()
+		name := absoluteCommandName(path, call.Args, lists, workDir)
+		if name == "" || !r.isUnderAllowedPaths(r.resolve(path, "/"), readAllowedPaths) {
+			return true
+		}
+		found, err := interp.LookPathDir(workDir, env, name)
+		if err != nil || !sameFile(path, found) {
+			return true
+		}
+		call.Args[0] = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: name}}}
+		return true
+	})
+}
+
+// sameFile reports whether a and b both exist and are the same file.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+// absoluteCommandName returns the name of the allowed command that cmdName,
+// an absolute path, would run, or "" if cmdName is not an absolute path to
+// an allowed command or is allowed as it is (by extra_commands or local
+// binary execution).
+func absoluteCommandName(cmdName string, args []*syntax.Word, lists commandLists, workDir string) string {
+	if !filepath.IsAbs(cmdName) {
+		return ""
+	}
+	notDeclared := func(string) bool { return false }
+	if allowSource(cmdName, args, lists, notDeclared, workDir) != "" {
+		return ""
+	}
+	name := filepath.Base(cmdName)
+	if lists.denied[name] || allowSource(name, args, lists, notDeclared, workDir) == "" {
+		return ""
+	}
+	return name
+}
+
+// absoluteCommandError returns the error for an allowed command invoked by
+// an absolute path that resolveAbsoluteCommands did not rewrite.
+func absoluteCommandError(cmdName, name string) error {
+	return &ValidationError{
+		Err:        fmt.Errorf("command %q is not allowed: %q is only allowed by path from a readable directory on the execution path", cmdName, name),
+		Suggestion: fmt.Sprintf("run it by name as %q", name),
+	}
+}
@@ -0,0 +1,94 @@
+package bash_sandboxed
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate_AbsoluteCommandPath(t *testing.T) {
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{"/bin/cat file", `command "/bin/cat" is not allowed: "cat" is only allowed by path from a readable directory on the execution path (suggestion: run it by name as "cat")`},
+		{"/usr/bin/rm file", `(suggestion: run it by name as "rm")`},
+		{"echo $(/usr/bin/ls)", `(suggestion: run it by name as "ls")`},
+		{"/usr/bin/python3 script.py", `command "/usr/bin/python3" is not allowed (suggestion: add "/usr/bin/python3" to extra_commands)`},
+		{"/usr/bin/sudo ls", `command "/usr/bin/sudo" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestExecute_AbsoluteCommandPath(t *testing.T) {
+	catPath, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat not found on PATH")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A same-named binary outside the execution path must not run as cat.
+	fakeDir := t.TempDir()
+	fakeCat := filepath.Join(fakeDir, "cat")
+	if err := os.WriteFile(fakeCat, []byte("#!/bin/sh\necho fake\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{dir}
+	binPaths := []string{dir, filepath.Dir(catPath), fakeDir}
+	ctx := context.Background()
+	s := NewSandbox()
+
+	out, err := s.Execute(ctx, "cat file", dir, paths, paths)
+	if err != nil || out != "hello\n" {
+		t.Errorf("expected cat to run by name, got %q and %v", out, err)
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		readPaths []string
+		wantErr   string
+	}{
+		{"directory not readable", catPath + " file", paths, `run it by name as "cat"`},
+		{"directory readable", catPath + " file", binPaths, ""},
+		{"validated like cat", catPath + " /etc/passwd", binPaths, "outside allowed directories"},
+		{"not on the execution path", fakeCat + " file", binPaths, `run it by name as "cat"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := s.Execute(ctx, tt.command, dir, tt.readPaths, paths)
+			verr := s.ValidateCommand(tt.command, dir, tt.readPaths, paths)
+			if tt.wantErr == "" {
+				if err != nil || out != "hello\n" {
+					t.Errorf("expected the command to run, got %q and %v", out, err)
+				}
+				if verr != nil {
+					t.Errorf("expected ValidateCommand to allow the command, got %v", verr)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q and %v", tt.wantErr, out, err)
+			}
+			if verr == nil || !strings.Contains(verr.Error(), tt.wantErr) {
+				t.Errorf("expected ValidateCommand error containing %q, got %v", tt.wantErr, verr)
+			}
+		})
+	}
+}
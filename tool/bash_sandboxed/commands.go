@@ -2,7 +2,9 @@ package bash_sandboxed
 
 import (
 	"fmt"
+	"slices"
 
+	"github.com/gartnera/lite-sandbox/config"
 	"mvdan.cc/sh/v3/syntax"
 )
 
@@ -30,6 +32,9 @@ var allowedCommands = map[string]bool{
 	"less":     true,
 	"more":     true,
 	"wc":       true,
+	// Formatters: files are read only as positional operands, which
+	// validatePaths checks. None has an option that writes a file or names
+	// another input path, so no argument validators are needed.
 	"column":   true,
 	"fold":     true,
 	"paste":    true,
@@ -51,6 +56,7 @@ var allowedCommands = map[string]bool{
 	"fgrep":   true,
 	"rg":      true,
 	"find":    true,
+	"tree":    true,
 	"locate":  true,
 	"which":   true,
 	"whereis": true,
@@ -136,10 +142,15 @@ var allowedCommands = map[string]bool{
 	"printenv": true,
 	"date":     true,
 	"cal":      true,
-
-	// Math / calculation (pure computation)
+	"nproc":    true,
+	"getconf":  true,
+	"arch":     true,
+	"lscpu":    true,
+	"free":     true,
+
+	// Math / calculation (pure computation). dc is excluded: its ! command
+	// runs a shell command.
 	"bc":      true,
-	"dc":      true,
 	"seq":     true,
 	"factor":  true,
 	"numfmt":  true,
@@ -175,13 +186,14 @@ var allowedCommands = map[string]bool{
 	"aws": true,
 
 	// Scoped write commands (path-validated to stay within allowedPaths)
-	"cp":    true,
-	"mv":    true,
-	"rm":    true,
-	"touch": true,
-	"chmod": true,
-	"ln":    true,
-	"sed":   true,
+	"cp":      true,
+	"mv":      true,
+	"rm":      true,
+	"touch":   true,
+	"chmod":   true,
+	"ln":      true,
+	"sed":     true,
+	"install": true,
 
 	// Control flow / job control
 	"sleep":    true,
@@ -192,6 +204,8 @@ var allowedCommands = map[string]bool{
 	"break":    true,
 	"continue": true,
 	"timeout":  true,
+	"nice":     true,
+	"ionice":   true,
 	"time":     true,
 	"yes":      true,
 
@@ -208,93 +222,283 @@ var allowedCommands = map[string]bool{
 	"xargs": true,
 }
 
+// privilegeEscalationCommands run commands as another user, usually root.
+// They are always denied, like denied_commands entries, so that neither
+// extra_commands nor unknown_command_policy "warn" can allow them.
+var privilegeEscalationCommands = map[string]bool{
+	"sudo":       true,
+	"sudoedit":   true,
+	"su":         true,
+	"pkexec":     true,
+	"doas":       true,
+	"run0":       true,
+	"machinectl": true,
+}
+
+// networkCommands are networking commands that are not allowed by default.
+// They are excluded because they can reach any host, so the suggestion for
+// them warns rather than recommending extra_commands outright.
+var networkCommands = map[string]bool{
+	"curl":   true,
+	"wget":   true,
+	"nc":     true,
+	"ncat":   true,
+	"netcat": true,
+	"socat":  true,
+	"telnet": true,
+	"ftp":    true,
+	"ssh":    true,
+	"scp":    true,
+	"sftp":   true,
+	"rsync":  true,
+	"ping":   true,
+	"nmap":   true,
+}
+
 // writeCommands is the set of commands that perform write operations.
 // Path arguments to these commands are validated against writeAllowedPaths
 // rather than readAllowedPaths. This matches the "Scoped write commands"
 // category in allowedCommands, plus mkdir.
 var writeCommands = map[string]bool{
-	"cp":    true,
-	"mv":    true,
-	"rm":    true,
-	"touch": true,
-	"chmod": true,
-	"ln":    true,
-	"sed":   true,
-	"mkdir": true,
+	"cp":      true,
+	"mv":      true,
+	"rm":      true,
+	"touch":   true,
+	"chmod":   true,
+	"ln":      true,
+	"sed":     true,
+	"install": true,
+	"mkdir":   true,
+}
+
+// conditionalWriteCommands maps commands that only write files for certain
+// argument combinations (e.g., sort -o FILE) to a detector that reports whether
+// a given invocation writes. Matching invocations are path-validated against
+// writeAllowedPaths like the commands in writeCommands.
+var conditionalWriteCommands = map[string]func(args []string) bool{
+	"sort": sortWritesOutput,
+	"uniq": uniqWritesOutput,
+}
+
+// isWriteInvocation reports whether the command invocation args (args[0] is the
+// command name) should be path-validated against writeAllowedPaths.
+func isWriteInvocation(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if wrapped := wrappedCommand(args); wrapped != nil {
+		return isWriteInvocation(wrapped)
+	}
+	if writeCommands[args[0]] {
+		return true
+	}
+	if detect, ok := conditionalWriteCommands[args[0]]; ok {
+		return detect(args)
+	}
+	return false
 }
 
 // commandArgValidators is a registry of per-command argument validation functions.
 // Commands with dangerous flags (e.g., find -exec, find -delete) register a
 // validator here to block those flags while still allowing the command itself.
-// Validators receive the *Sandbox so they can access config (e.g., runtimes, git).
-var commandArgValidators = map[string]func(s *Sandbox, args []*syntax.Word) error{
-	"awk":    validateAwkArgs,
-	"bash":   validateBashCommand,
-	"sh":     validateBashCommand,
-	"source": validateSourceCommand,
-	".":      validateSourceCommand,
-	"rg":    validateRgArgs,
-	"find":  validateFindArgs,
-	"tar":   validateTarArgs,
-	"unzip": validateUnzipArgs,
-	"ar":    validateArArgs,
-	"rm":    validateRmArgs,
-	"sed":   validateSedArgs,
-	"git":   validateGitCommand,
-	"go":    validateGoCommand,
-	"pnpm":  validatePnpmCommand,
-	"cargo": validateCargoCommand,
-	"rustc": validateRustcCommand,
-	"aws":   validateAWSCommand,
-	"xargs": validateXargsArgs,
+// Validators receive the sandbox state the command is checked against, so
+// they can access config (e.g., runtimes, git), and the directory the command
+// runs in ("" if not known statically).
+var commandArgValidators = map[string]func(st *sandboxState, args []*syntax.Word, workDir string) error{
+	"awk":       validateAwkArgs,
+	"bash":      validateBashCommand,
+	"sh":        validateBashCommand,
+	"source":    validateSourceCommand,
+	".":         validateSourceCommand,
+	"rg":        validateRgArgs,
+	"find":      validateFindArgs,
+	"tree":      validateTreeArgs,
+	"ls":        validateLsArgs,
+	"du":        validateDuArgs,
+	"locate":    validateLocateArgs,
+	"which":     validateCommandLookupArgs,
+	"whereis":   validateCommandLookupArgs,
+	"type":      validateCommandLookupArgs,
+	"command":   validateCommandLookupArgs,
+	"head":      validateHeadArgs,
+	"tail":      validateTailArgs,
+	"tar":       validateTarArgs,
+	"unzip":     validateUnzipArgs,
+	"ar":        validateArArgs,
+	"rm":        validateRmArgs,
+	"sed":       validateSedArgs,
+	"sort":      validateSortArgs,
+	"git":       validateGitCommand,
+	"go":        validateGoCommand,
+	"pnpm":      validatePnpmCommand,
+	"cargo":     validateCargoCommand,
+	"rustc":     validateRustcCommand,
+	"aws":       validateAWSCommand,
+	"xargs":     validateXargsArgs,
+	"timeout":   validateTimeoutArgs,
+	"nice":      validateNiceArgs,
+	"ionice":    validateIoniceArgs,
+	"env":       validateEnvArgs,
+	"man":       validateManArgs,
+	"apropos":   validateManArgs,
+	"info":      validateInfoArgs,
+	"seq":       validateSeqArgs,
+	"install":   validateInstallArgs,
+	"md5sum":    validateChecksumArgs,
+	"sha1sum":   validateChecksumArgs,
+	"sha256sum": validateChecksumArgs,
+	"shasum":    validateChecksumArgs,
+	"cksum":     validateChecksumArgs,
+	"b2sum":     validateChecksumArgs,
+}
+
+// argValidators refers to commandArgValidators so that validateSubCommand
+// can look up per-command validators without creating a package-level
+// initialization cycle.
+var argValidators map[string]func(st *sandboxState, args []*syntax.Word, workDir string) error
+
+func init() {
+	argValidators = commandArgValidators
+}
+
+// validateExecArgs re-runs the argument validator of a command about to run
+// with its expanded arguments and the interpreter's real working directory.
+// Static validation skips words that are not literal, so options supplied
+// through variables or command substitution (e.g., f=-z; rg $f) and the
+// commands wrappers run with them are only checked here, and per_path git
+// overrides may be stricter for the directory the command really runs in.
+// bash, sh and awk run in-process and validate what they run themselves.
+// extra_commands are not checked.
+func (st *sandboxState) validateExecArgs(args []string, dir string) error {
+	switch args[0] {
+	case "bash", "sh", "awk":
+		return nil
+	}
+	validator, ok := argValidators[args[0]]
+	if !ok || st.extraCommands[args[0]] {
+		return nil
+	}
+	words := make([]*syntax.Word, len(args))
+	for i, a := range args {
+		words[i] = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: a}}}
+	}
+	return validator(st, words, dir)
+}
+
+// execArgRewriters maps commands to functions that rewrite their expanded
+// arguments in the exec handler, just before they run. Rewriters add flags
+// that make the command safe to run (e.g., disabling lifecycle scripts or an
+// external pager) rather than rejecting it. They are not applied to
+// extra_commands entries.
+var execArgRewriters = map[string]func(args []string) []string{
+	"pnpm": pnpmIgnoreScripts,
+	"man":  manCatPager,
+	"rg":   rgExcludeGit,
+}
+
+// rewriteExecArgs applies execArgRewriters to the expanded arguments of a
+// command about to run, and to the command run by a timeout, env, nice or
+// ionice wrapper in them, which the exec handler never sees on its own.
+// Commands in extra, the extra_commands, are not rewritten or unwrapped.
+// The utility run by xargs is rewritten by rewriteXargsArgs.
+func rewriteExecArgs(args []string, extra map[string]bool) []string {
+	if len(args) == 0 || extra[args[0]] {
+		return args
+	}
+	if args[0] == "xargs" {
+		return rewriteXargsArgs(args, extra)
+	}
+	if wrapped := wrappedCommand(args); len(wrapped) > 0 {
+		prefix := args[:len(args)-len(wrapped)]
+		return append(slices.Clip(prefix), rewriteExecArgs(wrapped, extra)...)
+	}
+	if rewrite, ok := execArgRewriters[args[0]]; ok {
+		return rewrite(args)
+	}
+	return args
+}
+
+// rewriteXargsArgs applies execArgRewriters to the utility run by xargs and,
+// unless xargs is in replace mode, appends "--" to it when xargsOptionsEnd,
+// so that items read from standard input, which the sandbox never sees, are
+// passed as operands rather than options. validateXargsArgs rejects the
+// utilities "--" cannot be appended to.
+func rewriteXargsArgs(args []string, extra map[string]bool) []string {
+	i := xargsCommandIndex(args)
+	if i == len(args) {
+		return args
+	}
+	utility := rewriteExecArgs(args[i:], extra)
+	rewritten := append(slices.Clip(args[:i]), utility...)
+	if xargsReplaceString(args[:i]) != "" {
+		return rewritten
+	}
+	if end, err := xargsOptionsEnd(utility, extra); err != nil || !end {
+		return rewritten
+	}
+	return append(rewritten, "--")
+}
+
+// runtimeCommands maps the allowlisted commands that are gated by a config
+// section to the allow source reported for them and whether that section
+// enables them. Their validators in commandArgValidators enforce the gate.
+var runtimeCommands = map[string]struct {
+	source  string
+	enabled func(cfg *config.Config) bool
+}{
+	"go":    {"runtimes.go", func(cfg *config.Config) bool { return cfg.Runtimes != nil && cfg.Runtimes.Go.GoEnabled() }},
+	"pnpm":  {"runtimes.pnpm", func(cfg *config.Config) bool { return cfg.Runtimes != nil && cfg.Runtimes.Pnpm.PnpmEnabled() }},
+	"cargo": {"runtimes.rust", func(cfg *config.Config) bool { return cfg.Runtimes != nil && cfg.Runtimes.Rust.RustEnabled() }},
+	"rustc": {"runtimes.rust", func(cfg *config.Config) bool { return cfg.Runtimes != nil && cfg.Runtimes.Rust.RustEnabled() }},
+	"aws":   {"aws", func(cfg *config.Config) bool { return cfg.AWS.AWSEnabled() }},
 }
 
-func validateGitCommand(s *Sandbox, args []*syntax.Word) error {
-	return validateGitArgs(args, s.getConfig().Git)
+func validateGitCommand(st *sandboxState, args []*syntax.Word, workDir string) error {
+	return validateGitArgs(args, st.cfg.Git, workDir)
 }
 
-func validateGoCommand(s *Sandbox, args []*syntax.Word) error {
-	cfg := s.getConfig()
+func validateGoCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	cfg := st.cfg
 	if cfg.Runtimes == nil || cfg.Runtimes.Go == nil || !cfg.Runtimes.Go.GoEnabled() {
 		return fmt.Errorf("command \"go\" is not allowed (runtimes.go.enabled is disabled)")
 	}
 	return validateGoArgs(args, cfg.Runtimes.Go)
 }
 
-func validatePnpmCommand(s *Sandbox, args []*syntax.Word) error {
-	cfg := s.getConfig()
+func validatePnpmCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	cfg := st.cfg
 	if cfg.Runtimes == nil || cfg.Runtimes.Pnpm == nil || !cfg.Runtimes.Pnpm.PnpmEnabled() {
 		return fmt.Errorf("command \"pnpm\" is not allowed (runtimes.pnpm.enabled is disabled)")
 	}
 	return validatePnpmArgs(args, cfg.Runtimes.Pnpm)
 }
 
-func validateBashCommand(s *Sandbox, args []*syntax.Word) error {
-	return validateBashArgs(s, args)
+func validateBashCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	return validateBashArgs(st, args)
 }
 
-func validateSourceCommand(s *Sandbox, args []*syntax.Word) error {
-	return validateSourceArgs(s, args)
+func validateSourceCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	return validateSourceArgs(st, args)
 }
 
-func validateCargoCommand(s *Sandbox, args []*syntax.Word) error {
-	cfg := s.getConfig()
+func validateCargoCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	cfg := st.cfg
 	if cfg.Runtimes == nil || cfg.Runtimes.Rust == nil || !cfg.Runtimes.Rust.RustEnabled() {
 		return fmt.Errorf("command \"cargo\" is not allowed (runtimes.rust.enabled is disabled)")
 	}
 	return validateCargoArgs(args, cfg.Runtimes.Rust)
 }
 
-func validateRustcCommand(s *Sandbox, args []*syntax.Word) error {
-	cfg := s.getConfig()
+func validateRustcCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	cfg := st.cfg
 	if cfg.Runtimes == nil || cfg.Runtimes.Rust == nil || !cfg.Runtimes.Rust.RustEnabled() {
 		return fmt.Errorf("command \"rustc\" is not allowed (runtimes.rust.enabled is disabled)")
 	}
 	return nil
 }
 
-func validateAWSCommand(s *Sandbox, args []*syntax.Word) error {
-	cfg := s.getConfig()
+func validateAWSCommand(st *sandboxState, args []*syntax.Word, _ string) error {
+	cfg := st.cfg
 	if cfg.AWS == nil || !cfg.AWS.AWSEnabled() {
 		return fmt.Errorf("command \"aws\" is not allowed (aws.enabled is disabled)")
 	}
@@ -2,12 +2,14 @@ package bash_sandboxed
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gartnera/lite-sandbox/config"
 )
@@ -113,6 +115,263 @@ func TestOSSandboxWorkerPool(t *testing.T) {
 	}
 }
 
+// passthroughBwrap puts a bwrap stand-in on PATH when bwrap is not installed
+// on Linux. It runs the worker without any isolation, so tests of how the
+// interpreter and the worker exchange stdin and stdout still run; tests of
+// the isolation itself must not use it.
+func passthroughBwrap(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if _, err := exec.LookPath("bwrap"); err == nil {
+		return
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\n# Drop the bwrap options up to \"--\" and run the worker.\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "bwrap"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write bwrap stand-in: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestOSSandboxNestedBashStdin tests that stdin piped into a nested bash
+// reaches the commands it runs in the worker.
+func TestOSSandboxNestedBashStdin(t *testing.T) {
+	passthroughBwrap(t)
+	tmpDir := t.TempDir()
+
+	s := NewSandbox()
+
+	enabled := true
+	cfg := &config.Config{
+		OSSandbox: &enabled,
+	}
+	s.UpdateConfig(cfg, tmpDir)
+	defer s.Close()
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{`echo hello | bash -c 'cat'`, "hello\n"},
+		{`seq 5 | bash -c 'wc -l'`, "5\n"},
+	}
+	for _, tt := range tests {
+		output, err := s.Execute(context.Background(), tt.command, tmpDir, []string{tmpDir}, []string{tmpDir})
+		if err != nil {
+			t.Fatalf("%s: Execute failed: %v", tt.command, err)
+		}
+		if strings.TrimSpace(output) != strings.TrimSpace(tt.want) {
+			t.Errorf("%s: unexpected output: got %q, want %q", tt.command, output, tt.want)
+		}
+	}
+}
+
+// TestOSSandboxPipelines tests that pipelines mixing interpreter builtins and
+// commands run in the worker pass data between their stages.
+func TestOSSandboxPipelines(t *testing.T) {
+	passthroughBwrap(t)
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "file"), []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	s := NewSandbox()
+
+	enabled := true
+	cfg := &config.Config{
+		OSSandbox: &enabled,
+	}
+	s.UpdateConfig(cfg, tmpDir)
+	defer s.Close()
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{`cat file | wc -l`, "3"},
+		{`echo a | grep a | wc -c`, "2"},
+		{`cat file | head -n 2 | tail -n 1`, "b"},
+		{`printf 'x\ny\n' | sort -r | head -n 1`, "y"},
+		{`cat file | while read l; do echo "<$l>"; done | tail -n 1`, "<c>"},
+		// head exits before seq finishes writing.
+		{`seq 100000 | head -n 1`, "1"},
+		{`seq 100000 | wc -l`, "100000"},
+	}
+	for _, tt := range tests {
+		output, err := s.Execute(context.Background(), tt.command, tmpDir, []string{tmpDir}, []string{tmpDir})
+		if err != nil {
+			t.Fatalf("%s: Execute failed: %v", tt.command, err)
+		}
+		if strings.TrimSpace(output) != tt.want {
+			t.Errorf("%s: unexpected output: got %q, want %q", tt.command, output, tt.want)
+		}
+	}
+}
+
+// TestOSSandboxYes tests that yes run by the worker is stopped by the output
+// limit and the timeout, and can be bounded by head.
+func TestOSSandboxYes(t *testing.T) {
+	passthroughBwrap(t)
+	tmpDir := t.TempDir()
+	paths := []string{tmpDir}
+
+	s := NewSandbox()
+	enabled := true
+	s.UpdateConfig(&config.Config{OSSandbox: &enabled, MaxOutputBytes: 1000}, tmpDir)
+	defer s.Close()
+
+	output, err := s.Execute(context.Background(), "yes hello | head -3", tmpDir, paths, paths)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if output != "hello\nhello\nhello\n" {
+		t.Errorf("expected exactly three lines, got %q", output)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = s.Execute(ctx, "yes", tmpDir, paths, paths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) || !strings.Contains(err.Error(), "max_output_bytes") {
+		t.Fatalf("expected an output limit error, got %v", err)
+	}
+	if len(cmdErr.Output) != 1000 || ctx.Err() != nil {
+		t.Errorf("expected yes to stop at 1000 bytes before the timeout, got %d bytes", len(cmdErr.Output))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = s.Execute(ctx, "yes > /dev/null", tmpDir, paths, paths)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected yes > /dev/null to be stopped by the timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected yes > /dev/null to stop at the timeout, took %v", elapsed)
+	}
+}
+
+// TestOSSandboxTimeoutPartialOutput tests that output streamed from the
+// worker before a command times out is returned, including when the command
+// run by the worker started a process that outlives it.
+func TestOSSandboxTimeoutPartialOutput(t *testing.T) {
+	passthroughBwrap(t)
+	tmpDir := t.TempDir()
+	paths := []string{tmpDir}
+
+	s := NewSandbox()
+	enabled := true
+	s.UpdateConfig(&config.Config{OSSandbox: &enabled}, tmpDir)
+	defer s.Close()
+
+	for _, command := range []string{
+		"echo start; sleep 10; echo end",
+		"(echo start; sleep 10; echo end) | cat",
+		"timeout 30 sh -c 'echo start; sleep 10; echo end'",
+	} {
+		t.Run(command, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			start := time.Now()
+			output, err := s.Execute(ctx, command, tmpDir, paths, paths)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected the command to be stopped by the timeout, got %v", err)
+			}
+			if output != "start\n" {
+				t.Errorf("expected the output written before the timeout, got %q", output)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the command to stop at the timeout, took %v", elapsed)
+			}
+		})
+	}
+}
+
+// TestOSSandboxExtraBinds tests that os_sandbox_extra_writable_binds and
+// os_sandbox_extra_readable_binds expose directories the OS sandbox would
+// otherwise hide, while directories that are not bound stay inaccessible even
+// though the sandbox's own path validation allows them.
+func TestOSSandboxExtraBinds(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only bwrap hides unbound directories under /tmp")
+	}
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+	workDir := t.TempDir()
+	writable := t.TempDir()
+	readable := t.TempDir()
+	unbound := t.TempDir()
+	if err := os.WriteFile(filepath.Join(readable, "file"), []byte("shared\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	paths := []string{workDir, writable, readable, unbound}
+
+	s := NewSandbox()
+	enabled := true
+	s.UpdateConfig(&config.Config{
+		OSSandbox:                   &enabled,
+		OSSandboxExtraWritableBinds: []string{writable},
+		OSSandboxExtraReadableBinds: []string{readable},
+	}, workDir)
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Execute(ctx, "touch "+filepath.Join(writable, "ok"), workDir, paths, paths); err != nil {
+		t.Fatalf("expected write to the extra writable bind to succeed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(writable, "ok")); err != nil {
+		t.Errorf("expected the file to be created on the host: %v", err)
+	}
+
+	output, err := s.Execute(ctx, "cat "+filepath.Join(readable, "file"), workDir, paths, paths)
+	if err != nil || output != "shared\n" {
+		t.Errorf("expected to read the extra readable bind, got %q, %v", output, err)
+	}
+	if _, err := s.Execute(ctx, "touch "+filepath.Join(readable, "denied"), workDir, paths, paths); err == nil {
+		t.Error("expected write to the extra readable bind to fail")
+	}
+
+	if _, err := s.Execute(ctx, "touch "+filepath.Join(unbound, "denied"), workDir, paths, paths); err == nil {
+		t.Error("expected write to an unbound directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(unbound, "denied")); err == nil {
+		t.Error("expected no file to be created in the unbound directory")
+	}
+}
+
+// TestOSSandboxPIDNamespace tests that on Linux the worker runs in its own
+// PID namespace, so ps does not list host processes.
+func TestOSSandboxPIDNamespace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("PID namespaces are Linux-only")
+	}
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+	tmpDir := t.TempDir()
+
+	s := NewSandbox()
+
+	enabled := true
+	cfg := &config.Config{
+		OSSandbox: &enabled,
+	}
+	s.UpdateConfig(cfg, tmpDir)
+	defer s.Close()
+
+	output, err := s.Execute(context.Background(), "ps -e -o pid=", tmpDir, []string{tmpDir}, []string{tmpDir})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	// bwrap, the worker and ps itself.
+	if n := len(strings.Fields(output)); n == 0 || n > 5 {
+		t.Errorf("expected only the sandbox's own processes, got %d:\n%s", n, output)
+	}
+}
+
 // TestOSSandboxGoRuntime tests that Go build, test, and install work in OS sandbox.
 func TestOSSandboxGoRuntime(t *testing.T) {
 	tmpDir := t.TempDir()
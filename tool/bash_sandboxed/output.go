@@ -0,0 +1,126 @@
+package bash_sandboxed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+// outputTransformer post-processes command output before it is returned.
+type outputTransformer struct {
+	enabled func(cfg *config.Config) bool
+	apply   func(output string) string
+}
+
+// outputTransformers are applied, in order, to the output of every command
+// run by Execute.
+var outputTransformers = []outputTransformer{
+	{enabled: (*config.Config).StripsANSI, apply: stripANSI},
+}
+
+// ansiEscape matches ANSI escape sequences: CSI sequences (colors, cursor
+// movement), OSC sequences (window titles, hyperlinks) terminated by BEL or
+// ST, and two-character escapes.
+var ansiEscape = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// stripANSI removes ANSI escape sequences from output. Output that is not
+// valid UTF-8 is binary data, which ExecuteDetailed base64-encodes, and is
+// returned unchanged.
+func stripANSI(output string) string {
+	if !strings.Contains(output, "\x1b") || !utf8.ValidString(output) {
+		return output
+	}
+	return ansiEscape.ReplaceAllString(output, "")
+}
+
+// transformOutput applies the enabled outputTransformers to output, and to
+// the output carried by a CommandFailedError.
+func transformOutput(cfg *config.Config, output string, err error) (string, error) {
+	for _, t := range outputTransformers {
+		if !t.enabled(cfg) {
+			continue
+		}
+		output = t.apply(output)
+		var cmdErr *CommandFailedError
+		if errors.As(err, &cmdErr) {
+			cmdErr.Output = t.apply(cmdErr.Output)
+		}
+	}
+	return output, err
+}
+
+// emptyOutputNote is returned instead of the empty output of a successful
+// command when annotate_empty_output is set.
+const emptyOutputNote = "[command succeeded with no output]"
+
+// annotateEmptyOutput returns emptyOutputNote if the command succeeded
+// without output and cfg enables annotate_empty_output, and output otherwise.
+func annotateEmptyOutput(cfg *config.Config, output string, err error) string {
+	if output == "" && err == nil && cfg.AnnotatesEmptyOutput() {
+		return emptyOutputNote
+	}
+	return output
+}
+
+// errOutputLimit is the cause of the cancellation of a command whose output
+// exceeded max_output_bytes.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// limitedBuffer collects the combined stdout and stderr of a command, up to
+// limit bytes (0 means no limit). The write that crosses the limit is
+// truncated and stops the command; it and all later writes fail. It is safe
+// for concurrent use, since the stages of a pipeline write to it at once.
+type limitedBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	limit    int
+	stop     func()
+	exceeded bool
+}
+
+// withOutputLimit returns a buffer for the output of a command run with the
+// returned context, which is canceled once the output exceeds limit. The
+// returned cancel function must be called when the command is done.
+func withOutputLimit(ctx context.Context, limit int) (context.Context, context.CancelFunc, *limitedBuffer) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	b := &limitedBuffer{limit: limit, stop: func() { cancel(errOutputLimit) }}
+	return ctx, func() { cancel(nil) }, b
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exceeded {
+		return 0, errOutputLimit
+	}
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		n, _ := b.buf.Write(p[:b.limit-b.buf.Len()])
+		b.exceeded = true
+		b.stop()
+		return n, errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+// result returns the output of a command that finished with err. A command
+// stopped for exceeding the limit fails with its truncated output, even if
+// it exited successfully.
+func (b *limitedBuffer) result(err error) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	output := b.buf.String()
+	if b.exceeded {
+		err = fmt.Errorf("output exceeded max_output_bytes (%d bytes), command stopped", b.limit)
+	}
+	if err != nil {
+		return output, &CommandFailedError{Err: err, Output: output}
+	}
+	return output, nil
+}
@@ -0,0 +1,182 @@
+package bash_sandboxed
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello\n", "hello\n"},
+		{"color", "\x1b[31mred\x1b[0m\n", "red\n"},
+		{"bold and 256 colors", "\x1b[1;38;5;208mx\x1b[m", "x"},
+		{"grep match", "a\x1b[01;31m\x1b[Kb\x1b[m\x1b[Kc", "abc"},
+		{"cursor movement", "\x1b[2K\x1b[1Gdone", "done"},
+		{"private mode", "\x1b[?25lx\x1b[?25h", "x"},
+		{"osc title with bel", "\x1b]0;title\x07x", "x"},
+		{"osc hyperlink with st", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"two-character escape", "\x1bMx", "x"},
+		{"binary output unchanged", "\xff\x1b[31m", "\xff\x1b[31m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripANSI(tt.in); got != tt.want {
+				t.Errorf("stripANSI(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_StripANSI(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	command := `printf '\033[31mred\033[0m\n'`
+
+	s := NewSandbox()
+	out, err := s.Execute(context.Background(), command, dir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "red\n" {
+		t.Errorf("expected escapes to be stripped by default, got %q", out)
+	}
+
+	_, err = s.Execute(context.Background(), command+"; false", dir, paths, paths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("expected CommandFailedError, got %v", err)
+	}
+	if cmdErr.Output != "red\n" {
+		t.Errorf("expected escapes to be stripped from failed command output, got %q", cmdErr.Output)
+	}
+
+	off := false
+	s.UpdateConfig(&config.Config{StripANSI: &off}, dir)
+	out, err = s.Execute(context.Background(), command, dir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "\x1b[31mred\x1b[0m\n" {
+		t.Errorf("expected escapes to be kept with strip_ansi: false, got %q", out)
+	}
+}
+
+func TestExecute_AnnotateEmptyOutput(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+
+	out, err := s.Execute(context.Background(), "true", dir, paths, paths)
+	if err != nil || out != "" {
+		t.Errorf("expected empty output by default, got %q and %v", out, err)
+	}
+
+	on := true
+	s.UpdateConfig(&config.Config{AnnotateEmptyOutput: &on}, dir)
+	out, err = s.Execute(context.Background(), "true", dir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != emptyOutputNote {
+		t.Errorf("expected the empty output note, got %q", out)
+	}
+
+	out, err = s.Execute(context.Background(), "echo hi", dir, paths, paths)
+	if err != nil || out != "hi\n" {
+		t.Errorf("expected output to be unchanged, got %q and %v", out, err)
+	}
+
+	out, err = s.Execute(context.Background(), "false", dir, paths, paths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("expected CommandFailedError, got %v", err)
+	}
+	if out != "" || cmdErr.Output != "" {
+		t.Errorf("expected a failed command not to be annotated, got %q and %q", out, cmdErr.Output)
+	}
+
+	r := s.ExecuteDetailed(context.Background(), "true", dir, paths, paths, ExecuteOptions{})
+	if r.Err != nil || r.Output != emptyOutputNote {
+		t.Errorf("expected ExecuteDetailed to return the empty output note, got %q and %v", r.Output, r.Err)
+	}
+}
+
+func TestExecute_OutputLimit(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{MaxOutputBytes: 1000, ExtraCommands: []string{"seq"}}, dir)
+
+	for _, command := range []string{"yes", "yes | cat", "while true; do echo y; done", "seq 1 1000000"} {
+		t.Run(command, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_, err := s.Execute(ctx, command, dir, paths, paths)
+			var cmdErr *CommandFailedError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("expected CommandFailedError, got %v", err)
+			}
+			if !strings.Contains(cmdErr.Error(), "max_output_bytes") {
+				t.Errorf("expected an output limit error, got %v", cmdErr)
+			}
+			if len(cmdErr.Output) != 1000 {
+				t.Errorf("expected output truncated to 1000 bytes, got %d", len(cmdErr.Output))
+			}
+			if ctx.Err() != nil {
+				t.Error("expected the command to stop at the output limit, not the timeout")
+			}
+		})
+	}
+
+	out, err := s.Execute(context.Background(), "yes hello | head -3", dir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "hello\nhello\nhello\n" {
+		t.Errorf("expected exactly three lines, got %q", out)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = s.Execute(ctx, "yes > /dev/null", dir, paths, paths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected yes > /dev/null to be stopped by the timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected yes > /dev/null to stop at the timeout, took %v", elapsed)
+	}
+
+	s.UpdateConfig(&config.Config{MaxOutputBytes: -1}, dir)
+	out, err = s.Execute(context.Background(), "yes | head -c 20000", dir, paths, paths)
+	if err != nil || len(out) != 20000 {
+		t.Errorf("expected unlimited output with max_output_bytes: -1, got %d bytes and %v", len(out), err)
+	}
+}
+
+func TestExecute_TimeoutPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, err := s.Execute(ctx, "echo start; sleep 10; echo end", dir, paths, paths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the command to be stopped by the timeout, got %v", err)
+	}
+	if out != "start\n" || cmdErr.Output != "start\n" {
+		t.Errorf("expected the output written before the timeout, got %q and %q", out, cmdErr.Output)
+	}
+}
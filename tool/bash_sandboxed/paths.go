@@ -1,11 +1,17 @@
 package bash_sandboxed
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/gartnera/lite-sandbox/config"
 	"mvdan.cc/sh/v3/syntax"
 )
 
@@ -14,54 +20,163 @@ import (
 // the sandbox boundary (e.g., cat /etc/passwd, cat ../../../etc/shadow).
 // Write commands (cp, mv, rm, etc.) are checked against writeAllowedPaths;
 // all other commands are checked against readAllowedPaths.
+// Relative paths are resolved against the directory each command runs in,
+// following literal cd commands (see trackCdDirs).
 func validatePaths(f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	r := newPathResolver()
+	dirs := trackCdDirs(f, workDir)
 	var validationErr error
 	syntax.Walk(f, func(node syntax.Node) bool {
 		if validationErr != nil {
 			return false
 		}
-		callExpr, ok := node.(*syntax.CallExpr)
-		if !ok {
-			return true
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			validationErr = validateCallPaths(r, n, dirs.dir(n, workDir), readAllowedPaths, writeAllowedPaths)
+		case *syntax.TestClause:
+			validationErr = validateTestClausePaths(r, n, dirs.dir(n, workDir), readAllowedPaths)
+		}
+		return validationErr == nil
+	})
+	return validationErr
+}
+
+// validateCallPaths checks the path-like arguments of a single command.
+func validateCallPaths(r *pathResolver, callExpr *syntax.CallExpr, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	// Determine which allowed paths to use based on the invocation
+	allowedPaths := readAllowedPaths
+	if isWriteInvocation(wordLits(callExpr.Args)) {
+		allowedPaths = writeAllowedPaths
+	}
+	if len(callExpr.Args) == 0 {
+		return nil // assignment only
+	}
+	cmdName := callExpr.Args[0].Lit()
+	if cmdName == "git" {
+		if err := validateGitRepoDirs(r, wordLits(callExpr.Args), workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+			return err
 		}
-		// Determine which allowed paths to use based on command name
-		allowedPaths := readAllowedPaths
-		if len(callExpr.Args) > 0 {
-			cmdName := extractCommandName(callExpr.Args[0])
-			if writeCommands[cmdName] {
-				allowedPaths = writeAllowedPaths
+	}
+	endOfOptions := false
+	skipNext := false
+	fileOperand := false
+	for i, arg := range callExpr.Args {
+		if i == 0 {
+			continue // skip command name
+		}
+		if skipNext {
+			skipNext = false
+			continue // value of a format option
+		}
+		lit := arg.Lit()
+		if fileOperand {
+			fileOperand = false
+			if lit == "" {
+				continue // dynamic; checked at runtime by the CallHandler
+			}
+			if err := validatePathCandidate(r, lit, lit, true, workDir, allowedPaths); err != nil {
+				return err
 			}
+			continue
 		}
-		for i, arg := range callExpr.Args {
-			if i == 0 {
-				continue // skip command name
+		if lit == "" {
+			continue // dynamic/non-literal argument
+		}
+		value, next := fileOperandFlag(cmdName, lit)
+		fileOperand = next
+		if value != "" {
+			if err := validatePathCandidate(r, lit, value, true, workDir, allowedPaths); err != nil {
+				return err
 			}
-			lit := arg.Lit()
-			if lit == "" {
-				continue // dynamic/non-literal argument
+		}
+		if lit == "--" && !endOfOptions {
+			endOfOptions = true
+			continue
+		}
+		if !endOfOptions {
+			if isFormat, next := formatFlagArg(cmdName, lit); isFormat {
+				skipNext = next
+				continue
 			}
-			var pathToCheck string
-			if strings.HasPrefix(lit, "-") {
-				// Extract any path embedded in a flag (e.g., -f/etc/passwd, --file=/etc/passwd)
-				pathToCheck = extractPathFromFlag(lit)
-			} else {
-				pathToCheck = lit
+		}
+		if isFileOperand(cmdName, lit, endOfOptions) {
+			if err := validatePathCandidate(r, lit, lit, true, workDir, allowedPaths); err != nil {
+				return err
 			}
-			// Check for .git access even if it doesn't look like a typical path
-			if pathToCheck == ".git" || strings.HasPrefix(pathToCheck, ".git/") || strings.HasPrefix(pathToCheck, ".git\\") {
-				validationErr = fmt.Errorf("path %q accesses .git directory which is not allowed", lit)
-				return false
+			continue
+		}
+		if cmdName == "git" {
+			lit = gitFileURLPath(lit)
+		}
+		if err := validateArgPath(r, lit, endOfOptions, workDir, allowedPaths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileUnaryTestOps are [[ ]] unary operators whose operand is a file path.
+var fileUnaryTestOps = map[syntax.UnTestOperator]bool{
+	syntax.TsExists:  true, // -e
+	syntax.TsRegFile: true, // -f
+	syntax.TsDirect:  true, // -d
+	syntax.TsCharSp:  true, // -c
+	syntax.TsBlckSp:  true, // -b
+	syntax.TsNmPipe:  true, // -p
+	syntax.TsSocket:  true, // -S
+	syntax.TsSmbLink: true, // -L, -h
+	syntax.TsSticky:  true, // -k
+	syntax.TsGIDSet:  true, // -g
+	syntax.TsUIDSet:  true, // -u
+	syntax.TsGrpOwn:  true, // -G
+	syntax.TsUsrOwn:  true, // -O
+	syntax.TsModif:   true, // -N
+	syntax.TsRead:    true, // -r
+	syntax.TsWrite:   true, // -w
+	syntax.TsExec:    true, // -x
+	syntax.TsNoEmpty: true, // -s
+}
+
+// fileBinaryTestOps are [[ ]] binary operators whose operands are file paths.
+var fileBinaryTestOps = map[syntax.BinTestOperator]bool{
+	syntax.TsNewer:  true, // -nt
+	syntax.TsOlder:  true, // -ot
+	syntax.TsDevIno: true, // -ef
+}
+
+// validateTestClausePaths checks the file operands of [[ ]] file tests
+// against readAllowedPaths. Tests like [[ -e /root ]] would otherwise
+// disclose the existence or permissions of files outside the sandbox.
+// test and [ are ordinary commands whose operands are checked by
+// validateCallPaths.
+func validateTestClausePaths(r *pathResolver, tc *syntax.TestClause, workDir string, readAllowedPaths []string) error {
+	var validationErr error
+	syntax.Walk(tc.X, func(node syntax.Node) bool {
+		if validationErr != nil {
+			return false
+		}
+		var operands []syntax.TestExpr
+		switch n := node.(type) {
+		case *syntax.UnaryTest:
+			if fileUnaryTestOps[n.Op] {
+				operands = []syntax.TestExpr{n.X}
+			}
+		case *syntax.BinaryTest:
+			if fileBinaryTestOps[n.Op] {
+				operands = []syntax.TestExpr{n.X, n.Y}
 			}
-			if pathToCheck == "" || !looksLikePath(pathToCheck) {
+		}
+		for _, operand := range operands {
+			w, ok := operand.(*syntax.Word)
+			if !ok {
 				continue
 			}
-			resolved := ResolvePath(pathToCheck, workDir)
-			if !IsUnderAllowedPaths(resolved, allowedPaths) {
-				validationErr = fmt.Errorf("path %q resolves to %q which is outside allowed directories", lit, resolved)
-				return false
+			lit := w.Lit()
+			if lit == "" {
+				continue // dynamic; checked at runtime by the stat handler
 			}
-			if isGitInternalPath(resolved) {
-				validationErr = fmt.Errorf("path %q accesses .git directory which is not allowed", lit)
+			if err := validateArgPath(r, lit, true, workDir, readAllowedPaths); err != nil {
+				validationErr = err
 				return false
 			}
 		}
@@ -76,6 +191,8 @@ func validatePaths(f *syntax.File, workDir string, readAllowedPaths, writeAllowe
 // Input redirects are checked against readAllowedPaths; output redirects are
 // checked against writeAllowedPaths. Output redirects to /dev/null are always allowed.
 func validateRedirectPaths(f *syntax.File, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	r := newPathResolver()
+	dirs := trackCdDirs(f, workDir)
 	var validationErr error
 	syntax.Walk(f, func(node syntax.Node) bool {
 		if validationErr != nil {
@@ -85,45 +202,55 @@ func validateRedirectPaths(f *syntax.File, workDir string, readAllowedPaths, wri
 		if !ok {
 			return true
 		}
-		for _, r := range stmt.Redirs {
-			// Only check redirects that reference file paths.
-			// fd dups (DplIn, DplOut) and heredocs don't have file targets.
-			var allowedPaths []string
-			switch r.Op {
-			case syntax.RdrIn:
-				allowedPaths = readAllowedPaths
-			case syntax.RdrOut, syntax.AppOut, syntax.ClbOut,
-				syntax.RdrAll, syntax.AppAll:
-				allowedPaths = writeAllowedPaths
-			case syntax.RdrInOut:
-				// Read+write; must satisfy write permissions
-				allowedPaths = writeAllowedPaths
-			default:
-				continue
-			}
-			lit := r.Word.Lit()
-			if lit == "" {
-				continue
-			}
-			// /dev/null is always allowed for output
-			if lit == "/dev/null" {
-				continue
-			}
-			resolved := ResolvePath(lit, workDir)
-			if !IsUnderAllowedPaths(resolved, allowedPaths) {
-				validationErr = fmt.Errorf("redirect path %q resolves to %q which is outside allowed directories", lit, resolved)
-				return false
-			}
-			if isGitInternalPath(resolved) {
-				validationErr = fmt.Errorf("redirect path %q accesses .git directory which is not allowed", lit)
-				return false
-			}
-		}
-		return true
+		validationErr = validateStmtRedirectPaths(r, stmt, dirs.dir(stmt, workDir), readAllowedPaths, writeAllowedPaths)
+		return validationErr == nil
 	})
 	return validationErr
 }
 
+// validateStmtRedirectPaths checks the file targets of a single statement's
+// redirections.
+func validateStmtRedirectPaths(r *pathResolver, stmt *syntax.Stmt, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	for _, rd := range stmt.Redirs {
+		// Only check redirects that reference file paths.
+		// fd dups (DplIn, DplOut) and heredocs don't have file targets.
+		var allowedPaths []string
+		field := "writable_paths"
+		switch rd.Op {
+		case syntax.RdrIn:
+			allowedPaths = readAllowedPaths
+			field = "readable_paths"
+		case syntax.RdrOut, syntax.AppOut, syntax.ClbOut,
+			syntax.RdrAll, syntax.AppAll:
+			allowedPaths = writeAllowedPaths
+		case syntax.RdrInOut:
+			// Read+write; must satisfy write permissions
+			allowedPaths = writeAllowedPaths
+		default:
+			continue
+		}
+		lit := rd.Word.Lit()
+		if lit == "" {
+			continue
+		}
+		// /dev/null is always allowed for output
+		if lit == "/dev/null" {
+			continue
+		}
+		if workDir == "" && !filepath.IsAbs(lit) {
+			continue // directory unknown; checked at runtime by the OpenHandler
+		}
+		resolved := r.resolve(lit, workDir)
+		if !r.isUnderAllowedPaths(resolved, allowedPaths) {
+			return outsideAllowedPathsError(fmt.Sprintf("redirect path %q", lit), resolved, field)
+		}
+		if isGitInternalPath(resolved) {
+			return fmt.Errorf("redirect path %q accesses .git directory which is not allowed", lit)
+		}
+	}
+	return nil
+}
+
 // isGitInternalPath returns true if the resolved path is inside a .git directory.
 // Direct access to .git contents is blocked to prevent reading sensitive data
 // (hooks, config) and to force usage through the git command with its validator.
@@ -138,6 +265,16 @@ func isGitInternalPath(resolved string) bool {
 	return false
 }
 
+// wordLits returns the literal value of each word. Non-literal words (e.g.,
+// containing variable expansions) are returned as empty strings.
+func wordLits(words []*syntax.Word) []string {
+	lits := make([]string, len(words))
+	for i, w := range words {
+		lits[i] = w.Lit()
+	}
+	return lits
+}
+
 // looksLikePath returns true if the string looks like it references a filesystem
 // path rather than a plain argument. We check arguments that are absolute,
 // start with ./ or ../, or contain a path separator.
@@ -178,6 +315,91 @@ func extractPathFromFlag(flag string) string {
 	return ""
 }
 
+// validateDirs checks that workDir and every allowed path is absolute.
+// Relative ones would be resolved against the server's own working directory
+// rather than the caller's, and the allowed-paths prefix checks would compare
+// absolute paths with relative ones.
+func validateDirs(workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	if !filepath.IsAbs(workDir) {
+		return fmt.Errorf("working directory must be an absolute path, got %q", workDir)
+	}
+	for _, p := range readAllowedPaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("read-allowed path must be absolute, got %q", p)
+		}
+	}
+	for _, p := range writeAllowedPaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("write-allowed path must be absolute, got %q", p)
+		}
+	}
+	return nil
+}
+
+// systemDirs are the system directories. Allowing one as a whole makes path
+// restrictions ineffective (see broadPaths), and outsideAllowedPathsError
+// never suggests allowing one, nor any directory under it.
+var systemDirs = []string{"/etc", "/usr", "/bin", "/sbin", "/lib", "/lib64", "/boot", "/dev", "/proc", "/sys", "/System", "/Library"}
+
+// broadPaths are directories that make path restrictions ineffective when
+// allowed as a whole: the filesystem root, the parents of home directories,
+// /var, /private and systemDirs. The user's home directory is checked
+// separately.
+var broadPaths = func() map[string]bool {
+	paths := map[string]bool{
+		"/":        true,
+		"/home":    true,
+		"/Users":   true,
+		"/root":    true,
+		"/var":     true,
+		"/private": true,
+	}
+	for _, dir := range systemDirs {
+		paths[dir] = true
+	}
+	return paths
+}()
+
+// isBroadPath reports whether p, or the directory it resolves to, is one of
+// broadPaths or the user's home directory.
+func isBroadPath(p string) bool {
+	home, _ := os.UserHomeDir()
+	for _, candidate := range []string{filepath.Clean(p), ResolvePath(p, "/")} {
+		if broadPaths[candidate] {
+			return true
+		}
+		if home != "" && (candidate == filepath.Clean(home) || candidate == ResolvePath(home, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBroadPaths looks for allowed paths that are too broad (see
+// isBroadPath). With reject_broad_paths it returns an error for the first
+// one; otherwise it logs a warning the first time the sandbox sees each one.
+func (s *Sandbox) checkBroadPaths(cfg *config.Config, readAllowedPaths, writeAllowedPaths []string) error {
+	for _, p := range slices.Concat(readAllowedPaths, writeAllowedPaths) {
+		if !isBroadPath(p) {
+			continue
+		}
+		if cfg.RejectsBroadPaths() {
+			return fmt.Errorf("allowed path %q is too broad: it makes path restrictions ineffective (reject_broad_paths is set)", p)
+		}
+		if _, warned := s.warnedBroadPaths.LoadOrStore(p, true); !warned {
+			slog.Warn("allowed path is too broad; path restrictions are ineffective", "path", p)
+		}
+	}
+	return nil
+}
+
+// evalSymlinks and lstat are the filesystem lookups used by path resolution.
+// They are variables so benchmarks can count lookups.
+var (
+	evalSymlinks = filepath.EvalSymlinks
+	lstat        = os.Lstat
+)
+
 // ResolvePath resolves a potentially relative path to an absolute path,
 // handling symlinks for any existing prefix of the path.
 func ResolvePath(path, workDir string) string {
@@ -187,7 +409,7 @@ func ResolvePath(path, workDir string) string {
 	path = filepath.Clean(path)
 
 	// Try to resolve symlinks on the full path
-	resolved, err := filepath.EvalSymlinks(path)
+	resolved, err := evalSymlinks(path)
 	if err == nil {
 		return resolved
 	}
@@ -207,7 +429,7 @@ func resolveExistingPrefix(path string) string {
 		return path
 	}
 
-	resolved, err := filepath.EvalSymlinks(dir)
+	resolved, err := evalSymlinks(dir)
 	if err == nil {
 		return filepath.Join(resolved, base)
 	}
@@ -215,6 +437,76 @@ func resolveExistingPrefix(path string) string {
 	return filepath.Join(resolveExistingPrefix(dir), base)
 }
 
+// pathResolver resolves paths like ResolvePath and checks them like
+// IsUnderAllowedPaths, memoizing directory and allowed-path resolution so
+// that arguments sharing a directory prefix are not re-resolved component by
+// component. A resolver must only be used for a single validation pass: it
+// assumes the filesystem does not change while it is in use, so runtime
+// checks (which run after earlier commands may have modified the tree)
+// create a new one each time.
+type pathResolver struct {
+	resolved map[string]string
+	allowed  map[string]string
+}
+
+func newPathResolver() *pathResolver {
+	return &pathResolver{
+		resolved: make(map[string]string),
+		allowed:  make(map[string]string),
+	}
+}
+
+// resolve returns the same result as ResolvePath(path, workDir).
+func (r *pathResolver) resolve(path, workDir string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir, path)
+	}
+	return r.resolveClean(filepath.Clean(path))
+}
+
+// resolveClean resolves a clean absolute path. The parent directory is
+// resolved (and cached) first; the final component then needs only an
+// Lstat unless it is itself a symlink.
+func (r *pathResolver) resolveClean(path string) string {
+	if resolved, ok := r.resolved[path]; ok {
+		return resolved
+	}
+	dir := filepath.Dir(path)
+	var resolved string
+	if dir == path {
+		resolved = path // root
+	} else {
+		joined := filepath.Join(r.resolveClean(dir), filepath.Base(path))
+		resolved = joined
+		if info, err := lstat(joined); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := evalSymlinks(joined); err == nil {
+				resolved = target
+			}
+		}
+	}
+	r.resolved[path] = resolved
+	return resolved
+}
+
+// isUnderAllowedPaths returns the same result as IsUnderAllowedPaths.
+func (r *pathResolver) isUnderAllowedPaths(path string, allowedPaths []string) bool {
+	for _, allowed := range allowedPaths {
+		resolvedAllowed, ok := r.allowed[allowed]
+		if !ok {
+			var err error
+			resolvedAllowed, err = evalSymlinks(allowed)
+			if err != nil {
+				resolvedAllowed = allowed
+			}
+			r.allowed[allowed] = resolvedAllowed
+		}
+		if path == resolvedAllowed || strings.HasPrefix(path, resolvedAllowed+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsUnderAllowedPaths checks whether the resolved path is equal to or nested
 // under one of the allowed directories. It resolves symlinks in the allowed
 // paths to ensure comparisons work correctly on systems where directories
@@ -222,7 +514,7 @@ func resolveExistingPrefix(path string) string {
 func IsUnderAllowedPaths(path string, allowedPaths []string) bool {
 	for _, allowed := range allowedPaths {
 		// Resolve symlinks in the allowed path for accurate comparison
-		resolvedAllowed, err := filepath.EvalSymlinks(allowed)
+		resolvedAllowed, err := evalSymlinks(allowed)
 		if err != nil {
 			// If we can't resolve, try the original path
 			resolvedAllowed = allowed
@@ -239,61 +531,749 @@ func IsUnderAllowedPaths(path string, allowedPaths []string) bool {
 // command substitutions have been resolved to their actual values.
 // This catches bypasses like "cat $HOME/secret" that static validation misses.
 // Write commands are checked against writeAllowedPaths; others against readAllowedPaths.
-func validateExpandedPaths(args []string, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+// The files a write command modifies must also not be in readonlySubpaths.
+func validateExpandedPaths(args []string, workDir string, readAllowedPaths, writeAllowedPaths, readonlySubpaths []string) error {
 	if len(args) == 0 {
 		return nil
 	}
 	allowedPaths := readAllowedPaths
-	if writeCommands[args[0]] {
+	if isWriteInvocation(args) {
 		allowedPaths = writeAllowedPaths
+		for _, target := range writeTargets(args) {
+			if err := validateNotReadonly(target, workDir, writeAllowedPaths, readonlySubpaths); err != nil {
+				return err
+			}
+		}
+		for _, target := range recursiveWriteTargets(args) {
+			if err := validateNoReadonlyBelow(target, workDir, writeAllowedPaths, readonlySubpaths); err != nil {
+				return err
+			}
+		}
+	}
+	// A fresh resolver per call: the filesystem may have changed since static
+	// validation (e.g., an earlier command in the script created a symlink).
+	r := newPathResolver()
+	if args[0] == "git" {
+		if err := validateGitRepoDirs(r, args, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+			return err
+		}
 	}
+	endOfOptions := false
+	skipNext := false
+	fileOperand := false
 	for _, arg := range args[1:] {
-		if arg == ".git" || strings.HasPrefix(arg, ".git/") || strings.HasPrefix(arg, ".git\\") {
-			return fmt.Errorf("path %q accesses .git directory which is not allowed", arg)
+		if skipNext {
+			skipNext = false
+			continue // value of a format option
+		}
+		if fileOperand {
+			fileOperand = false
+			if err := validatePathCandidate(r, arg, arg, true, workDir, allowedPaths); err != nil {
+				return err
+			}
+			continue
 		}
-		var pathToCheck string
-		if strings.HasPrefix(arg, "-") {
-			pathToCheck = extractPathFromFlag(arg)
-		} else {
-			pathToCheck = arg
+		value, next := fileOperandFlag(args[0], arg)
+		fileOperand = next
+		if value != "" {
+			if err := validatePathCandidate(r, arg, value, true, workDir, allowedPaths); err != nil {
+				return err
+			}
 		}
-		if pathToCheck == "" || !looksLikePath(pathToCheck) {
+		if arg == "--" && !endOfOptions {
+			endOfOptions = true
 			continue
 		}
-		resolved := ResolvePath(pathToCheck, workDir)
-		if !IsUnderAllowedPaths(resolved, allowedPaths) {
-			return fmt.Errorf("path %q resolves to %q which is outside allowed directories", arg, resolved)
+		if !endOfOptions {
+			if isFormat, next := formatFlagArg(args[0], arg); isFormat {
+				skipNext = next
+				continue
+			}
 		}
-		if isGitInternalPath(resolved) {
-			return fmt.Errorf("path %q accesses .git directory which is not allowed", arg)
+		if isFileOperand(args[0], arg, endOfOptions) {
+			if err := validatePathCandidate(r, arg, arg, true, workDir, allowedPaths); err != nil {
+				return err
+			}
+			continue
+		}
+		if args[0] == "git" {
+			arg = gitFileURLPath(arg)
+		}
+		if err := validateArgPath(r, arg, endOfOptions, workDir, allowedPaths); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// contentReadingCommands lists the commands that read the contents of their
+// file operands, which validateRegularFileOperands checks. Commands that
+// only look at metadata (ls, stat, find) or write (rm, mv) are not listed.
+var contentReadingCommands = map[string]bool{
+	"cat":       true,
+	"head":      true,
+	"tail":      true,
+	"less":      true,
+	"more":      true,
+	"wc":        true,
+	"column":    true,
+	"fold":      true,
+	"paste":     true,
+	"rev":       true,
+	"tac":       true,
+	"nl":        true,
+	"pr":        true,
+	"expand":    true,
+	"unexpand":  true,
+	"fmt":       true,
+	"grep":      true,
+	"egrep":     true,
+	"fgrep":     true,
+	"rg":        true,
+	"look":      true,
+	"sha256sum": true,
+	"sha1sum":   true,
+	"md5sum":    true,
+	"shasum":    true,
+	"cksum":     true,
+	"b2sum":     true,
+	"sort":      true,
+	"uniq":      true,
+	"cut":       true,
+	"diff":      true,
+	"comm":      true,
+	"join":      true,
+	"tsort":     true,
+	"strings":   true,
+	"od":        true,
+	"hexdump":   true,
+	"xxd":       true,
+	"iconv":     true,
+	"jq":        true,
+	"yq":        true,
+	"base64":    true,
+}
+
+// standardStreams are the device paths of the standard streams, which may
+// be read even though they are not regular files.
+var standardStreams = map[string]bool{
+	"/dev/stdin":  true,
+	"/dev/stdout": true,
+	"/dev/stderr": true,
+}
+
+// validateRegularFile rejects reading path if it is a FIFO, socket or
+// device rather than a regular file or directory: reading a FIFO without a
+// writer blocks until the command times out, and reading a device can have
+// side effects. The standard streams and the FIFOs the interpreter creates
+// for process substitutions are allowed. Paths that do not exist are left
+// to the command.
+func validateRegularFile(path, workDir string) error {
+	if standardStreams[path] {
+		return nil
+	}
+	abs := absPath(path, workDir)
+	if filepath.Dir(abs) == filepath.Clean(os.TempDir()) && strings.HasPrefix(filepath.Base(abs), "sh-interp-") {
+		return nil // process substitution
+	}
+	info, err := os.Stat(abs)
+	if err != nil || info.Mode().IsRegular() || info.IsDir() {
+		return nil
+	}
+	kind := "special file"
+	switch mode := info.Mode(); {
+	case mode&os.ModeNamedPipe != 0:
+		kind = "named pipe"
+	case mode&os.ModeSocket != 0:
+		kind = "socket"
+	case mode&os.ModeDevice != 0:
+		kind = "device"
+	}
+	return fmt.Errorf("refusing to open non-regular file %q: it is a %s", path, kind)
+}
+
+// validateRegularFileOperands applies validateRegularFile to the operands
+// of a command in contentReadingCommands, run directly or by timeout or env.
+// Option arguments are skipped until "--".
+func validateRegularFileOperands(args []string, workDir string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if wrapped := wrappedCommand(args); wrapped != nil {
+		return validateRegularFileOperands(wrapped, workDir)
+	}
+	if !contentReadingCommands[args[0]] {
+		return nil
+	}
+	endOfOptions := false
+	for _, arg := range args[1:] {
+		if arg == "--" && !endOfOptions {
+			endOfOptions = true
+			continue
+		}
+		if arg == "" || arg == "-" || (!endOfOptions && strings.HasPrefix(arg, "-")) {
+			continue
+		}
+		if err := validateRegularFile(arg, workDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumCommands lists the commands whose -c/--check mode reads a manifest
+// of other files to hash.
+var checksumCommands = map[string]bool{
+	"md5sum":    true,
+	"sha1sum":   true,
+	"sha256sum": true,
+	"shasum":    true,
+	"cksum":     true,
+	"b2sum":     true,
+}
+
+// checksumGNULine and checksumBSDLine match manifest lines in GNU ("HASH
+// name", "HASH *name") and BSD tag ("SHA256 (name) = HASH") format,
+// capturing the file name. A leading backslash marks a name with escaped
+// newlines or backslashes.
+var (
+	checksumGNULine = regexp.MustCompile(`^[ \t]*(\\?)[0-9A-Fa-f]+[ \t](.*)$`)
+	checksumBSDLine = regexp.MustCompile(`^[ \t]*(\\?)[A-Za-z0-9-]+ ?\((.*)\) ?= ?\S+$`)
+)
+
+// checksumManifestNames returns the file names a manifest line may refer to.
+// A line can parse in both formats, and sha256sum accepts both "HASH *name"
+// and, for manifests from BSD tools, "HASH name", so every reading is
+// returned, with and without a trailing carriage return.
+func checksumManifestNames(line string) []string {
+	var names []string
+	for _, re := range []*regexp.Regexp{checksumGNULine, checksumBSDLine} {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name := m[2]
+		if m[1] != "" {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(name)
+		}
+		candidates := []string{name}
+		if re == checksumGNULine && (strings.HasPrefix(name, " ") || strings.HasPrefix(name, "*")) {
+			candidates = append(candidates, name[1:])
+		}
+		for _, c := range candidates {
+			names = append(names, c)
+			if trimmed := strings.TrimSuffix(c, "\r"); trimmed != c {
+				names = append(names, trimmed)
+			}
+		}
+	}
+	return names
+}
+
+// scanChecksumLines is a bufio.SplitFunc that splits a manifest at newlines
+// and NUL bytes. The checksum commands treat a file name as a C string, so a
+// NUL ends it; splitting there validates the name they actually open.
+func scanChecksumLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// validateChecksumManifests checks, for a checksum command in -c/--check
+// mode, that every file listed in its manifests is within readAllowedPaths.
+// sha256sum opens those files itself, so neither the OpenHandler nor static
+// validation sees them. Listed paths are relative to workDir. A manifest that
+// cannot be read is left for the command to report.
+func validateChecksumManifests(args []string, workDir string, readAllowedPaths []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if wrapped := wrappedCommand(args); wrapped != nil {
+		args = wrapped
+	}
+	if len(args) == 0 || !checksumCommands[args[0]] {
+		return nil
+	}
+	check, manifests := checksumCheckMode(args)
+	if !check {
+		return nil
+	}
+	if len(manifests) == 0 {
+		manifests = []string{"-"}
+	}
+	r := newPathResolver()
+	for _, manifest := range manifests {
+		if manifest == "-" {
+			return fmt.Errorf("%s -c from standard input is not allowed: the files it lists cannot be validated; pass the checksum file as an argument", args[0])
+		}
+		if resolved := r.resolve(manifest, workDir); !r.isUnderAllowedPaths(resolved, readAllowedPaths) {
+			return fmt.Errorf("path %q resolves to %q which is outside allowed directories", manifest, resolved)
+		}
+		f, err := os.Open(absPath(manifest, workDir))
+		if err != nil {
+			continue
+		}
+		err = func() error {
+			defer f.Close()
+			sc := bufio.NewScanner(f)
+			sc.Split(scanChecksumLines)
+			for sc.Scan() {
+				for _, name := range checksumManifestNames(sc.Text()) {
+					if name == "" || name == "-" {
+						continue
+					}
+					resolved := r.resolve(name, workDir)
+					if !r.isUnderAllowedPaths(resolved, readAllowedPaths) {
+						return fmt.Errorf("%s -c: %s lists %q, which resolves to %q outside allowed directories", args[0], manifest, name, resolved)
+					}
+					if isGitInternalPath(resolved) {
+						return fmt.Errorf("%s -c: %s lists %q, which accesses .git directory which is not allowed", args[0], manifest, name)
+					}
+				}
+			}
+			if err := sc.Err(); err != nil {
+				return fmt.Errorf("%s -c: reading %s: %w", args[0], manifest, err)
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFlags lists, per command, options whose value is an output format
+// string or an inline program that is never opened as a file. Without this,
+// a format such as stat -c /%n would be mistaken for an absolute path. Short
+// options are single letters; long options match with or without an
+// attached "=value".
+var formatFlags = map[string]struct {
+	short map[byte]bool
+	long  map[string]bool
+}{
+	"stat": {
+		short: map[byte]bool{'c': true},
+		long:  map[string]bool{"--format": true, "--printf": true},
+	},
+	"bc": {
+		short: map[byte]bool{'e': true},
+		long:  map[string]bool{"--expression": true},
+	},
+	"comm": {
+		long: map[string]bool{"--output-delimiter": true},
+	},
+}
+
+// formatFlagArg reports whether arg is a format option of cmdName (see
+// formatFlags), and if so whether its value is the next argument rather than
+// attached to arg. In a short option cluster such as -Lc, the letters before
+// the format option are assumed to take no value.
+func formatFlagArg(cmdName, arg string) (isFormat, valueIsNext bool) {
+	flags, ok := formatFlags[cmdName]
+	if !ok || len(arg) < 2 || arg[0] != '-' {
+		return false, false
+	}
+	if strings.HasPrefix(arg, "--") {
+		name, _, attached := strings.Cut(arg, "=")
+		if !flags.long[name] {
+			return false, false
+		}
+		return true, !attached
+	}
+	for j := 1; j < len(arg); j++ {
+		if flags.short[arg[j]] {
+			return true, j == len(arg)-1
+		}
+	}
+	return false, false
+}
+
+// fileOperandFlags maps commands to a function reporting whether arg is an
+// option whose value always names a file the command reads: value is a value
+// attached to arg (e.g., --file=pats), and next reports that the value is the
+// next argument. Such values are path-validated even if they do not look like
+// paths, since a bare name can be a symlink to a file outside the allowed
+// directories.
+var fileOperandFlags = map[string]func(arg string) (value string, next bool){
+	"find":  func(arg string) (string, bool) { return "", findReferenceFlag(arg) },
+	"grep":  grepPatternFileFlag,
+	"egrep": grepPatternFileFlag,
+	"fgrep": grepPatternFileFlag,
+	"rg":    rgFileFlag,
+	"bc":    bcFileFlag,
+}
+
+// findReferenceFlag reports whether arg is a find test that compares files
+// against a reference file, disclosing its timestamps or inode: -newer,
+// -anewer, -cnewer, -samefile, and -newerXY unless Y is t (a date string).
+func findReferenceFlag(arg string) bool {
+	switch arg {
+	case "-newer", "-anewer", "-cnewer", "-samefile":
+		return true
+	}
+	xy, ok := strings.CutPrefix(arg, "-newer")
+	return ok && len(xy) == 2 && strings.IndexByte("aBcm", xy[0]) >= 0 && strings.IndexByte("aBcm", xy[1]) >= 0
+}
+
+// grepValueOptions, rgValueOptions and bcValueOptions are the short options
+// of grep, rg and bc that take a value.
+const (
+	grepValueOptions = "ABCDdefm"
+	rgValueOptions   = "ABCEMTdefgjmrt"
+	bcValueOptions   = "ef"
+)
+
+// grepPatternFileFlag reports whether arg is grep's -f/--file, which reads
+// patterns from a file (see fileOperandFlags).
+func grepPatternFileFlag(arg string) (string, bool) {
+	return patternFileFlag(arg, grepValueOptions)
+}
+
+// rgFileFlag reports whether arg is rg's -f/--file, which reads patterns
+// from a file, or --ignore-file, which reads ignore rules from one (see
+// fileOperandFlags).
+func rgFileFlag(arg string) (string, bool) {
+	if arg == "--ignore-file" {
+		return "", true
+	}
+	if value, ok := strings.CutPrefix(arg, "--ignore-file="); ok {
+		return value, false
+	}
+	return patternFileFlag(arg, rgValueOptions)
+}
+
+// bcFileFlag reports whether arg is bc's -f/--file, which reads a program
+// from a file (see fileOperandFlags). Only some bc implementations have it;
+// the others take program files as operands.
+func bcFileFlag(arg string) (string, bool) {
+	return patternFileFlag(arg, bcValueOptions)
+}
+
+// patternFileFlag reports whether arg is -f or --file, alone or with an
+// attached value, including -f at the end of a group of short options like
+// -if. valueOptions are the command's short options that take a value.
+func patternFileFlag(arg, valueOptions string) (string, bool) {
+	if arg == "--file" {
+		return "", true
+	}
+	if value, ok := strings.CutPrefix(arg, "--file="); ok {
+		return value, false
+	}
+	if found, value := shortOption(arg, 'f', valueOptions); found {
+		return value, value == ""
+	}
+	return "", false
+}
+
+// shortOption reports whether arg, a group of short options like -if,
+// contains opt. valueOptions are the options that take a value, which is the
+// rest of the group, so scanning stops at the first of them. If opt is one of
+// them, its attached value is returned; it is empty if the value is the next
+// argument.
+func shortOption(arg string, opt byte, valueOptions string) (found bool, value string) {
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return false, ""
+	}
+	for i := 1; i < len(arg); i++ {
+		c := arg[i]
+		if c == opt {
+			if strings.IndexByte(valueOptions, c) >= 0 {
+				return true, arg[i+1:]
+			}
+			return true, ""
+		}
+		if strings.IndexByte(valueOptions, c) >= 0 {
+			return false, ""
+		}
+	}
+	return false, ""
+}
+
+// fileOperandFlag reports whether arg is an option of cmdName whose value
+// names a file, and returns its attached value (see fileOperandFlags).
+func fileOperandFlag(cmdName, arg string) (value string, next bool) {
+	detect, ok := fileOperandFlags[cmdName]
+	if !ok {
+		return "", false
+	}
+	return detect(arg)
+}
+
+// fileOperandCommands lists commands whose operands are all files they read,
+// such as the two files comm compares. Their operands are path-validated
+// even if they do not look like paths, like the values of fileOperandFlags;
+// "-" is standard input.
+var fileOperandCommands = map[string]bool{
+	"comm": true,
+}
+
+// isFileOperand reports whether arg is an operand of cmdName that names a
+// file it reads (see fileOperandCommands).
+func isFileOperand(cmdName, arg string, endOfOptions bool) bool {
+	if !fileOperandCommands[cmdName] || arg == "-" {
+		return false
+	}
+	return endOfOptions || !strings.HasPrefix(arg, "-")
+}
+
+// gitFileURLPath returns the local path named by a git file:// URL, so that
+// "git clone file:///etc" is validated like "git clone /etc". Other
+// arguments are returned unchanged.
+func gitFileURLPath(arg string) string {
+	rest, ok := strings.CutPrefix(arg, "file://")
+	if !ok {
+		return arg
+	}
+	if strings.HasPrefix(rest, "/") {
+		return rest
+	}
+	// file://host/path; git ignores the host.
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		return rest[i:]
+	}
+	return "/"
+}
+
+// argPathCandidates returns the strings in a single argument that may name a
+// filesystem path. A flag contributes any embedded value (e.g., -f/etc/passwd,
+// --file=/etc/passwd). After a "--" end-of-options marker the whole argument
+// is an operand, so it is returned as-is; any embedded flag value is returned
+// too, because some commands (notably find) keep parsing predicates after "--".
+func argPathCandidates(arg string, endOfOptions bool) []string {
+	if !strings.HasPrefix(arg, "-") {
+		return []string{arg}
+	}
+	var candidates []string
+	if endOfOptions {
+		candidates = append(candidates, arg)
+	}
+	if p := extractPathFromFlag(arg); p != "" {
+		candidates = append(candidates, p)
+	}
+	return candidates
+}
+
+// validateArgPath checks every path candidate in arg against allowedPaths
+// and rejects access to .git internals. An empty workDir means the directory
+// is not known statically, so only absolute paths are checked.
+func validateArgPath(r *pathResolver, arg string, endOfOptions bool, workDir string, allowedPaths []string) error {
+	for _, pathToCheck := range argPathCandidates(arg, endOfOptions) {
+		if err := validatePathCandidate(r, arg, pathToCheck, false, workDir, allowedPaths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePathCandidate checks pathToCheck, a path named by arg, against
+// allowedPaths and rejects access to .git internals. Unless isPath is set,
+// a candidate that does not look like a path is skipped.
+func validatePathCandidate(r *pathResolver, arg, pathToCheck string, isPath bool, workDir string, allowedPaths []string) error {
+	// Check for .git access even if it doesn't look like a typical path
+	if pathToCheck == ".git" || strings.HasPrefix(pathToCheck, ".git/") || strings.HasPrefix(pathToCheck, ".git\\") {
+		return fmt.Errorf("path %q accesses .git directory which is not allowed", arg)
+	}
+	if !isPath && !looksLikePath(pathToCheck) {
+		return nil
+	}
+	if workDir == "" && !filepath.IsAbs(pathToCheck) {
+		return nil // directory unknown; checked at runtime by the CallHandler
+	}
+	resolved := r.resolve(pathToCheck, workDir)
+	if !r.isUnderAllowedPaths(resolved, allowedPaths) {
+		return outsideAllowedPathsError(fmt.Sprintf("path %q", arg), resolved, "readable_paths, or writable_paths if the command writes to it")
+	}
+	if isGitInternalPath(resolved) {
+		return fmt.Errorf("path %q accesses .git directory which is not allowed", arg)
+	}
+	return nil
+}
+
 // validateOpenPath checks a file path before the interpreter opens it (for
 // redirections). This is called by the interpreter's OpenHandler, where
 // variables in redirect targets have been expanded to actual paths.
 // If the open flags include any write bits, the path is checked against
-// writeAllowedPaths; otherwise it is checked against readAllowedPaths.
-func validateOpenPath(path string, flag int, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+// writeAllowedPaths; otherwise it is checked against readAllowedPaths and
+// must not be a FIFO, socket or device (see validateRegularFile).
+func validateOpenPath(path string, flag int, workDir string, readAllowedPaths, writeAllowedPaths, readonlySubpaths []string) error {
 	if path == "/dev/null" {
 		return nil
 	}
 	allowedPaths := readAllowedPaths
 	if isWriteFlag(flag) {
 		allowedPaths = writeAllowedPaths
+		if err := validateNotReadonly(path, workDir, writeAllowedPaths, readonlySubpaths); err != nil {
+			return err
+		}
 	}
 	resolved := ResolvePath(path, workDir)
 	if !IsUnderAllowedPaths(resolved, allowedPaths) {
-		return fmt.Errorf("path %q resolves to %q which is outside allowed directories", path, resolved)
+		field := "readable_paths"
+		if isWriteFlag(flag) {
+			field = "writable_paths"
+		}
+		return outsideAllowedPathsError(fmt.Sprintf("path %q", path), resolved, field)
 	}
 	if isGitInternalPath(resolved) {
 		return fmt.Errorf("path %q accesses .git directory which is not allowed", path)
 	}
+	if !isWriteFlag(flag) {
+		return validateRegularFile(path, workDir)
+	}
+	return nil
+}
+
+// validateNotReadonly rejects writing path if, relative to the write-allowed
+// directory it is in, it or one of its parents matches a readonly_subpaths
+// glob. Globs are slash-separated; "**" matches any number of directories.
+func validateNotReadonly(path, workDir string, writeAllowedPaths, readonlySubpaths []string) error {
+	if len(readonlySubpaths) == 0 {
+		return nil
+	}
+	resolved := ResolvePath(path, workDir)
+	for _, root := range writeAllowedPaths {
+		resolvedRoot, err := evalSymlinks(root)
+		if err != nil {
+			resolvedRoot = root
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolved)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for _, pattern := range readonlySubpaths {
+			patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+			for n := 1; n <= len(segments); n++ {
+				if matchGlobSegments(patternSegments, segments[:n]) {
+					return fmt.Errorf("path %q is in a read-only subpath (%s)", path, pattern)
+				}
+			}
+		}
+	}
 	return nil
 }
 
+// validateNoReadonlyBelow rejects a recursive write to path, such as rm -r
+// or chmod -R, if a readonly_subpaths glob may match a path below it. That
+// is the case if path is a write-allowed directory or one of its parents,
+// such as the working directory itself, or if a glob matches path followed
+// by more segments.
+func validateNoReadonlyBelow(path, workDir string, writeAllowedPaths, readonlySubpaths []string) error {
+	if len(readonlySubpaths) == 0 {
+		return nil
+	}
+	resolved := ResolvePath(path, workDir)
+	for _, root := range writeAllowedPaths {
+		resolvedRoot, err := evalSymlinks(root)
+		if err != nil {
+			resolvedRoot = root
+		}
+		if IsUnderAllowedPaths(resolvedRoot, []string{resolved}) {
+			return fmt.Errorf("path %q contains read-only subpaths (%s)", path, strings.Join(readonlySubpaths, ", "))
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for _, pattern := range readonlySubpaths {
+			if matchGlobBelow(strings.Split(strings.Trim(pattern, "/"), "/"), segments) {
+				return fmt.Errorf("path %q contains a read-only subpath (%s)", path, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlobBelow reports whether glob segments may match segments followed
+// by zero or more further path segments.
+func matchGlobBelow(pattern, segments []string) bool {
+	if len(segments) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return matchGlobBelow(pattern[1:], segments) || matchGlobBelow(pattern, segments[1:])
+	}
+	if ok, _ := filepath.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlobBelow(pattern[1:], segments[1:])
+}
+
+// matchGlobSegments matches path segments against glob segments, where a
+// "**" segment matches zero or more path segments.
+func matchGlobSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlobSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlobSegments(pattern[1:], segments[1:])
+}
+
+// validateStatPath checks a path before the interpreter stats it for a file
+// test ([[ -e ]], test -f, ...) or cd. path is absolute. Paths outside
+// readAllowedPaths are rejected so file tests cannot probe the host.
+func validateStatPath(path string, readAllowedPaths []string) error {
+	resolved := ResolvePath(path, "/")
+	if !IsUnderAllowedPaths(resolved, readAllowedPaths) {
+		return outsideAllowedPathsError(fmt.Sprintf("path %q", path), resolved, "readable_paths")
+	}
+	return nil
+}
+
+// outsideAllowedPathsError returns the error for what, a path that resolves
+// to resolved outside the allowed directories, suggesting adding its parent
+// directory to field, the config list that would allow it. The path is not
+// looked up, so the error does not reveal whether it exists, and nothing is
+// suggested for a broad or system directory such as /etc.
+func outsideAllowedPathsError(what, resolved, field string) error {
+	err := fmt.Errorf("%s resolves to %q which is outside allowed directories", what, resolved)
+	dir := filepath.Dir(resolved)
+	if isSystemPath(dir) {
+		return &ValidationError{Err: err}
+	}
+	return &ValidationError{Err: err, Suggestion: fmt.Sprintf("add %s to %s", dir, field)}
+}
+
+// isSystemPath reports whether dir is one of broadPaths, the user's home
+// directory, or under one of systemDirs. Unlike isBroadPath, it does not
+// resolve symlinks.
+func isSystemPath(dir string) bool {
+	if broadPaths[dir] {
+		return true
+	}
+	if home, _ := os.UserHomeDir(); home != "" && dir == filepath.Clean(home) {
+		return true
+	}
+	for _, sys := range systemDirs {
+		if dir == sys || strings.HasPrefix(dir, sys+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // isWriteFlag returns true if the open flags include any write-related bits.
 func isWriteFlag(flag int) bool {
 	const writeBits = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_APPEND | os.O_TRUNC
@@ -0,0 +1,217 @@
+package bash_sandboxed
+
+import (
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// cdDirs maps statements, commands and [[ ]] test clauses to the working
+// directory they run in, following literal cd commands, so that in
+// "cd sub && cat f" the path f is validated as sub/f. An empty directory
+// means it cannot be determined statically (e.g., after cd "$DIR" or a cd
+// inside a loop); relative paths there are left to the runtime checks, which
+// see the interpreter's real directory.
+type cdDirs map[syntax.Node]string
+
+// trackCdDirs computes the working directory of every statement in f,
+// starting from workDir.
+func trackCdDirs(f *syntax.File, workDir string) cdDirs {
+	d := make(cdDirs)
+	d.stmts(f.Stmts, workDir)
+	return d
+}
+
+// dir returns the working directory of node, or workDir if the tracker did
+// not reach it.
+func (d cdDirs) dir(node syntax.Node, workDir string) string {
+	if dir, ok := d[node]; ok {
+		return dir
+	}
+	return workDir
+}
+
+// mergeDirs returns the directory after one of two control-flow paths ran:
+// the common directory if both agree, otherwise unknown.
+func mergeDirs(a, b string) string {
+	if a == b {
+		return a
+	}
+	return ""
+}
+
+func (d cdDirs) stmts(stmts []*syntax.Stmt, dir string) string {
+	for _, st := range stmts {
+		dir = d.stmt(st, dir)
+	}
+	return dir
+}
+
+// stmt records dir for st and returns the directory after it runs.
+func (d cdDirs) stmt(st *syntax.Stmt, dir string) string {
+	d[st] = dir
+	for _, rd := range st.Redirs {
+		d.words(rd, dir)
+	}
+	if st.Cmd == nil {
+		return dir
+	}
+	after := d.cmd(st.Cmd, dir)
+	if st.Background {
+		return dir // runs in a subshell
+	}
+	return after
+}
+
+func (d cdDirs) cmd(cmd syntax.Command, dir string) string {
+	switch c := cmd.(type) {
+	case *syntax.CallExpr:
+		d[c] = dir
+		d.words(c, dir)
+		if len(c.Args) > 0 && c.Args[0].Lit() == "cd" {
+			return cdTarget(c.Args[1:], dir)
+		}
+		return dir
+	case *syntax.BinaryCmd:
+		switch c.Op {
+		case syntax.AndStmt:
+			return d.stmt(c.Y, d.stmt(c.X, dir))
+		case syntax.OrStmt:
+			// Y runs only if X failed, possibly before X changed directory.
+			left := d.stmt(c.X, dir)
+			right := d.stmt(c.Y, mergeDirs(dir, left))
+			if terminates(c.Y) {
+				return left
+			}
+			return mergeDirs(left, right)
+		default:
+			// Pipeline elements run in subshells.
+			d.stmt(c.X, dir)
+			d.stmt(c.Y, dir)
+			return dir
+		}
+	case *syntax.Subshell:
+		d.stmts(c.Stmts, dir)
+		return dir
+	case *syntax.Block:
+		return d.stmts(c.Stmts, dir)
+	case *syntax.IfClause:
+		cond := d.stmts(c.Cond, dir)
+		then := d.stmts(c.Then, cond)
+		if c.Else == nil {
+			return mergeDirs(then, cond)
+		}
+		return mergeDirs(then, d.cmd(c.Else, cond))
+	case *syntax.WhileClause:
+		return d.loop(dir, func(dir string) string {
+			return d.stmts(c.Do, d.stmts(c.Cond, dir))
+		})
+	case *syntax.ForClause:
+		d.words(c.Loop, dir)
+		return d.loop(dir, func(dir string) string {
+			return d.stmts(c.Do, dir)
+		})
+	case *syntax.CaseClause:
+		d.words(c.Word, dir)
+		after := dir // no pattern matched
+		for _, item := range c.Items {
+			for _, pat := range item.Patterns {
+				d.words(pat, dir)
+			}
+			after = mergeDirs(after, d.stmts(item.Stmts, dir))
+		}
+		return after
+	case *syntax.TimeClause:
+		if c.Stmt == nil {
+			return dir
+		}
+		return d.stmt(c.Stmt, dir)
+	case *syntax.FuncDecl:
+		// The body runs when called; validate it against the directory at
+		// declaration, as before cd tracking.
+		d.stmt(c.Body, dir)
+		return dir
+	case *syntax.TestClause:
+		d[c] = dir
+		d.words(c, dir)
+		return dir
+	default:
+		d.words(c, dir)
+		return dir
+	}
+}
+
+// loop tracks a loop body. If one iteration changes directory, later
+// iterations start somewhere else, so the body is re-tracked from an unknown
+// directory.
+func (d cdDirs) loop(dir string, body func(dir string) string) string {
+	if after := body(dir); after == dir {
+		return dir
+	}
+	body("")
+	return ""
+}
+
+// words tracks command and process substitutions nested anywhere in node.
+// They run in a subshell starting in dir.
+func (d cdDirs) words(node syntax.Node, dir string) {
+	if node == nil {
+		return
+	}
+	syntax.Walk(node, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.CmdSubst:
+			d.stmts(n.Stmts, dir)
+			return false
+		case *syntax.ProcSubst:
+			d.stmts(n.Stmts, dir)
+			return false
+		}
+		return true
+	})
+}
+
+// cdTarget returns the directory after "cd args..." run in dir, or unknown
+// if the target is not a literal (cd "$X", cd ~, cd -, or cd with no
+// arguments, which goes to $HOME).
+func cdTarget(args []*syntax.Word, dir string) string {
+	var target string
+	endOfOptions := false
+	for _, arg := range args {
+		lit := arg.Lit()
+		if lit == "" {
+			return ""
+		}
+		if !endOfOptions && lit == "--" {
+			endOfOptions = true
+			continue
+		}
+		if !endOfOptions && lit != "-" && strings.HasPrefix(lit, "-") {
+			continue // -L, -P
+		}
+		target = lit
+		break
+	}
+	if target == "" || target == "-" || strings.HasPrefix(target, "~") {
+		return ""
+	}
+	if filepath.IsAbs(target) {
+		return filepath.Clean(target)
+	}
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, target)
+}
+
+// terminates reports whether st always leaves the current script or
+// function (exit or return), so nothing after it runs.
+func terminates(st *syntax.Stmt) bool {
+	ce, ok := st.Cmd.(*syntax.CallExpr)
+	if !ok || len(ce.Args) == 0 {
+		return false
+	}
+	name := ce.Args[0].Lit()
+	return name == "exit" || name == "return"
+}
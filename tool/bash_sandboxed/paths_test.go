@@ -2,11 +2,19 @@ package bash_sandboxed
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/gartnera/lite-sandbox/config"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func TestValidateRedirectPaths_Allowed(t *testing.T) {
@@ -27,6 +35,12 @@ func TestValidateRedirectPaths_Allowed(t *testing.T) {
 		{"clobber to local file", "echo hello >| output.txt"},
 		{"all to local file", "echo hello &> output.txt"},
 		{"append all to local file", "echo hello &>> output.txt"},
+		// Redirects on compound commands apply to the whole block
+		{"block output to local file", "{ echo a; echo b; } > out.txt"},
+		{"loop output to local file", "for i in 1 2; do echo $i; done > out.txt"},
+		{"subshell output to /dev/null", "(echo a) > /dev/null"},
+		{"block input from local file", "{ cat; } < input.txt"},
+		{"block redirect after cd", "mkdir -p sub && cd sub && { cd ..; echo a; } > ../out.txt"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -56,6 +70,16 @@ func TestValidateRedirectPaths_Blocked(t *testing.T) {
 		{"output redirect traversal", "echo hello > ../../../tmp/evil", "outside allowed directories"},
 		{"all redirect outside", "echo hello &> /tmp/evil", "outside allowed directories"},
 		{"output redirect to .git", "echo hello > .git/config", "accesses .git directory"},
+		{"block output outside", "{ echo a; } > /tmp/evil", "outside allowed directories"},
+		{"block input outside", "{ cat; } < /etc/passwd", "outside allowed directories"},
+		{"for loop output outside", "for i in 1; do echo $i; done > /etc/x", "outside allowed directories"},
+		{"while loop output outside", "while false; do echo a; done > /etc/x", "outside allowed directories"},
+		{"if output outside", "if true; then echo a; fi > /tmp/evil", "outside allowed directories"},
+		{"case output outside", "case a in a) echo a;; esac > /tmp/evil", "outside allowed directories"},
+		{"subshell output outside", "(echo a) > /tmp/evil", "outside allowed directories"},
+		{"function body output outside", "f() { echo a; } > /tmp/evil", "outside allowed directories"},
+		{"block stderr outside", "{ echo a; } 2> /tmp/evil", "outside allowed directories"},
+		{"piped block output outside", "echo a | { cat; } > /tmp/evil", "outside allowed directories"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -137,6 +161,32 @@ func TestBashSandboxed_RedirectPathBlocked(t *testing.T) {
 	}
 }
 
+// TestBashSandboxed_BlockRedirects checks that redirects on compound commands
+// are enforced when the block runs, including targets only known at runtime.
+func TestBashSandboxed_BlockRedirects(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+
+	out, err := NewSandbox().Execute(context.Background(), "{ echo a; echo b; } > out.txt; (echo c) > /dev/null; cat out.txt", workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "a\nb\n" {
+		t.Fatalf("expected block output in out.txt, got %q", out)
+	}
+
+	for _, command := range []string{
+		"d=/tmp; { echo a; } > $d/evil",
+		"d=/etc; for i in 1; do echo $i; done > $d/x",
+		"f=/etc/passwd; { cat; } < $f",
+	} {
+		_, err := NewSandbox().Execute(context.Background(), command, workDir, paths, paths)
+		if err == nil || !strings.Contains(err.Error(), "outside allowed directories") {
+			t.Fatalf("%s: expected outside allowed directories error, got %v", command, err)
+		}
+	}
+}
+
 func TestExtractPathFromFlag(t *testing.T) {
 	tests := []struct {
 		flag     string
@@ -195,6 +245,8 @@ func TestValidatePaths_Allowed(t *testing.T) {
 		{"short flag no value", "ls -la"},
 		{"long flag no value", "grep --count pattern"},
 		{"long flag with eq local path", "grep --file=" + workDir + "/file.txt pattern"},
+		{"xargs arg file", "xargs -a ./file.txt cat"},
+		{"timeout wrapped command", "timeout --signal=KILL 5 grep p file.txt"},
 		{"short flag with local path", "cat -n ./file.txt"},
 	}
 	for _, tt := range tests {
@@ -248,6 +300,17 @@ func TestValidatePaths_Blocked(t *testing.T) {
 		{"ln outside target", "ln -s /etc/passwd link", "outside allowed directories"},
 		{"ln outside link", "ln -s target /tmp/link", "outside allowed directories"},
 		{"sed outside", "sed -i 's/a/b/' /etc/passwd", "outside allowed directories"},
+		{"install outside dest", "install a /etc/b", "outside allowed directories"},
+		{"install outside src", "install -m 644 /etc/passwd b", "outside allowed directories"},
+		{"install -t outside", "install -t /tmp/evil a", "outside allowed directories"},
+		{"install attached -t outside", "install -t/tmp/evil a", "outside allowed directories"},
+		{"install --target-directory outside", "install --target-directory=/tmp/evil a", "outside allowed directories"},
+		{"man local file outside", "man -l /etc/passwd", "outside allowed directories"},
+		{"man local file long flag", "man --local-file /etc/passwd", "outside allowed directories"},
+		{"xargs arg file outside", "xargs -a /etc/passwd cat", "outside allowed directories"},
+		{"xargs arg file long flag", "xargs --arg-file=/etc/passwd cat", "outside allowed directories"},
+		{"timeout wrapped path outside", "timeout 5 cat /etc/passwd", "outside allowed directories"},
+		{"env chdir outside", "env -C /etc cat passwd", "outside allowed directories"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -346,6 +409,42 @@ func TestValidatePaths_MultipleAllowedPaths(t *testing.T) {
 	}
 }
 
+func TestRelativeDirsRejected(t *testing.T) {
+	dir := t.TempDir()
+	abs := []string{dir}
+	tests := []struct {
+		name       string
+		workDir    string
+		readPaths  []string
+		writePaths []string
+		errMsg     string
+	}{
+		{"relative workDir", "subdir", abs, abs, `working directory must be an absolute path, got "subdir"`},
+		{"empty workDir", "", abs, abs, `working directory must be an absolute path, got ""`},
+		{"relative read path", dir, []string{dir, "."}, abs, `read-allowed path must be absolute, got "."`},
+		{"relative write path", dir, abs, []string{"out"}, `write-allowed path must be absolute, got "out"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSandbox()
+			err := s.ValidateCommand("echo hi", tt.workDir, tt.readPaths, tt.writePaths)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("ValidateCommand: expected error containing %q, got %v", tt.errMsg, err)
+			}
+			out, err := s.Execute(context.Background(), "echo hi", tt.workDir, tt.readPaths, tt.writePaths)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("Execute: expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if out != "" {
+				t.Fatalf("Execute: expected no output, got %q", out)
+			}
+		})
+	}
+	if err := validateDirs(dir, abs, abs); err != nil {
+		t.Fatalf("expected absolute paths to pass, got %v", err)
+	}
+}
+
 func TestValidatePaths_InSubshellAndPipeline(t *testing.T) {
 	workDir := t.TempDir()
 
@@ -436,6 +535,113 @@ func TestBashSandboxed_VariableExpansionAllowed(t *testing.T) {
 	}
 }
 
+func TestBashSandboxed_ChecksumManifest(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	os.WriteFile(filepath.Join(workDir, "a.txt"), []byte("a\n"), 0o644)
+	os.Symlink("/etc/passwd", filepath.Join(workDir, "link"))
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := strings.Repeat("0", 64)
+	write("outside.txt", hash+"  /etc/passwd\n")
+	write("bsd.txt", "SHA256 (/etc/passwd) = "+hash+"\n")
+	write("binary.txt", hash+" */etc/passwd\n")
+	write("symlink.txt", hash+"  link\n")
+	write("git.txt", hash+"  .git/config\n")
+	// sha256sum stops the name at the NUL and opens ../x; the whole line
+	// would resolve to a.txt.
+	write("nul.txt", hash+"  ../x\x00/../"+filepath.Base(workDir)+"/a.txt\n")
+	os.Symlink("/etc/hostname", filepath.Join(workDir, "mlink"))
+
+	out, err := NewSandbox().Execute(context.Background(), "sha256sum a.txt > m.txt && sha256sum -c m.txt", workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "a.txt: OK\n" {
+		t.Fatalf("expected a.txt: OK, got %q", out)
+	}
+
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{"sha256sum -c /etc/manifest", "outside allowed directories"},
+		{"sha256sum -c outside.txt", `outside.txt lists "/etc/passwd"`},
+		{"sha256sum --check bsd.txt", `bsd.txt lists "/etc/passwd"`},
+		{"sha256sum -c binary.txt", `binary.txt lists "/etc/passwd"`},
+		{"sha256sum -c symlink.txt", `symlink.txt lists "link"`},
+		{"sha256sum -c git.txt", ".git directory"},
+		{"m=outside.txt; md5sum -c $m", `outside.txt lists "/etc/passwd"`},
+		{"timeout 5 sha256sum -c outside.txt", `outside.txt lists "/etc/passwd"`},
+		{"m=-; sha256sum -c $m < outside.txt", "from standard input is not allowed"},
+		{"sha256sum -c mlink", "outside allowed directories"},
+		{"sha256sum -c nul.txt", `nul.txt lists "../x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			_, err := NewSandbox().Execute(context.Background(), tt.command, workDir, paths, paths)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestBashSandboxed_ChecksumCommands(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	os.WriteFile(filepath.Join(workDir, "a.txt"), []byte("a\n"), 0o644)
+
+	// hash is the command that writes a manifest; cksum needs an algorithm
+	// for its output to be checkable.
+	for _, hash := range []string{"sha256sum", "sha1sum", "md5sum", "b2sum", "cksum -a sha256", "sha256sum --tag"} {
+		cmd := strings.Fields(hash)[0]
+		t.Run(hash, func(t *testing.T) {
+			out, err := NewSandbox().Execute(context.Background(), hash+" a.txt", workDir, paths, paths)
+			if err != nil || !strings.Contains(out, "a.txt") {
+				t.Fatalf("hashing: got %q, %v", out, err)
+			}
+			out, err = NewSandbox().Execute(context.Background(), hash+" a.txt > m.txt && "+cmd+" -c m.txt", workDir, paths, paths)
+			if err != nil || out != "a.txt: OK\n" {
+				t.Fatalf("in-bounds manifest: got %q, %v", out, err)
+			}
+			_, err = NewSandbox().Execute(context.Background(), cmd+" --check /etc/manifest", workDir, paths, paths)
+			if err == nil || !strings.Contains(err.Error(), "outside allowed directories") {
+				t.Fatalf("out-of-bounds manifest: expected outside allowed directories error, got %v", err)
+			}
+			_, err = NewSandbox().Execute(context.Background(), "sed 's|a.txt|/etc/passwd|' m.txt > bad.txt && "+cmd+" -c bad.txt", workDir, paths, paths)
+			if err == nil || !strings.Contains(err.Error(), `bad.txt lists "/etc/passwd"`) {
+				t.Fatalf("manifest listing an outside file: expected error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestChecksumManifestNames(t *testing.T) {
+	hash := "d41d8cd98f00b204e9800998ecf8427e"
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{hash + "  a.txt", []string{" a.txt", "a.txt"}},
+		{hash + " *a.txt", []string{"*a.txt", "a.txt"}},
+		{hash + " a.txt", []string{"a.txt"}},
+		{"MD5 (a.txt) = " + hash, []string{"a.txt"}},
+		{`\` + hash + `  a\nb\\c`, []string{" a\nb\\c", "a\nb\\c"}},
+		{hash + "  ..\r", []string{" ..\r", " ..", "..\r", ".."}},
+		{"# comment", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := checksumManifestNames(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("checksumManifestNames(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
 func TestValidatePaths_ReadWriteSeparation(t *testing.T) {
 	workDir := t.TempDir()
 	extraReadDir := t.TempDir()
@@ -644,3 +850,1057 @@ func TestBashSandboxed_ReadWriteSeparation_Integration(t *testing.T) {
 		}
 	})
 }
+
+func TestValidatePaths_SortUniqOutputFiles(t *testing.T) {
+	workDir := t.TempDir()
+	extraReadDir := t.TempDir()
+
+	readPaths := []string{workDir, extraReadDir}
+	writePaths := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"sort -o in workdir", "sort -o out.txt in.txt", false},
+		{"sort --output= in workdir", "sort --output=" + workDir + "/out.txt in.txt", false},
+		{"sort reading read-only path", "sort " + extraReadDir + "/in.txt", false},
+		{"sort -o outside allowed", "sort -o /etc/x in.txt", true},
+		{"sort -o to read-only path", "sort -o " + extraReadDir + "/out.txt in.txt", true},
+		{"sort -ro to read-only path", "sort -ro " + extraReadDir + "/out.txt in.txt", true},
+		{"sort --output to read-only path", "sort --output " + extraReadDir + "/out.txt in.txt", true},
+		{"uniq single input from read-only path", "uniq " + extraReadDir + "/in.txt", false},
+		{"uniq output in workdir", "uniq in.txt out.txt", false},
+		{"uniq output outside allowed", "uniq in.txt /etc/out", true},
+		{"uniq output to read-only path", "uniq in.txt " + extraReadDir + "/out.txt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, readPaths, writePaths)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+// TestBashSandboxed_PipelineReadWriteStages checks that each stage of a
+// pipeline is validated against its own path set: a read stage against the
+// readable paths and a write stage against the writable paths, both before
+// and during execution.
+func TestBashSandboxed_PipelineReadWriteStages(t *testing.T) {
+	workDir := t.TempDir()
+	readDir := t.TempDir()
+	writeDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(readDir, "in.txt"), []byte("b\na\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(writeDir, "in.txt"), []byte("d\nc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	readPaths := []string{workDir, readDir, writeDir}
+	writePaths := []string{workDir, writeDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"read stage then write stage", "cat " + readDir + "/in.txt | sort -o " + writeDir + "/out.txt", false},
+		{"read stage then redirect", "cat " + readDir + "/in.txt | sort > " + writeDir + "/out.txt", false},
+		{"read stage then uniq output", "cat " + readDir + "/in.txt | uniq - " + writeDir + "/out.txt", false},
+		{"write stage then read stage", "sort -o " + writeDir + "/out.txt " + writeDir + "/in.txt | cat " + readDir + "/in.txt", false},
+		{"write stage into read-only path", "cat " + writeDir + "/in.txt | sort -o " + readDir + "/out.txt", true},
+		{"redirect into read-only path", "cat " + readDir + "/in.txt | sort > " + readDir + "/out.txt", true},
+		{"uniq output into read-only path", "cat " + writeDir + "/in.txt | uniq - " + readDir + "/out.txt", true},
+		{"read stage outside allowed", "cat /etc/passwd | sort -o " + writeDir + "/out.txt", true},
+	}
+	s := NewSandbox()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, readPaths, writePaths)
+			if err == nil {
+				err = validateRedirectPaths(f, workDir, readPaths, writePaths)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validate %q: expected error %v, got %v", tt.command, tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			// Check the runtime path too, where each stage runs through the
+			// CallHandler with its expanded arguments.
+			os.Remove(filepath.Join(writeDir, "out.txt"))
+			if _, err := s.Execute(context.Background(), tt.command, workDir, readPaths, writePaths); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(writeDir, "out.txt")); err != nil {
+				t.Errorf("expected the write stage to create out.txt: %v", err)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(readDir, "out.txt")); err == nil {
+		t.Error("expected nothing to be written to the read-only path")
+	}
+}
+
+func TestValidatePaths_EndOfOptions(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"operand after -- resolved in workdir", "cat -- -notes.txt", false},
+		{"grep flag-like pattern after --", "grep -- -f pattern", false},
+		{"absolute operand after --", "cat -- /etc/passwd", true},
+		{"operand escaping workdir after --", "cat -- -x/../../../etc/passwd", true},
+		{"embedded flag path still checked after --", "cat -- -f/etc/passwd", true},
+		{".git operand after --", "cat -- .git/config", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestValidatePaths_FormatterPositionalArgs(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+
+	commands := []string{"pr", "fold", "expand", "unexpand", "nl", "tac", "rev", "column", "paste"}
+	for _, cmd := range commands {
+		t.Run(cmd+" blocked outside allowed", func(t *testing.T) {
+			f, err := ParseBash(cmd + " /etc/passwd")
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if err == nil {
+				t.Fatalf("expected %s /etc/passwd to be blocked", cmd)
+			}
+			if !strings.Contains(err.Error(), "outside allowed directories") {
+				t.Fatalf("expected outside allowed directories error, got %q", err.Error())
+			}
+		})
+		t.Run(cmd+" blocked after other operands", func(t *testing.T) {
+			f, err := ParseBash(cmd + " ./a.txt ../../../etc/passwd")
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := validatePaths(f, workDir, allowed, allowed); err == nil {
+				t.Fatalf("expected %s with escaping operand to be blocked", cmd)
+			}
+		})
+		t.Run(cmd+" allowed in workdir", func(t *testing.T) {
+			f, err := ParseBash(cmd + " ./a.txt")
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := validatePaths(f, workDir, allowed, allowed); err != nil {
+				t.Fatalf("expected %s ./a.txt to be allowed, got: %v", cmd, err)
+			}
+		})
+	}
+}
+
+func TestBashSandboxed_LsRecursiveStaysInBoundary(t *testing.T) {
+	workDir := t.TempDir()
+	outsideDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outsideDir, "outside-secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(workDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "sub", "inside.txt"), []byte("ok"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideDir, filepath.Join(workDir, "sub", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{workDir}
+	s := newTestSandbox()
+
+	output, err := s.Execute(context.Background(), "ls -R .", workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(output, "inside.txt") || !strings.Contains(output, "escape") {
+		t.Fatalf("expected recursive listing of workdir, got %q", output)
+	}
+	if strings.Contains(output, "outside-secret.txt") {
+		t.Fatalf("ls -R followed symlink outside the boundary: %q", output)
+	}
+
+	if _, err := s.Execute(context.Background(), "ls -RL .", workDir, paths, paths); err == nil {
+		t.Fatal("expected ls -RL to be blocked")
+	}
+	if _, err := s.Execute(context.Background(), "ls sub/escape", workDir, paths, paths); err == nil {
+		t.Fatal("expected ls on symlink to outside dir to be blocked")
+	}
+}
+
+func TestBashSandboxed_DereferenceStaysInBoundary(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "inside.txt"), []byte("ok"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(workDir, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("inside.txt", filepath.Join(workDir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{workDir}
+	s := newTestSandbox()
+
+	output, err := s.Execute(context.Background(), "du -a .", workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(output, "./etc\n") || strings.Contains(output, "./etc/") {
+		t.Fatalf("expected du to list the symlink without traversing it, got %q", output)
+	}
+
+	// stat, file and du -D only dereference their operands, which path
+	// validation resolves.
+	for _, command := range []string{"du -L .", "du -aL .", "du --dereference .", "du -D ./etc", "stat -L etc/hostname", "file -L etc/hostname", "f=-L; du -a $f ."} {
+		if _, err := s.Execute(context.Background(), command, workDir, paths, paths); err == nil {
+			t.Errorf("expected %q to be blocked", command)
+		}
+	}
+	// Options read by xargs are passed after "--", as operands.
+	output, _ = s.Execute(context.Background(), "echo -L | xargs du -a", workDir, paths, paths)
+	if strings.Contains(output, "./etc/") {
+		t.Errorf("expected du run by xargs not to traverse the symlink, got %q", output)
+	}
+	for _, command := range []string{"stat -L link.txt", "file -L link.txt", "du -D ./link.txt"} {
+		_, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		var cmdErr *CommandFailedError
+		if err != nil && !errors.As(err, &cmdErr) {
+			t.Errorf("expected %q to pass validation, got: %v", command, err)
+		}
+	}
+}
+
+func TestPathResolver_MatchesResolvePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	mustMkdir := func(p string) {
+		if err := os.MkdirAll(filepath.Join(root, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustSymlink := func(target, p string) {
+		if err := os.Symlink(target, filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustMkdir("a/b/c")
+	if err := os.WriteFile(filepath.Join(root, "a/b/file.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mustSymlink(outside, "a/out")
+	mustSymlink("b", "a/rel")
+	mustSymlink("rel/c", "a/chain")
+	mustSymlink(filepath.Join(root, "missing"), "a/dangling")
+	mustSymlink("loop2", "a/loop1")
+	mustSymlink("loop1", "a/loop2")
+
+	paths := []string{
+		"a", "a/b", "a/b/c", "a/b/file.txt", "a/b/file.txt/x",
+		"a/out", "a/out/secret", "a/out/../etc",
+		"a/rel", "a/rel/c", "a/rel/file.txt", "a/chain", "a/chain/new.txt",
+		"a/dangling", "a/dangling/x", "a/loop1", "a/loop1/x",
+		"missing", "missing/deep/path", "a/b/../../a/rel/./c",
+		"../", "/", "/etc/passwd", outside, root,
+	}
+	r := newPathResolver()
+	for _, p := range paths {
+		want := ResolvePath(p, root)
+		// Resolve twice so the second lookup is served from the cache.
+		for i := 0; i < 2; i++ {
+			if got := r.resolve(p, root); got != want {
+				t.Errorf("resolve(%q) = %q, want %q", p, got, want)
+			}
+		}
+		allowed := []string{root}
+		if got, want := r.isUnderAllowedPaths(want, allowed), IsUnderAllowedPaths(want, allowed); got != want {
+			t.Errorf("isUnderAllowedPaths(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+// countFSLookups wraps evalSymlinks and lstat to count calls until the
+// returned restore function is called.
+func countFSLookups(count *int) (restore func()) {
+	origEval, origLstat := evalSymlinks, lstat
+	evalSymlinks = func(p string) (string, error) {
+		*count++
+		return origEval(p)
+	}
+	lstat = func(p string) (os.FileInfo, error) {
+		*count++
+		return origLstat(p)
+	}
+	return func() { evalSymlinks, lstat = origEval, origLstat }
+}
+
+func benchmarkManyFileArgs(b *testing.B, validate func(f *syntax.File, workDir string, paths []string) error) {
+	workDir := b.TempDir()
+	dir := filepath.Join(workDir, "src", "pkg", "internal")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	var sb strings.Builder
+	sb.WriteString("grep x")
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("file%d.go", i)
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			b.Fatal(err)
+		}
+		sb.WriteString(" ./src/pkg/internal/" + name)
+	}
+	f, err := ParseBash(sb.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	paths := []string{workDir}
+
+	lookups := 0
+	restore := countFSLookups(&lookups)
+	defer restore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := validate(f, workDir, paths); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(lookups)/float64(b.N), "fs-lookups/op")
+}
+
+func BenchmarkValidatePaths_ManyFileArgs(b *testing.B) {
+	benchmarkManyFileArgs(b, func(f *syntax.File, workDir string, paths []string) error {
+		return validatePaths(f, workDir, paths, paths)
+	})
+}
+
+func BenchmarkValidatePaths_ManyFileArgsUncached(b *testing.B) {
+	benchmarkManyFileArgs(b, func(f *syntax.File, workDir string, paths []string) error {
+		for _, arg := range f.Stmts[0].Cmd.(*syntax.CallExpr).Args[1:] {
+			resolved := ResolvePath(arg.Lit(), workDir)
+			if !IsUnderAllowedPaths(resolved, paths) {
+				return fmt.Errorf("%q outside allowed", resolved)
+			}
+		}
+		return nil
+	})
+}
+
+func TestValidatePaths_FileTests(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"[ -f outside", "[ -f /etc/passwd ]", true},
+		{"[ -f inside", "[ -f ./ok ]", false},
+		{"test -r outside", "test -r /etc/shadow", true},
+		{"[[ -d outside", "[[ -d /root ]]", true},
+		{"[[ -e inside", "[[ -e ./ok ]]", false},
+		{"[[ -e relative escape", "[[ -e ../../etc ]]", true},
+		{"[[ -L in compound expr", "[[ -n x && ! -L /etc/alternatives ]]", true},
+		{"[[ -nt outside operand", "[[ ./a -nt /etc/passwd ]]", true},
+		{"[[ -ef inside", "[[ ./a -ef ./b ]]", false},
+		{"[[ string test not a file", "[[ -n /etc/passwd ]]", false},
+		{"[[ pattern compare not a file", "[[ $p == /etc/* ]]", false},
+		{"[[ .git", "[[ -d .git ]]", true},
+		{"[[ in if condition", "if [[ -f /etc/passwd ]]; then echo y; fi", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestBashSandboxed_FileTestsRuntime(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "ok"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{workDir}
+	s := newTestSandbox()
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr bool
+	}{
+		{"[[ -e inside", "[[ -e ./ok ]] && echo yes || echo no", "yes\n", false},
+		// [[ is evaluated by the interpreter; StatHandler makes the outside
+		// path look missing.
+		{"[[ -e dynamic outside", "p=/etc/passwd; [[ -e $p ]] && echo yes || echo no", "no\n", false},
+		// [ is a builtin call, so its expanded operands are rejected by the
+		// CallHandler path check.
+		{"[ -f dynamic outside", "p=/etc/passwd; [ -f \"$p\" ] && echo yes || echo no", "", true},
+		{"[ -d dynamic inside", "p=.; [ -d \"$p\" ] && echo yes || echo no", "yes\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := s.Execute(context.Background(), tt.command, workDir, paths, paths)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected %q to be blocked, got output %q", tt.command, output)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestValidatePaths_Stat(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"file in workdir", "stat file.txt", false},
+		{"outside file", "stat /etc/hostname", true},
+		{"format flag with =", "stat --format=%s file.txt", false},
+		{"format flag separate", "stat --format %s file.txt", false},
+		{"short format flag", "stat -c %n file.txt", false},
+		{"short format glued", "stat -c%s file.txt", false},
+		{"printf with escapes", `stat --printf='%n\n' file.txt`, false},
+		{"format containing slash", "stat --format=%n/%s file.txt", false},
+		{"format with leading slash", "stat -c /%n file.txt", false},
+		{"printf with leading slash", "stat --printf=/%n file.txt", false},
+		{"format in short cluster", "stat -Lc /%n file.txt", false},
+		{"dynamic format value", "stat -c \"$fmt\" /etc/passwd", true},
+		{"format value does not hide next operand", "stat --format /%n /etc/passwd", true},
+		{"format flag of other command still checked", "ls -c/etc", true},
+		{"filesystem stat of workdir", "stat -f .", false},
+		{"filesystem stat long flag", "stat --file-system .", false},
+		{"filesystem stat of root", "stat -f /", true},
+		{"root", "stat /", true},
+		{"dereference outside", "stat -L /etc/passwd", true},
+		{"outside after format", "stat -c %s /etc/passwd", true},
+		{"outside after end of options", "stat -- /etc/passwd", true},
+		{"dash path after end of options", "stat -- -c/etc/passwd", true},
+		{".git", "stat .git/config", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestValidateExpandedPaths_StatFormat(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"format with leading slash", []string{"stat", "-c", "/%n", "file.txt"}, false},
+		{"printf attached", []string{"stat", "--printf=/%n\n", "file.txt"}, false},
+		{"outside operand", []string{"stat", "-c", "/%n", "/etc/passwd"}, true},
+		{"format after end of options", []string{"stat", "--", "-c/etc/passwd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExpandedPaths(tt.args, workDir, allowed, allowed, nil)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.args)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.args, err)
+			}
+		})
+	}
+}
+
+func TestValidatePaths_FindReferenceFiles(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+	if err := os.WriteFile(filepath.Join(workDir, "ref"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(workDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"newer outside", "find . -newer /etc/passwd", true},
+		{"samefile outside", "find . -samefile /etc/shadow", true},
+		{"newer relative", "find . -newer ./ref", false},
+		{"newer bare name", "find . -newer ref", false},
+		{"newer missing file", "find . -newer missing", false},
+		{"newer symlink out", "find . -newer link", true},
+		{"anewer symlink out", "find . -anewer link", true},
+		{"cnewer symlink out", "find . -cnewer link", true},
+		{"samefile symlink out", "find . -samefile link", true},
+		{"newerXY symlink out", "find . -newermc link", true},
+		{"newerXY bare name", "find . -newerBm ref", false},
+		{"newermt date", "find . -newermt 2024-01-01", false},
+		{"newer .git", "find . -newer .git", true},
+		{"name is not a file", "find . -name link", false},
+		{"other command", "ls -newer link", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+			args := strings.Fields(tt.command)
+			err = validateExpandedPaths(args, workDir, allowed, allowed, nil)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked at runtime", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed at runtime, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestValidatePaths_PatternFiles(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+	if err := os.WriteFile(filepath.Join(workDir, "pat"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(workDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"grep outside", "grep -f /etc/patterns x", true},
+		{"grep relative", "grep -f ./pat x", false},
+		{"grep bare name", "grep -f pat x", false},
+		{"grep symlink out", "grep -f link x", true},
+		{"grep attached symlink out", "grep -flink x", true},
+		{"grep grouped symlink out", "grep -xf link x", true},
+		{"grep grouped attached symlink out", "grep -xflink x", true},
+		{"grep long symlink out", "grep --file link x", true},
+		{"grep long attached symlink out", "grep --file=link x", true},
+		{"egrep symlink out", "egrep -f link x", true},
+		{"fgrep symlink out", "fgrep -f link x", true},
+		{"grep pattern named like f", "grep -e f link", false},
+		{"grep value option before f", "grep -ef link", false},
+		{"grep .git", "grep -f .git/config x", true},
+		{"rg relative", "rg -f ./pat", false},
+		{"rg symlink out", "rg -f link", true},
+		{"rg long attached symlink out", "rg --file=link", true},
+		{"rg glob is not a file", "rg -g link x", false},
+		{"rg ignore file outside", "rg --ignore-file /etc/x y", true},
+		{"rg ignore file symlink out", "rg --ignore-file link y", true},
+		{"rg attached ignore file symlink out", "rg --ignore-file=link y", true},
+		{"rg ignore file relative", "rg --ignore-file ./pat y", false},
+		{"bc outside", "bc -f /etc/x", true},
+		{"bc relative", "bc -f ./calc.bc", false},
+		{"bc symlink out", "bc -f link", true},
+		{"bc long attached symlink out", "bc --file=link", true},
+		{"bc operand outside", "bc -l /etc/x", true},
+		{"bc expression", "bc -e 1+1", false},
+		{"bc expression with slash", "bc -e /etc/x", false},
+		{"bc long expression with slash", "bc --expression=/etc/x", false},
+		{"other command", "sort -f link", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+			args := strings.Fields(tt.command)
+			err = validateExpandedPaths(args, workDir, allowed, allowed, nil)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked at runtime", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed at runtime, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestValidatePaths_CommOperands(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+	if err := os.Symlink("/etc/passwd", filepath.Join(workDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"files in workdir", "comm file1 file2", false},
+		{"files outside", "comm /etc/a /etc/b", true},
+		{"second file outside", "comm file1 /etc/b", true},
+		{"suppress columns", "comm -12 file1 file2", false},
+		{"separate column flags", "comm -1 -2 -3 file1 file2", false},
+		{"stdin", "comm - file2", false},
+		{"stdin second", "comm -12 file1 -", false},
+		{"symlink out", "comm link file2", true},
+		{"symlink out after stdin", "comm - link", true},
+		{"symlink out after --", "comm -- file1 link", true},
+		{"flag-like name after --", "comm -- -1 file2", false},
+		{"check order", "comm --check-order file1 /etc/b", true},
+		{"output delimiter with slash", "comm --output-delimiter=/ file1 file2", false},
+		{"separate output delimiter with slash", "comm --output-delimiter / file1 file2", false},
+		{"other command bare name", "cut -f1 link", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = validatePaths(f, workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed, got: %v", tt.command, err)
+			}
+			args := strings.Fields(tt.command)
+			err = validateExpandedPaths(args, workDir, allowed, allowed, nil)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q to be blocked at runtime", tt.command)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q to be allowed at runtime, got: %v", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestBashSandboxed_CommStdin(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	if err := os.WriteFile(filepath.Join(workDir, "f2"), []byte("a\nb\nc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := NewSandbox().Execute(context.Background(), "printf 'b\\nc\\nd\\n' | comm -12 - f2", workDir, paths, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "b\nc\n" {
+		t.Errorf("expected the lines common to stdin and f2, got %q", out)
+	}
+}
+
+// TestBashSandboxed_Rg runs rg in a git repository, checking that
+// --hidden and -u do not search .git, and that pattern files and
+// --search-zip work when allowed.
+func TestBashSandboxed_Rg(t *testing.T) {
+	if _, err := exec.LookPath("rg"); err != nil {
+		t.Skip("rg not installed")
+	}
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	for name, data := range map[string]string{
+		".git/token": "secret\n",
+		".hidden":    "secret\n",
+		"pat":        "secret\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(workDir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewSandbox()
+
+	for _, command := range []string{"rg --hidden secret", "rg -uuu secret .", "rg --hidden -g '*' secret", "rg --hidden --files", "timeout 5 rg --hidden secret", "echo . | xargs rg --hidden secret"} {
+		out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", command, err)
+		}
+		if strings.Contains(out, ".git") {
+			t.Errorf("%s: expected .git not to be searched, got %q", command, out)
+		}
+		if !strings.Contains(out, ".hidden") {
+			t.Errorf("%s: expected other hidden files to be searched, got %q", command, out)
+		}
+	}
+	if _, err := s.Execute(context.Background(), "rg secret .git", workDir, paths, paths); err == nil || !strings.Contains(err.Error(), ".git") {
+		t.Errorf("expected rg on .git to be blocked, got %v", err)
+	}
+
+	out, err := s.Execute(context.Background(), "rg --hidden -f ./pat .hidden", workDir, paths, paths)
+	if err != nil || out != "secret\n" {
+		t.Errorf("expected rg -f ./pat to search with the pattern file, got %q and %v", out, err)
+	}
+
+	if _, err := s.Execute(context.Background(), "rg -z x file.gz", workDir, paths, paths); err == nil || !strings.Contains(err.Error(), "rg --search-zip runs gzip") {
+		t.Errorf("expected rg -z to be blocked while its decompressors are not allowed, got %v", err)
+	}
+	if _, err := exec.LookPath("gzip"); err != nil {
+		return
+	}
+	s.UpdateConfig(&config.Config{ExtraCommands: rgDecompressors}, workDir)
+	if _, err := s.Execute(context.Background(), "printf 'x\\n' > file && gzip file", workDir, paths, paths); err != nil {
+		t.Fatal(err)
+	}
+	out, err = s.Execute(context.Background(), "rg -z x file.gz", workDir, paths, paths)
+	if err != nil || out != "x\n" {
+		t.Errorf("expected rg -z to search the compressed file, got %q and %v", out, err)
+	}
+}
+
+func TestValidatePaths_TailFollowOperands(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+	tests := []struct {
+		command string
+		wantErr bool
+	}{
+		{"tail -n 5 ./log", false},
+		{"tail -n 5 /var/log/syslog", true},
+		{"tail -f /var/log/syslog", true},
+		{"tail --follow=name ../../etc/passwd", true},
+	}
+	for _, tt := range tests {
+		f, err := ParseBash(tt.command)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = validatePaths(f, workDir, allowed, allowed)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%q: wantErr %v, got %v", tt.command, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidatePaths_GitFileURL(t *testing.T) {
+	workDir := t.TempDir()
+	allowed := []string{workDir}
+	tests := []struct {
+		command string
+		wantErr bool
+	}{
+		{"git clone file:///etc", true},
+		{"git clone file://localhost/etc/repo", true},
+		{"git fetch file:///root/repo main", true},
+		{"git clone file://" + workDir + "/repo copy", false},
+		{"git clone /etc", true},
+		{"git clone https://host/repo", false},
+		{"cat file:///etc/passwd", false}, // only git treats file:// as a URL
+	}
+	for _, tt := range tests {
+		f, err := ParseBash(tt.command)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = validatePaths(f, workDir, allowed, allowed)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%q: wantErr %v, got %v", tt.command, tt.wantErr, err)
+		}
+	}
+	if err := validateExpandedPaths([]string{"git", "clone", "file:///etc"}, workDir, allowed, allowed, nil); err == nil {
+		t.Error("expected expanded file:// URL outside allowed paths to be blocked")
+	}
+}
+
+func TestBashSandboxed_ReadonlySubpaths(t *testing.T) {
+	workDir := t.TempDir()
+	for _, dir := range []string{"vendor/lib", "node_modules/pkg", "src"} {
+		if err := os.MkdirAll(filepath.Join(workDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "vendor", "lib", "a.go"), []byte("package lib\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "src", "b.go"), []byte("package src\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{ReadonlySubpaths: []string{"vendor/**", "node_modules/**"}}, workDir)
+	paths := []string{workDir}
+
+	tests := []struct {
+		name    string
+		command string
+		blocked bool
+	}{
+		{"read vendor file", "cat vendor/lib/a.go", false},
+		{"grep vendor", "grep -r package vendor", false},
+		{"copy out of vendor", "cp vendor/lib/a.go src/x.go", false},
+		{"sed without -i reads vendor", "sed s/lib/x/ vendor/lib/a.go", false},
+		{"write src", "echo x > src/x", false},
+		{"touch src", "touch src/y", false},
+		{"redirect into vendor", "echo x > vendor/x", true},
+		{"append into vendor", "echo x >> vendor/lib/a.go", true},
+		{"touch vendor", "touch vendor/x", true},
+		{"copy into vendor", "cp src/b.go vendor/lib/", true},
+		{"cp -t vendor", "cp -t vendor src/b.go", true},
+		{"install out of vendor", "install -m 644 vendor/lib/a.go src/i.go", false},
+		{"install into vendor", "install -m 644 src/b.go vendor/lib/b.go", true},
+		{"install -d vendor", "install -d -m 755 src/d vendor/d", true},
+		{"move out of vendor", "mv vendor/lib/a.go src/", true},
+		{"rm vendor dir", "rm -rf vendor", true},
+		{"sed -i vendor", "sed -i s/lib/x/ vendor/lib/a.go", true},
+		{"mkdir node_modules", "mkdir node_modules/other", true},
+		{"cd into vendor then write", "cd vendor/lib && touch z", true},
+		{"variable target", "d=vendor; touch $d/x", true},
+		{"absolute path", "touch " + workDir + "/vendor/abs", true},
+		{"timeout wrapped rm", "timeout 5 rm -rf vendor", true},
+		{"env wrapped touch", "env FOO=bar touch vendor/x", true},
+		{"chmod -R src", "chmod -R 755 src", false},
+		{"mv into workdir", "mv src/b.go .", false},
+		{"rm -r src", "rm -r src", false},
+		{"rm -rf workdir", `rm -rf "$PWD"`, true},
+		{"rm -rf workdir by parent", "rm -rf ../" + filepath.Base(workDir), true},
+		{"rm -r dot", "rm -r .", true},
+		{"chmod -R dot", "chmod -R 000 .", true},
+		{"timeout wrapped chmod -R", "timeout 5 chmod --recursive 000 .", true},
+		{"mv workdir", `mv "$PWD" moved`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Execute(context.Background(), tt.command, workDir, paths, paths)
+			if !tt.blocked {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "read-only subpath") {
+				t.Fatalf("expected read-only subpath error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchGlobSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"vendor/**", "vendor", true},
+		{"vendor/**", "vendor/a/b", true},
+		{"vendor/**", "src/vendor", false},
+		{"vendor", "vendor", true},
+		{"**/node_modules", "a/b/node_modules", true},
+		{"**/node_modules", "node_modules", true},
+		{"*.lock", "Cargo.lock", true},
+		{"*.lock", "dir/Cargo.lock", false},
+		{"docs/*/gen", "docs/v1/gen", true},
+	}
+	for _, tt := range tests {
+		got := matchGlobSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/"))
+		if got != tt.want {
+			t.Errorf("matchGlobSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlobBelow(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"vendor/**", "vendor", true},
+		{"vendor/**", "src", false},
+		{"vendor/lib", "vendor", true},
+		{"**/node_modules", "a/b", true},
+		{"*.lock", "dir", false},
+		{"docs/*/gen", "docs", true},
+		{"docs/*/gen", "docs/v1/src", false},
+	}
+	for _, tt := range tests {
+		got := matchGlobBelow(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/"))
+		if got != tt.want {
+			t.Errorf("matchGlobBelow(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIsBroadPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	dir := t.TempDir()
+	rootLink := filepath.Join(dir, "root")
+	if err := os.Symlink("/", rootLink); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/home", true},
+		{"/home/", true},
+		{"/Users", true},
+		{"/etc", true},
+		{"/usr/../etc", true},
+		{home, true},
+		{home + "/", true},
+		{rootLink, true},
+		{dir, false},
+		{"/usr/share", false},
+		{"/etc/ssl", false},
+		{filepath.Join(home, "project"), false},
+	}
+	for _, tt := range tests {
+		if got := isBroadPath(tt.path); got != tt.want {
+			t.Errorf("isBroadPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBashSandboxed_NonRegularFiles(t *testing.T) {
+	workDir := t.TempDir()
+	fifo := filepath.Join(workDir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "regular.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("fifo", filepath.Join(workDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{workDir}
+	s := NewSandbox()
+
+	for _, command := range []string{
+		"cat fifo",
+		"cat regular.txt fifo",
+		"cat -- fifo",
+		"cat link",
+		"grep x " + fifo,
+		"timeout 5 cat fifo",
+		"cat < fifo",
+		"while read l; do echo $l; done < fifo",
+	} {
+		t.Run(command, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := s.Execute(ctx, command, workDir, paths, paths)
+			if err == nil || !strings.Contains(err.Error(), "refusing to open non-regular file") {
+				t.Fatalf("expected the FIFO to be refused, got %v", err)
+			}
+		})
+	}
+
+	for command, want := range map[string]string{
+		"cat regular.txt":                  "hello\n",
+		"cat < regular.txt":                "hello\n",
+		"ls fifo":                          "fifo\n",
+		"test -p fifo && echo pipe":        "pipe\n",
+		"echo hi | cat -":                  "hi\n",
+		"echo hi > fifo.txt; cat fifo.txt": "hi\n",
+	} {
+		out, err := s.Execute(context.Background(), command, workDir, paths, paths)
+		if err != nil || out != want {
+			t.Errorf("%s: expected %q, got %q and %v", command, want, out, err)
+		}
+	}
+
+	// Process substitutions are FIFOs created by the interpreter.
+	tmpPaths := []string{workDir, os.TempDir()}
+	out, err := s.Execute(context.Background(), "cat <(echo sub)", workDir, tmpPaths, paths)
+	if err != nil || out != "sub\n" {
+		t.Errorf("expected process substitution to work, got %q and %v", out, err)
+	}
+}
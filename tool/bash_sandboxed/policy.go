@@ -0,0 +1,170 @@
+package bash_sandboxed
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// commandCategory is a group of allowlisted commands in the policy document.
+type commandCategory struct {
+	name     string
+	commands []string
+}
+
+// commandCategories groups the commands in allowedCommands for
+// WritePolicyMarkdown, following the sections of allowedCommands. Every
+// allowed command must appear in exactly one category.
+var commandCategories = []commandCategory{
+	{"Output / display", []string{"echo", "printf", "cat", "head", "tail", "less", "more", "wc"}},
+	{"Formatters", []string{"column", "fold", "paste", "rev", "tac", "nl", "pr", "expand", "unexpand", "col", "colrm", "vis", "unvis", "fmt"}},
+	{"Search / find", []string{"grep", "egrep", "fgrep", "rg", "find", "tree", "locate", "which", "whereis", "type", "look"}},
+	{"Navigation / directory management", []string{"cd", "mkdir"}},
+	{"File info", []string{"ls", "stat", "file", "du", "df", "readlink", "realpath", "basename", "dirname", "pathchk", "pwd", "sha256sum", "sha1sum", "md5sum", "shasum", "cksum", "b2sum"}},
+	{"Text processing", []string{"sort", "uniq", "cut", "tr", "diff", "comm", "join", "tsort", "strings", "od", "hexdump", "xxd", "iconv", "jq", "yq", "awk", "base64"}},
+	{"Shell sourcing", []string{"source", "."}},
+	{"Shell builtins", []string{"test", "[", "true", "false", "read", "set", "unset", "export", "local", "declare", "typeset", "readonly", "shift", "getopts", "let", "expr"}},
+	{"Process / system info", []string{"ps", "uptime", "uname", "hostname", "whoami", "id", "groups", "env", "printenv", "date", "cal", "nproc", "getconf", "arch", "lscpu", "free"}},
+	{"Math / calculation", []string{"bc", "seq", "factor", "numfmt", "uuidgen"}},
+	{"Compressed file readers", []string{"zcat", "zless", "zgrep", "bzcat", "xzcat"}},
+	{"Archive inspection", []string{"tar", "unzip", "zipinfo", "ar"}},
+	{"Version control", []string{"git"}},
+	{"Nested shell", []string{"bash", "sh"}},
+	{"Runtimes", []string{"go", "pnpm", "cargo", "rustc"}},
+	{"Cloud CLI tools", []string{"aws"}},
+	{"Scoped write commands", []string{"cp", "mv", "rm", "touch", "chmod", "ln", "sed", "install"}},
+	{"Control flow / job control", []string{"sleep", "wait", "trap", "return", "exit", "break", "continue", "timeout", "nice", "ionice", "time", "yes"}},
+	{"Safe introspection", []string{"command", "builtin", "hash", "help", "man", "info", "apropos"}},
+	{"Pipe utilities", []string{"xargs"}},
+}
+
+// validatorNotes summarizes what each validator in commandArgValidators
+// restricts, for WritePolicyMarkdown. Every validator must have a note.
+var validatorNotes = map[string]string{
+	"awk":       "system(), pipes and file writes are disabled at run time; getline may only read string-literal files in allowed paths",
+	"bash":      "-c strings and scripts are parsed and validated like any command; interactive, login, stdin and rc-file flags are blocked",
+	"sh":        "same as bash, parsed as POSIX sh",
+	"source":    "requires a file, whose commands are validated",
+	".":         "same as source",
+	"rg":        "--pre and --hostname-bin commands must be allowed; -z/--search-zip requires its decompressors (gzip, xz, ...) to be allowed; -f and --ignore-file files must be readable; .git directories are never searched",
+	"find":      "-exec/-execdir/-ok/-okdir commands must be allowed; -delete and other writing actions are blocked; -newer, -samefile and similar reference files must be readable",
+	"tree":      "-o (output file) and -l (follow symlinks) are blocked",
+	"ls":        "-R combined with -L is blocked",
+	"du":        "-L/--dereference (follow symlinks while recursing) is blocked",
+	"locate":    "blocked with block_system_enumeration",
+	"which":     "with block_system_enumeration, only allowed commands can be looked up",
+	"whereis":   "same as which",
+	"type":      "same as which",
+	"command":   "-v/-V are limited like which with block_system_enumeration",
+	"head":      "-c/--bytes counts above max_head_tail_bytes (1 GiB by default) are blocked",
+	"tail":      "-f/-F/--follow are blocked unless allow_follow is set; -c/--bytes counts are limited like head",
+	"tar":       "list mode only (-t/--list)",
+	"unzip":     "list or test mode only (-l, -Z, -t)",
+	"ar":        "list and print operations only (t, p)",
+	"rm":        "--no-preserve-root is blocked",
+	"sed":       "the e, r/R and w/W commands are blocked",
+	"install":   "-o/-g (ownership changes) and -s/--strip-program (runs strip) are blocked",
+	"sort":      "--compress-program must be allowed; the -o file must be writable",
+	"git":       "subject to the git permissions (local/remote read/write); -C, --git-dir and --work-tree must be writable for subcommands that modify the repository; the --directory of apply and am must be writable and --unsafe-paths is blocked",
+	"go":        "requires runtimes.go.enabled; generate, run and module fetches need their own flags",
+	"pnpm":      "requires runtimes.pnpm.enabled; publish needs its own flag; --ignore-scripts is added to installs",
+	"cargo":     "requires runtimes.rust.enabled; install and publish need their own flags",
+	"rustc":     "requires runtimes.rust.enabled",
+	"aws":       "requires aws.enabled; credentials come from the IMDS server",
+	"xargs":     "the command run must be allowed",
+	"timeout":   "the command run must be allowed",
+	"nice":      "the command run must be allowed",
+	"ionice":    "the command run must be allowed; -p, -P and -u (changing running processes) are blocked",
+	"env":       "the command run must be allowed; blocked environment variables cannot be set",
+	"man":       "pager, browser and config-file flags are blocked; -P cat is forced",
+	"apropos":   "same as man",
+	"info":      "-o/--output (except to stdout) and --dribble are blocked",
+	"seq":       "ranges longer than " + fmt.Sprint(maxSeqLines) + " lines are blocked",
+	"md5sum":    "in -c mode the manifest must be a file, and it and the files it lists must be readable",
+	"sha1sum":   "same as md5sum",
+	"sha256sum": "same as md5sum",
+	"shasum":    "same as md5sum",
+	"cksum":     "same as md5sum",
+	"b2sum":     "same as md5sum",
+}
+
+// redirectRules describes what validateRedirect and the path checks allow
+// for each kind of redirection.
+var redirectRules = []struct {
+	op   string
+	rule string
+}{
+	{"`<<`, `<<-`, `<<<`", "always allowed (input only)"},
+	{"`<&`", "always allowed"},
+	{"`>&`", "only to a literal file descriptor number or `-`"},
+	{"`<`", "the file must be under the read-allowed paths"},
+	{"`>`, `>>`, `>|`, `&>`, `&>>`", "the file must be under the write-allowed paths and not in a readonly subpath"},
+	{"`<>`", "blocked"},
+}
+
+// WritePolicyMarkdown writes the built-in command policy to w as markdown:
+// the allowed commands by category, the restrictions of each argument
+// validator, the commands gated by config, the commands that are always
+// denied, the blocked environment variables and the redirection rules. It is
+// generated from the same tables that validation uses, so it does not depend
+// on the user's config.
+func WritePolicyMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# lite-sandbox command policy\n\n")
+
+	b.WriteString("## Allowed commands\n\n")
+	b.WriteString("| Category | Commands |\n|---|---|\n")
+	for _, c := range commandCategories {
+		fmt.Fprintf(&b, "| %s | %s |\n", c.name, codeList(c.commands))
+	}
+
+	b.WriteString("\n## Argument restrictions\n\n")
+	b.WriteString("| Command | Restriction |\n|---|---|\n")
+	for _, name := range sortedKeys(commandArgValidators) {
+		fmt.Fprintf(&b, "| `%s` | %s |\n", name, validatorNotes[name])
+	}
+
+	b.WriteString("\n## Config-gated commands\n\n")
+	b.WriteString("| Command | Enabled by |\n|---|---|\n")
+	for _, name := range sortedKeys(runtimeCommands) {
+		fmt.Fprintf(&b, "| `%s` | `%s` |\n", name, runtimeCommands[name].source)
+	}
+
+	b.WriteString("\n## Always denied\n\n")
+	fmt.Fprintf(&b, "%s run commands with elevated privileges and cannot be allowed by extra_commands.\n", codeList(sortedKeys(privilegeEscalationCommands)))
+
+	b.WriteString("\n## Blocked environment variables\n\n")
+	b.WriteString("| Variable | Reason |\n|---|---|\n")
+	for _, name := range sortedKeys(blockedEnvVars) {
+		fmt.Fprintf(&b, "| `%s` | %s |\n", name, blockedEnvVars[name])
+	}
+
+	b.WriteString("\n## Redirections\n\n")
+	b.WriteString("| Operator | Rule |\n|---|---|\n")
+	for _, r := range redirectRules {
+		fmt.Fprintf(&b, "| %s | %s |\n", r.op, r.rule)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// codeList formats names as a comma-separated list of code spans.
+func codeList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = "`" + n + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package bash_sandboxed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandCategories(t *testing.T) {
+	seen := make(map[string]string)
+	for _, c := range commandCategories {
+		for _, name := range c.commands {
+			if !allowedCommands[name] {
+				t.Errorf("category %q lists %q, which is not in allowedCommands", c.name, name)
+			}
+			if prev, ok := seen[name]; ok {
+				t.Errorf("%q is in both %q and %q", name, prev, c.name)
+			}
+			seen[name] = c.name
+		}
+	}
+	for name := range allowedCommands {
+		if _, ok := seen[name]; !ok {
+			t.Errorf("allowed command %q is not in any category of commandCategories", name)
+		}
+	}
+}
+
+func TestValidatorNotes(t *testing.T) {
+	for name := range commandArgValidators {
+		if validatorNotes[name] == "" {
+			t.Errorf("validator for %q has no entry in validatorNotes", name)
+		}
+	}
+	for name := range validatorNotes {
+		if _, ok := commandArgValidators[name]; !ok {
+			t.Errorf("validatorNotes has %q, which has no validator", name)
+		}
+	}
+}
+
+func TestWritePolicyMarkdown(t *testing.T) {
+	var b strings.Builder
+	if err := WritePolicyMarkdown(&b); err != nil {
+		t.Fatalf("WritePolicyMarkdown: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"| Search / find | `grep`,",
+		"| `tar` | list mode only (-t/--list) |",
+		"| `go` | `runtimes.go` |",
+		"| `LD_PRELOAD` | shared library injection |",
+		"| `<>` | blocked |",
+		"`doas`, `machinectl`, `pkexec`, `run0`, `su`, `sudo`, `sudoedit` run commands with elevated privileges",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected policy to contain %q", want)
+		}
+	}
+	for name := range allowedCommands {
+		if !strings.Contains(out, "`"+name+"`") {
+			t.Errorf("allowed command %q is missing from the policy", name)
+		}
+	}
+}
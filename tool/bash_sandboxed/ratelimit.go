@@ -0,0 +1,68 @@
+package bash_sandboxed
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows burst commands at once and
+// refills at perMinute commands per minute.
+type rateLimiter struct {
+	perMinute int
+	burst     int
+	// now is time.Now, replaceable in tests.
+	now func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rateLimiter with a full bucket.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	l := &rateLimiter{perMinute: perMinute, burst: burst, now: time.Now, tokens: float64(burst)}
+	l.last = l.now()
+	return l
+}
+
+// refill adds the tokens accrued since the last call. l.mu must be held.
+func (l *rateLimiter) refill() {
+	now := l.now()
+	elapsed := now.Sub(l.last)
+	l.last = now
+	l.tokens = min(float64(l.burst), l.tokens+elapsed.Minutes()*float64(l.perMinute))
+}
+
+// allow takes a token from the bucket, or returns an error if it is empty.
+func (l *rateLimiter) allow() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if l.tokens < 1 {
+		return fmt.Errorf("rate limit exceeded: at most %d commands per minute (burst %d)", l.perMinute, l.burst)
+	}
+	l.tokens--
+	return nil
+}
+
+// stats returns the limits and the number of tokens in the bucket.
+func (l *rateLimiter) stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	return RateLimitStats{CommandsPerMinute: l.perMinute, Burst: l.burst, Available: l.tokens}
+}
+
+// rateLimiterFor returns the rate limiter for a new config: nil if commands
+// are not limited, old if the limits are unchanged, so that reloading the
+// config does not refill the bucket, or a new, full one.
+func rateLimiterFor(old *rateLimiter, perMinute, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if old != nil && old.perMinute == perMinute && old.burst == burst {
+		return old
+	}
+	return newRateLimiter(perMinute, burst)
+}
@@ -0,0 +1,110 @@
+package bash_sandboxed
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gartnera/lite-sandbox/config"
+)
+
+// fakeClock makes l use a fake clock and returns a function that advances it.
+func fakeClock(l *rateLimiter) func(time.Duration) {
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	l.last = now
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	advance := fakeClock(l)
+
+	steps := []struct {
+		advance time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false}, // burst used up
+		{500 * time.Millisecond, false},
+		{500 * time.Millisecond, true}, // one token per second
+		{0, false},
+		{time.Hour, true}, // refills up to the burst, not beyond
+		{0, true},
+		{0, false},
+	}
+	for i, step := range steps {
+		advance(step.advance)
+		err := l.allow()
+		if (err == nil) != step.allowed {
+			t.Fatalf("step %d: allowed = %v, want %v (err %v)", i, err == nil, step.allowed, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "rate limit exceeded") {
+			t.Fatalf("step %d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestRateLimiter_Concurrent(t *testing.T) {
+	l := newRateLimiter(60, 10)
+	fakeClock(l)
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.allow() == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 10 {
+		t.Fatalf("expected exactly the burst of 10 to be allowed, got %d", n)
+	}
+}
+
+func TestExecute_RateLimit(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+	cfg := &config.Config{RateLimit: &config.RateLimitConfig{CommandsPerMinute: 1, Burst: 3}}
+	s.UpdateConfig(cfg, dir)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Execute(context.Background(), "echo hi", dir, paths, paths); err != nil {
+			t.Fatalf("command %d: unexpected error: %v", i, err)
+		}
+	}
+	_, err := s.Execute(context.Background(), "echo hi", dir, paths, paths)
+	if err == nil || !strings.Contains(err.Error(), "rate limit exceeded") {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	r := s.ExecuteDetailed(context.Background(), "echo hi", dir, paths, paths, ExecuteOptions{})
+	if r.Err == nil || !strings.Contains(r.Err.Error(), "rate limit exceeded") {
+		t.Fatalf("expected ExecuteDetailed to be rate limited, got %v", r.Err)
+	}
+
+	// Reloading the same limits keeps the empty bucket.
+	s.UpdateConfig(&config.Config{RateLimit: &config.RateLimitConfig{CommandsPerMinute: 1, Burst: 3}}, dir)
+	if _, err := s.Execute(context.Background(), "echo hi", dir, paths, paths); err == nil {
+		t.Fatal("expected reloading unchanged limits not to refill the bucket")
+	}
+	// New limits start with a full bucket.
+	s.UpdateConfig(&config.Config{RateLimit: &config.RateLimitConfig{CommandsPerMinute: 2}}, dir)
+	if _, err := s.Execute(context.Background(), "echo hi", dir, paths, paths); err != nil {
+		t.Fatalf("expected new limits to allow a command, got %v", err)
+	}
+	// Removing the limit allows any number of commands.
+	s.UpdateConfig(&config.Config{}, dir)
+	for i := 0; i < 5; i++ {
+		if _, err := s.Execute(context.Background(), "echo hi", dir, paths, paths); err != nil {
+			t.Fatalf("unexpected error without a rate limit: %v", err)
+		}
+	}
+}
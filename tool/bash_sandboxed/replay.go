@@ -0,0 +1,158 @@
+package bash_sandboxed
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// replayRecord is one Execute call in a recording, written as a line of JSON.
+type replayRecord struct {
+	Command string `json:"command"`
+	WorkDir string `json:"work_dir"`
+	Output  string `json:"output"`
+	// Encoding is EncodingBase64 if Output was not valid UTF-8 and is stored
+	// base64-encoded, since JSON cannot hold it unchanged.
+	Encoding string `json:"encoding,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Failed is set if Error was a CommandFailedError, and ExitStatus if
+	// that error wrapped an exit status.
+	Failed     bool  `json:"failed,omitempty"`
+	ExitStatus uint8 `json:"exit_status,omitempty"`
+}
+
+// newReplayRecord records the result of running command in workDir.
+func newReplayRecord(command, workDir, output string, err error) replayRecord {
+	rec := replayRecord{Command: command, WorkDir: workDir, Output: output}
+	if !utf8.ValidString(output) {
+		rec.Output = base64.StdEncoding.EncodeToString([]byte(output))
+		rec.Encoding = EncodingBase64
+	}
+	if err == nil {
+		return rec
+	}
+	rec.Error = err.Error()
+	var cmdErr *CommandFailedError
+	if errors.As(err, &cmdErr) {
+		rec.Failed = true
+		rec.Error = cmdErr.Err.Error()
+		var status interp.ExitStatus
+		if errors.As(cmdErr.Err, &status) {
+			rec.ExitStatus = uint8(status)
+		}
+	}
+	return rec
+}
+
+// result rebuilds the output and error that Execute returned.
+func (rec replayRecord) result() (string, error) {
+	output := rec.Output
+	if rec.Encoding == EncodingBase64 {
+		b, err := base64.StdEncoding.DecodeString(rec.Output)
+		if err != nil {
+			return "", fmt.Errorf("replay: invalid base64 output for %q: %w", rec.Command, err)
+		}
+		output = string(b)
+	}
+	switch {
+	case rec.Error == "":
+		return output, nil
+	case !rec.Failed:
+		return output, errors.New(rec.Error)
+	case rec.ExitStatus != 0:
+		return output, &CommandFailedError{Err: interp.ExitStatus(rec.ExitStatus), Output: output}
+	default:
+		return output, &CommandFailedError{Err: errors.New(rec.Error), Output: output}
+	}
+}
+
+// recorder writes a replayRecord for every Execute call.
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (r *recorder) record(rec replayRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(rec)
+}
+
+// replayKey identifies the recorded results for a command.
+type replayKey struct {
+	command string
+	workDir string
+}
+
+// replayLog holds the results loaded by LoadReplay.
+type replayLog struct {
+	strict bool
+	mu     sync.Mutex
+	// results holds, per command, the recorded results not yet replayed.
+	// The last one is kept and replayed for any further calls.
+	results map[replayKey][]replayRecord
+}
+
+// lookup returns the next recorded result for command in workDir.
+func (l *replayLog) lookup(command, workDir string) (replayRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := replayKey{command, workDir}
+	recs := l.results[key]
+	if len(recs) == 0 {
+		return replayRecord{}, false
+	}
+	if len(recs) > 1 {
+		l.results[key] = recs[1:]
+	}
+	return recs[0], true
+}
+
+// StartRecording makes every subsequent Execute call (including those made by
+// ExecuteDetailed and ExecuteBatch) write its command, working directory and
+// result to w as a line of JSON, for use with LoadReplay. Each line is
+// written in a single call under a lock, so concurrent commands do not
+// interleave. It replaces any previous recording.
+func (s *Sandbox) StartRecording(w io.Writer) {
+	s.recorder.Store(&recorder{enc: json.NewEncoder(w)})
+}
+
+// StopRecording stops the recording started by StartRecording.
+func (s *Sandbox) StopRecording() {
+	s.recorder.Store(nil)
+}
+
+// LoadReplay reads a recording made by StartRecording and makes Execute
+// return the recorded results instead of running commands, so that tests of
+// code built on a Sandbox are deterministic and need no OS sandbox. Results
+// are matched by command and working directory; a command recorded several
+// times replays its results in order, repeating the last one. A command that
+// was not recorded is run normally, or fails if strict is set. It replaces any
+// previously loaded replay.
+func (s *Sandbox) LoadReplay(r io.Reader, strict bool) error {
+	l := &replayLog{strict: strict, results: make(map[replayKey][]replayRecord)}
+	dec := json.NewDecoder(r)
+	for {
+		var rec replayRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading replay: %w", err)
+		}
+		key := replayKey{rec.Command, rec.WorkDir}
+		l.results[key] = append(l.results[key], rec)
+	}
+	s.replay.Store(l)
+	return nil
+}
+
+// StopReplay makes Execute run commands again after LoadReplay.
+func (s *Sandbox) StopReplay() {
+	s.replay.Store(nil)
+}
@@ -0,0 +1,122 @@
+package bash_sandboxed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0o644)
+	ctx := context.Background()
+
+	commands := []string{
+		"cat a.txt",
+		"cat missing.txt",
+		"curl example.com",
+		`printf '\xff\xfe'`,
+	}
+	type result struct {
+		output string
+		err    error
+	}
+	var buf bytes.Buffer
+	s := newTestSandbox()
+	s.StartRecording(&buf)
+	want := make([]result, len(commands))
+	for i, c := range commands {
+		out, err := s.Execute(ctx, c, dir, paths, paths)
+		want[i] = result{out, err}
+	}
+	s.StopRecording()
+	s.Execute(ctx, "echo not recorded", dir, paths, paths)
+	if n := strings.Count(buf.String(), "\n"); n != len(commands) {
+		t.Fatalf("expected %d recorded commands, got %d:\n%s", len(commands), n, buf.String())
+	}
+
+	// Replayed results must not depend on the filesystem.
+	os.Remove(filepath.Join(dir, "a.txt"))
+
+	r := newTestSandbox()
+	if err := r.LoadReplay(bytes.NewReader(buf.Bytes()), true); err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	for i, c := range commands {
+		out, err := r.Execute(ctx, c, dir, paths, paths)
+		if out != want[i].output {
+			t.Errorf("%s: output %q, want %q", c, out, want[i].output)
+		}
+		if (err == nil) != (want[i].err == nil) || (err != nil && err.Error() != want[i].err.Error()) {
+			t.Errorf("%s: error %v, want %v", c, err, want[i].err)
+		}
+		var gotFailed, wantFailed *CommandFailedError
+		if errors.As(err, &gotFailed) != errors.As(want[i].err, &wantFailed) {
+			t.Errorf("%s: CommandFailedError mismatch: got %v, want %v", c, err, want[i].err)
+		}
+		var gotStatus, wantStatus interp.ExitStatus
+		errors.As(err, &gotStatus)
+		errors.As(want[i].err, &wantStatus)
+		if gotStatus != wantStatus {
+			t.Errorf("%s: exit status %d, want %d", c, gotStatus, wantStatus)
+		}
+	}
+
+	_, err := r.Execute(ctx, "echo not recorded", dir, paths, paths)
+	if err == nil || !strings.Contains(err.Error(), "no recorded result") {
+		t.Fatalf("expected a strict replay miss, got %v", err)
+	}
+	_, err = r.Execute(ctx, "cat a.txt", t.TempDir(), paths, paths)
+	if err == nil || !strings.Contains(err.Error(), "no recorded result") {
+		t.Fatalf("expected a miss for a different workDir, got %v", err)
+	}
+
+	r.StopReplay()
+	if _, err := r.Execute(ctx, "cat a.txt", dir, paths, paths); err == nil {
+		t.Fatal("expected cat of the removed file to fail after StopReplay")
+	}
+}
+
+func TestReplay_RepeatedAndNonStrict(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	s := newTestSandbox()
+	s.StartRecording(&buf)
+	s.Execute(ctx, "echo 1 > n.txt; cat n.txt", dir, paths, paths)
+	s.Execute(ctx, "cat n.txt", dir, paths, paths)
+	s.Execute(ctx, "echo 2 > n.txt", dir, paths, paths)
+	s.Execute(ctx, "cat n.txt", dir, paths, paths)
+	s.StopRecording()
+
+	r := newTestSandbox()
+	if err := r.LoadReplay(&buf, false); err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	for i, want := range []string{"1\n", "2\n", "2\n"} {
+		out, err := r.Execute(ctx, "cat n.txt", dir, paths, paths)
+		if err != nil || out != want {
+			t.Fatalf("replay %d: got %q, %v; want %q", i, out, err, want)
+		}
+	}
+	out, err := r.Execute(ctx, "echo live", dir, paths, paths)
+	if err != nil || out != "live\n" {
+		t.Fatalf("expected a non-strict miss to run the command, got %q, %v", out, err)
+	}
+}
+
+func TestLoadReplay_Invalid(t *testing.T) {
+	s := newTestSandbox()
+	if err := s.LoadReplay(strings.NewReader("{not json"), true); err == nil {
+		t.Fatal("expected error for invalid replay")
+	}
+}
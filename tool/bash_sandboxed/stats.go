@@ -0,0 +1,107 @@
+package bash_sandboxed
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gartnera/lite-sandbox/os_sandbox"
+)
+
+// counters counts the outcomes of the commands run by Execute.
+type counters struct {
+	commands           atomic.Uint64
+	rateLimited        atomic.Uint64
+	validationFailures atomic.Uint64
+	commandFailures    atomic.Uint64
+}
+
+// record counts the outcome of a command that was not rate limited.
+func (c *counters) record(err error) {
+	var cmdErr *CommandFailedError
+	switch {
+	case err == nil:
+	case errors.As(err, &cmdErr):
+		c.commandFailures.Add(1)
+	default:
+		c.validationFailures.Add(1)
+	}
+}
+
+// Stats is a snapshot of a Sandbox's activity, for monitoring.
+type Stats struct {
+	// Commands is the number of commands passed to Execute, including those
+	// that were rate limited or rejected.
+	Commands    uint64 `json:"commands"`
+	RateLimited uint64 `json:"rate_limited"`
+	// ValidationFailures counts commands rejected before they ran, and
+	// CommandFailures those that ran and failed.
+	ValidationFailures uint64 `json:"validation_failures"`
+	CommandFailures    uint64 `json:"command_failures"`
+	OSSandbox          bool   `json:"os_sandbox"`
+	// Worker is nil if no OS sandbox worker is running.
+	Worker *os_sandbox.WorkerStats `json:"worker"`
+	// RateLimit is nil if commands are not rate limited.
+	RateLimit *RateLimitStats `json:"rate_limit"`
+}
+
+// RateLimitStats is the state of the rate limiter.
+type RateLimitStats struct {
+	CommandsPerMinute int `json:"commands_per_minute"`
+	Burst             int `json:"burst"`
+	// Available is the number of commands that can run now.
+	Available float64 `json:"available"`
+}
+
+// Stats returns a snapshot of the sandbox's command counters, OS sandbox
+// worker and rate limiter.
+func (s *Sandbox) Stats() Stats {
+	st := s.loadState()
+	stats := Stats{
+		Commands:           s.counters.commands.Load(),
+		RateLimited:        s.counters.rateLimited.Load(),
+		ValidationFailures: s.counters.validationFailures.Load(),
+		CommandFailures:    s.counters.commandFailures.Load(),
+		OSSandbox:          st.osSandbox,
+	}
+	s.mu.Lock()
+	w := s.worker
+	s.mu.Unlock()
+	if w != nil && !w.IsDead() {
+		ws := w.Stats()
+		stats.Worker = &ws
+	}
+	if st.rateLimiter != nil {
+		rl := st.rateLimiter.stats()
+		stats.RateLimit = &rl
+	}
+	return stats
+}
+
+// Ready returns an error if commands cannot run because the OS sandbox is
+// required but disabled, or is enabled and its worker has not started yet
+// (Warmup starts it) or failed to start the last time it was started. It
+// never starts a worker itself. A worker that exited or was closed after a
+// config change is restarted by the next command, so it does not make the
+// sandbox unready.
+func (s *Sandbox) Ready() error {
+	st := s.loadState()
+	if !st.osSandbox {
+		if st.cfg.RequiresOSSandbox() {
+			return fmt.Errorf("OS sandbox required but unavailable: os_sandbox is disabled")
+		}
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.worker != nil && !s.worker.IsDead() {
+		return nil
+	}
+	if s.workerErr != nil {
+		return fmt.Errorf("OS sandbox unavailable: %w", s.workerErr)
+	}
+	if s.workerState == nil {
+		return fmt.Errorf("OS sandbox unavailable: worker has not started yet")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package bash_sandboxed
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gartnera/lite-sandbox/config"
+	"github.com/gartnera/lite-sandbox/os_sandbox"
+)
+
+func TestSandboxStats(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{dir}
+	s := NewSandbox()
+	defer s.Close()
+
+	stats := s.Stats()
+	if stats.Commands != 0 || stats.Worker != nil || stats.RateLimit != nil {
+		t.Fatalf("unexpected initial stats: %+v", stats)
+	}
+
+	ctx := context.Background()
+	s.Execute(ctx, "echo hi", dir, paths, paths)
+	s.Execute(ctx, "cat /etc/passwd", dir, paths, paths)
+	s.Execute(ctx, "exit 3", dir, paths, paths)
+	s.Execute(ctx, "true", dir, paths, paths)
+
+	stats = s.Stats()
+	if stats.Commands != 4 {
+		t.Errorf("expected 4 commands, got %d", stats.Commands)
+	}
+	if stats.ValidationFailures != 1 {
+		t.Errorf("expected 1 validation failure, got %d", stats.ValidationFailures)
+	}
+	if stats.CommandFailures != 1 {
+		t.Errorf("expected 1 command failure, got %d", stats.CommandFailures)
+	}
+	if stats.RateLimited != 0 {
+		t.Errorf("expected no rate limited commands, got %d", stats.RateLimited)
+	}
+
+	s.UpdateConfig(&config.Config{RateLimit: &config.RateLimitConfig{CommandsPerMinute: 60, Burst: 1}}, dir)
+	s.Execute(ctx, "true", dir, paths, paths)
+	s.Execute(ctx, "true", dir, paths, paths)
+	stats = s.Stats()
+	if stats.RateLimited != 1 {
+		t.Errorf("expected 1 rate limited command, got %d", stats.RateLimited)
+	}
+	if stats.RateLimit == nil || stats.RateLimit.CommandsPerMinute != 60 || stats.RateLimit.Burst != 1 {
+		t.Errorf("unexpected rate limit stats: %+v", stats.RateLimit)
+	}
+}
+
+func TestSandboxReady(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		s := NewSandbox()
+		if err := s.Ready(); err != nil {
+			t.Errorf("expected ready, got %v", err)
+		}
+	})
+
+	t.Run("required but disabled", func(t *testing.T) {
+		s := NewSandbox()
+		required := true
+		s.UpdateConfig(&config.Config{OSSandboxRequired: &required}, t.TempDir())
+		if err := s.Ready(); err == nil {
+			t.Error("expected an error when the required OS sandbox is disabled")
+		}
+	})
+
+	t.Run("worker not started", func(t *testing.T) {
+		calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return &os_sandbox.Worker{}, nil })
+		s := newOSSandboxTestSandbox(t)
+		err := s.Ready()
+		if err == nil || !strings.Contains(err.Error(), "has not started yet") {
+			t.Errorf("expected a not started error, got %v", err)
+		}
+		if *calls != 0 {
+			t.Errorf("Ready started %d workers, want 0", *calls)
+		}
+	})
+
+	t.Run("worker fails to start", func(t *testing.T) {
+		calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return nil, errors.New("bwrap missing") })
+		s := newOSSandboxTestSandbox(t)
+		if err := s.Warmup(context.Background()); err == nil {
+			t.Fatal("expected Warmup to fail")
+		}
+		err := s.Ready()
+		if err == nil || !strings.Contains(err.Error(), "bwrap missing") {
+			t.Errorf("expected the worker start error, got %v", err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected only Warmup to start a worker, got %d starts", *calls)
+		}
+	})
+
+	t.Run("worker starts", func(t *testing.T) {
+		calls := stubStartWorker(t, func() (*os_sandbox.Worker, error) { return &os_sandbox.Worker{}, nil })
+		s := newOSSandboxTestSandbox(t)
+		if err := s.Warmup(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Ready(); err != nil {
+			t.Errorf("expected ready, got %v", err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected only Warmup to start a worker, got %d starts", *calls)
+		}
+	})
+}
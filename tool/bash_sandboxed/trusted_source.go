@@ -0,0 +1,90 @@
+package bash_sandboxed
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// isTrustedSourceFile reports whether name, a file sourced in dir, is one of
+// trusted_source_files. Names without a slash are never trusted, because
+// source looks them up in PATH.
+func (st *sandboxState) isTrustedSourceFile(name, dir string) bool {
+	if len(st.trustedSourceFiles) == 0 || !strings.Contains(name, "/") {
+		return false
+	}
+	if dir == "" && !strings.HasPrefix(name, "/") {
+		return false
+	}
+	resolved := ResolvePath(name, dir)
+	for _, trusted := range st.trustedSourceFiles {
+		if ResolvePath(trusted, "/") == resolved {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedCommand reports whether name is used by a trusted_source_files
+// entry that has been sourced earlier in this execution. This applies to any
+// later call, including one from a file sourced afterwards by a dynamic path
+// that validation could not read.
+func (p *sandboxPaths) isTrustedCommand(name string) bool {
+	_, ok := p.trustedCommands.Load(name)
+	return ok
+}
+
+// parseSourceFile reads and parses the shell file at path, ignoring a
+// shebang line.
+func parseSourceFile(path string) (*syntax.File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script := string(data)
+	if strings.HasPrefix(script, "#!") {
+		if idx := strings.IndexByte(script, '\n'); idx >= 0 {
+			script = script[idx+1:]
+		} else {
+			script = ""
+		}
+	}
+	return ParseBash(script)
+}
+
+// trustedSourceCommands returns the names of the commands the trusted source
+// file at path calls, which the ExecHandler allows once it is sourced.
+func trustedSourceCommands(path string) map[string]bool {
+	f, err := parseSourceFile(path)
+	if err != nil {
+		return nil // the source builtin reports the error
+	}
+	names := make(map[string]bool)
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if ce, ok := node.(*syntax.CallExpr); ok && len(ce.Args) > 0 {
+			if name := extractCommandName(ce.Args[0]); name != "" {
+				names[name] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// validateTrustedSourceFile validates the paths used by a trusted source
+// file. Its commands are not checked against the allowlist.
+func validateTrustedSourceFile(filePath, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	f, err := parseSourceFile(absPath(filePath, workDir))
+	if err != nil {
+		return nil // fail-open like validateScriptFile; handled at runtime
+	}
+	if err := validatePaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+		return fmt.Errorf("script %s: %w", filePath, err)
+	}
+	if err := validateRedirectPaths(f, workDir, readAllowedPaths, writeAllowedPaths); err != nil {
+		return fmt.Errorf("script %s: %w", filePath, err)
+	}
+	return nil
+}
@@ -2,47 +2,194 @@ package bash_sandboxed
 
 import (
 	"fmt"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"mvdan.cc/sh/v3/syntax"
 )
 
-// validateRgArgs checks that rg --pre (preprocessor) references only
-// whitelisted commands. --pre executes COMMAND for each file searched,
-// so the command is validated recursively against the allowlist.
-func validateRgArgs(s *Sandbox, args []*syntax.Word) error {
+// rgDecompressors are the commands rg --search-zip runs, chosen by file
+// extension, to decompress the files it searches.
+var rgDecompressors = []string{"gzip", "bzip2", "xz", "lz4", "brotli", "zstd", "uncompress"}
+
+// rgLongValueOptions are the rg long options that take a value, which is
+// the next argument unless attached with "=". The value may itself be "--"
+// or look like an option (rg -e -- searches for "--").
+var rgLongValueOptions = map[string]bool{
+	"--after-context":           true,
+	"--before-context":          true,
+	"--color":                   true,
+	"--colors":                  true,
+	"--context":                 true,
+	"--context-separator":       true,
+	"--dfa-size-limit":          true,
+	"--encoding":                true,
+	"--engine":                  true,
+	"--field-context-separator": true,
+	"--field-match-separator":   true,
+	"--file":                    true,
+	"--generate":                true,
+	"--glob":                    true,
+	"--hostname-bin":            true,
+	"--hyperlink-format":        true,
+	"--iglob":                   true,
+	"--ignore-file":             true,
+	"--max-columns":             true,
+	"--max-count":               true,
+	"--max-depth":               true,
+	"--max-filesize":            true,
+	"--path-separator":          true,
+	"--pre":                     true,
+	"--pre-glob":                true,
+	"--regex-size-limit":        true,
+	"--regexp":                  true,
+	"--replace":                 true,
+	"--sort":                    true,
+	"--sortr":                   true,
+	"--threads":                 true,
+	"--type":                    true,
+	"--type-add":                true,
+	"--type-clear":              true,
+	"--type-not":                true,
+}
+
+// rgCommandOptions are the rg options whose value is a command rg runs:
+// --pre for each file searched, and --hostname-bin to find the hostname
+// for hyperlinks. An empty value disables them.
+var rgCommandOptions = map[string]bool{
+	"--pre":          true,
+	"--hostname-bin": true,
+}
+
+// rgOptionsEnd returns the index of the "--" that ends rg's options in
+// args, or len(args) if there is none, skipping option values. next reports
+// that the last option's value is missing.
+func rgOptionsEnd(args []string) (end int, next bool) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return i, false
+		case strings.HasPrefix(arg, "--"):
+			if _, _, attached := strings.Cut(arg, "="); !attached && rgLongValueOptions[arg] {
+				i++
+			}
+		default:
+			if found, value := rgShortValueOption(arg); found && value == "" {
+				i++
+			}
+		}
+		if i == len(args) {
+			return i, true
+		}
+	}
+	return len(args), false
+}
+
+// rgShortValueOption reports whether arg, a group of short options, ends
+// with one that takes a value, and returns the value attached to it.
+func rgShortValueOption(arg string) (found bool, value string) {
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return false, ""
+	}
+	for i := 1; i < len(arg); i++ {
+		if strings.IndexByte(rgValueOptions, arg[i]) >= 0 {
+			return true, arg[i+1:]
+		}
+	}
+	return false, ""
+}
+
+// validateRgArgs checks that the commands rg runs are allowed. --pre runs
+// a preprocessor for each file searched and --hostname-bin runs a command
+// for hyperlinks; both are validated recursively against the allowlist.
+// --search-zip (-z) runs rgDecompressors, which must all be allowed, since
+// which one runs depends on the files found. Pattern and ignore files (-f,
+// --ignore-file) are path-validated like other file operands (see
+// fileOperandFlags). Option values, which may be "--", are skipped, and
+// arguments after "--" are patterns or paths, not flags. rg has no option
+// that writes to a file.
+func validateRgArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
 	for i := 1; i < len(args); i++ {
 		text := wordText(args[i])
 		if text == "" {
 			continue
 		}
-		// --pre COMMAND (separate arg)
-		if text == "--pre" {
-			i++
-			if i >= len(args) {
-				return fmt.Errorf("rg --pre requires a command argument")
+		if text == "--" {
+			return nil
+		}
+		if strings.HasPrefix(text, "--") {
+			name, value, attached := strings.Cut(text, "=")
+			valueWord := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: value}}}
+			if !attached && rgLongValueOptions[name] {
+				i++
+				if i >= len(args) {
+					if rgCommandOptions[name] {
+						return fmt.Errorf("rg %s requires a command argument", name)
+					}
+					return nil
+				}
+				valueWord = args[i]
 			}
-			if err := validateSubCommand(s, args[i:i+1]); err != nil {
-				return fmt.Errorf("rg --pre: %w", err)
+			if rgCommandOptions[name] {
+				if attached && value == "" {
+					continue // an empty --pre= disables preprocessing
+				}
+				if err := validateSubCommand(st, []*syntax.Word{valueWord}, workDir); err != nil {
+					return fmt.Errorf("rg %s: %w", name, err)
+				}
+			}
+			if name == "--search-zip" {
+				if err := validateRgDecompressors(st, workDir); err != nil {
+					return err
+				}
 			}
 			continue
 		}
-		// --pre=COMMAND (inline)
-		if strings.HasPrefix(text, "--pre=") {
-			cmdName := text[len("--pre="):]
-			if cmdName == "" {
-				continue // empty --pre= disables preprocessing
-			}
-			cmdWord := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: cmdName}}}
-			if err := validateSubCommand(s, []*syntax.Word{cmdWord}); err != nil {
-				return fmt.Errorf("rg --pre: %w", err)
+		// --search-zip, possibly grouped with other short options (-iz)
+		if found, _ := shortOption(text, 'z', rgValueOptions); found {
+			if err := validateRgDecompressors(st, workDir); err != nil {
+				return err
 			}
-			continue
+		}
+		if found, value := rgShortValueOption(text); found && value == "" {
+			i++
+		}
+	}
+	return nil
+}
+
+// validateRgDecompressors checks that every command rg --search-zip may run
+// is allowed.
+func validateRgDecompressors(st *sandboxState, workDir string) error {
+	for _, name := range rgDecompressors {
+		cmdWord := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: name}}}
+		if err := validateSubCommand(st, []*syntax.Word{cmdWord}, workDir); err != nil {
+			return fmt.Errorf("rg --search-zip runs %s: %w", name, err)
 		}
 	}
 	return nil
 }
 
+// rgExcludeGit returns rg's expanded arguments with --glob=!.git added as
+// its last option, so that --hidden, -u and --no-ignore never search .git
+// directories. Path validation only blocks .git when it is named. Globs
+// given later take precedence in rg, so it goes after the other options.
+// If the last option is missing its value, args are returned unchanged and
+// rg reports the error.
+func rgExcludeGit(args []string) []string {
+	end, next := rgOptionsEnd(args)
+	if next {
+		return args
+	}
+	rewritten := make([]string, 0, len(args)+1)
+	rewritten = append(rewritten, args[:end]...)
+	rewritten = append(rewritten, "--glob=!.git")
+	return append(rewritten, args[end:]...)
+}
+
 // blockedFindFlags lists find flags that modify the filesystem or write to files.
 var blockedFindFlags = map[string]string{
 	"-delete":  "deletes files",
@@ -70,7 +217,12 @@ func isFindExecTerminator(lit string) bool {
 // validateFindArgs checks that find is not called with dangerous flags.
 // For -exec/-execdir/-ok/-okdir, the embedded subcommand is extracted and
 // validated recursively against the command whitelist.
-func validateFindArgs(s *Sandbox, args []*syntax.Word) error {
+//
+// Unlike most commands, find does not stop parsing its expression at "--":
+// the marker only ends find's own options before the starting points, and
+// predicates such as -delete that follow it are still evaluated. Every
+// argument is therefore checked regardless of "--".
+func validateFindArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
 	i := 1 // skip command name
 	for i < len(args) {
 		lit := args[i].Lit()
@@ -94,7 +246,7 @@ func validateFindArgs(s *Sandbox, args []*syntax.Word) error {
 			if len(subArgs) == 0 {
 				return fmt.Errorf("find %s has no command to execute", execFlag)
 			}
-			if err := validateSubCommand(s, subArgs); err != nil {
+			if err := validateSubCommand(st, subArgs, workDir); err != nil {
 				return fmt.Errorf("find %s: %w", execFlag, err)
 			}
 			continue
@@ -111,7 +263,7 @@ func validateFindArgs(s *Sandbox, args []*syntax.Word) error {
 // whitelist, including any per-command argument validators. args[0] must be
 // the command name. Used for recursive validation of commands embedded in
 // find -exec and xargs.
-func validateSubCommand(s *Sandbox, args []*syntax.Word) error {
+func validateSubCommand(st *sandboxState, args []*syntax.Word, workDir string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("empty command")
 	}
@@ -119,12 +271,17 @@ func validateSubCommand(s *Sandbox, args []*syntax.Word) error {
 	if cmdName == "" {
 		return fmt.Errorf("dynamic command names are not allowed")
 	}
-	extra := s.getExtraCommands()
-	if !allowedCommands[cmdName] && !extra[cmdName] {
-		return fmt.Errorf("command %q is not allowed", cmdName)
+	if st.deniedCommands[cmdName] {
+		return deniedCommandError(cmdName)
+	}
+	if !allowedCommands[cmdName] && !st.extraCommands[cmdName] {
+		if !st.cfg.WarnOnUnknownCommands() {
+			return commandNotAllowedError(cmdName)
+		}
+		warnUnknownCommand(cmdName)
 	}
-	if validator, ok := s.argValidators[cmdName]; ok {
-		if err := validator(s, args); err != nil {
+	if validator, ok := argValidators[cmdName]; ok {
+		if err := validator(st, args, workDir); err != nil {
 			return err
 		}
 	}
@@ -134,6 +291,7 @@ func validateSubCommand(s *Sandbox, args []*syntax.Word) error {
 // xargsArgConsumingFlags lists xargs short flags that consume the next
 // argument as their value (e.g., -I {}, -n 5).
 var xargsArgConsumingFlags = map[string]bool{
+	"-a": true, // GNU: read input from file (path-validated by validatePaths)
 	"-d": true, // GNU: delimiter character
 	"-E": true, // logical EOF string
 	"-I": true, // replace string
@@ -146,39 +304,534 @@ var xargsArgConsumingFlags = map[string]bool{
 	"-s": true, // max chars per command line
 }
 
-// validateXargsArgs validates xargs by extracting the utility command from
-// its arguments and recursively validating it against the command whitelist.
-// If no command is given, xargs defaults to echo which is safe.
-func validateXargsArgs(s *Sandbox, args []*syntax.Word) error {
+// xargsLongArgConsumingFlags lists GNU xargs long options whose value may be
+// the next argument. Options with an optional value (--eof, --replace,
+// --max-lines) only accept it after "=".
+var xargsLongArgConsumingFlags = map[string]bool{
+	"--arg-file":         true,
+	"--delimiter":        true,
+	"--max-args":         true,
+	"--max-chars":        true,
+	"--max-procs":        true,
+	"--process-slot-var": true,
+}
+
+// xargsCommandIndex returns the index in args of the utility run by
+// "xargs [OPTION]... [COMMAND...]", or len(args) if there is none and xargs
+// runs echo. A non-literal argument ("" from wordLits) starts the utility.
+func xargsCommandIndex(args []string) int {
 	i := 1 // skip "xargs"
 	for i < len(args) {
-		lit := args[i].Lit()
+		arg := args[i]
 		// End of options marker
-		if lit == "--" {
-			i++
-			if i < len(args) {
-				return validateSubCommand(s, args[i:])
-			}
-			return nil
+		if arg == "--" {
+			return i + 1
 		}
 		// Non-flag argument = start of the utility command
-		if !strings.HasPrefix(lit, "-") {
-			return validateSubCommand(s, args[i:])
+		if !strings.HasPrefix(arg, "-") {
+			return i
 		}
-		// Long option (--foo or --foo=val): always a single token
-		if strings.HasPrefix(lit, "--") {
+		// Long option: --foo=val is a single token, --foo val is two
+		if strings.HasPrefix(arg, "--") {
+			if xargsLongArgConsumingFlags[arg] {
+				i++
+			}
 			i++
 			continue
 		}
 		// Short flag: if exactly 2 chars ("-X") and it consumes the next arg,
 		// skip both. A longer token like "-I{}" has the value attached.
-		if len(lit) >= 2 && len(lit) == 2 && xargsArgConsumingFlags[lit[:2]] {
+		if len(arg) == 2 && xargsArgConsumingFlags[arg] {
 			i += 2
 			continue
 		}
 		i++
 	}
-	// No explicit command — xargs defaults to echo, which is safe
+	return len(args)
+}
+
+// xargsReplaceString returns the string that xargs replaces with input
+// items in its utility's arguments (-I, -i, --replace or BSD -J), or "" if
+// input items are appended to the command line instead.
+func xargsReplaceString(args []string) string {
+	repl := ""
+	for i := 1; i < xargsCommandIndex(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-I" || arg == "-J":
+			if i+1 < len(args) {
+				repl = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(arg, "-I") || strings.HasPrefix(arg, "-J"):
+			repl = arg[2:]
+		case arg == "-i" || arg == "--replace":
+			repl = "{}"
+		case strings.HasPrefix(arg, "-i"):
+			repl = arg[2:]
+		case strings.HasPrefix(arg, "--replace="):
+			repl = strings.TrimPrefix(arg, "--replace=")
+		case xargsLongArgConsumingFlags[arg] || len(arg) == 2 && xargsArgConsumingFlags[arg]:
+			i++
+		}
+	}
+	return repl
+}
+
+// xargsChecksInput reports whether items that xargs passes to the command
+// line args could bypass the argument validators or rewriters of the
+// command it runs, after unwrapping timeout, env, nice and ionice. bash, sh
+// and awk take a script as their first operand, so their input is not
+// treated as options. Commands in extra are not checked.
+func xargsChecksInput(args []string, extra map[string]bool) bool {
+	for len(args) > 0 {
+		if extra[args[0]] {
+			return false
+		}
+		wrapped := wrappedCommand(args)
+		if len(wrapped) == 0 {
+			break
+		}
+		args = wrapped
+	}
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "bash", "sh", "awk":
+		return false
+	}
+	_, validated := argValidators[args[0]]
+	_, rewritten := execArgRewriters[args[0]]
+	return validated || rewritten
+}
+
+// valueOptions are the options of a command that take a value: short
+// options by letter, and long options, which may be abbreviated.
+type valueOptions struct {
+	short string
+	long  map[string]bool
+}
+
+// takesValue reports whether arg is an option whose value is the next
+// argument: a long option without "=value", or a group of short options
+// ending with one that takes a value.
+func (o valueOptions) takesValue(arg string) bool {
+	if name, ok := strings.CutPrefix(arg, "--"); ok {
+		if name == "" || strings.Contains(name, "=") {
+			return false
+		}
+		for opt := range o.long {
+			if strings.HasPrefix(strings.TrimPrefix(opt, "--"), name) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	for i := 1; i < len(arg); i++ {
+		if strings.IndexByte(o.short, arg[i]) >= 0 {
+			return i == len(arg)-1
+		}
+	}
+	return false
+}
+
+// byteSet returns the keys of m as a string, for valueOptions.short.
+func byteSet(m map[byte]bool) string {
+	var b strings.Builder
+	for c := range m {
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// xargsEndOfOptions lists the commands with argument validators or rewriters
+// for which "--" only ends options, without changing what the operands after
+// it mean, and their options that take a value. rewriteXargsArgs appends "--"
+// to these when xargs runs them; xargs cannot pass its input to the others.
+// git subcommands are listed in xargsGitEndOfOptions.
+var xargsEndOfOptions = map[string]valueOptions{
+	"rg":        {rgValueOptions, rgLongValueOptions},
+	"tree":      {byteSet(treeArgConsumingFlags), treeLongArgConsumingFlags},
+	"ls":        {byteSet(lsArgConsumingFlags), lsLongArgConsumingFlags},
+	"du":        {byteSet(duArgConsumingFlags), duLongArgConsumingFlags},
+	"locate":    {"dlr", map[string]bool{"--database": true, "--limit": true, "--regexp": true}},
+	"which":     {},
+	"head":      {byteSet(headArgConsumingFlags), headLongArgConsumingFlags},
+	"tail":      {byteSet(tailArgConsumingFlags), tailLongArgConsumingFlags},
+	"rm":        {},
+	"sed":       {"efl", map[string]bool{"--expression": true, "--file": true, "--line-length": true}},
+	"sort":      {byteSet(sortArgConsumingFlags) + "o", sortLongArgConsumingFlags},
+	"man":       {byteSet(manArgConsumingFlags), manLongArgConsumingFlags},
+	"apropos":   {byteSet(manArgConsumingFlags), manLongArgConsumingFlags},
+	"info":      {byteSet(infoArgConsumingFlags), nil},
+	"seq":       {"fs", seqArgConsumingFlags},
+	"install":   {installValueOptions, installValueLongOptions},
+	"md5sum":    {"al", checksumArgConsumingFlags},
+	"sha1sum":   {"al", checksumArgConsumingFlags},
+	"sha256sum": {"al", checksumArgConsumingFlags},
+	"shasum":    {"al", checksumArgConsumingFlags},
+	"cksum":     {"al", checksumArgConsumingFlags},
+	"b2sum":     {"al", checksumArgConsumingFlags},
+}
+
+// xargsOptionsEnd reports whether "--" must be appended to utility, the
+// command line xargs runs, so that the items xargs reads are passed to the
+// command it runs, after unwrapping timeout, env, nice, ionice and xargs, as
+// operands. It returns an error if they cannot be: the command is not in
+// xargsEndOfOptions, or utility ends with an option whose value would be the
+// first item. It returns false if xargsChecksInput is false or utility
+// already ends its options with "--".
+func xargsOptionsEnd(utility []string, extra map[string]bool) (bool, error) {
+	if !xargsChecksInput(utility, extra) {
+		return false, nil
+	}
+	for wrapped := wrappedCommand(utility); len(wrapped) > 0; wrapped = wrappedCommand(utility) {
+		utility = wrapped
+	}
+	if utility[0] == "xargs" {
+		// The items are appended to the command line the inner xargs runs.
+		i := xargsCommandIndex(utility)
+		if i == len(utility) || xargsReplaceString(utility[:i]) != "" {
+			return false, fmt.Errorf("xargs cannot pass its input to xargs without a command or in replace mode: the items it reads could be a command or options, which are not validated")
+		}
+		return xargsOptionsEnd(utility[i:], extra)
+	}
+	name, start := utility[0], 1
+	opts, ok := xargsEndOfOptions[name]
+	if name == "git" {
+		start = gitSubcommandIndex(utility)
+		if start < len(utility) {
+			name = "git " + utility[start]
+			opts, ok = xargsGitEndOfOptions[utility[start]]
+			start++
+		}
+	}
+	if !ok {
+		return false, fmt.Errorf("xargs cannot pass its input to %s: the items it reads could be options, which are not validated; use -I with the replace string after \"--\"", name)
+	}
+	for i := start; i < len(utility); i++ {
+		if utility[i] == "--" {
+			return false, nil
+		}
+		if opts.takesValue(utility[i]) {
+			if i == len(utility)-1 {
+				return false, fmt.Errorf("xargs %s: the value of %s would come from its input", name, utility[i])
+			}
+			i++
+		}
+	}
+	return true, nil
+}
+
+// validateXargsArgs validates xargs by extracting the utility command from
+// its arguments and recursively validating it against the command whitelist.
+// If no command is given, xargs defaults to echo which is safe. Input items
+// are never seen by the sandbox, so they must not supply the command run by
+// a wrapper, a pnpm subcommand or the manifests of a checksum -c, nor
+// options to commands with argument validators: in replace mode the replace
+// string must follow "--", otherwise rewriteXargsArgs appends "--" to the
+// utility when it runs, and utilities it cannot be appended to are rejected
+// (see xargsOptionsEnd).
+func validateXargsArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	lits := wordLits(args)
+	i := xargsCommandIndex(lits)
+	if i == len(args) {
+		return nil
+	}
+	if err := validateSubCommand(st, args[i:], workDir); err != nil {
+		return err
+	}
+	utility := lits[i:]
+	for !st.extraCommands[utility[0]] {
+		wrapped := wrappedCommand(utility)
+		if len(wrapped) == 0 {
+			break
+		}
+		utility = wrapped
+	}
+	if st.extraCommands[utility[0]] {
+		return nil
+	}
+	switch utility[0] {
+	case "timeout", "env", "nice", "ionice":
+		return fmt.Errorf("xargs cannot run %s without a command: the command would come from its input", utility[0])
+	case "pnpm":
+		if pnpmSubcommandIndex(utility) == len(utility) {
+			return fmt.Errorf("xargs cannot run pnpm without a subcommand: the subcommand would come from its input")
+		}
+	case "git":
+		if gitSubcommandIndex(utility) == len(utility) {
+			return fmt.Errorf("xargs cannot run git without a subcommand: the subcommand would come from its input")
+		}
+	}
+	if checksumCommands[utility[0]] {
+		if check, _ := checksumCheckMode(utility); check {
+			return fmt.Errorf("xargs cannot run %s -c: the checksum files it reads from its input cannot be validated", utility[0])
+		}
+	}
+	repl := xargsReplaceString(lits[:i])
+	if repl == "" {
+		_, err := xargsOptionsEnd(utility, st.extraCommands)
+		return err
+	}
+	if !xargsChecksInput(utility, st.extraCommands) {
+		return nil
+	}
+	for _, arg := range utility[1:] {
+		if arg == "--" {
+			break
+		}
+		if strings.Contains(arg, repl) {
+			return fmt.Errorf("xargs %s: %q must follow \"--\", so that input items are not read as options", utility[0], arg)
+		}
+	}
+	return nil
+}
+
+// timeoutArgConsumingFlags lists timeout options that consume the next
+// argument as their value.
+var timeoutArgConsumingFlags = map[string]bool{
+	"-s":           true, // signal
+	"-k":           true, // kill after duration
+	"--signal":     true,
+	"--kill-after": true,
+}
+
+// timeoutCommandIndex returns the index in args of the command run by
+// "timeout [OPTION]... DURATION COMMAND...", or len(args) if there is none.
+// Options are only recognized before DURATION, as timeout parses them.
+func timeoutCommandIndex(args []string) int {
+	i := 1 // skip "timeout"
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		if timeoutArgConsumingFlags[arg] {
+			i++
+		}
+		i++
+	}
+	return min(i+1, len(args)) // skip DURATION
+}
+
+// validateTimeoutArgs validates the command run by timeout against the
+// command whitelist, like xargs. timeout execs the command itself, so the
+// interpreter's exec handler never sees it.
+func validateTimeoutArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	if i := timeoutCommandIndex(wordLits(args)); i < len(args) {
+		return validateSubCommand(st, args[i:], workDir)
+	}
+	return nil
+}
+
+// envArgConsumingFlags lists env options that consume the next argument as
+// their value.
+var envArgConsumingFlags = map[string]bool{
+	"-u":      true, // unset variable
+	"-C":      true, // change directory (path-validated by validatePaths)
+	"--unset": true,
+	"--chdir": true,
+}
+
+// blockedEnvFlags lists env options that run a command which cannot be
+// validated statically.
+var blockedEnvFlags = map[string]string{
+	"-S":             "splits a string into a command line",
+	"--split-string": "splits a string into a command line",
+	"-P":             "searches an alternate PATH",
+}
+
+// envCommandIndex returns the index in args of the command run by
+// "env [OPTION]... [NAME=VALUE]... COMMAND...", or len(args) if env only
+// prints the environment. It returns an error for a blocked option.
+func envCommandIndex(args []string) (int, error) {
+	i := 1 // skip "env"
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name, _, _ := strings.Cut(arg, "=")
+		if reason, blocked := blockedEnvFlags[name]; blocked {
+			return 0, fmt.Errorf("env flag %q is not allowed: %s", name, reason)
+		}
+		if reason, blocked := blockedEnvFlags[arg[:2]]; blocked && arg[1] != '-' {
+			return 0, fmt.Errorf("env flag %q is not allowed: %s", arg[:2], reason)
+		}
+		if envArgConsumingFlags[arg] {
+			i++
+		}
+		i++
+	}
+	for i < len(args) && strings.Contains(args[i], "=") && !strings.HasPrefix(args[i], "=") {
+		i++
+	}
+	return i, nil
+}
+
+// validateEnvArgs blocks env assignments to the variables in blockedEnvVars
+// and validates the command env runs against the command whitelist. Like
+// timeout, env execs the command itself.
+func validateEnvArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	lits := wordLits(args)
+	i, err := envCommandIndex(lits)
+	if err != nil {
+		return err
+	}
+	for _, arg := range lits[1:i] {
+		name, _, ok := strings.Cut(arg, "=")
+		if !ok {
+			continue
+		}
+		if reason, blocked := blockedEnvVars[name]; blocked {
+			return fmt.Errorf("setting %s is not allowed: %s", name, reason)
+		}
+	}
+	if i < len(args) {
+		return validateSubCommand(st, args[i:], workDir)
+	}
+	return nil
+}
+
+// niceCommandIndex returns the index in args of the command run by
+// "nice [OPTION]... [COMMAND...]", or len(args) if nice only prints the
+// niceness. Besides -n N, nice accepts the obsolete -N form, which takes no
+// separate value.
+func niceCommandIndex(args []string) int {
+	i := 1 // skip "nice"
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		if arg == "-n" || arg == "--adjustment" {
+			i++
+		}
+		i++
+	}
+	return min(i, len(args))
+}
+
+// validateNiceArgs validates the command run by nice against the command
+// whitelist, like timeout.
+func validateNiceArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	if i := niceCommandIndex(wordLits(args)); i < len(args) {
+		return validateSubCommand(st, args[i:], workDir)
+	}
+	return nil
+}
+
+// ioniceArgConsumingFlags lists the ionice options that take a value, which
+// may be attached (-c3) or the next argument.
+var ioniceArgConsumingFlags = map[string]bool{
+	"-c":          true, // scheduling class
+	"-n":          true, // priority within the class
+	"--class":     true,
+	"--classdata": true,
+}
+
+// blockedIoniceFlags lists ionice options that change the I/O priority of
+// processes that are already running instead of running a command.
+var blockedIoniceFlags = map[string]bool{
+	"-p":     true,
+	"-P":     true,
+	"-u":     true,
+	"--pid":  true,
+	"--pgid": true,
+	"--uid":  true,
+}
+
+// ioniceCommandIndex returns the index in args of the command run by
+// "ionice [OPTION]... COMMAND...", or len(args) if there is none. It returns
+// an error for an option that targets running processes.
+func ioniceCommandIndex(args []string) (int, error) {
+	i := 1 // skip "ionice"
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		i++
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(arg, "=")
+			if blockedIoniceFlags[name] {
+				return 0, fmt.Errorf("ionice flag %q is not allowed: changes the I/O priority of running processes", name)
+			}
+			if ioniceArgConsumingFlags[name] && !hasValue {
+				i++
+			}
+			continue
+		}
+		// A cluster of short options, such as -tc3, ends at the first
+		// option that takes a value.
+		for j := 1; j < len(arg); j++ {
+			flag := "-" + arg[j:j+1]
+			if blockedIoniceFlags[flag] {
+				return 0, fmt.Errorf("ionice flag %q is not allowed: changes the I/O priority of running processes", flag)
+			}
+			if ioniceArgConsumingFlags[flag] {
+				if j == len(arg)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return min(i, len(args)), nil
+}
+
+// validateIoniceArgs validates the command run by ionice against the command
+// whitelist, like timeout, and blocks changing running processes.
+func validateIoniceArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	i, err := ioniceCommandIndex(wordLits(args))
+	if err != nil {
+		return err
+	}
+	if i < len(args) {
+		return validateSubCommand(st, args[i:], workDir)
+	}
+	return nil
+}
+
+// wrappedCommand returns the command run by a timeout, env, nice or ionice
+// invocation, so that path checks apply to it as if it ran directly. It
+// returns nil if args is not such an invocation.
+func wrappedCommand(args []string) []string {
+	switch args[0] {
+	case "timeout":
+		return args[timeoutCommandIndex(args):]
+	case "env":
+		if i, err := envCommandIndex(args); err == nil {
+			return args[i:]
+		}
+	case "nice":
+		return args[niceCommandIndex(args):]
+	case "ionice":
+		if i, err := ioniceCommandIndex(args); err == nil {
+			return args[i:]
+		}
+	}
 	return nil
 }
 
@@ -192,13 +845,17 @@ var blockedTarOps = map[byte]string{
 
 // validateTarArgs ensures tar is invoked in list mode only (-t/--list).
 // Blocks extract (-x), create (-c), append (-r), update (-u), and --delete.
-func validateTarArgs(_ *Sandbox, args []*syntax.Word) error {
+// Arguments after "--" are archive member names, not flags.
+func validateTarArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
 	hasListMode := false
 	for _, arg := range args[1:] { // skip command name
 		lit := arg.Lit()
 		if lit == "" {
 			continue
 		}
+		if lit == "--" {
+			break
+		}
 		// Check long options
 		if lit == "--list" {
 			hasListMode = true
@@ -254,7 +911,7 @@ func validateTarArgs(_ *Sandbox, args []*syntax.Word) error {
 
 // validateUnzipArgs ensures unzip is invoked in list/test mode only.
 // Requires -l (list), -Z (zipinfo mode), or -t (test integrity).
-func validateUnzipArgs(_ *Sandbox, args []*syntax.Word) error {
+func validateUnzipArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
 	hasReadOnlyFlag := false
 	for _, arg := range args[1:] {
 		lit := arg.Lit()
@@ -292,7 +949,7 @@ var blockedArOps = map[byte]string{
 
 // validateArArgs ensures ar is invoked in read-only mode only.
 // Only permits t (list) and p (print to stdout) operations.
-func validateArArgs(_ *Sandbox, args []*syntax.Word) error {
+func validateArArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("ar requires an operation argument")
 	}
@@ -321,3 +978,750 @@ func validateArArgs(_ *Sandbox, args []*syntax.Word) error {
 	}
 	return nil
 }
+
+// treeArgConsumingFlags lists tree short flags that consume the next argument
+// as their value (e.g., -L 2, -I 'node_modules').
+var treeArgConsumingFlags = map[byte]bool{
+	'L': true, // max display depth
+	'P': true, // include pattern
+	'I': true, // exclude pattern
+	'H': true, // HTML base href
+	'T': true, // HTML title
+	'o': true, // output file (blocked)
+}
+
+// treeLongArgConsumingFlags lists tree long options that take a separate value.
+var treeLongArgConsumingFlags = map[string]bool{
+	"--charset":   true,
+	"--filelimit": true,
+	"--timefmt":   true,
+	"--sort":      true,
+	"--hintro":    true,
+	"--houtro":    true,
+}
+
+// blockedTreeFlags lists tree short flags that write files or escape path validation.
+var blockedTreeFlags = map[byte]string{
+	'o': "writes output to a file",
+	'l': "follows symbolic links to directories outside path validation",
+}
+
+// validateTreeArgs checks that tree is not called with -o (file output) or
+// -l (follow directory symlinks). Short flags may be combined (e.g., -aL 2),
+// so each character is checked. Root directory arguments are validated
+// against the read paths by validatePaths.
+func validateTreeArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	i := 1 // skip command name
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			return nil
+		}
+		if strings.HasPrefix(lit, "--") {
+			if treeLongArgConsumingFlags[lit] {
+				i++
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if reason, blocked := blockedTreeFlags[lit[j]]; blocked {
+				return fmt.Errorf("tree flag '-%c' is not allowed: %s", lit[j], reason)
+			}
+			if treeArgConsumingFlags[lit[j]] {
+				i++
+			}
+		}
+	}
+	return nil
+}
+
+// tailArgConsumingFlags lists tail short flags that consume the next
+// argument (or the rest of the cluster) as their value.
+var tailArgConsumingFlags = map[byte]bool{
+	'c': true, // bytes
+	'n': true, // lines
+	's': true, // sleep interval
+}
+
+// tailLongArgConsumingFlags lists tail long options that take a separate value.
+var tailLongArgConsumingFlags = map[string]bool{
+	"--bytes":               true,
+	"--lines":               true,
+	"--sleep-interval":      true,
+	"--pid":                 true,
+	"--max-unchanged-stats": true,
+}
+
+// headArgConsumingFlags lists head short flags that consume the next
+// argument (or the rest of the cluster) as their value.
+var headArgConsumingFlags = map[byte]bool{
+	'c': true, // bytes
+	'n': true, // lines
+}
+
+// headLongArgConsumingFlags lists head long options that take a separate value.
+var headLongArgConsumingFlags = map[string]bool{
+	"--bytes": true,
+	"--lines": true,
+}
+
+// obsoleteByteCount matches the obsolete first-argument byte count of GNU
+// head and tail, e.g. -500c.
+var obsoleteByteCount = regexp.MustCompile(`^-([0-9]+)c$`)
+
+// byteSuffixPowers are the multiplier suffixes head and tail accept after a
+// byte count, in increasing powers of 1024 (K, KiB) or 1000 (KB).
+const byteSuffixPowers = "KMGTPEZYRQ"
+
+// byteCountArgs returns the values of -c and --bytes (or an abbreviation of
+// it) in the arguments of head or tail, including an obsolete -NUMc first
+// argument. shortValue and longValue are the command's options that take a
+// value, so that values are not mistaken for options. Dynamic values are
+// returned as "".
+func byteCountArgs(args []*syntax.Word, shortValue map[byte]bool, longValue map[string]bool) []string {
+	var counts []string
+	if len(args) > 1 {
+		if m := obsoleteByteCount.FindStringSubmatch(wordText(args[1])); m != nil {
+			counts = append(counts, m[1])
+		}
+	}
+	for i := 1; i < len(args); i++ {
+		lit := wordText(args[i])
+		if lit == "--" {
+			break
+		}
+		if strings.HasPrefix(lit, "--") {
+			name, value, attached := strings.Cut(lit, "=")
+			bytes := len(name) > 2 && strings.HasPrefix("--bytes", name)
+			if !attached && (bytes || longValue[name]) && i+1 < len(args) {
+				i++
+				value = wordText(args[i])
+			}
+			if bytes {
+				counts = append(counts, value)
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if !shortValue[lit[j]] {
+				continue
+			}
+			// The rest of the cluster, or the next argument, is the value.
+			value := lit[j+1:]
+			if value == "" && i+1 < len(args) {
+				i++
+				value = wordText(args[i])
+			}
+			if lit[j] == 'c' {
+				counts = append(counts, value)
+			}
+			break
+		}
+	}
+	return counts
+}
+
+// parseByteCount parses a head or tail byte count such as 1024, 10K, 5MiB,
+// 3MB or 2b (512-byte blocks), ignoring a leading sign. It returns false if
+// count is not a valid byte count, which the command rejects itself.
+func parseByteCount(count string) (float64, bool) {
+	if count != "" && (count[0] == '+' || count[0] == '-') {
+		count = count[1:]
+	}
+	end := 0
+	for end < len(count) && count[end] >= '0' && count[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(count[:end], 64)
+	if err != nil {
+		return 0, false
+	}
+	suffix := count[end:]
+	switch suffix {
+	case "":
+		return n, true
+	case "b":
+		return n * 512, true
+	}
+	power := strings.IndexByte(byteSuffixPowers, suffix[0])
+	if power < 0 {
+		return 0, false
+	}
+	switch suffix[1:] {
+	case "", "iB":
+		return n * math.Pow(1024, float64(power+1)), true
+	case "B":
+		return n * math.Pow(1000, float64(power+1)), true
+	}
+	return 0, false
+}
+
+// validateByteCounts rejects head or tail byte counts above
+// max_head_tail_bytes. head and tail read up to that many bytes, and buffer
+// them when printing all but (head -c -N) or only (tail -c N) the last N
+// bytes of input that cannot seek, even if their output goes to a file the
+// output limit does not cover. A tail count starting with + is the offset
+// to start at, so it is not limited.
+func validateByteCounts(st *sandboxState, cmdName string, counts []string) error {
+	limit := st.cfg.HeadTailByteLimit()
+	if limit == 0 {
+		return nil
+	}
+	for _, count := range counts {
+		if cmdName == "tail" && strings.HasPrefix(count, "+") {
+			continue
+		}
+		if n, ok := parseByteCount(count); ok && n > float64(limit) {
+			return fmt.Errorf("%s -c %s is more than the limit of %d bytes (max_head_tail_bytes)", cmdName, count, limit)
+		}
+	}
+	return nil
+}
+
+// validateHeadArgs blocks byte counts above max_head_tail_bytes.
+func validateHeadArgs(st *sandboxState, args []*syntax.Word, _ string) error {
+	return validateByteCounts(st, "head", byteCountArgs(args, headArgConsumingFlags, headLongArgConsumingFlags))
+}
+
+// maxSeqLines is the most lines seq may print. Larger ranges such as
+// "seq 1 1e18" burn CPU until the command times out and produce output no
+// caller can use.
+const maxSeqLines = 10_000_000
+
+// seqArgConsumingFlags lists seq options that consume the next argument.
+var seqArgConsumingFlags = map[string]bool{
+	"-f":          true,
+	"-s":          true,
+	"--format":    true,
+	"--separator": true,
+}
+
+// validateSeqArgs estimates how many lines "seq [FIRST [INCREMENT]] LAST"
+// prints and blocks ranges longer than maxSeqLines. Ranges with operands that
+// are not known statically are left to the command timeout, as are bc and dc
+// programs, whose cost cannot be bounded without running them.
+func validateSeqArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	var operands []float64
+	for i := 1; i < len(args); i++ {
+		lit := args[i].Lit()
+		if lit == "" {
+			return nil // dynamic argument
+		}
+		n, err := strconv.ParseFloat(lit, 64)
+		if err == nil {
+			operands = append(operands, n)
+			continue
+		}
+		if seqArgConsumingFlags[lit] {
+			i++
+		}
+	}
+	first, inc := 1.0, 1.0
+	var last float64
+	switch len(operands) {
+	case 1:
+		last = operands[0]
+	case 2:
+		first, last = operands[0], operands[1]
+	case 3:
+		first, inc, last = operands[0], operands[1], operands[2]
+	default:
+		return nil // seq reports the usage error
+	}
+	if inc == 0 || math.IsNaN(first) || math.IsNaN(inc) || math.IsNaN(last) {
+		return nil // seq rejects these itself
+	}
+	lines := math.Floor((last-first)/inc) + 1
+	if lines > maxSeqLines {
+		return fmt.Errorf("seq would print %g lines, more than the limit of %d", lines, maxSeqLines)
+	}
+	return nil
+}
+
+// checksumArgConsumingFlags lists options of the checksum commands (b2sum
+// --length, cksum and shasum --algorithm) that consume the next argument.
+var checksumArgConsumingFlags = map[string]bool{
+	"-a":          true,
+	"-l":          true,
+	"--algorithm": true,
+	"--length":    true,
+}
+
+// checksumCheckMode reports whether a checksum command (sha256sum, md5sum,
+// etc.) is in -c/--check mode, and returns its file operands, which in that
+// mode are manifests listing other files to hash. Non-literal words are
+// returned as "".
+func checksumCheckMode(args []string) (bool, []string) {
+	check := false
+	var files []string
+	endOfOptions := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case endOfOptions || arg == "-" || !strings.HasPrefix(arg, "-"):
+			files = append(files, arg)
+		case arg == "--":
+			endOfOptions = true
+		case arg == "--check":
+			check = true
+		case checksumArgConsumingFlags[arg]:
+			i++
+		case strings.HasPrefix(arg, "--"):
+		default:
+			// Short flag cluster, e.g. -cw or -a256
+			for j, ch := range arg[1:] {
+				if ch == 'c' {
+					check = true
+				}
+				if ch == 'a' || ch == 'l' {
+					if j == len(arg)-2 {
+						i++
+					}
+					break
+				}
+			}
+		}
+	}
+	return check, files
+}
+
+// validateChecksumArgs validates the commands in checksumCommands. Options
+// such as --tag, --zero and -b only change the output format and are allowed.
+// In -c/--check mode the commands hash the files listed in a manifest; the
+// manifest and the files it lists are checked against the read-allowed paths
+// at runtime by validateChecksumManifests, which needs the manifest to be a
+// file, so reading the manifest from standard input is blocked.
+func validateChecksumArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		texts[i] = wordText(arg)
+	}
+	check, files := checksumCheckMode(texts)
+	if !check {
+		return nil
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, f := range files {
+		if f == "-" {
+			return fmt.Errorf("%s -c from standard input is not allowed: the files it lists cannot be validated; pass the checksum file as an argument", texts[0])
+		}
+	}
+	return nil
+}
+
+// tailObsoleteFollow matches GNU tail's obsolete first-argument syntax with a
+// trailing follow flag, e.g. -5f or +10lf.
+var tailObsoleteFollow = regexp.MustCompile(`^[-+][0-9]*[bcl]?f$`)
+
+// validateTailArgs blocks following files (-f, -F, --follow, --retry) unless
+// allow_follow is set. A following tail never exits on its own, so it holds
+// Execute until the command times out. Byte counts above
+// max_head_tail_bytes are blocked too. Positional file arguments are still
+// checked by validatePaths.
+func validateTailArgs(st *sandboxState, args []*syntax.Word, _ string) error {
+	if err := validateByteCounts(st, "tail", byteCountArgs(args, tailArgConsumingFlags, tailLongArgConsumingFlags)); err != nil {
+		return err
+	}
+	if st.cfg.FollowAllowed() {
+		return nil
+	}
+	i := 1 // skip command name
+	if i < len(args) {
+		if lit := wordText(args[i]); tailObsoleteFollow.MatchString(lit) {
+			return fmt.Errorf("tail %s is not allowed (blocks indefinitely)", lit)
+		}
+	}
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			break
+		}
+		if strings.HasPrefix(lit, "--") {
+			name, _, _ := strings.Cut(lit, "=")
+			if name == "--follow" || name == "--retry" {
+				return fmt.Errorf("tail %s is not allowed (blocks indefinitely)", name)
+			}
+			if tailLongArgConsumingFlags[lit] {
+				i++
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if lit[j] == 'f' || lit[j] == 'F' {
+				return fmt.Errorf("tail -%c is not allowed (blocks indefinitely)", lit[j])
+			}
+			if tailArgConsumingFlags[lit[j]] {
+				// The rest of the cluster, or the next argument, is the value.
+				if j == len(lit)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// lsArgConsumingFlags lists ls short flags that consume the next argument
+// as their value (e.g., -I '*.o', -w 80).
+var lsArgConsumingFlags = map[byte]bool{
+	'I': true, // ignore pattern
+	'T': true, // tab size
+	'w': true, // output width
+}
+
+// lsLongArgConsumingFlags lists ls long options that take a separate value.
+var lsLongArgConsumingFlags = map[string]bool{
+	"--block-size":      true,
+	"--format":          true,
+	"--hide":            true,
+	"--ignore":          true,
+	"--indicator-style": true,
+	"--quoting-style":   true,
+	"--sort":            true,
+	"--tabsize":         true,
+	"--time":            true,
+	"--time-style":      true,
+	"--width":           true,
+}
+
+// validateLsArgs blocks recursive listing combined with -L/--dereference.
+// ls -R does not follow symlinks to directories by default, so a symlink
+// inside the sandbox pointing elsewhere is listed but not descended into.
+// With -L, ls -R would traverse the symlink target, which path validation
+// of the positional arguments cannot see.
+func validateLsArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	recursive := false
+	dereference := ""
+	i := 1 // skip command name
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			break
+		}
+		if strings.HasPrefix(lit, "--") {
+			switch lit {
+			case "--recursive":
+				recursive = true
+			case "--dereference":
+				dereference = lit
+			}
+			if lsLongArgConsumingFlags[lit] {
+				i++
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			switch lit[j] {
+			case 'R':
+				recursive = true
+			case 'L':
+				dereference = "-L"
+			}
+			if lsArgConsumingFlags[lit[j]] {
+				// The rest of the cluster, or the next argument, is the value.
+				if j == len(lit)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	if recursive && dereference != "" {
+		return fmt.Errorf("ls flag %q is not allowed with -R: follows symbolic links outside path validation", dereference)
+	}
+	return nil
+}
+
+// duArgConsumingFlags lists du short flags that consume the next argument
+// (or the rest of the cluster) as their value.
+var duArgConsumingFlags = map[byte]bool{
+	'B': true, // block size
+	'd': true, // max depth
+	't': true, // threshold
+	'X': true, // exclude-from file
+}
+
+// duLongArgConsumingFlags lists du long options that take a separate value.
+var duLongArgConsumingFlags = map[string]bool{
+	"--block-size":   true,
+	"--max-depth":    true,
+	"--threshold":    true,
+	"--exclude":      true,
+	"--exclude-from": true,
+	"--files0-from":  true,
+	"--time-style":   true,
+}
+
+// validateLocateArgs blocks locate under block_system_enumeration. Its
+// database lists files across the whole host, so its output is not limited
+// to the allowed paths.
+func validateLocateArgs(st *sandboxState, _ []*syntax.Word, _ string) error {
+	if st.cfg.BlocksSystemEnumeration() {
+		return fmt.Errorf("locate is not allowed with block_system_enumeration: its database lists files across the whole host")
+	}
+	return nil
+}
+
+// validateCommandLookupArgs limits which, whereis, type and command -v/-V
+// under block_system_enumeration to looking up commands the sandbox allows,
+// so they cannot be used to probe which other programs the host has
+// installed and where. Names must be literal, and at least one is required
+// so that names cannot be supplied by xargs.
+func validateCommandLookupArgs(st *sandboxState, args []*syntax.Word, _ string) error {
+	if !st.cfg.BlocksSystemEnumeration() {
+		return nil
+	}
+	cmdName := args[0].Lit()
+	lookup := cmdName != "command"
+	i := 1
+	for ; i < len(args); i++ {
+		lit := args[i].Lit()
+		if lit == "--" {
+			i++
+			break
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			break
+		}
+		if strings.ContainsAny(lit, "vV") {
+			lookup = true
+		}
+	}
+	if !lookup {
+		return nil // command runs its operand, which is validated itself
+	}
+	if i == len(args) {
+		return fmt.Errorf("%s without command names is not allowed with block_system_enumeration", cmdName)
+	}
+	for _, arg := range args[i:] {
+		name := arg.Lit()
+		if name == "" {
+			return fmt.Errorf("%s with dynamic command names is not allowed with block_system_enumeration", cmdName)
+		}
+		if strings.HasPrefix(name, "-") {
+			continue // a later flag, such as whereis -f
+		}
+		if st.whyAllowed(name) == "" {
+			return fmt.Errorf("%s %s is not allowed with block_system_enumeration: only allowed commands can be looked up", cmdName, name)
+		}
+	}
+	return nil
+}
+
+// validateDuArgs blocks -L/--dereference. du descends into directories, and
+// with -L it follows every symlink it meets, so a symlink inside an allowed
+// directory would be traversed to its target, which path validation of the
+// operands cannot see. -D/-H/--dereference-args only follow symlinks given
+// as operands, which path validation resolves, and are allowed.
+func validateDuArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	i := 1 // skip command name
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			return nil
+		}
+		if strings.HasPrefix(lit, "--") {
+			if lit == "--dereference" {
+				return fmt.Errorf("du flag %q is not allowed: follows symbolic links outside path validation", lit)
+			}
+			if duLongArgConsumingFlags[lit] {
+				i++
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if lit[j] == 'L' {
+				return fmt.Errorf("du flag \"-L\" is not allowed: follows symbolic links outside path validation")
+			}
+			if duArgConsumingFlags[lit[j]] {
+				// The rest of the cluster, or the next argument, is the value.
+				if j == len(lit)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// manArgConsumingFlags lists man and apropos short flags that consume the
+// next argument (or the rest of the cluster) as their value.
+var manArgConsumingFlags = map[byte]bool{
+	'C': true, // config file (blocked)
+	'E': true, // output encoding
+	'L': true, // locale
+	'M': true, // manual path
+	'P': true, // pager (blocked)
+	'R': true, // recode encoding
+	'S': true, // sections
+	'e': true, // section suffix
+	'm': true, // other systems
+	'p': true, // preprocessors
+	'r': true, // pager prompt
+	's': true, // sections
+	'T': true, // troff device
+}
+
+// manLongArgConsumingFlags lists man and apropos long options that take a
+// separate value.
+var manLongArgConsumingFlags = map[string]bool{
+	"--encoding":     true,
+	"--extension":    true,
+	"--locale":       true,
+	"--manpath":      true,
+	"--preprocessor": true,
+	"--prompt":       true,
+	"--recode":       true,
+	"--sections":     true,
+	"--systems":      true,
+}
+
+// blockedManFlags lists man and apropos short flags that run another program.
+var blockedManFlags = map[byte]string{
+	'C': "the config file can set the pager and formatter commands",
+	'H': "runs a web browser",
+	'P': "runs an arbitrary pager command",
+	'X': "runs an X viewer",
+}
+
+// blockedManLongFlags lists the long forms of blockedManFlags.
+var blockedManLongFlags = map[string]string{
+	"--config-file": "the config file can set the pager and formatter commands",
+	"--html":        "runs a web browser",
+	"--pager":       "runs an arbitrary pager command",
+	"--gxditview":   "runs an X viewer",
+}
+
+// validateManArgs blocks man and apropos flags that run a pager, browser or
+// viewer, or load a config file that can. The exec handler also forces
+// "-P cat" on man. Files displayed with -l/--local-file are positional
+// arguments and are validated against the read paths by validatePaths.
+func validateManArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	cmdName := wordText(args[0])
+	i := 1 // skip command name
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			break
+		}
+		if strings.HasPrefix(lit, "--") {
+			name, _, hasValue := strings.Cut(lit, "=")
+			if reason, blocked := blockedManLongFlags[name]; blocked {
+				return fmt.Errorf("%s flag %q is not allowed: %s", cmdName, name, reason)
+			}
+			if manLongArgConsumingFlags[name] && !hasValue {
+				i++
+			}
+			continue
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if reason, blocked := blockedManFlags[lit[j]]; blocked {
+				return fmt.Errorf("%s flag '-%c' is not allowed: %s", cmdName, lit[j], reason)
+			}
+			if manArgConsumingFlags[lit[j]] {
+				// The rest of the cluster, or the next argument, is the value.
+				if j == len(lit)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// manCatPager returns man's expanded arguments with "-P cat" inserted, so
+// that man never runs the pager named by MANPAGER or PAGER (or its default,
+// less, which can run shell commands).
+func manCatPager(args []string) []string {
+	rewritten := make([]string, 0, len(args)+2)
+	rewritten = append(rewritten, args[0], "-P", "cat")
+	return append(rewritten, args[1:]...)
+}
+
+// infoArgConsumingFlags lists info short flags that consume the next
+// argument (or the rest of the cluster) as their value.
+var infoArgConsumingFlags = map[byte]bool{
+	'd': true, // directory
+	'f': true, // file
+	'k': true, // apropos string
+	'n': true, // node
+	'o': true, // output file
+	'x': true, // debug level
+}
+
+// validateInfoArgs blocks info flags that write files: -o/--output (unless
+// writing to standard output with "-") and --dribble, which records
+// keystrokes. Files read with -f are validated by validatePaths.
+func validateInfoArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	i := 1 // skip command name
+	for i < len(args) {
+		lit := wordText(args[i])
+		i++
+		if lit == "--" {
+			break
+		}
+		output, isOutput := "", false
+		if strings.HasPrefix(lit, "--") {
+			name, value, hasValue := strings.Cut(lit, "=")
+			switch name {
+			case "--dribble":
+				return fmt.Errorf("info flag %q is not allowed: writes keystrokes to a file", name)
+			case "--output", "--out":
+				isOutput, output = true, value
+				if !hasValue && i < len(args) {
+					output = wordText(args[i])
+					i++
+				}
+			}
+		} else if len(lit) >= 2 && lit[0] == '-' {
+			for j := 1; j < len(lit); j++ {
+				if !infoArgConsumingFlags[lit[j]] {
+					continue
+				}
+				// The rest of the cluster, or the next argument, is the value.
+				value := lit[j+1:]
+				if value == "" && i < len(args) {
+					value = wordText(args[i])
+					i++
+				}
+				isOutput, output = lit[j] == 'o', value
+				break
+			}
+		}
+		if isOutput && output != "-" {
+			return fmt.Errorf("info output to %q is not allowed: writes a file (use -o - for standard output)", output)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package bash_sandboxed
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/gartnera/lite-sandbox/config"
@@ -54,6 +56,14 @@ var gitLocalWriteSubcommands = map[string]bool{
 	"am":          true,
 }
 
+// gitPatchSubcommands are local write subcommands that apply patches to the
+// working tree. Their --directory option prepends a directory to the paths
+// in the patch, and --unsafe-paths lets those paths leave the working tree.
+var gitPatchSubcommands = map[string]bool{
+	"apply": true,
+	"am":    true,
+}
+
 // gitRemoteReadSubcommands are subcommands that read from remotes.
 var gitRemoteReadSubcommands = map[string]bool{
 	"fetch":     true,
@@ -122,8 +132,204 @@ var blockedRemoteWriteSubcommands = map[string]bool{
 	"prune":    true,
 }
 
+// blockedGitConfigKeys are config keys that make git run a command, and so
+// cannot be set with "git -c" or "git --config-env". Keys are lowercase;
+// blockedGitConfigKeyPattern covers keys with variable parts.
+var blockedGitConfigKeys = map[string]bool{
+	"core.pager":                 true,
+	"core.sshcommand":            true,
+	"core.fsmonitor":             true,
+	"core.hookspath":             true,
+	"core.editor":                true,
+	"core.askpass":               true,
+	"core.gitproxy":              true,
+	"sequence.editor":            true,
+	"diff.external":              true,
+	"gpg.program":                true,
+	"uploadpack.packobjectshook": true,
+	"credential.helper":          true,
+	"protocol.allow":             true,
+}
+
+// blockedGitConfigSubsectionKeys are the variable names that make git run a
+// command when set in a subsection of these sections, such as
+// filter.<driver>.smudge.
+var blockedGitConfigSubsectionKeys = map[string]map[string]bool{
+	"filter":     {"clean": true, "smudge": true, "process": true},
+	"diff":       {"textconv": true, "command": true},
+	"merge":      {"driver": true},
+	"gpg":        {"program": true},
+	"mergetool":  {"cmd": true},
+	"difftool":   {"cmd": true},
+	"remote":     {"uploadpack": true, "receivepack": true},
+	"credential": {"helper": true},
+	"protocol":   {"allow": true},
+}
+
+// blockedGitConfigKeyPattern matches alias.*, *.sshCommand and the keys in
+// blockedGitConfigSubsectionKeys.
+func blockedGitConfigKeyPattern(key string) bool {
+	section, rest, _ := strings.Cut(key, ".")
+	name := key[strings.LastIndex(key, ".")+1:]
+	switch {
+	case section == "alias":
+		return true
+	case name == "sshcommand":
+		return true
+	case strings.Contains(rest, "."):
+		return blockedGitConfigSubsectionKeys[section][name]
+	}
+	return false
+}
+
+// validateGitConfigOverride rejects a "git -c key=value" or
+// "git --config-env key=ENVVAR" override of a key that executes commands.
+func validateGitConfigOverride(flag, override string) error {
+	key, _, _ := strings.Cut(override, "=")
+	// Section and variable names are case-insensitive.
+	lower := strings.ToLower(key)
+	if blockedGitConfigKeys[lower] || blockedGitConfigKeyPattern(lower) {
+		return fmt.Errorf("git %s %s is not allowed", flag, key)
+	}
+	return nil
+}
+
+// gitAllowedURLSchemes are the transports git implements natively. Any other
+// "scheme://" or "transport::address" URL is handed to a remote helper, and
+// helpers such as ext:: and fd:: run arbitrary commands or use inherited file
+// descriptors. file:// URLs are path-validated like local paths.
+var gitAllowedURLSchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"ssh":     true,
+	"git":     true,
+	"git+ssh": true,
+	"ssh+git": true,
+	"ftp":     true,
+	"ftps":    true,
+	"file":    true,
+}
+
+// gitURLScheme matches the transport prefix of "scheme://..." and
+// "transport::address" repository URLs.
+var gitURLScheme = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*)(://|::)`)
+
+// validateGitTransportArgs rejects repository URLs that use a transport other
+// than the ones in gitAllowedURLSchemes.
+func validateGitTransportArgs(args []*syntax.Word) error {
+	for _, arg := range args {
+		lit := arg.Lit()
+		if strings.HasPrefix(lit, "-") {
+			continue
+		}
+		m := gitURLScheme.FindStringSubmatch(lit)
+		if m == nil {
+			continue
+		}
+		if m[2] == "::" || !gitAllowedURLSchemes[m[1]] {
+			return fmt.Errorf("git transport %q is not allowed", m[1]+m[2])
+		}
+	}
+	return nil
+}
+
+// blockedGitExecFlags are remote subcommand flags that name the program git
+// runs as the "server" side of the transfer. For a local or attacker-chosen
+// remote this is arbitrary code execution.
+var blockedGitExecFlags = map[string]bool{
+	"--upload-pack":  true,
+	"--receive-pack": true,
+	"--exec":         true,
+}
+
+// gitUploadPackShortFlag lists the subcommands where -u means --upload-pack,
+// with the other short flags of that subcommand that take a value. Elsewhere
+// -u is harmless (push --set-upstream, fetch --update-head-ok).
+var gitUploadPackShortFlag = map[string]string{
+	"clone":     "bcjo",
+	"ls-remote": "",
+}
+
+// validateGitExecFlags rejects the flags in blockedGitExecFlags, and -u where
+// it is short for --upload-pack, in the arguments of a remote subcommand.
+func validateGitExecFlags(subcommand string, args []*syntax.Word) error {
+	valueFlags, hasShortU := gitUploadPackShortFlag[subcommand]
+	for _, arg := range args {
+		lit := arg.Lit()
+		if lit == "--" {
+			return nil
+		}
+		if strings.HasPrefix(lit, "--") {
+			name, _, _ := strings.Cut(lit, "=")
+			if blockedGitExecFlags[name] {
+				return fmt.Errorf("git flag %q is not allowed", name)
+			}
+			continue
+		}
+		if !hasShortU || len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		// Walk a short option cluster such as -qu; a value-taking option
+		// consumes the rest of the argument.
+		for _, ch := range lit[1:] {
+			if ch == 'u' {
+				return fmt.Errorf("git flag %q is not allowed", "-u")
+			}
+			if strings.ContainsRune(valueFlags, ch) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// gitGlobalValueFlags lists git global options that consume the next argument
+// as their value.
+var gitGlobalValueFlags = map[string]bool{
+	"-C":             true,
+	"-c":             true,
+	"--config-env":   true,
+	"--git-dir":      true,
+	"--work-tree":    true,
+	"--namespace":    true,
+	"--super-prefix": true,
+}
+
+// gitSubcommandIndex returns the index in args of the git subcommand,
+// skipping global flags, or len(args) if there is none.
+func gitSubcommandIndex(args []string) int {
+	for i := 1; i < len(args); i++ {
+		if gitGlobalValueFlags[args[i]] {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+	}
+	return len(args)
+}
+
+// xargsGitEndOfOptions lists the git subcommands whose operands are paths or
+// patch files whether or not they follow "--", and their options that take a
+// value (see xargsEndOfOptions).
+var xargsGitEndOfOptions = map[string]valueOptions{
+	"add":          {"", map[string]bool{"--chmod": true, "--pathspec-from-file": true}},
+	"rm":           {"", map[string]bool{"--pathspec-from-file": true}},
+	"mv":           {},
+	"reset":        {"", map[string]bool{"--pathspec-from-file": true}},
+	"clean":        {"e", map[string]bool{"--exclude": true}},
+	"status":       {},
+	"ls-files":     {"xX", map[string]bool{"--exclude": true, "--exclude-from": true, "--exclude-per-directory": true, "--format": true}},
+	"check-ignore": {},
+	"apply":        {"pC", map[string]bool{"--directory": true, "--exclude": true, "--include": true, "--whitespace": true, "--build-fake-ancestor": true}},
+	"am":           {"pC", map[string]bool{"--directory": true, "--exclude": true, "--include": true, "--whitespace": true, "--patch-format": true, "--resolvemsg": true}},
+}
+
 // validateGitArgs validates git commands according to the granular permission model.
-func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
+// The permissions are those of gitCfg for the repository directory: workDir,
+// or the -C target. An empty workDir means the directory is unknown.
+func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig, workDir string) error {
 	if len(args) < 2 {
 		// bare "git" with no subcommand is fine (prints help)
 		return nil
@@ -131,19 +337,61 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 
 	// Find the subcommand, skipping global flags like -C, --git-dir, etc.
 	subcommand := ""
+	subcommandIdx := 0
 	skipNext := false
-	for _, arg := range args[1:] {
+	configFlag := ""
+	repoDir := workDir
+	chdir := false
+	for i, arg := range args[1:] {
 		if skipNext {
 			skipNext = false
 			continue
 		}
+		if chdir {
+			// Each -C is relative to the previous one, like cd. A dynamic
+			// value is allowed (its path is validated at runtime) but makes
+			// the directory unknown.
+			chdir = false
+			dir := arg.Lit()
+			switch {
+			case dir == "":
+				repoDir = ""
+			case filepath.IsAbs(dir):
+				repoDir = filepath.Clean(dir)
+			case repoDir != "":
+				repoDir = filepath.Join(repoDir, dir)
+			}
+			continue
+		}
 		lit := arg.Lit()
 		if lit == "" {
 			return fmt.Errorf("git arguments must be literal strings")
 		}
+		if configFlag != "" {
+			if err := validateGitConfigOverride(configFlag, lit); err != nil {
+				return err
+			}
+			configFlag = ""
+			continue
+		}
+		// Config overrides can run commands (core.pager, alias.*, ...)
+		if lit == "-c" || lit == "--config-env" {
+			configFlag = lit
+			continue
+		}
+		if override, ok := strings.CutPrefix(lit, "--config-env="); ok {
+			if err := validateGitConfigOverride("--config-env", override); err != nil {
+				return err
+			}
+			continue
+		}
+		if lit == "-C" {
+			chdir = true
+			continue
+		}
 		// Skip global git flags that take a value argument
-		if lit == "-C" || lit == "-c" || lit == "--git-dir" || lit == "--work-tree" ||
-			lit == "--namespace" || lit == "--super-prefix" || lit == "--config-env" {
+		if lit == "--git-dir" || lit == "--work-tree" ||
+			lit == "--namespace" || lit == "--super-prefix" {
 			skipNext = true
 			continue
 		}
@@ -152,6 +400,7 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 			continue
 		}
 		subcommand = lit
+		subcommandIdx = i + 1
 		break
 	}
 
@@ -159,6 +408,7 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 		// Only flags, no subcommand (e.g., "git --version")
 		return nil
 	}
+	gitCfg = gitCfg.ForDir(repoDir)
 
 	// Always blocked regardless of config
 	if gitAlwaysBlockedSubcommands[subcommand] {
@@ -185,6 +435,7 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 			if !gitCfg.GitLocalWrite() {
 				return validateGitConfigReadOnlyArgs(args)
 			}
+			return validateGitConfigWriteArgs(args[subcommandIdx+1:])
 		}
 		return nil
 	}
@@ -193,6 +444,14 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 		if !gitCfg.GitLocalWrite() {
 			return fmt.Errorf("git subcommand %q is not allowed (local_write is disabled)", subcommand)
 		}
+		if !gitCfg.GitAllowDestructive() {
+			if err := validateGitDestructiveArgs(subcommand, args[subcommandIdx+1:]); err != nil {
+				return err
+			}
+		}
+		if gitPatchSubcommands[subcommand] {
+			return validateGitPatchArgs(args[subcommandIdx+1:])
+		}
 		return nil
 	}
 
@@ -200,14 +459,17 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 		if !gitCfg.GitRemoteRead() {
 			return fmt.Errorf("git subcommand %q is not allowed (remote_read is disabled)", subcommand)
 		}
-		return nil
+		if err := validateGitExecFlags(subcommand, args[subcommandIdx+1:]); err != nil {
+			return err
+		}
+		return validateGitTransportArgs(args[subcommandIdx+1:])
 	}
 
 	if gitRemoteWriteSubcommands[subcommand] {
 		if !gitCfg.GitRemoteWrite() {
 			return fmt.Errorf("git subcommand %q is not allowed (remote_write is disabled)", subcommand)
 		}
-		return nil
+		return validateGitExecFlags(subcommand, args[subcommandIdx+1:])
 	}
 
 	// Handle remote/submodule which span read/write
@@ -215,10 +477,254 @@ func validateGitArgs(args []*syntax.Word, gitCfg *config.GitConfig) error {
 		return validateGitRemoteSubcommand(args, subcommand, gitCfg)
 	}
 
-	// Unknown subcommand - block by default
+	// Unknown subcommand - block by default. This also blocks aliases, which
+	// a repository's .git/config can define as "!shell command".
 	return fmt.Errorf("git subcommand %q is not allowed", subcommand)
 }
 
+// validateGitDestructiveArgs rejects the local write operations that discard
+// work, for when allow_destructive is disabled: git clean, git reset --hard,
+// git stash drop and clear, and git checkout or git switch with --force (or
+// switch --discard-changes). args are the arguments after the subcommand.
+func validateGitDestructiveArgs(subcommand string, args []*syntax.Word) error {
+	operation := ""
+	switch subcommand {
+	case "clean":
+		operation = "git clean"
+	case "reset":
+		if gitHasLongFlag(args, "hard") {
+			operation = "git reset --hard"
+		}
+	case "stash":
+		for _, arg := range args {
+			lit := arg.Lit()
+			if lit == "" || strings.HasPrefix(lit, "-") {
+				continue
+			}
+			if lit == "drop" || lit == "clear" {
+				operation = "git stash " + lit
+			}
+			break
+		}
+	case "checkout", "switch":
+		if gitHasForceFlag(args) || subcommand == "switch" && gitHasLongFlag(args, "discard-changes") {
+			operation = "git " + subcommand + " --force"
+		}
+	}
+	if operation != "" {
+		return fmt.Errorf("%s is not allowed (allow_destructive is disabled)", operation)
+	}
+	return nil
+}
+
+// gitHasLongFlag reports whether args, before any "--", contain the long
+// option --name or an abbreviation of it, which git accepts.
+func gitHasLongFlag(args []*syntax.Word, name string) bool {
+	for _, arg := range args {
+		lit := arg.Lit()
+		if lit == "--" {
+			return false
+		}
+		flag, ok := strings.CutPrefix(lit, "--")
+		if !ok || flag == "" {
+			continue
+		}
+		flag, _, _ = strings.Cut(flag, "=")
+		if strings.HasPrefix(name, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitHasForceFlag reports whether the arguments of git checkout or git switch
+// contain -f, alone or in a group of short flags such as -qf, or --force.
+func gitHasForceFlag(args []*syntax.Word) bool {
+	if gitHasLongFlag(args, "force") {
+		return true
+	}
+	for _, arg := range args {
+		lit := arg.Lit()
+		if lit == "--" {
+			return false
+		}
+		if len(lit) < 2 || lit[0] != '-' || lit[1] == '-' {
+			continue
+		}
+		for _, c := range lit[1:] {
+			if c == 'f' {
+				return true
+			}
+			// The rest of the group is the value of -b, -B, -c or -C.
+			if strings.ContainsRune("bBcC", c) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// validateGitPatchArgs rejects --unsafe-paths, or any abbreviation of it, in
+// the arguments of git apply or git am. Without it, git refuses patches that
+// touch files outside the working tree.
+func validateGitPatchArgs(args []*syntax.Word) error {
+	for _, arg := range args {
+		lit := arg.Lit()
+		if lit == "--" {
+			return nil
+		}
+		name, ok := strings.CutPrefix(lit, "--")
+		if !ok || name == "" {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "=")
+		if strings.HasPrefix("unsafe-paths", name) {
+			return fmt.Errorf("git flag %q is not allowed: lets a patch write outside the working tree", lit)
+		}
+	}
+	return nil
+}
+
+// gitPatchDirectories returns the values of the --directory options (or
+// their abbreviations) in the arguments of git apply or git am. Empty
+// (dynamic) values are skipped.
+func gitPatchDirectories(args []string) []string {
+	var dirs []string
+	for i := 0; i < len(args); i++ {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok || name == "" {
+			if args[i] == "--" {
+				break
+			}
+			continue
+		}
+		name, value, attached := strings.Cut(name, "=")
+		if !strings.HasPrefix("directory", name) {
+			continue
+		}
+		if !attached && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if value != "" {
+			dirs = append(dirs, value)
+		}
+	}
+	return dirs
+}
+
+// validateGitRepoDirs checks that the directories git is pointed at with -C,
+// --git-dir and --work-tree are under the allowed paths: writeAllowedPaths
+// if the invocation may modify the repository (see gitWrites), otherwise
+// readAllowedPaths. The --directory of git apply and git am, which patched
+// files are written under, must be in writeAllowedPaths. Empty (dynamic)
+// values, and relative values when workDir is unknown, are skipped; the
+// runtime check sees them expanded, in the directory git runs in.
+func validateGitRepoDirs(r *pathResolver, args []string, workDir string, readAllowedPaths, writeAllowedPaths []string) error {
+	type target struct{ flag, value string }
+	var targets []target
+	subcommandIdx := 0
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-C" || arg == "--git-dir" || arg == "--work-tree" {
+			if i+1 < len(args) && args[i+1] != "" {
+				targets = append(targets, target{arg, args[i+1]})
+			}
+			i++
+			continue
+		}
+		if flag, value, ok := strings.Cut(arg, "="); ok && (flag == "--git-dir" || flag == "--work-tree") {
+			if value != "" {
+				targets = append(targets, target{flag, value})
+			}
+			continue
+		}
+		// The values of these flags are not directories.
+		if arg == "-c" || arg == "--config-env" || arg == "--namespace" || arg == "--super-prefix" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		subcommandIdx = i
+		break
+	}
+	var patchDirs []string
+	if subcommandIdx > 0 && gitPatchSubcommands[args[subcommandIdx]] {
+		patchDirs = gitPatchDirectories(args[subcommandIdx+1:])
+	}
+	if len(targets) == 0 && len(patchDirs) == 0 {
+		return nil
+	}
+
+	allowedPaths := readAllowedPaths
+	if subcommandIdx > 0 && gitWrites(args[subcommandIdx:]) {
+		allowedPaths = writeAllowedPaths
+	}
+	// Each -C is relative to the previous one, like cd. Relative --git-dir
+	// and --work-tree values are relative to the directory after all -C.
+	// Relative values in an unknown directory are checked at runtime.
+	dir := workDir
+	for _, t := range targets {
+		if t.flag != "-C" || dir == "" && !filepath.IsAbs(t.value) {
+			continue
+		}
+		dir = r.resolve(t.value, dir)
+		if !r.isUnderAllowedPaths(dir, allowedPaths) {
+			return fmt.Errorf("git -C target %q is outside allowed directories", t.value)
+		}
+	}
+	for _, t := range targets {
+		if t.flag == "-C" || dir == "" && !filepath.IsAbs(t.value) {
+			continue
+		}
+		if !r.isUnderAllowedPaths(r.resolve(t.value, dir), allowedPaths) {
+			return fmt.Errorf("git %s target %q is outside allowed directories", t.flag, t.value)
+		}
+	}
+	// Patch paths are relative to the top of the working tree; the
+	// directory git runs in is the closest known approximation.
+	for _, d := range patchDirs {
+		if dir == "" && !filepath.IsAbs(d) {
+			continue // directory unknown; checked at runtime
+		}
+		if !r.isUnderAllowedPaths(r.resolve(d, dir), writeAllowedPaths) {
+			return fmt.Errorf("git %s --directory target %q is outside allowed directories", args[subcommandIdx], d)
+		}
+	}
+	return nil
+}
+
+// gitWrites reports whether the git subcommand invocation args (args[0] is
+// the subcommand) may modify the repository. Local read subcommands and
+// read-only remote and submodule operations do not; branch, tag and config
+// do only with the flags their read-only validators reject.
+func gitWrites(args []string) bool {
+	words := make([]*syntax.Word, len(args)+1)
+	words[0] = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: "git"}}}
+	for i, a := range args {
+		words[i+1] = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: a}}}
+	}
+	switch args[0] {
+	case "branch":
+		return validateGitBranchArgs(words) != nil
+	case "tag":
+		return validateGitTagArgs(words) != nil
+	case "config":
+		return validateGitConfigReadOnlyArgs(words) != nil
+	case "remote":
+		return blockedRemoteWriteSubcommands[findSubSubcommand(words, "remote")]
+	case "submodule":
+		switch findSubSubcommand(words, "submodule") {
+		case "", "status", "summary":
+			return false
+		}
+		return true
+	}
+	return !gitLocalReadSubcommands[args[0]] && args[0] != "ls-remote"
+}
+
 // validateGitRemoteSubcommand handles "remote" and "submodule" which have
 // both read and write sub-subcommands.
 func validateGitRemoteSubcommand(args []*syntax.Word, subcommand string, gitCfg *config.GitConfig) error {
@@ -289,11 +795,20 @@ func findSubSubcommand(args []*syntax.Word, subcommand string) string {
 
 // validateGitBranchArgs checks that git branch is not invoked with mutation flags.
 func validateGitBranchArgs(args []*syntax.Word) error {
+	pastSubcommand := false
 	for _, arg := range args[1:] {
 		lit := arg.Lit()
 		if lit == "" {
 			continue
 		}
+		// Arguments after "--" are branch names or patterns, not flags. git
+		// itself rejects "--" before the subcommand, so only honor it after.
+		if lit == "branch" {
+			pastSubcommand = true
+		}
+		if lit == "--" && pastSubcommand {
+			return nil
+		}
 		if reason, blocked := blockedGitBranchFlags[lit]; blocked {
 			return fmt.Errorf("git branch flag %q is not allowed: %s", lit, reason)
 		}
@@ -303,11 +818,20 @@ func validateGitBranchArgs(args []*syntax.Word) error {
 
 // validateGitTagArgs checks that git tag is not invoked with mutation flags.
 func validateGitTagArgs(args []*syntax.Word) error {
+	pastSubcommand := false
 	for _, arg := range args[1:] {
 		lit := arg.Lit()
 		if lit == "" {
 			continue
 		}
+		// Arguments after "--" are tag names or patterns, not flags. git
+		// itself rejects "--" before the subcommand, so only honor it after.
+		if lit == "tag" {
+			pastSubcommand = true
+		}
+		if lit == "--" && pastSubcommand {
+			return nil
+		}
 		if reason, blocked := blockedGitTagFlags[lit]; blocked {
 			return fmt.Errorf("git tag flag %q is not allowed: %s", lit, reason)
 		}
@@ -333,3 +857,90 @@ func validateGitConfigReadOnlyArgs(args []*syntax.Word) error {
 	}
 	return nil
 }
+
+// gitConfigValueFlags are git config flags that take a separate value.
+var gitConfigValueFlags = map[string]bool{
+	"-f":        true,
+	"--file":    true,
+	"--blob":    true,
+	"--type":    true,
+	"--default": true,
+	"--comment": true,
+	"--value":   true,
+}
+
+// validateGitConfigWriteArgs rejects "git config" writes that would plant a
+// key in blockedGitConfigKeys (e.g. an alias such as "!sh"), which git would
+// run on a later, otherwise harmless invocation. args are the arguments after
+// "config". Reads are allowed.
+func validateGitConfigWriteArgs(args []*syntax.Word) error {
+	var operands []string
+	renameSection := false
+	for i := 0; i < len(args); i++ {
+		// wordText keeps quoted keys; a key built from variables is
+		// re-checked at runtime with its expanded value.
+		lit := wordText(args[i])
+		switch {
+		case lit == "--list" || lit == "-l" || lit == "--get" || lit == "--get-all" ||
+			lit == "--get-regexp" || lit == "--get-urlmatch":
+			return nil
+		case lit == "--rename-section":
+			renameSection = true
+		case gitConfigValueFlags[lit]:
+			i++
+		case strings.HasPrefix(lit, "-"):
+		default:
+			operands = append(operands, lit)
+		}
+	}
+	if len(operands) == 0 {
+		return nil
+	}
+	// git 2.46+ subcommand syntax: git config set <key> <value>, ...
+	switch operands[0] {
+	case "get", "list":
+		return nil
+	case "rename-section":
+		renameSection = true
+		operands = operands[1:]
+	case "set", "unset":
+		operands = operands[1:]
+	}
+	if len(operands) == 0 {
+		return nil
+	}
+	key := operands[0]
+	if renameSection {
+		// The new section name; renaming a section to "alias", "core" or
+		// "filter.<driver>" can create blocked keys from harmless ones.
+		key = operands[len(operands)-1]
+		if blockedGitConfigSection(strings.ToLower(key)) {
+			return fmt.Errorf("git config %s is not allowed", key)
+		}
+		return nil
+	}
+	lower := strings.ToLower(key)
+	if blockedGitConfigKeys[lower] || blockedGitConfigKeyPattern(lower) {
+		return fmt.Errorf("git config %s is not allowed", key)
+	}
+	return nil
+}
+
+// blockedGitConfigSection reports whether section, lowercase, may contain a
+// key in blockedGitConfigKeys or matched by blockedGitConfigKeyPattern.
+func blockedGitConfigSection(section string) bool {
+	name, _, hasSubsection := strings.Cut(section, ".")
+	if name == "alias" {
+		return true
+	}
+	if hasSubsection {
+		_, ok := blockedGitConfigSubsectionKeys[name]
+		return ok
+	}
+	for key := range blockedGitConfigKeys {
+		if strings.HasPrefix(key, name+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,11 @@
 package bash_sandboxed
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -150,6 +155,7 @@ func TestValidate_GitLocalReadOnly(t *testing.T) {
 		{"git diff", "git diff"},
 		{"git branch list", "git branch -v"},
 		{"git tag list", "git tag -l 'v*'"},
+		{"git branch list pattern after --", "git branch --list -- -d"},
 		{"git config get", "git config --get user.name"},
 		{"git config list", "git config --list"},
 		{"git reflog", "git reflog"},
@@ -176,6 +182,7 @@ func TestValidate_GitLocalReadOnly(t *testing.T) {
 		{"branch -m", "git branch -m old new", `git branch flag "-m" is not allowed`},
 		{"tag -a", "git tag -a v1.0 -m 'release'", `git tag flag "-a" is not allowed`},
 		{"tag -d", "git tag -d v1.0", `git tag flag "-d" is not allowed`},
+		{"branch -d before --", "git branch -d -- feature", `git branch flag "-d" is not allowed`},
 		{"config set", "git config user.name 'test'", "git config is only allowed with"},
 		// Local write blocked
 		{"git add", "git add file.go", "local_write is disabled"},
@@ -208,6 +215,139 @@ func TestValidate_GitLocalReadOnly(t *testing.T) {
 	}
 }
 
+// TestValidate_GitNoDestructive tests with local_write enabled but
+// allow_destructive disabled.
+func TestValidate_GitNoDestructive(t *testing.T) {
+	s := newTestSandboxWithGitConfig(&config.GitConfig{
+		LocalWrite:       boolPtr(true),
+		AllowDestructive: boolPtr(false),
+	})
+
+	allowed := []struct {
+		name    string
+		command string
+	}{
+		{"git add", "git add file.go"},
+		{"git commit", "git commit -m 'msg'"},
+		{"git reset", "git reset HEAD file.go"},
+		{"git reset --soft", "git reset --soft HEAD~1"},
+		{"git reset path named --hard", "git reset -- --hard"},
+		{"git stash", "git stash"},
+		{"git stash push", "git stash push -m 'wip'"},
+		{"git stash pop", "git stash pop"},
+		{"git checkout", "git checkout main"},
+		{"git checkout new branch named f", "git checkout -bf"},
+		{"git switch", "git switch -c feature"},
+	}
+	for _, tt := range allowed {
+		t.Run("allowed/"+tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := s.validate(f); err != nil {
+				t.Fatalf("expected allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"git clean", "git clean -fd", "git clean is not allowed (allow_destructive is disabled)"},
+		{"git clean dry run", "git clean -n", "git clean is not allowed"},
+		{"git reset --hard", "git reset --hard", "git reset --hard is not allowed (allow_destructive is disabled)"},
+		{"git reset --hard ref", "git reset --hard HEAD~1", "git reset --hard is not allowed"},
+		{"git reset abbreviated --hard", "git reset --har", "git reset --hard is not allowed"},
+		{"git -C reset --hard", "git -C sub reset --hard", "git reset --hard is not allowed"},
+		{"git stash drop", "git stash drop stash@{0}", "git stash drop is not allowed"},
+		{"git stash clear", "git stash clear", "git stash clear is not allowed"},
+		{"git checkout -f", "git checkout -f main", "git checkout --force is not allowed"},
+		{"git checkout --force", "git checkout --force main", "git checkout --force is not allowed"},
+		{"git checkout grouped -f", "git checkout -qf main", "git checkout --force is not allowed"},
+		{"git switch --discard-changes", "git switch --discard-changes main", "git switch --force is not allowed"},
+		{"in a substitution", "echo $(git clean -fdx)", "git clean is not allowed"},
+		{"timeout git reset --hard", "timeout 5 git reset --hard", "git reset --hard is not allowed"},
+		{"xargs git reset --hard", "echo HEAD | xargs git reset --hard", "git reset --hard is not allowed"},
+		{"xargs git without subcommand", "echo clean -fd | xargs git", "xargs cannot run git without a subcommand"},
+		{"xargs git -C without subcommand", "echo clean -fd | xargs git -C sub", "xargs cannot run git without a subcommand"},
+		{"xargs env git without subcommand", "echo clean -fd | xargs env git", "xargs cannot run git without a subcommand"},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = s.validate(f)
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+
+	// Flags built from a variable are checked at runtime, also when a
+	// wrapper runs git, and flags read by xargs are passed after "--", as
+	// paths. None of these may discard the uncommitted change.
+	dir := t.TempDir()
+	tracked, untracked := filepath.Join(dir, "file.go"), filepath.Join(dir, "new.go")
+	if err := os.WriteFile(tracked, []byte("committed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "file.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	for _, file := range []string{tracked, untracked} {
+		if err := os.WriteFile(file, []byte("wip\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		command string
+		errMsg  string
+	}{
+		{`h=--hard; git reset $h`, "git reset --hard is not allowed"},
+		{`h=--hard; timeout 5 git reset $h`, "git reset --hard is not allowed"},
+		{`h=--hard; env git reset "$h"`, "git reset --hard is not allowed"},
+		{`f=-fd; nice git clean $f`, "git clean is not allowed"},
+		{`echo --hard | xargs git reset`, ""},
+		{`echo -f main | xargs git checkout`, "xargs cannot pass its input to git checkout"},
+	} {
+		_, err := s.Execute(context.Background(), tt.command, dir, []string{dir}, []string{dir})
+		if tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)) {
+			t.Errorf("%s: expected error containing %q, got: %v", tt.command, tt.errMsg, err)
+		}
+		for _, file := range []string{tracked, untracked} {
+			if data, err := os.ReadFile(file); err != nil || string(data) != "wip\n" {
+				t.Fatalf("%s: uncommitted change to %s was discarded: %q, %v", tt.command, filepath.Base(file), data, err)
+			}
+		}
+	}
+
+	// Destructive operations are allowed by default.
+	s = newTestSandboxWithGitConfig(&config.GitConfig{})
+	for _, command := range []string{"git clean -fd", "git reset --hard", "git stash drop", "git checkout -f main"} {
+		f, err := ParseBash(command)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := s.validate(f); err != nil {
+			t.Errorf("expected %q allowed by default, got: %v", command, err)
+		}
+	}
+}
+
 // TestValidate_GitAllDisabled tests with all git permissions disabled.
 func TestValidate_GitAllDisabled(t *testing.T) {
 	s := newTestSandboxWithGitConfig(&config.GitConfig{
@@ -469,3 +609,488 @@ func TestValidate_GitRemoteSubcommand(t *testing.T) {
 		})
 	}
 }
+
+// TestValidate_GitConfigOverrides tests that -c and --config-env cannot set
+// config keys that make git run commands.
+func TestValidate_GitConfigOverrides(t *testing.T) {
+	allowed := []string{
+		"git -c user.name=x status",
+		"git -c color.ui=always log",
+		"git -c core.quotePath=false -c user.email=a@b.c diff",
+		"git --config-env=user.name=GIT_USER log",
+		"git -c diff.noprefix=true diff",
+		"git -c merge.conflictStyle=diff3 merge main",
+		"git -c filter.x.required=true status",
+		"git -c gpg.format=ssh log",
+	}
+	for _, cmd := range allowed {
+		t.Run("allowed/"+cmd, func(t *testing.T) {
+			f, err := ParseBash(cmd)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		command string
+		errMsg  string
+	}{
+		{"git -c core.pager=evil log", "git -c core.pager is not allowed"},
+		{"git -c core.sshCommand=evil fetch", "git -c core.sshCommand is not allowed"},
+		{"git -c core.fsmonitor=evil status", "git -c core.fsmonitor is not allowed"},
+		{"git -c core.hooksPath=/tmp/hooks commit -m x", "git -c core.hooksPath is not allowed"},
+		{"git -c alias.x=!sh x", "git -c alias.x is not allowed"},
+		{"git -c ALIAS.st=status st", "git -c ALIAS.st is not allowed"},
+		{"git -c url.example.sshCommand=evil fetch", "git -c url.example.sshCommand is not allowed"},
+		{"git -c protocol.ext.allow=always fetch", "git -c protocol.ext.allow is not allowed"},
+		{"git -c protocol.allow=always fetch", "git -c protocol.allow is not allowed"},
+		{"git -c credential.helper=evil fetch", "git -c credential.helper is not allowed"},
+		{"git -c credential.https://example.com.helper=evil fetch", "git -c credential.https://example.com.helper is not allowed"},
+		{"git -c user.name=x -c core.pager=evil log", "git -c core.pager is not allowed"},
+		{"git --config-env core.pager=PAGER log", "git --config-env core.pager is not allowed"},
+		{"git --config-env=core.sshCommand=CMD fetch", "git --config-env core.sshCommand is not allowed"},
+		{"git -c core.editor=evil commit", "git -c core.editor is not allowed"},
+		{"git -c core.askPass=evil fetch", "git -c core.askPass is not allowed"},
+		{"git -c core.gitProxy=evil fetch", "git -c core.gitProxy is not allowed"},
+		{"git -c sequence.editor=evil rebase -i HEAD~1", "git -c sequence.editor is not allowed"},
+		{"git -c diff.external=evil diff", "git -c diff.external is not allowed"},
+		{"git -c gpg.program=evil log --show-signature", "git -c gpg.program is not allowed"},
+		{"git -c gpg.ssh.program=evil log --show-signature", "git -c gpg.ssh.program is not allowed"},
+		{"git -c uploadpack.packObjectsHook=evil fetch", "git -c uploadpack.packObjectsHook is not allowed"},
+		{"git -c filter.x.clean=evil add .", "git -c filter.x.clean is not allowed"},
+		{"git -c filter.x.smudge=evil checkout .", "git -c filter.x.smudge is not allowed"},
+		{"git -c filter.x.process=evil checkout .", "git -c filter.x.process is not allowed"},
+		{"git -c diff.x.textconv=evil diff", "git -c diff.x.textconv is not allowed"},
+		{"git -c diff.x.command=evil diff", "git -c diff.x.command is not allowed"},
+		{"git -c merge.x.driver=evil merge main", "git -c merge.x.driver is not allowed"},
+		{"git -c mergetool.x.cmd=evil status", "git -c mergetool.x.cmd is not allowed"},
+		{"git -c difftool.x.cmd=evil status", "git -c difftool.x.cmd is not allowed"},
+		{"git -c remote.origin.uploadpack=evil fetch", "git -c remote.origin.uploadpack is not allowed"},
+		{"git -c remote.origin.receivepack=evil push", "git -c remote.origin.receivepack is not allowed"},
+		{"git --config-env filter.x.smudge=CMD checkout .", "git --config-env filter.x.smudge is not allowed"},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+// TestValidate_GitTransports tests that remote-read subcommands only accept
+// git's built-in transports.
+func TestValidate_GitTransports(t *testing.T) {
+	allowed := []string{
+		"git clone https://host/repo",
+		"git clone ssh://git@host/repo.git",
+		"git clone git@host:org/repo.git",
+		"git fetch git://host/repo main",
+		"git clone file:///tmp/repo",
+		"git ls-remote origin",
+		"git clone -c http.proxy=http://proxy:3128 https://host/repo",
+	}
+	for _, cmd := range allowed {
+		t.Run("allowed/"+cmd, func(t *testing.T) {
+			f, err := ParseBash(cmd)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		command string
+		errMsg  string
+	}{
+		{"git clone ext::sh -c touch% /tmp/pwned", `git transport "ext::" is not allowed`},
+		{"git fetch fd::3 main", `git transport "fd::" is not allowed`},
+		{"git pull custom::host/repo", `git transport "custom::" is not allowed`},
+		{"git ls-remote https::host/repo", `git transport "https::" is not allowed`},
+		{"git clone foo://host/repo", `git transport "foo://" is not allowed`},
+		{"git clone --depth 1 ext::sh", `git transport "ext::" is not allowed`},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+// TestValidate_GitExecFlags tests that remote subcommands cannot override the
+// program run on the other end of the transfer.
+func TestValidate_GitExecFlags(t *testing.T) {
+	s := newTestSandboxWithGitConfig(&config.GitConfig{
+		RemoteRead:  boolPtr(true),
+		RemoteWrite: boolPtr(true),
+	})
+	allowed := []string{
+		"git fetch origin",
+		"git fetch -u origin main",
+		"git push -u origin main",
+		"git clone -b upstream https://host/repo",
+		"git clone -q https://host/repo",
+		"git ls-remote --heads origin",
+	}
+	for _, cmd := range allowed {
+		t.Run("allowed/"+cmd, func(t *testing.T) {
+			f, err := ParseBash(cmd)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := s.validate(f); err != nil {
+				t.Fatalf("expected allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		command string
+		errMsg  string
+	}{
+		{"git fetch --upload-pack=/bin/sh origin", `git flag "--upload-pack" is not allowed`},
+		{"git clone --upload-pack x https://host/repo", `git flag "--upload-pack" is not allowed`},
+		{"git pull --upload-pack=x origin", `git flag "--upload-pack" is not allowed`},
+		{"git push --receive-pack=/bin/sh origin main", `git flag "--receive-pack" is not allowed`},
+		{"git push --exec=/bin/sh origin main", `git flag "--exec" is not allowed`},
+		{"git ls-remote --exec=x origin", `git flag "--exec" is not allowed`},
+		{"git clone -u x https://host/repo", `git flag "-u" is not allowed`},
+		{"git clone -qu x https://host/repo", `git flag "-u" is not allowed`},
+		{"git ls-remote -u x origin", `git flag "-u" is not allowed`},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = s.validate(f)
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+// TestValidate_GitPerPath tests that git.per_path overrides apply to commands
+// whose repository directory matches the glob, and only to them.
+func TestValidate_GitPerPath(t *testing.T) {
+	root := t.TempDir()
+	trusted := filepath.Join(root, "trusted", "repo")
+	other := filepath.Join(root, "other")
+	for _, dir := range []string{trusted, other} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestSandboxWithGitConfig(&config.GitConfig{
+		PerPath: map[string]*config.GitConfig{
+			filepath.Join(root, "trusted", "*"): {RemoteWrite: boolPtr(true)},
+		},
+	})
+	allowed := []string{root}
+
+	tests := []struct {
+		name    string
+		workDir string
+		command string
+		wantErr bool
+	}{
+		{"push in trusted repo", trusted, "git push origin main", false},
+		{"push in trusted subdirectory", filepath.Join(trusted, "sub"), "git push origin main", false},
+		{"push outside", other, "git push origin main", true},
+		{"default still applies in trusted repo", trusted, "git hook run pre-commit", true},
+		{"-C into trusted repo", other, "git -C " + trusted + " push origin main", false},
+		{"relative -C into trusted repo", root, "git -C trusted/repo push origin main", false},
+		{"-C out of trusted repo", trusted, "git -C " + other + " push origin main", true},
+		{"cd into trusted repo", root, "cd trusted/repo && git push origin main", false},
+		{"cd out of trusted repo", trusted, "cd " + other + " && git push origin main", true},
+		{"unknown directory uses default", trusted, "cd \"$DIR\" && git push origin main", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ValidateCommand(tt.command, tt.workDir, allowed, allowed)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected %q in %s to be blocked", tt.command, tt.workDir)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected %q in %s to be allowed, got: %v", tt.command, tt.workDir, err)
+			}
+		})
+	}
+}
+
+// TestValidate_GitRepoDirs tests that -C, --git-dir and --work-tree must
+// point under the read-allowed paths, and under the write-allowed paths for
+// subcommands that modify the repository.
+func TestValidate_GitRepoDirs(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	ro := filepath.Join(root, "ro")
+	for _, dir := range []string{filepath.Join(work, "sub"), ro} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readPaths := []string{work, ro}
+	writePaths := []string{work}
+
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{"git -C /etc status", `git -C target "/etc" is outside allowed directories`},
+		{"git -C ./sub status", ""},
+		{"git -C sub -C ../.. status", `git -C target "../.." is outside allowed directories`},
+		{"git --git-dir=/other/.git log", `git --git-dir target "/other/.git" is outside allowed directories`},
+		{"git --git-dir /other/.git log", `git --git-dir target "/other/.git" is outside allowed directories`},
+		{"git --work-tree=/etc status", `git --work-tree target "/etc" is outside allowed directories`},
+		{"git -C ../ro --work-tree=/etc status", `git --work-tree target "/etc" is outside allowed directories`},
+		// Read-only repositories can be inspected but not modified.
+		{"git -C " + ro + " log", ""},
+		{"git -C ../ro branch -v", ""},
+		{"git -C ../ro commit -m msg", `git -C target "../ro" is outside allowed directories`},
+		{"git -C ../ro branch -d feature", `git -C target "../ro" is outside allowed directories`},
+		{"git -C ../ro config user.name x", `git -C target "../ro" is outside allowed directories`},
+		{"git --work-tree=../ro checkout .", `git --work-tree target "../ro" is outside allowed directories`},
+		{"git -C sub commit -m msg", ""},
+		// --namespace is not a directory.
+		{"git --namespace ns log", ""},
+		// After cd to a variable, relative targets are checked at runtime.
+		{`D=` + work + `; cd "$D"; git -C sub status`, ""},
+		{`D=` + work + `; cd "$D"; git --git-dir=sub/.git --work-tree sub status`, ""},
+		{`D=` + work + `; cd "$D"; git -C sub -C /etc status`, `git -C target "/etc" is outside allowed directories`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := newTestSandbox().ValidateCommand(tt.command, work, readPaths, writePaths)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// A -C target built from a variable is checked at runtime.
+	_, err := newTestSandbox().Execute(context.Background(), `d=../ro; git -C "$d" commit -m msg`, work, readPaths, writePaths)
+	if err == nil || !strings.Contains(err.Error(), `git -C target "../ro" is outside allowed directories`) {
+		t.Fatalf("expected runtime -C error, got: %v", err)
+	}
+	_, err = newTestSandbox().Execute(context.Background(), `D=`+work+`; cd "$D"; git -C ../ro commit -m msg`, root, readPaths, writePaths)
+	if err == nil || !strings.Contains(err.Error(), `git -C target "../ro" is outside allowed directories`) {
+		t.Fatalf("expected runtime -C error after cd, got: %v", err)
+	}
+	if out, err := exec.Command("git", "init", "-q", filepath.Join(work, "sub")).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	if _, err := newTestSandbox().Execute(context.Background(), `D=`+work+`; cd "$D"; git -C sub status`, root, readPaths, writePaths); err != nil {
+		t.Fatalf("expected git -C sub after cd to run, got: %v", err)
+	}
+}
+
+// TestValidate_GitPatch tests that git apply and git am may only write
+// patched files under the write-allowed paths.
+func TestValidate_GitPatch(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	ro := filepath.Join(root, "ro")
+	for _, dir := range []string{filepath.Join(work, "sub"), ro} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readPaths := []string{work, ro}
+	writePaths := []string{work}
+
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{"git apply patch.diff", ""},
+		{"git apply --check --index patch.diff", ""},
+		{"git apply --directory=sub patch.diff", ""},
+		{"git apply --directory sub patch.diff", ""},
+		{"git am --directory=sub 0001.patch", ""},
+		{"git am --directory=sub < 0001.patch", ""},
+		{"git apply --directory=/etc patch.diff", `"/etc"`},
+		{"git apply --directory=../ro patch.diff", `git apply --directory target "../ro" is outside allowed directories`},
+		{"git apply --directory " + ro + " patch.diff", `git apply --directory target "` + ro + `" is outside allowed directories`},
+		{"git apply --dir=../ro patch.diff", `git apply --directory target "../ro" is outside allowed directories`},
+		{"git am --directory=../ro 0001.patch", `git am --directory target "../ro" is outside allowed directories`},
+		{"git -C sub apply --directory=../../ro patch.diff", `git apply --directory target "../../ro" is outside allowed directories`},
+		{"git apply --unsafe-paths patch", `git flag "--unsafe-paths" is not allowed`},
+		{"git apply --unsafe patch", `git flag "--unsafe" is not allowed`},
+		{"git am --unsafe-paths 0001.patch", `git flag "--unsafe-paths" is not allowed`},
+		// After --, arguments are patch files.
+		{"git apply -- --unsafe-paths", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := newTestSandbox().ValidateCommand(tt.command, work, readPaths, writePaths)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// git apply needs local_write.
+	s := newTestSandboxWithGitConfig(&config.GitConfig{LocalWrite: boolPtr(false)})
+	err := s.ValidateCommand("git apply patch.diff", work, readPaths, writePaths)
+	if err == nil || !strings.Contains(err.Error(), "local_write is disabled") {
+		t.Fatalf("expected local_write error, got: %v", err)
+	}
+
+	// A --directory built from a variable is checked at runtime.
+	_, err = newTestSandbox().Execute(context.Background(), `d=../ro; git apply --directory="$d" patch.diff`, work, readPaths, writePaths)
+	if err == nil || !strings.Contains(err.Error(), `git apply --directory target "../ro" is outside allowed directories`) {
+		t.Fatalf("expected runtime --directory error, got: %v", err)
+	}
+
+	// So are flags built from a variable, also when a wrapper runs git.
+	for _, command := range []string{
+		`u=--unsafe-paths; git apply $u patch.diff`,
+		`u=--unsafe-paths; timeout 5 git apply $u patch.diff`,
+		`u=--unsafe-paths; env git am "$u" 0001.patch`,
+	} {
+		_, err = newTestSandbox().Execute(context.Background(), command, work, readPaths, writePaths)
+		if err == nil || !strings.Contains(err.Error(), `git flag "--unsafe-paths" is not allowed`) {
+			t.Errorf("%s: expected runtime --unsafe-paths error, got: %v", command, err)
+		}
+	}
+
+	// Flags read by xargs are passed after "--", as patch files.
+	_, err = newTestSandbox().Execute(context.Background(), `echo --unsafe-paths patch.diff | xargs git apply`, work, readPaths, writePaths)
+	var cmdErr *CommandFailedError
+	if !errors.As(err, &cmdErr) || !strings.Contains(cmdErr.Output, "can't open patch '--unsafe-paths'") {
+		t.Errorf("expected git apply to read --unsafe-paths as a patch file, got: %v", err)
+	}
+}
+
+// TestBashSandboxed_GitPerPathRuntime tests that a per_path override that is
+// stricter than the default is enforced at runtime when the directory is not
+// known statically.
+func TestBashSandboxed_GitPerPathRuntime(t *testing.T) {
+	root := t.TempDir()
+	prod := filepath.Join(root, "prod")
+	if err := os.MkdirAll(prod, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSandboxWithGitConfig(&config.GitConfig{
+		RemoteWrite: boolPtr(true),
+		PerPath: map[string]*config.GitConfig{
+			prod: {RemoteWrite: boolPtr(false)},
+		},
+	})
+	paths := []string{root}
+	_, err := s.Execute(context.Background(), `d=prod; cd "$d" && git push origin main`, root, paths, paths)
+	if err == nil || !strings.Contains(err.Error(), "remote_write is disabled") {
+		t.Fatalf("expected runtime remote_write error, got: %v", err)
+	}
+}
+
+// TestValidate_GitAliases tests that git aliases, which can run shell commands
+// with "!cmd", can neither be invoked nor planted with git config.
+func TestValidate_GitAliases(t *testing.T) {
+	// Local write is enabled by default, so git config can write keys.
+	allowed := []string{
+		"git config user.name x",
+		"git config --add user.email a@b.c",
+		"git config set user.name x",
+		"git config --get alias.co",
+		"git config --get-regexp '^alias\\.'",
+		"git config get alias.co",
+		"git config --list",
+		"git config --file cfg user.name alias.x",
+		"git config --rename-section old new",
+	}
+	for _, cmd := range allowed {
+		t.Run("allowed/"+cmd, func(t *testing.T) {
+			f, err := ParseBash(cmd)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		command string
+		errMsg  string
+	}{
+		// Unknown subcommands are denied, so an alias defined in the
+		// repository's .git/config cannot be invoked.
+		{"git somealias", `git subcommand "somealias" is not allowed`},
+		{"git -C /tmp x", `git subcommand "x" is not allowed`},
+		{"git config alias.x '!sh'", "git config alias.x is not allowed"},
+		{"git config 'alias.st' status", "git config alias.st is not allowed"},
+		{"git config --global alias.x '!sh'", "git config alias.x is not allowed"},
+		{"git config --add Alias.x '!sh'", "git config Alias.x is not allowed"},
+		{"git config --replace-all alias.x '!sh'", "git config alias.x is not allowed"},
+		{"git config -f .git/config alias.x '!sh'", "git config alias.x is not allowed"},
+		{"git config set alias.x '!sh'", "git config alias.x is not allowed"},
+		{"git config --rename-section foo alias", "git config alias is not allowed"},
+		{"git config rename-section foo alias", "git config alias is not allowed"},
+		{"git config core.pager evil", "git config core.pager is not allowed"},
+		{"git config core.hooksPath /tmp/hooks", "git config core.hooksPath is not allowed"},
+		{"git config filter.lfs.smudge evil", "git config filter.lfs.smudge is not allowed"},
+		{"git config diff.external evil", "git config diff.external is not allowed"},
+		{"git config --rename-section foo core", "git config core is not allowed"},
+		{"git config --rename-section foo.bar filter.bar", "git config filter.bar is not allowed"},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.command)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
@@ -66,17 +66,25 @@ func validateGoArgs(args []*syntax.Word, goCfg *config.GoConfig) error {
 	// Validate specific subcommands
 	switch subcommand {
 	case "run":
-		return validateGoRunArgs(args)
+		if !goCfg.GoRun() {
+			return fmt.Errorf("go run is not allowed (runtimes.go.allow_run is disabled)")
+		}
+		return validateGoRunArgs(args, goCfg)
+	case "get":
+		if !goCfg.GoFetch() {
+			return fmt.Errorf("go get is not allowed (runtimes.go.allow_fetch is disabled)")
+		}
 	case "install":
-		return validateGoInstallArgs(args)
+		return validateGoInstallArgs(args, goCfg)
 	}
 
 	// All other subcommands are allowed (build, test, mod, list, etc.)
 	return nil
 }
 
-// validateGoRunArgs checks that go run is not invoked with remote package references or -exec flag.
-func validateGoRunArgs(args []*syntax.Word) error {
+// validateGoRunArgs checks that go run is not invoked with the -exec flag, or
+// with remote package references unless fetching is allowed.
+func validateGoRunArgs(args []*syntax.Word, goCfg *config.GoConfig) error {
 	foundRun := false
 	skipNext := false
 	for _, arg := range args[1:] {
@@ -105,15 +113,19 @@ func validateGoRunArgs(args []*syntax.Word) error {
 			continue
 		}
 		// Check if argument contains @ (remote package reference)
-		if strings.Contains(lit, "@") {
-			return fmt.Errorf("go run with remote package references (@) is not allowed: fetches and executes remote code")
+		if strings.Contains(lit, "@") && !goCfg.GoFetch() {
+			return fmt.Errorf("go run with remote package references (@) is not allowed (runtimes.go.allow_fetch is disabled): fetches and executes remote code")
 		}
 	}
 	return nil
 }
 
-// validateGoInstallArgs checks that go install is not invoked with remote package references.
-func validateGoInstallArgs(args []*syntax.Word) error {
+// validateGoInstallArgs checks that go install is not invoked with remote
+// package references unless fetching is allowed.
+func validateGoInstallArgs(args []*syntax.Word, goCfg *config.GoConfig) error {
+	if goCfg.GoFetch() {
+		return nil
+	}
 	foundInstall := false
 	for _, arg := range args[1:] {
 		lit := arg.Lit()
@@ -132,7 +144,7 @@ func validateGoInstallArgs(args []*syntax.Word) error {
 		}
 		// Check if argument contains @ (remote package reference)
 		if strings.Contains(lit, "@") {
-			return fmt.Errorf("go install with remote package references (@) is not allowed: fetches and installs remote code")
+			return fmt.Errorf("go install with remote package references (@) is not allowed (runtimes.go.allow_fetch is disabled): fetches and installs remote code")
 		}
 	}
 	return nil
@@ -71,9 +71,16 @@ func TestValidateGoArgs(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name:    "go get allowed",
+			name:      "go get blocked by default",
+			command:   "go get github.com/example/pkg",
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runtimes.go.allow_fetch is disabled",
+		},
+		{
+			name:    "go get allowed when allow_fetch=true",
 			command: "go get github.com/example/pkg",
-			goCfg:   &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:   &config.GoConfig{Enabled: boolPtr(true), AllowFetch: boolPtr(true)},
 			wantErr: false,
 		},
 		{
@@ -97,35 +104,69 @@ func TestValidateGoArgs(t *testing.T) {
 
 		// go run variants
 		{
-			name:    "go run local file allowed",
+			name:      "go run blocked by default",
+			command:   "go run main.go",
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runtimes.go.allow_run is disabled",
+		},
+		{
+			name:      "go run blocked when allow_run=false",
+			command:   "go -C sub run .",
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(false)},
+			wantErr:   true,
+			errSubstr: "runtimes.go.allow_run is disabled",
+		},
+		{
+			name:    "go run local file allowed when allow_run=true",
 			command: "go run main.go",
-			goCfg:   &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:   &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true)},
 			wantErr: false,
 		},
 		{
-			name:    "go run current directory allowed",
+			name:    "go run current directory allowed when allow_run=true",
 			command: "go run .",
-			goCfg:   &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:   &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true)},
 			wantErr: false,
 		},
 		{
 			name:      "go run with @ blocked",
 			command:   "go run example.com/cmd@latest",
-			goCfg:     &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true)},
 			wantErr:   true,
 			errSubstr: "remote package references",
 		},
 		{
 			name:      "go run with @version blocked",
 			command:   "go run github.com/user/tool@v1.0.0",
-			goCfg:     &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true)},
 			wantErr:   true,
 			errSubstr: "remote package references",
 		},
+		{
+			name:      "go run with @ needs allow_run even when allow_fetch=true",
+			command:   "go run example.com/cmd@latest",
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowFetch: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runtimes.go.allow_run is disabled",
+		},
+		{
+			name:    "go run with @ allowed when allow_run and allow_fetch are true",
+			command: "go run example.com/cmd@latest",
+			goCfg:   &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true), AllowFetch: boolPtr(true)},
+			wantErr: false,
+		},
 		{
 			name:      "go run with -exec blocked",
 			command:   "go run -exec echo main.go",
-			goCfg:     &config.GoConfig{Enabled: boolPtr(true)},
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "-exec",
+		},
+		{
+			name:      "go run with -exec blocked even with allow_fetch",
+			command:   "go run -exec echo main.go",
+			goCfg:     &config.GoConfig{Enabled: boolPtr(true), AllowRun: boolPtr(true), AllowFetch: boolPtr(true)},
 			wantErr:   true,
 			errSubstr: "-exec",
 		},
@@ -157,6 +198,12 @@ func TestValidateGoArgs(t *testing.T) {
 			wantErr:   true,
 			errSubstr: "remote package references",
 		},
+		{
+			name:    "go install with @ allowed when allow_fetch=true",
+			command: "go install example.com/cmd@latest",
+			goCfg:   &config.GoConfig{Enabled: boolPtr(true), AllowFetch: boolPtr(true)},
+			wantErr: false,
+		},
 
 		// go generate
 		{
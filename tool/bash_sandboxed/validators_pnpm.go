@@ -11,6 +11,28 @@ import (
 // blockedPnpmSubcommands are dangerous subcommands that affect shared state.
 var blockedPnpmSubcommands = map[string]string{
 	"publish": "publishes packages to npm registry (affects shared state)",
+	"rebuild": "runs dependency lifecycle scripts",
+}
+
+// pnpmInstallSubcommands install dependencies and would run their lifecycle
+// scripts (preinstall, install, postinstall). pnpmIgnoreScripts, registered
+// in execArgRewriters, rewrites them to pass --ignore-scripts.
+var pnpmInstallSubcommands = map[string]bool{
+	"install": true,
+	"i":       true,
+	"add":     true,
+	"update":  true,
+	"up":      true,
+}
+
+// pnpmValueFlags are global pnpm flags that take a value argument. Other
+// flags, such as -w/--workspace-root, are boolean.
+var pnpmValueFlags = map[string]bool{
+	"-C":            true,
+	"--dir":         true,
+	"-F":            true,
+	"--filter":      true,
+	"--filter-prod": true,
 }
 
 // validatePnpmArgs validates pnpm commands according to the runtime config.
@@ -33,7 +55,7 @@ func validatePnpmArgs(args []*syntax.Word, pnpmCfg *config.PnpmConfig) error {
 			return fmt.Errorf("pnpm arguments must be literal strings")
 		}
 		// Skip global pnpm flags that take a value argument
-		if lit == "-C" || lit == "--dir" || lit == "-w" || lit == "--workspace-root" {
+		if pnpmValueFlags[lit] {
 			skipNext = true
 			continue
 		}
@@ -63,6 +85,14 @@ func validatePnpmArgs(args []*syntax.Word, pnpmCfg *config.PnpmConfig) error {
 		return fmt.Errorf("pnpm subcommand %q is not allowed: %s", subcommand, reason)
 	}
 
+	if pnpmInstallSubcommands[subcommand] {
+		for _, arg := range args[1:] {
+			if lit := arg.Lit(); lit == "--no-ignore-scripts" || strings.HasPrefix(lit, "--ignore-scripts=") {
+				return fmt.Errorf("pnpm %s %s is not allowed: lifecycle scripts are always ignored", subcommand, lit)
+			}
+		}
+	}
+
 	// Validate specific subcommands
 	switch subcommand {
 	case "dlx":
@@ -75,6 +105,40 @@ func validatePnpmArgs(args []*syntax.Word, pnpmCfg *config.PnpmConfig) error {
 	return nil
 }
 
+// pnpmIgnoreScripts returns args with --ignore-scripts inserted after the
+// subcommand if it installs dependencies, so that packages cannot run code
+// through lifecycle scripts. Other commands are returned unchanged.
+func pnpmIgnoreScripts(args []string) []string {
+	i := pnpmSubcommandIndex(args)
+	if i == len(args) || !pnpmInstallSubcommands[args[i]] {
+		return args
+	}
+	for _, arg := range args[i+1:] {
+		if arg == "--ignore-scripts" {
+			return args
+		}
+	}
+	rewritten := make([]string, 0, len(args)+1)
+	rewritten = append(rewritten, args[:i+1]...)
+	rewritten = append(rewritten, "--ignore-scripts")
+	return append(rewritten, args[i+1:]...)
+}
+
+// pnpmSubcommandIndex returns the index in args of the pnpm subcommand,
+// skipping global flags, or len(args) if there is none.
+func pnpmSubcommandIndex(args []string) int {
+	for i := 1; i < len(args); i++ {
+		if pnpmValueFlags[args[i]] {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+	}
+	return len(args)
+}
+
 // validatePnpmDlxArgs checks that pnpm dlx is not invoked with remote package references.
 // pnpm dlx downloads and executes packages, similar to npx.
 func validatePnpmDlxArgs(args []*syntax.Word) error {
@@ -108,4 +172,3 @@ func validatePnpmExecArgs(args []*syntax.Word) error {
 	// No additional validation needed beyond what's already in place
 	return nil
 }
-
@@ -1,6 +1,7 @@
 package bash_sandboxed
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/gartnera/lite-sandbox/config"
@@ -158,6 +159,49 @@ func TestValidatePnpmArgs(t *testing.T) {
 			wantErr: false,
 		},
 
+		// Lifecycle scripts
+		{
+			name:      "pnpm install --no-ignore-scripts blocked",
+			command:   "pnpm install --no-ignore-scripts",
+			pnpmCfg:   &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "lifecycle scripts are always ignored",
+		},
+		{
+			name:      "pnpm --filter install --no-ignore-scripts blocked",
+			command:   "pnpm --filter web install --no-ignore-scripts",
+			pnpmCfg:   &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "lifecycle scripts are always ignored",
+		},
+		{
+			name:      "pnpm -w add --no-ignore-scripts blocked",
+			command:   "pnpm -w add x --no-ignore-scripts",
+			pnpmCfg:   &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "lifecycle scripts are always ignored",
+		},
+		{
+			name:      "pnpm add --ignore-scripts=false blocked",
+			command:   "pnpm add react --ignore-scripts=false",
+			pnpmCfg:   &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "lifecycle scripts are always ignored",
+		},
+		{
+			name:    "pnpm install --ignore-scripts allowed",
+			command: "pnpm install --ignore-scripts",
+			pnpmCfg: &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr: false,
+		},
+		{
+			name:      "pnpm rebuild blocked",
+			command:   "pnpm rebuild",
+			pnpmCfg:   &config.PnpmConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runs dependency lifecycle scripts",
+		},
+
 		// Edge cases
 		{
 			name:    "bare pnpm command allowed",
@@ -216,3 +260,67 @@ func TestValidatePnpmArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestPnpmIgnoreScripts(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"pnpm", "install"}, []string{"pnpm", "install", "--ignore-scripts"}},
+		{[]string{"pnpm", "i", "--frozen-lockfile"}, []string{"pnpm", "i", "--ignore-scripts", "--frozen-lockfile"}},
+		{[]string{"pnpm", "add", "react"}, []string{"pnpm", "add", "--ignore-scripts", "react"}},
+		{[]string{"pnpm", "-C", "web", "update"}, []string{"pnpm", "-C", "web", "update", "--ignore-scripts"}},
+		{[]string{"pnpm", "-w", "install"}, []string{"pnpm", "-w", "install", "--ignore-scripts"}},
+		{[]string{"pnpm", "--workspace-root", "add", "x"}, []string{"pnpm", "--workspace-root", "add", "--ignore-scripts", "x"}},
+		{[]string{"pnpm", "--filter", "web", "install"}, []string{"pnpm", "--filter", "web", "install", "--ignore-scripts"}},
+		{[]string{"pnpm", "-F", "web", "add", "x"}, []string{"pnpm", "-F", "web", "add", "--ignore-scripts", "x"}},
+		{[]string{"pnpm", "--filter=web", "install"}, []string{"pnpm", "--filter=web", "install", "--ignore-scripts"}},
+		{[]string{"pnpm", "install", "--ignore-scripts"}, []string{"pnpm", "install", "--ignore-scripts"}},
+		{[]string{"pnpm", "list"}, []string{"pnpm", "list"}},
+		{[]string{"pnpm", "run", "install"}, []string{"pnpm", "run", "install"}},
+		{[]string{"pnpm", "--version"}, []string{"pnpm", "--version"}},
+		{[]string{"pnpm"}, []string{"pnpm"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got := pnpmIgnoreScripts(tt.args)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("pnpmIgnoreScripts(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePnpmXargs(t *testing.T) {
+	s := NewSandbox()
+	s.UpdateConfig(&config.Config{Runtimes: &config.RuntimesConfig{Pnpm: &config.PnpmConfig{Enabled: boolPtr(true)}}}, t.TempDir())
+	tests := []struct {
+		command string
+		errMsg  string
+	}{
+		{"echo react | xargs pnpm add", "xargs cannot pass its input to pnpm"},
+		{"echo react | xargs -n1 pnpm -w add", "xargs cannot pass its input to pnpm"},
+		{"echo react | xargs -I{} pnpm add -- {}", ""},
+		{"echo install | xargs pnpm", "xargs cannot run pnpm without a subcommand"},
+		{"echo install | xargs pnpm -C web", "xargs cannot run pnpm without a subcommand"},
+		{"echo install | xargs timeout 600 pnpm --filter web", "xargs cannot run pnpm without a subcommand"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = s.validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
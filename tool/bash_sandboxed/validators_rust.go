@@ -17,6 +17,73 @@ var blockedCargoSubcommands = map[string]string{
 	"yank":    "removes a version from the registry index",
 }
 
+// blockedCargoConfigKeys are cargo config keys, by table, that make cargo run
+// a program of the caller's choosing, and so cannot be set with --config.
+// Keys under target and host are matched after the <triple> or cfg() part,
+// e.g. target.x86_64-unknown-linux-gnu.runner.
+var blockedCargoConfigKeys = map[string]map[string]bool{
+	"build": {
+		"rustc":                   true,
+		"rustc-wrapper":           true,
+		"rustc-workspace-wrapper": true,
+		"rustdoc":                 true,
+		"rustflags":               true,
+		"rustdocflags":            true,
+	},
+	"target": {
+		"runner":    true,
+		"linker":    true,
+		"rustflags": true,
+	},
+	"host": {
+		"linker":    true,
+		"rustflags": true,
+	},
+}
+
+// validateCargoConfigArg rejects a --config KEY=VALUE override of a key in
+// blockedCargoConfigKeys. A --config value without "=" names a config file,
+// which is path-validated like other file arguments.
+func validateCargoConfigArg(value string) error {
+	segments, ok := cargoConfigKey(value)
+	if !ok || len(segments) < 2 {
+		return nil
+	}
+	if blockedCargoConfigKeys[segments[0]][segments[len(segments)-1]] {
+		return fmt.Errorf("cargo --config %s is not allowed", strings.Join(segments, "."))
+	}
+	return nil
+}
+
+// cargoConfigKey splits the TOML dotted key of a KEY=VALUE --config override
+// into its segments, unquoting quoted segments such as 'cfg(unix)'. Dots and
+// "=" inside quotes do not split. It reports false if value has no "=".
+func cargoConfigKey(value string) ([]string, bool) {
+	var segments []string
+	var cur strings.Builder
+	var quote rune
+	for _, ch := range value {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '.':
+			segments = append(segments, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		case ch == '=':
+			return append(segments, strings.TrimSpace(cur.String())), true
+		default:
+			cur.WriteRune(ch)
+		}
+	}
+	return nil, false
+}
+
 // validateCargoArgs validates cargo commands according to the runtime config.
 func validateCargoArgs(args []*syntax.Word, rustCfg *config.RustConfig) error {
 	if len(args) < 2 {
@@ -49,6 +116,25 @@ func validateCargoArgs(args []*syntax.Word, rustCfg *config.RustConfig) error {
 		break
 	}
 
+	// --config is accepted both before and after the subcommand.
+	for i := 1; i < len(args); i++ {
+		text := wordText(args[i])
+		if text == "--" {
+			break
+		}
+		value, attached := strings.CutPrefix(text, "--config=")
+		if !attached {
+			if text != "--config" || i+1 >= len(args) {
+				continue
+			}
+			i++
+			value = wordText(args[i])
+		}
+		if err := validateCargoConfigArg(value); err != nil {
+			return err
+		}
+	}
+
 	if subcommand == "" {
 		// Only flags, no subcommand (e.g., "cargo --version")
 		return nil
@@ -70,6 +156,9 @@ func validateCargoArgs(args []*syntax.Word, rustCfg *config.RustConfig) error {
 	// Validate specific subcommands
 	switch subcommand {
 	case "install":
+		if !rustCfg.RustInstall() {
+			return fmt.Errorf("cargo install is not allowed (runtimes.rust.install is disabled)")
+		}
 		return validateCargoInstallArgs(args)
 	}
 
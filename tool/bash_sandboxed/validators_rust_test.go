@@ -151,32 +151,95 @@ func TestValidateCargoArgs(t *testing.T) {
 
 		// cargo install variants
 		{
-			name:    "cargo install --path local allowed",
+			name:      "cargo install blocked by default",
+			command:   "cargo install --path .",
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runtimes.rust.install is disabled",
+		},
+		{
+			name:    "cargo install --path local allowed when install=true",
 			command: "cargo install --path .",
-			rustCfg: &config.RustConfig{Enabled: boolPtr(true)},
+			rustCfg: &config.RustConfig{Enabled: boolPtr(true), Install: boolPtr(true)},
 			wantErr: false,
 		},
 		{
-			name:    "cargo install --path=local allowed",
+			name:    "cargo install --path=local allowed when install=true",
 			command: "cargo install --path=./my-crate",
-			rustCfg: &config.RustConfig{Enabled: boolPtr(true)},
+			rustCfg: &config.RustConfig{Enabled: boolPtr(true), Install: boolPtr(true)},
 			wantErr: false,
 		},
 		{
 			name:      "cargo install remote crate blocked",
 			command:   "cargo install ripgrep",
-			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true), Install: boolPtr(true)},
 			wantErr:   true,
 			errSubstr: "remote crate references",
 		},
 		{
 			name:      "cargo install remote crate with version blocked",
 			command:   "cargo install ripgrep --version 13.0.0",
-			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true), Install: boolPtr(true)},
 			wantErr:   true,
 			errSubstr: "remote crate references",
 		},
 
+		// --config overrides
+		{
+			name:    "cargo --config benign key allowed",
+			command: "cargo --config net.git-fetch-with-cli=true build",
+			rustCfg: &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr: false,
+		},
+		{
+			name:    "cargo --config file allowed",
+			command: "cargo build --config ./ci.toml",
+			rustCfg: &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr: false,
+		},
+		{
+			name:      "cargo --config build.rustflags blocked",
+			command:   `cargo --config 'build.rustflags=["-C","link-arg=-fuse-ld=evil"]' build`,
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "cargo --config build.rustflags is not allowed",
+		},
+		{
+			name:      "cargo --config= build.rustc-wrapper blocked",
+			command:   "cargo check --config=build.rustc-wrapper=/bin/sh",
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "cargo --config build.rustc-wrapper is not allowed",
+		},
+		{
+			name:      "cargo --config target runner blocked",
+			command:   `cargo test --config 'target.x86_64-unknown-linux-gnu.runner="sh"'`,
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "cargo --config target.x86_64-unknown-linux-gnu.runner is not allowed",
+		},
+		{
+			name:      "cargo --config cfg target runner blocked",
+			command:   `cargo run --config "target.'cfg(unix)'.runner = 'sh'"`,
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "is not allowed",
+		},
+		{
+			name:      "cargo --config cfg with = and dots blocked",
+			command:   `cargo run --config 'target."cfg(any(unix, target_env = gnu.x))".runner="sh"'`,
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "runner is not allowed",
+		},
+		{
+			name:      "cargo --config target linker blocked",
+			command:   "cargo build --config target.aarch64-apple-darwin.linker=/tmp/ld",
+			rustCfg:   &config.RustConfig{Enabled: boolPtr(true)},
+			wantErr:   true,
+			errSubstr: "cargo --config target.aarch64-apple-darwin.linker is not allowed",
+		},
+
 		// cargo publish
 		{
 			name:      "cargo publish blocked by default",
@@ -3,6 +3,8 @@ package bash_sandboxed
 import (
 	"strings"
 	"testing"
+
+	"github.com/gartnera/lite-sandbox/config"
 )
 
 func TestValidate_BlockedFindFlags(t *testing.T) {
@@ -82,6 +84,20 @@ func TestValidate_Xargs(t *testing.T) {
 		{"xargs -I python", `find . | xargs -I {} python {}`, `command "python" is not allowed`},
 		{"xargs -n python", `find . | xargs -n1 python`, `command "python" is not allowed`},
 		{"xargs -- python", `find . | xargs -- python`, `command "python" is not allowed`},
+		{"xargs -a python", `xargs -a list.txt python`, `command "python" is not allowed`},
+		{"xargs --arg-file python", `xargs --arg-file list.txt python`, `command "python" is not allowed`},
+		{"xargs --max-args python", `find . | xargs --max-args 5 python`, `command "python" is not allowed`},
+		{"xargs env without command", `echo curl x | xargs env`, "xargs cannot run env without a command"},
+		{"xargs timeout without command", `echo curl x | xargs timeout 5`, "xargs cannot run timeout without a command"},
+		{"xargs nice env without command", `echo curl x | xargs nice env FOO=1`, "xargs cannot run env without a command"},
+		{"xargs -I rg flag", `echo -z | xargs -I{} rg {} a`, `"{}" must follow "--"`},
+		{"xargs --replace git", `echo --hard | xargs --replace git reset {}`, `"{}" must follow "--"`},
+		{"xargs git checkout", `echo other | xargs git checkout`, "xargs cannot pass its input to git checkout"},
+		{"xargs tar", `echo x | xargs tar -tf`, "xargs cannot pass its input to tar"},
+		{"xargs head value flag", `echo 5 f | xargs head -n`, "xargs head: the value of -n would come from its input"},
+		{"xargs sort -o", `echo f | xargs sort -uo`, "xargs sort: the value of -uo would come from its input"},
+		{"xargs timeout sort abbreviated", `echo f | xargs timeout 5 sort --out`, "xargs sort: the value of --out would come from its input"},
+		{"xargs git add value flag", `echo x | xargs git add --chmod`, "xargs git add: the value of --chmod would come from its input"},
 	}
 	for _, tt := range blocked {
 		t.Run(tt.name, func(t *testing.T) {
@@ -111,6 +127,17 @@ func TestValidate_Xargs(t *testing.T) {
 		{"xargs -I {} cat", `find . | xargs -I {} cat {}`},
 		{"xargs -0 grep", `find . -print0 | xargs -0 grep pattern`},
 		{"xargs no command", `find . | xargs`},
+		{"xargs -a cat", `xargs -a list.txt cat`},
+		{"xargs --arg-file= cat", `xargs --arg-file=list.txt cat`},
+		{"xargs --max-args cat", `find . | xargs --max-args 5 cat`},
+		{"xargs --max-args= cat", `find . | xargs --max-args=5 cat`},
+		{"xargs timeout git", `echo a.go | xargs timeout 5 git add`},
+		{"xargs -I rg after --", `echo x | xargs -I{} rg -e pattern -- {}`},
+		{"xargs head -n", `find . | xargs head -n 5`},
+		{"xargs rm -f", `find . | xargs rm -f`},
+		{"xargs rg -e --", `find . | xargs rg -e --`},
+		{"xargs git reset", `echo a.go | xargs git reset`},
+		{"xargs -I git checkout", `echo other | xargs -I{} git checkout -- {}`},
 	}
 	for _, tt := range allowed {
 		t.Run(tt.name, func(t *testing.T) {
@@ -136,8 +163,6 @@ func TestValidate_RecursiveFindAndXargs(t *testing.T) {
 		{"find -exec xargs grep", `find . -exec xargs grep pattern {} \;`},
 		// xargs running xargs which runs grep
 		{"xargs xargs grep", `find . | xargs xargs grep pattern`},
-		// xargs running find with -exec
-		{"xargs find -exec grep", `find . | xargs find . -exec grep pattern {} \;`},
 	}
 	for _, tt := range allowed {
 		t.Run(tt.name, func(t *testing.T) {
@@ -164,6 +189,11 @@ func TestValidate_RecursiveFindAndXargs(t *testing.T) {
 		{"xargs xargs python", `find . | xargs xargs python`, `command "python" is not allowed`},
 		// xargs running find whose -exec runs a blocked command
 		{"xargs find -exec python", `find . | xargs find . -exec python {} \;`, `command "python" is not allowed`},
+		// find does not stop evaluating its expression at "--", so its
+		// input could add predicates like -delete
+		{"xargs find -exec grep", `find . | xargs find . -exec grep pattern {} \;`, `xargs cannot pass its input to find`},
+		// the inner xargs would run its input as a command
+		{"xargs xargs without command", `find . | xargs xargs`, `xargs cannot pass its input to xargs without a command`},
 	}
 	for _, tt := range blocked {
 		t.Run(tt.name, func(t *testing.T) {
@@ -289,6 +319,12 @@ func TestValidate_RgPre(t *testing.T) {
 		{"rg --pre=python", "rg --pre=python pattern", `command "python" is not allowed`},
 		{"rg --pre curl", "rg --pre curl pattern", `command "curl" is not allowed`},
 		{"rg --pre no arg", "rg --pre", `rg --pre requires a command argument`},
+		{"rg --hostname-bin curl", "rg --hostname-bin curl pattern", `rg --hostname-bin: command "curl" is not allowed`},
+		{"rg --hostname-bin=curl", "rg --hostname-bin=curl pattern", `command "curl" is not allowed`},
+		{"rg --pre after -e --", "rg -e -- --pre=curl pattern", `command "curl" is not allowed`},
+		{"rg --pre after -ie --", "rg -ie -- --pre curl pattern", `command "curl" is not allowed`},
+		{"rg --pre after --glob --", "rg --glob -- --pre=curl pattern", `command "curl" is not allowed`},
+		{"rg --pre after --regexp --", "rg --regexp -- --pre=curl pattern", `command "curl" is not allowed`},
 	}
 	for _, tt := range blocked {
 		t.Run(tt.name, func(t *testing.T) {
@@ -315,6 +351,118 @@ func TestValidate_RgPre(t *testing.T) {
 		{"rg --pre zcat", "rg --pre zcat pattern"},
 		{"rg --pre-glob without pre", "rg pattern"},
 		{"rg empty --pre=", "rg --pre= pattern"},
+		{"rg --pre after --", "rg -- --pre=curl pattern"},
+		{"rg -e value looks like --pre", "rg -e --pre=curl file"},
+		{"rg --regexp value looks like --pre", "rg --regexp --pre=curl file"},
+		{"rg attached -e value", "rg -e-- --pre=cat file"},
+	}
+	for _, tt := range allowed {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected command to be allowed, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate_RgSearchZip(t *testing.T) {
+	for _, command := range []string{"rg -z pattern", "rg --search-zip pattern", "rg -iz pattern"} {
+		f, err := ParseBash(command)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = newTestSandbox().validate(f)
+		if err == nil || !strings.Contains(err.Error(), `rg --search-zip runs gzip: command "gzip" is not allowed`) {
+			t.Errorf("%s: expected the decompressor to be rejected, got %v", command, err)
+		}
+	}
+
+	// -e takes a value, so -ez is the pattern "z", not --search-zip.
+	f, err := ParseBash("rg -ez file")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := newTestSandbox().validate(f); err != nil {
+		t.Errorf("expected rg -ez to be allowed, got %v", err)
+	}
+
+	s := newTestSandbox()
+	s.UpdateConfig(&config.Config{ExtraCommands: rgDecompressors}, t.TempDir())
+	f, err = ParseBash("rg -z pattern")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := s.validate(f); err != nil {
+		t.Errorf("expected rg -z to be allowed with all decompressors allowed, got %v", err)
+	}
+}
+
+func TestRgExcludeGit(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"rg", "x"}, []string{"rg", "x", "--glob=!.git"}},
+		{[]string{"rg", "--hidden", "x", "."}, []string{"rg", "--hidden", "x", ".", "--glob=!.git"}},
+		{[]string{"rg", "-g", "*", "x"}, []string{"rg", "-g", "*", "x", "--glob=!.git"}},
+		{[]string{"rg", "-uuu", "--", "-x", "."}, []string{"rg", "-uuu", "--glob=!.git", "--", "-x", "."}},
+		{[]string{"rg", "-e", "--", "x"}, []string{"rg", "-e", "--", "x", "--glob=!.git"}},
+		{[]string{"rg", "--glob", "--", "x"}, []string{"rg", "--glob", "--", "x", "--glob=!.git"}},
+		{[]string{"rg", "x", "-g"}, []string{"rg", "x", "-g"}},
+		{[]string{"rg"}, []string{"rg", "--glob=!.git"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := rgExcludeGit(tt.args); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("rgExcludeGit(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_Tree(t *testing.T) {
+	blocked := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"tree -l", "tree -l .", "tree flag '-l' is not allowed"},
+		{"tree -o", "tree -o out.txt .", "tree flag '-o' is not allowed"},
+		{"tree combined -al", "tree -al .", "tree flag '-l' is not allowed"},
+		{"tree combined -ao", "tree -ao out.txt .", "tree flag '-o' is not allowed"},
+	}
+	for _, tt := range blocked {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+
+	allowed := []struct {
+		name    string
+		command string
+	}{
+		{"tree", "tree"},
+		{"tree .", "tree ."},
+		{"tree -L", "tree -L 2 ."},
+		{"tree -aL", "tree -aL 2 ."},
+		{"tree -I pattern", "tree -I node_modules ."},
+		{"tree -P pattern containing l", "tree -P '*.lock' ."},
+		{"tree --dirsfirst", "tree --dirsfirst -d"},
+		{"tree -- dir", "tree -- -l"},
 	}
 	for _, tt := range allowed {
 		t.Run(tt.name, func(t *testing.T) {
@@ -327,4 +475,704 @@ func TestValidate_RgPre(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("tree /etc path blocked", func(t *testing.T) {
+		dir := t.TempDir()
+		err := newTestSandbox().ValidateCommand("tree /etc", dir, []string{dir}, []string{dir})
+		if err == nil {
+			t.Fatal("expected path validation error")
+		}
+		if !strings.Contains(err.Error(), "outside allowed directories") {
+			t.Fatalf("expected outside allowed directories error, got %q", err.Error())
+		}
+	})
+}
+
+func TestValidate_EndOfOptions(t *testing.T) {
+	allowed := []struct {
+		name    string
+		command string
+	}{
+		{"grep pattern after --", "grep -- -f pattern"},
+		{"rg --pre as pattern after --", "rg -- --pre file.txt"},
+		{"tar member named like a flag", "tar -tf archive.tar -- -x"},
+		{"rm file named like a flag", "rm -- --no-preserve-root"},
+		{"sed file named like a flag", "sed 's/a/b/' -- -f"},
+	}
+	for _, tt := range allowed {
+		t.Run("allowed/"+tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected command to be allowed, got: %v", err)
+			}
+		})
+	}
+
+	blocked := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		// find keeps evaluating predicates after "--", so -delete is still a predicate.
+		{"find -delete after --", "find . -- -delete", `find flag "-delete" is not allowed`},
+		{"find -exec after --", "find -- . -exec python {} ;", `command "python" is not allowed`},
+		{"rg --pre before --", "rg --pre python -- pattern", `command "python" is not allowed`},
+		{"tar extract before --", "tar -xf archive.tar -- file", "tar flag '-x' is not allowed"},
+		{"rm flag before --", "rm --no-preserve-root -- /", `rm flag "--no-preserve-root" is not allowed`},
+		{"sed dangerous script after --", "sed -- 'e id'", "sed commands"},
+		{"sed -f before --", "sed -f script.sed -- file", `sed flag "-f" is not allowed`},
+	}
+	for _, tt := range blocked {
+		t.Run("blocked/"+tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Tail(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"tail", "tail log", ""},
+		{"tail -n", "tail -n 5 log", ""},
+		{"tail -n attached", "tail -n5 log", ""},
+		{"tail -c", "tail -c 100 log", ""},
+		{"tail --lines", "tail --lines 5 log", ""},
+		{"tail --lines=", "tail --lines=5 log", ""},
+		{"tail -q -v", "tail -qv a b", ""},
+		{"tail obsolete count", "tail -5 log", ""},
+		{"tail -n value looks like f", "tail -n f log", ""},
+		{"tail file named -f after --", "tail -- -f", ""},
+		{"head -f is not follow", "head -n 5 log", ""},
+		{"tail -f", "tail -f log", "tail -f is not allowed (blocks indefinitely)"},
+		{"tail -F", "tail -F log", "tail -F is not allowed (blocks indefinitely)"},
+		{"tail --follow", "tail --follow log", "tail --follow is not allowed"},
+		{"tail --follow=name", "tail --follow=name log", "tail --follow is not allowed"},
+		{"tail --retry", "tail --retry log", "tail --retry is not allowed"},
+		{"tail -nf cluster", "tail -qf log", "tail -f is not allowed"},
+		{"tail -n then -f", "tail -n 5 -f log", "tail -f is not allowed"},
+		{"tail obsolete follow", "tail -5f log", "tail -5f is not allowed"},
+		{"tail obsolete plus follow", "tail +10lf log", "tail +10lf is not allowed"},
+		{"tail -f in pipeline", "tail -f log | grep x", "tail -f is not allowed"},
+		{"tail -f via xargs", "echo log | xargs tail -f", "tail -f is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_HeadTailBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"head -c", "head -c 1024 f", ""},
+		{"tail -c", "tail -c 500 f", ""},
+		{"head -c at limit", "head -c 1G f", ""},
+		{"head -n large", "head -n 100000000000 f", ""},
+		{"tail -c offset", "tail -c +100000000000 f", ""},
+		{"head -c dynamic", "head -c \"$N\" f", ""},
+		{"head -c invalid", "head -c lots f", ""},
+		{"head file after --", "head -- -c100000000000", ""},
+		{"head -c huge", "head -c 100000000000 f", "head -c 100000000000 is more than the limit of 1073741824 bytes"},
+		{"head -c attached", "head -c100000000000 f", "head -c 100000000000 is more than the limit"},
+		{"head -c in cluster", "head -qc 2G f", "head -c 2G is more than the limit"},
+		{"head -c all but last", "head -c -2G f", "head -c -2G is more than the limit"},
+		{"head --bytes", "head --bytes=5GB f", "head -c 5GB is more than the limit"},
+		{"head --bytes separate", "head --bytes 100T f", "head -c 100T is more than the limit"},
+		{"head --bytes abbreviated", "head --byt=2GiB f", "head -c 2GiB is more than the limit"},
+		{"head -n then -c", "head -n 5 -c 3000000b f", "head -c 3000000b is more than the limit"},
+		{"tail -c huge", "tail -c 100000000000 f", "tail -c 100000000000 is more than the limit"},
+		{"tail obsolete bytes", "tail -100000000000c f", "tail -c 100000000000 is more than the limit"},
+		{"tail -c via xargs", "echo f | xargs tail -c 2G", "tail -c 2G is more than the limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	s := newTestSandbox()
+	s.UpdateConfig(&config.Config{MaxHeadTailBytes: 100}, t.TempDir())
+	f, _ := ParseBash("head -c 1K f")
+	if err := s.validate(f); err == nil || !strings.Contains(err.Error(), "limit of 100 bytes") {
+		t.Errorf("expected the configured limit to apply, got %v", err)
+	}
+	s.UpdateConfig(&config.Config{MaxHeadTailBytes: -1}, t.TempDir())
+	f, _ = ParseBash("head -c 100000000000 f")
+	if err := s.validate(f); err != nil {
+		t.Errorf("expected no limit with max_head_tail_bytes: -1, got %v", err)
+	}
+}
+
+func TestValidate_TailAllowFollow(t *testing.T) {
+	s := newTestSandbox()
+	allow := true
+	s.UpdateConfig(&config.Config{AllowFollow: &allow}, t.TempDir())
+	for _, cmd := range []string{"tail -f log", "tail -F log", "tail --follow=name --retry log"} {
+		f, err := ParseBash(cmd)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := s.validate(f); err != nil {
+			t.Errorf("expected %q to be allowed with allow_follow, got: %v", cmd, err)
+		}
+	}
+}
+
+func TestValidate_SystemEnumeration(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"locate", "locate passwd", `locate is not allowed`},
+		{"locate via xargs", "echo passwd | xargs locate", `locate is not allowed`},
+		{"which allowed command", "which grep", ""},
+		{"which -a allowed commands", "which -a grep ls", ""},
+		{"which other command", "which docker", `which docker is not allowed`},
+		{"which dynamic name", "which $x", "dynamic command names"},
+		{"which via xargs", "echo docker | xargs which", "without command names"},
+		{"whereis allowed command", "whereis -b grep", ""},
+		{"whereis other command", "whereis docker", `whereis docker is not allowed`},
+		{"whereis search dirs", "whereis -B /opt/bin -f grep", `whereis /opt/bin is not allowed`},
+		{"type builtin", "type cd", ""},
+		{"type other command", "type -p docker", `type docker is not allowed`},
+		{"command -v allowed command", "command -v grep", ""},
+		{"command -v other command", "command -v docker", `command docker is not allowed`},
+		{"command -pV other command", "command -pV docker", `command docker is not allowed`},
+		{"command runs its operand", "command ls", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if err := newTestSandbox().validate(f); err != nil {
+				t.Fatalf("expected command to be allowed by default, got: %v", err)
+			}
+			s := newTestSandbox()
+			s.UpdateConfig(&config.Config{BlockSystemEnumeration: boolPtr(true)}, "")
+			err = s.validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Du(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"du", "du -sh .", ""},
+		{"du -H", "du -H link", ""},
+		{"du -D", "du -sD link", ""},
+		{"du --dereference-args", "du --dereference-args link", ""},
+		{"du -d value then path", "du -d 1 .", ""},
+		{"du --exclude value containing L", "du --exclude L .", ""},
+		{"du -B value containing L", "du -BL .", ""},
+		{"du -L after --", "du -- -L", ""},
+		{"du -L", "du -L .", `du flag "-L" is not allowed`},
+		{"du -shL", "du -shL .", `du flag "-L" is not allowed`},
+		{"du -L after -d value", "du -d 1 -L .", `du flag "-L" is not allowed`},
+		{"du --dereference", "du --dereference .", `du flag "--dereference" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Ls(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"ls", "ls", ""},
+		{"ls -la", "ls -la", ""},
+		{"ls -R", "ls -R .", ""},
+		{"ls --recursive", "ls --recursive .", ""},
+		{"ls -L without recursion", "ls -L .", ""},
+		{"ls -I pattern containing L", "ls -R -I L .", ""},
+		{"ls -w value then R", "ls -w80 -R .", ""},
+		{"ls -L after --", "ls -R -- -L", ""},
+		{"ls -RL", "ls -RL .", `ls flag "-L" is not allowed with -R`},
+		{"ls -LR separate", "ls -L -R .", `ls flag "-L" is not allowed with -R`},
+		{"ls -laRL", "ls -laRL .", `ls flag "-L" is not allowed with -R`},
+		{"ls --recursive --dereference", "ls --recursive --dereference .", `ls flag "--dereference" is not allowed with -R`},
+		{"ls --sort value then -RL", "ls --sort size -RL .", `ls flag "-L" is not allowed with -R`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Man(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"man page", "man ls", ""},
+		{"man section", "man 5 passwd", ""},
+		{"man -k", "man -k printf", ""},
+		{"man local file", "man -l doc/tool.1", ""},
+		{"man sections value containing P", "man -S P ls", ""},
+		{"man locale attached", "man -Len ls", ""},
+		{"man after --", "man -- -P", ""},
+		{"apropos", "apropos socket", ""},
+		{"man -P", "man -P less ls", "man flag '-P' is not allowed"},
+		{"man -P attached", "man -Psh ls", "man flag '-P' is not allowed"},
+		{"man --pager", "man --pager=sh ls", `man flag "--pager" is not allowed`},
+		{"man -H", "man -Hfirefox ls", "man flag '-H' is not allowed"},
+		{"man --html", "man --html ls", `man flag "--html" is not allowed`},
+		{"man -X", "man -X ls", "man flag '-X' is not allowed"},
+		{"man -C", "man -C evil.conf ls", "man flag '-C' is not allowed"},
+		{"man clustered C", "man -aC evil.conf ls", "man flag '-C' is not allowed"},
+		{"apropos --config-file", "apropos --config-file=evil.conf socket", `apropos flag "--config-file" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestManCatPager(t *testing.T) {
+	got := manCatPager([]string{"man", "-a", "printf"})
+	want := []string{"man", "-P", "cat", "-a", "printf"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("manCatPager = %q, want %q", got, want)
+	}
+	if rewrite := execArgRewriters["man"]; rewrite == nil {
+		t.Fatal("man is not registered in execArgRewriters")
+	}
+}
+
+func TestRewriteExecArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"timeout", "600", "pnpm", "install"}, []string{"timeout", "600", "pnpm", "install", "--ignore-scripts"}},
+		{[]string{"env", "pnpm", "add", "x"}, []string{"env", "pnpm", "add", "--ignore-scripts", "x"}},
+		{[]string{"timeout", "5", "rg", "--hidden", "x"}, []string{"timeout", "5", "rg", "--hidden", "x", "--glob=!.git"}},
+		{[]string{"nice", "-n", "5", "env", "rg", "-uuu", "x"}, []string{"nice", "-n", "5", "env", "rg", "-uuu", "x", "--glob=!.git"}},
+		{[]string{"xargs", "pnpm", "add"}, []string{"xargs", "pnpm", "add", "--ignore-scripts"}},
+		{[]string{"xargs", "-n1", "timeout", "600", "pnpm", "install"}, []string{"xargs", "-n1", "timeout", "600", "pnpm", "install", "--ignore-scripts"}},
+		{[]string{"xargs", "git", "reset"}, []string{"xargs", "git", "reset", "--"}},
+		{[]string{"xargs", "git", "-C", "sub", "add", "-v"}, []string{"xargs", "git", "-C", "sub", "add", "-v", "--"}},
+		{[]string{"xargs", "git", "add", "--"}, []string{"xargs", "git", "add", "--"}},
+		{[]string{"xargs", "git", "checkout"}, []string{"xargs", "git", "checkout"}},
+		{[]string{"xargs", "tar", "-tf"}, []string{"xargs", "tar", "-tf"}},
+		{[]string{"xargs", "head", "-n", "5"}, []string{"xargs", "head", "-n", "5", "--"}},
+		{[]string{"xargs", "head", "-n"}, []string{"xargs", "head", "-n"}},
+		{[]string{"xargs", "sort", "-uo"}, []string{"xargs", "sort", "-uo"}},
+		{[]string{"xargs", "rm", "-f", "--"}, []string{"xargs", "rm", "-f", "--"}},
+		{[]string{"xargs", "xargs", "-n1", "rm"}, []string{"xargs", "xargs", "-n1", "rm", "--"}},
+		{[]string{"xargs", "rg", "-e", "--"}, []string{"xargs", "rg", "-e", "--", "--glob=!.git", "--"}},
+		{[]string{"xargs", "-I{}", "git", "add", "--", "{}"}, []string{"xargs", "-I{}", "git", "add", "--", "{}"}},
+		{[]string{"xargs", "cat"}, []string{"xargs", "cat"}},
+		{[]string{"xargs", "bash", "-c", "echo"}, []string{"xargs", "bash", "-c", "echo"}},
+		{[]string{"xargs", "mytool"}, []string{"xargs", "mytool"}},
+		{[]string{"xargs"}, []string{"xargs"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got := rewriteExecArgs(tt.args, map[string]bool{"mytool": true})
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("rewriteExecArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_Info(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"info topic", "info coreutils", ""},
+		{"info node", "info -n Invoking grep", ""},
+		{"info stdout", "info -o - grep", ""},
+		{"info --output stdout", "info --output=- grep", ""},
+		{"info file", "info -f doc/manual.info", ""},
+		{"info node value o", "info -n o grep", ""},
+		{"info -o file", "info -o out.txt grep", `info output to "out.txt" is not allowed`},
+		{"info -o attached", "info -oout.txt grep", `info output to "out.txt" is not allowed`},
+		{"info clustered -o", "info -ao out.txt grep", `info output to "out.txt" is not allowed`},
+		{"info --output", "info --output out.txt grep", `info output to "out.txt" is not allowed`},
+		{"info --output=", "info --output=out.txt grep", `info output to "out.txt" is not allowed`},
+		{"info --dribble", "info --dribble=keys.txt", `info flag "--dribble" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Timeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"timeout grep", "timeout 5 grep p f", ""},
+		{"timeout --signal=", "timeout --signal=KILL 5 grep p f", ""},
+		{"timeout -s", "timeout -s KILL 5 grep p f", ""},
+		{"timeout -k", "timeout -k 1 5 grep p f", ""},
+		{"timeout --kill-after", "timeout --kill-after 1 --preserve-status 5 grep p f", ""},
+		{"timeout --", "timeout -- 5 grep p f", ""},
+		{"timeout no command", "timeout 5", ""},
+		{"timeout curl", "timeout 5 curl example.com", `command "curl" is not allowed`},
+		{"timeout --signal curl", "timeout --signal KILL 5 curl example.com", `command "curl" is not allowed`},
+		{"timeout -s curl", "timeout -s 9 5 curl example.com", `command "curl" is not allowed`},
+		{"timeout nested", "timeout 5 timeout 1 python", `command "python" is not allowed`},
+		{"timeout recurses", "timeout 5 git push", `git subcommand "push" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Env(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"env print", "env", ""},
+		{"env assignment", "env FOO=bar grep p f", ""},
+		{"env -i", "env -i FOO=bar grep p f", ""},
+		{"env -u", "env -u HOME grep p f", ""},
+		{"env --", "env -- grep p f", ""},
+		{"env only assignments", "env FOO=bar", ""},
+		{"env curl", "env curl example.com", `command "curl" is not allowed`},
+		{"env assignment curl", "env FOO=bar curl example.com", `command "curl" is not allowed`},
+		{"env -u value curl", "env -u grep curl example.com", `command "curl" is not allowed`},
+		{"env PATH", "env PATH=/tmp grep p f", "setting PATH is not allowed"},
+		{"env LD_PRELOAD", "env LD_PRELOAD=x.so cat f", "setting LD_PRELOAD is not allowed"},
+		{"env -S", "env -S 'sh -c id'", `env flag "-S" is not allowed`},
+		{"env --split-string", "env --split-string=id", `env flag "--split-string" is not allowed`},
+		{"env -P", "env -P /tmp cat f", `env flag "-P" is not allowed`},
+		{"env nested timeout", "env timeout 5 python", `command "python" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_NiceIonice(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"nice grep", "nice -n 10 grep p f", ""},
+		{"nice attached value", "nice -n10 grep p f", ""},
+		{"nice obsolete form", "nice -10 grep p f", ""},
+		{"nice --adjustment", "nice --adjustment 5 grep p f", ""},
+		{"nice --adjustment=", "nice --adjustment=5 grep p f", ""},
+		{"nice no command", "nice", ""},
+		{"nice python", "nice -n 10 python x", `command "python" is not allowed`},
+		{"nice curl", "nice curl example.com", `command "curl" is not allowed`},
+		{"nice --", "nice -- python x", `command "python" is not allowed`},
+		{"nice recurses", "nice -n 5 git push", `git subcommand "push" is not allowed`},
+		{"nice timeout", "nice timeout 5 python", `command "python" is not allowed`},
+		{"ionice grep", "ionice -c 3 grep p f", ""},
+		{"ionice attached class", "ionice -c3 -n7 grep p f", ""},
+		{"ionice cluster", "ionice -tc3 grep p f", ""},
+		{"ionice --class", "ionice --class idle --classdata=7 grep p f", ""},
+		{"ionice no command", "ionice", ""},
+		{"ionice python", "ionice -c 2 -n 0 python x", `command "python" is not allowed`},
+		{"ionice value is not the command", "ionice -c python grep p f", ""},
+		{"ionice -p", "ionice -c 3 -p 1", `ionice flag "-p" is not allowed`},
+		{"ionice -P in cluster", "ionice -tP 1", `ionice flag "-P" is not allowed`},
+		{"ionice --uid", "ionice --uid=0", `ionice flag "--uid" is not allowed`},
+		{"nice ionice", "nice ionice -c 3 python x", `command "python" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_ChecksumArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"hash files", "sha256sum a.txt b.txt", ""},
+		{"hash stdin", "echo x | sha256sum", ""},
+		{"check manifest", "sha256sum -c m.txt", ""},
+		{"check long flag", "md5sum --check --quiet m.txt", ""},
+		{"check cluster", "sha1sum -cw m.txt", ""},
+		{"check dynamic manifest", "sha256sum -c $M", ""},
+		{"check after length", "b2sum -l 256 -c m.txt", ""},
+		{"check after algorithm", "shasum -a 256 -c m.txt", ""},
+		{"tag and zero", "sha1sum --tag --zero a.txt", ""},
+		{"binary", "md5sum -b a.txt", ""},
+		{"cksum check", "cksum --check m.txt", ""},
+		{"algorithm value is not a flag", "cksum -a c m.txt", ""},
+		{"check stdin", "cat m.txt | sha256sum -c", "sha256sum -c from standard input is not allowed"},
+		{"check dash", "sha256sum --check -", "from standard input is not allowed"},
+		{"check stdin after length", "b2sum -l 256 -c", "from standard input is not allowed"},
+		{"xargs check stdin", "echo m.txt | xargs md5sum -c", "from standard input is not allowed"},
+		{"xargs check with manifest", "echo outside.txt | xargs sha256sum -c m.txt", "xargs cannot run sha256sum -c"},
+		{"xargs check replace", "echo outside.txt | xargs -I{} sha256sum --check -- {}", "xargs cannot run sha256sum -c"},
+		{"xargs timeout check", "echo outside.txt | xargs timeout 5 b2sum -c m.txt", "xargs cannot run b2sum -c"},
+		{"xargs hash", "echo a.txt | xargs sha256sum", ""},
+		{"cksum check stdin", "cksum -a sha256 -c < m.txt", "cksum -c from standard input is not allowed"},
+		{"b2sum check stdin", "b2sum --check", "b2sum -c from standard input is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_Seq(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"seq last", "seq 100", ""},
+		{"seq range", "seq 1 100", ""},
+		{"seq increment", "seq 0 1000 1000000000", ""},
+		{"seq descending", "seq 10 -1 1", ""},
+		{"seq negative first", "seq -5 5", ""},
+		{"seq empty range", "seq 100000000000 1", ""},
+		{"seq at limit", "seq 10000000", ""},
+		{"seq separator number", "seq -s 5 10", ""},
+		{"seq format", "seq -f %03g 1 10", ""},
+		{"seq dynamic", "seq 1 $N", ""},
+		{"seq huge", "seq 1 100000000000", "seq would print 1e+11 lines"},
+		{"seq exponent", "seq 1e18", "more than the limit of 10000000"},
+		{"seq infinite", "seq inf", "more than the limit"},
+		{"seq with flags", "seq -w -s , 1 100000000000", "more than the limit"},
+		{"seq over limit", "seq 10000001", "more than the limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
 }
@@ -13,12 +13,16 @@ var blockedRmFlags = map[string]string{
 }
 
 // validateRmArgs checks that rm is not called with dangerous flags.
-func validateRmArgs(_ *Sandbox, args []*syntax.Word) error {
+// Arguments after "--" are file operands, not flags.
+func validateRmArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
 	for _, arg := range args[1:] {
 		lit := arg.Lit()
 		if lit == "" {
 			continue
 		}
+		if lit == "--" {
+			return nil
+		}
 		if reason, blocked := blockedRmFlags[lit]; blocked {
 			return fmt.Errorf("rm flag %q is not allowed: %s", lit, reason)
 		}
@@ -37,18 +41,27 @@ func validateRmArgs(_ *Sandbox, args []*syntax.Word) error {
 // Note: GNU sed supports --sandbox which disables e/r/w commands natively,
 // but BSD sed does not support this flag, so we parse expressions instead
 // to stay portable across both implementations.
-func validateSedArgs(_ *Sandbox, args []*syntax.Word) error {
+func validateSedArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	endOfOptions := false
 	for _, arg := range args[1:] {
 		text := wordText(arg)
 		if text == "" {
 			continue
 		}
-		if text == "-f" || text == "--file" || strings.HasPrefix(text, "--file=") {
-			return fmt.Errorf("sed flag %q is not allowed: script files bypass command validation", text)
-		}
-		// Skip flags
-		if strings.HasPrefix(text, "-") {
-			continue
+		// After "--" every argument is an operand (script or input file),
+		// so dash-prefixed text is checked as a possible script.
+		if !endOfOptions {
+			if text == "--" {
+				endOfOptions = true
+				continue
+			}
+			if text == "-f" || text == "--file" || strings.HasPrefix(text, "--file=") {
+				return fmt.Errorf("sed flag %q is not allowed: script files bypass command validation", text)
+			}
+			// Skip flags
+			if strings.HasPrefix(text, "-") {
+				continue
+			}
 		}
 		if containsSedDangerousCmd(text) {
 			return fmt.Errorf("sed commands 'e', 'r', 'R', 'w', 'W' are not allowed: they can execute commands or access files outside path validation")
@@ -93,6 +106,74 @@ func containsSedDangerousCmd(expr string) bool {
 	return false
 }
 
+// blockedInstallFlags lists install options that change ownership or run
+// another program. Short options are keyed by their letter.
+var blockedInstallFlags = map[string]string{
+	"o":             "changes file ownership",
+	"g":             "changes file ownership",
+	"s":             "runs the strip program",
+	"owner":         "changes file ownership",
+	"group":         "changes file ownership",
+	"strip":         "runs the strip program",
+	"strip-program": "runs an arbitrary program",
+}
+
+// installValueOptions are the install short options that take a value, which
+// is the rest of the argument or the next argument.
+const installValueOptions = "mgoSt"
+
+// installValueLongOptions are the install long options that take a value.
+var installValueLongOptions = map[string]bool{
+	"mode":             true,
+	"owner":            true,
+	"group":            true,
+	"suffix":           true,
+	"target-directory": true,
+	"strip-program":    true,
+}
+
+// validateInstallArgs allows install as a copy that may set modes: -o/-g
+// (ownership changes) and -s/--strip-program (which run strip or another
+// program) are blocked. Long options may be abbreviated, so any prefix of a
+// blocked long option is blocked too. Source and destination paths are
+// checked by validatePaths like those of cp.
+func validateInstallArgs(_ *sandboxState, args []*syntax.Word, _ string) error {
+	skipNext := false
+	for _, arg := range args[1:] {
+		lit := arg.Lit()
+		if skipNext {
+			skipNext = false
+			continue // value of an option
+		}
+		if lit == "--" {
+			return nil
+		}
+		if len(lit) < 2 || lit[0] != '-' {
+			continue
+		}
+		if name, ok := strings.CutPrefix(lit, "--"); ok {
+			name, _, attached := strings.Cut(name, "=")
+			for blocked, reason := range blockedInstallFlags {
+				if len(blocked) > 1 && strings.HasPrefix(blocked, name) {
+					return fmt.Errorf("install flag %q is not allowed: %s", lit, reason)
+				}
+			}
+			skipNext = installValueLongOptions[name] && !attached
+			continue
+		}
+		for j := 1; j < len(lit); j++ {
+			if reason, blocked := blockedInstallFlags[lit[j:j+1]]; blocked {
+				return fmt.Errorf("install flag '-%c' is not allowed: %s", lit[j], reason)
+			}
+			if strings.IndexByte(installValueOptions, lit[j]) >= 0 {
+				skipNext = j == len(lit)-1
+				break
+			}
+		}
+	}
+	return nil
+}
+
 // wordText extracts the literal text content from a Word node,
 // including content inside single and double quotes.
 func wordText(w *syntax.Word) string {
@@ -113,3 +194,337 @@ func wordText(w *syntax.Word) string {
 	}
 	return sb.String()
 }
+
+// sortWritesOutput reports whether a sort invocation writes to a file via
+// -o FILE, -oFILE, --output FILE, or --output=FILE.
+func sortWritesOutput(args []string) bool {
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--output" || strings.HasPrefix(arg, "--output=") {
+			return true
+		}
+		// -o may be combined with other short flags (e.g., -uo FILE)
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'o' {
+					return true
+				}
+				// The rest of the token is the value of -k, -S, -t or -T.
+				if sortArgConsumingFlags[arg[j]] {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sortArgConsumingFlags lists sort short flags that take a value.
+var sortArgConsumingFlags = map[byte]bool{
+	'k': true, // key definition
+	'S': true, // buffer size
+	't': true, // field separator
+	'T': true, // temporary directory
+}
+
+// sortLongArgConsumingFlags lists sort long flags that consume the next
+// argument.
+var sortLongArgConsumingFlags = map[string]bool{
+	"--batch-size":          true,
+	"--buffer-size":         true,
+	"--compress-program":    true,
+	"--field-separator":     true,
+	"--files0-from":         true,
+	"--key":                 true,
+	"--output":              true,
+	"--parallel":            true,
+	"--random-source":       true,
+	"--sort":                true,
+	"--temporary-directory": true,
+}
+
+// validateSortArgs checks that sort -o has an output file argument and that
+// --compress-program references only whitelisted commands, since sort runs
+// that program to compress temporary files. The -o target itself is validated
+// against writeAllowedPaths by path validation (see conditionalWriteCommands).
+func validateSortArgs(st *sandboxState, args []*syntax.Word, workDir string) error {
+	for i := 1; i < len(args); i++ {
+		text := wordText(args[i])
+		if text == "--" {
+			return nil
+		}
+		if text == "-o" || text == "--output" {
+			if i+1 >= len(args) {
+				return fmt.Errorf("sort %s requires an output file argument", text)
+			}
+			i++
+			continue
+		}
+		if text == "--compress-program" {
+			i++
+			if i >= len(args) {
+				return fmt.Errorf("sort --compress-program requires a program argument")
+			}
+			if err := validateSubCommand(st, args[i:i+1], workDir); err != nil {
+				return fmt.Errorf("sort --compress-program: %w", err)
+			}
+			continue
+		}
+		if prog, ok := strings.CutPrefix(text, "--compress-program="); ok {
+			cmdWord := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: prog}}}
+			if err := validateSubCommand(st, []*syntax.Word{cmdWord}, workDir); err != nil {
+				return fmt.Errorf("sort --compress-program: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// uniqArgConsumingFlags lists uniq flags that consume the next argument.
+var uniqArgConsumingFlags = map[string]bool{
+	"-f":            true, // skip fields
+	"-s":            true, // skip chars
+	"-w":            true, // compare chars
+	"--skip-fields": true,
+	"--skip-chars":  true,
+	"--check-chars": true,
+}
+
+// uniqWritesOutput reports whether a uniq invocation names an OUTPUT file,
+// i.e. has a second positional argument (uniq [OPTION]... [INPUT [OUTPUT]]).
+func uniqWritesOutput(args []string) bool {
+	positional := 0
+	endOfOpts := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !endOfOpts {
+			if arg == "--" {
+				endOfOpts = true
+				continue
+			}
+			if uniqArgConsumingFlags[arg] {
+				i++
+				continue
+			}
+			if len(arg) > 1 && arg[0] == '-' {
+				continue
+			}
+		}
+		positional++
+	}
+	return positional >= 2
+}
+
+// writeTargets returns the arguments of a write invocation (see
+// isWriteInvocation) that name files it creates, modifies or removes, as
+// opposed to files it only reads, such as the source of cp. It is used to
+// enforce readonly_subpaths, which must not block reads.
+func writeTargets(args []string) []string {
+	if wrapped := wrappedCommand(args); wrapped != nil {
+		return writeTargets(wrapped)
+	}
+	switch args[0] {
+	case "cp", "ln", "install":
+		if args[0] == "install" && installCreatesDirectories(args) {
+			return positionalArgs(args)
+		}
+		if dir := targetDirectoryArg(args); dir != "" {
+			return []string{dir}
+		}
+		positional := positionalArgs(args)
+		if len(positional) == 0 {
+			return nil
+		}
+		return positional[len(positional)-1:]
+	case "sed":
+		if !sedInPlace(args) {
+			return nil
+		}
+	case "sort":
+		if out := sortOutputArg(args); out != "" {
+			return []string{out}
+		}
+		return nil
+	case "uniq":
+		if positional := positionalArgs(args); len(positional) >= 2 {
+			return positional[1:2]
+		}
+		return nil
+	}
+	return positionalArgs(args)
+}
+
+// recursiveWriteTargets returns the operands of a write invocation that it
+// modifies together with everything below them: the operands of rm -r, the
+// files of chmod -R and the sources of mv.
+func recursiveWriteTargets(args []string) []string {
+	if wrapped := wrappedCommand(args); wrapped != nil {
+		return recursiveWriteTargets(wrapped)
+	}
+	positional := positionalArgs(args)
+	switch args[0] {
+	case "rm":
+		if hasRecursiveFlag(args, "rR") {
+			return positional
+		}
+	case "chmod":
+		if hasRecursiveFlag(args, "R") && len(positional) > 0 {
+			return positional[1:] // skip the mode
+		}
+	case "mv":
+		if targetDirectoryArg(args) != "" {
+			return positional
+		}
+		if len(positional) > 0 {
+			return positional[:len(positional)-1]
+		}
+	}
+	return nil
+}
+
+// hasRecursiveFlag reports whether args contain --recursive, or a group of
+// short flags with one of the letters in shorts.
+func hasRecursiveFlag(args []string, shorts string) bool {
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--recursive" {
+			return true
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.ContainsAny(arg[1:], shorts) {
+			return true
+		}
+	}
+	return false
+}
+
+// positionalArgs returns the non-flag arguments of a command.
+func positionalArgs(args []string) []string {
+	var positional []string
+	endOfOpts := false
+	for _, arg := range args[1:] {
+		if !endOfOpts {
+			if arg == "--" {
+				endOfOpts = true
+				continue
+			}
+			if len(arg) > 1 && arg[0] == '-' {
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+	return positional
+}
+
+// targetDirectoryArg returns the value of cp, ln or install
+// -t/--target-directory.
+func targetDirectoryArg(args []string) string {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if dir, ok := strings.CutPrefix(arg, "--target-directory="); ok {
+			return dir
+		}
+		if arg == "-t" || arg == "--target-directory" {
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		}
+		if dir, ok := strings.CutPrefix(arg, "-t"); ok && !strings.HasPrefix(arg, "--") {
+			return dir
+		}
+	}
+	return ""
+}
+
+// installCreatesDirectories reports whether an install invocation creates
+// its operands as directories (-d/--directory) rather than copying files.
+func installCreatesDirectories(args []string) bool {
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--directory" {
+			return true
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'd' {
+					return true
+				}
+				if strings.IndexByte(installValueOptions, arg[j]) >= 0 {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sedInPlace reports whether a sed invocation edits files in place (-i,
+// -i.bak, --in-place, or -i combined with other short flags such as -Ei).
+func sedInPlace(args []string) bool {
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--in-place" || strings.HasPrefix(arg, "--in-place=") {
+			return true
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'i' {
+					return true
+				}
+				// The rest of the token is the value of -e, -f, -l or -s.
+				if strings.IndexByte("efls", arg[j]) >= 0 {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sortOutputArg returns the file named by sort -o/--output, or "".
+func sortOutputArg(args []string) string {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return ""
+		}
+		if out, ok := strings.CutPrefix(arg, "--output="); ok {
+			return out
+		}
+		if arg == "--output" {
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'o' {
+					if out := arg[j+1:]; out != "" {
+						return out
+					}
+					if i+1 < len(args) {
+						return args[i+1]
+					}
+					return ""
+				}
+				if sortArgConsumingFlags[arg[j]] {
+					break
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -1,6 +1,9 @@
 package bash_sandboxed
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -111,3 +114,177 @@ func TestValidate_BlockedSedCommands(t *testing.T) {
 		})
 	}
 }
+
+func TestValidate_SortFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"sort -o", "sort -o out.txt in.txt", ""},
+		{"sort -t with o separator", "sort -to in.txt", ""},
+		{"sort --output=", "sort --output=out.txt in.txt", ""},
+		{"sort -o missing value", "sort -o", "requires an output file argument"},
+		{"sort --compress-program python", "sort --compress-program=python in.txt", "python"},
+		{"sort --compress-program separate", "sort --compress-program python in.txt", "python"},
+		{"sort --compress-program gzip", "sort --compress-program=gzip in.txt", `command "gzip" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate_InstallFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		errMsg  string
+	}{
+		{"install mode", "install -m 644 a b", ""},
+		{"install attached mode", "install -m644 a b", ""},
+		{"install long mode", "install --mode=0755 a b", ""},
+		{"install leading dirs", "install -D -m 0755 build/app bin/app", ""},
+		{"install target directory", "install -t dir a b", ""},
+		{"install directories", "install -d out", ""},
+		{"install backup suffix", "install -b --suffix .orig a b", ""},
+		{"install mode value looks like -o", "install -m -o a b", ""},
+		{"install operand after --", "install -- -o b", ""},
+		{"install -o", "install -o root a b", `install flag '-o' is not allowed: changes file ownership`},
+		{"install -g", "install -g wheel a b", `install flag '-g' is not allowed: changes file ownership`},
+		{"install -o in cluster", "install -Do root a b", `install flag '-o' is not allowed`},
+		{"install -s", "install -s a b", `install flag '-s' is not allowed: runs the strip program`},
+		{"install -s in cluster", "install -sm 755 a b", `install flag '-s' is not allowed`},
+		{"install --owner", "install --owner=root a b", `install flag "--owner=root" is not allowed`},
+		{"install --group", "install --group wheel a b", `install flag "--group" is not allowed`},
+		{"install --strip", "install --strip a b", `install flag "--strip" is not allowed`},
+		{"install --strip-program", "install --strip-program=/bin/sh a b", `install flag "--strip-program=/bin/sh" is not allowed`},
+		{"install abbreviated --strip", "install --stri a b", `install flag "--stri" is not allowed`},
+		{"install abbreviated --owner", "install --own=root a b", `install flag "--own=root" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseBash(tt.command)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			err = newTestSandbox().validate(f)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected command to be allowed, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestExecute_Install(t *testing.T) {
+	workDir := t.TempDir()
+	paths := []string{workDir}
+	if err := os.WriteFile(filepath.Join(workDir, "a"), []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSandbox()
+	defer s.Close()
+	if _, err := s.Execute(context.Background(), "install -m 644 a b", workDir, paths, paths); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(workDir, "b"))
+	if err != nil {
+		t.Fatalf("expected install to create b: %v", err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("expected mode 0644, got %v", info.Mode().Perm())
+	}
+
+	for _, command := range []string{"install -o root a b", "install a /etc/b"} {
+		if _, err := s.Execute(context.Background(), command, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), "validation failed") {
+			t.Errorf("%s: expected a validation error, got %v", command, err)
+		}
+	}
+	if _, err := s.Execute(context.Background(), `p="--strip-program=./evil.sh --strip"; install $p a c`, workDir, paths, paths); err == nil || !strings.Contains(err.Error(), `install flag "--strip-program=./evil.sh" is not allowed`) {
+		t.Errorf("expected an expanded --strip-program to be blocked, got %v", err)
+	}
+
+	// Options read by xargs are passed after "--", as operands.
+	if err := os.WriteFile(filepath.Join(workDir, "evil.sh"), []byte("#!/bin/sh\ntouch pwned\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	s.Execute(context.Background(), "echo --strip-program=./evil.sh --strip | xargs install a c", workDir, paths, paths) //nolint:errcheck
+	if _, err := os.Stat(filepath.Join(workDir, "pwned")); err == nil {
+		t.Error("expected the strip program read by xargs not to run")
+	}
+}
+
+func TestSortUniqWritesOutput(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"sort", "in.txt"}, false},
+		{[]string{"sort", "-o", "out.txt"}, true},
+		{[]string{"sort", "-ro", "out.txt"}, true},
+		{[]string{"sort", "--output=out.txt"}, true},
+		{[]string{"sort", "-to", "in.txt"}, false},
+		{[]string{"sort", "-k", "1", "in.txt"}, false},
+		{[]string{"uniq", "in.txt"}, false},
+		{[]string{"uniq", "-c", "in.txt"}, false},
+		{[]string{"uniq", "-f", "1", "in.txt"}, false},
+		{[]string{"uniq", "in.txt", "out.txt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := isWriteInvocation(tt.args); got != tt.want {
+				t.Fatalf("isWriteInvocation(%v) = %v, want %v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWriteInvocation_Wrapped(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"timeout", "5", "rm", "f"}, true},
+		{[]string{"timeout", "-s", "KILL", "5", "touch", "f"}, true},
+		{[]string{"timeout", "5", "cat", "f"}, false},
+		{[]string{"timeout", "5", "sort", "-o", "out.txt"}, true},
+		{[]string{"timeout", "5"}, false},
+		{[]string{"env", "FOO=bar", "mv", "a", "b"}, true},
+		{[]string{"env", "-u", "rm", "cat", "f"}, false},
+		{[]string{"env", "timeout", "5", "rm", "f"}, true},
+		{[]string{"env"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := isWriteInvocation(tt.args); got != tt.want {
+				t.Fatalf("isWriteInvocation(%v) = %v, want %v", tt.args, got, tt.want)
+			}
+		})
+	}
+}